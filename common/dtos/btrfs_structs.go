@@ -1,5 +1,7 @@
 package dtos
 
+import "time"
+
 //BlockDevID represents the identifier field of a BlockDevice entry
 type BlockDevID int32
 
@@ -20,6 +22,16 @@ type BlockDevice struct {
 	Path     string          `json:"path"`
 	UUID     UUIDType        `json:"UUID"`
 	Type     string          `json:"type"`
+	Stats    *DeviceStats    `json:"stats,omitempty"`
+}
+
+//DeviceStats contains the IO error counters btrfs keeps for a device
+type DeviceStats struct {
+	WriteIOErrs    uint64 `json:"writeIOErrs"`
+	ReadIOErrs     uint64 `json:"readIOErrs"`
+	FlushIOErrs    uint64 `json:"flushIOErrs"`
+	CorruptionErrs uint64 `json:"corruptionErrs"`
+	GenerationErrs uint64 `json:"generationErrs"`
 }
 
 //StorageServer represents a Network Attached Storage device
@@ -50,10 +62,405 @@ type MountPoint struct {
 	FSCKPassNo    int
 }
 
+/*MountEntry is a mount point, either an fstab entry or a live mount, together
+with the UUID of the mounted volume if it is known. Managed is set on the fstab
+entries written by the storage server.*/
+type MountEntry struct {
+	MountPoint
+	VolumeUUID UUIDType `json:"volumeUUID,omitempty"`
+	Managed    bool     `json:"managed"`
+}
+
+//FstabDrift kinds
+const (
+	//FstabDriftNotMounted is an fstab entry with nothing mounted at its path
+	FstabDriftNotMounted = "not_mounted"
+	//FstabDriftNotInFstab is a live mount without an fstab entry
+	FstabDriftNotInFstab = "not_in_fstab"
+	//FstabDriftVolumeDiffers is an fstab entry of another volume than the one mounted
+	FstabDriftVolumeDiffers = "volume_differs"
+	//FstabDriftOptionsDiffer is an fstab entry with options the live mount lacks
+	FstabDriftOptionsDiffer = "options_differ"
+)
+
+/*FstabDrift describes a difference between the fstab of a storage server and
+its live mounts. Entry and Mount are set if they exist.*/
+type FstabDrift struct {
+	Kind      string      `json:"kind"`
+	MountPath string      `json:"mountPath"`
+	Entry     *MountEntry `json:"entry,omitempty"`
+	Mount     *MountEntry `json:"mount,omitempty"`
+	Details   string      `json:"details,omitempty"`
+}
+
 //BtrfsSubVolume represents a subvolume on a btrfs volume
 type BtrfsSubVolume struct {
 	SubVolID     int
 	RelativePath string `json:"relativePath"`
 	VolumeUUID   UUIDType
 	ParentUUID   UUIDType
+	UUID         UUIDType     `json:"UUID"`
+	ReceivedUUID UUIDType     `json:"receivedUUID"`
+	ParentID     int          `json:"parentID"`
+	Generation   uint64       `json:"generation"`
+	CreationTime time.Time    `json:"creationTime"`
+	Flags        uint64       `json:"flags"`
+	ReadOnly     bool         `json:"readOnly"`
+	Default      bool         `json:"default"`
+	Usage        *QgroupUsage `json:"usage,omitempty"`
+}
+
+/*StreamUUID returns the received UUID the copies of the subvolume get from btrfs
+receive. A send stream carries the received UUID of the subvolume if it was
+received itself, its UUID otherwise.*/
+func (s BtrfsSubVolume) StreamUUID() UUIDType {
+	if s.ReceivedUUID != "" {
+		return s.ReceivedUUID
+	}
+	return s.UUID
+}
+
+//BtrfsDeviceSpace describes an amount of space on a single device of a volume
+type BtrfsDeviceSpace struct {
+	Path  string `json:"path"`
+	Bytes uint64 `json:"bytes"`
+}
+
+//BtrfsBlockGroupUsage describes the space allocated to block groups of a given
+//type (Data, Metadata, System) and RAID profile
+type BtrfsBlockGroupUsage struct {
+	Type      string             `json:"type"`
+	Profile   string             `json:"profile"`
+	Allocated uint64             `json:"allocated"`
+	Used      uint64             `json:"used"`
+	Devices   []BtrfsDeviceSpace `json:"devices"`
+}
+
+//BtrfsVolumeUsage represents the space usage of a btrfs volume. All values
+//are in bytes.
+type BtrfsVolumeUsage struct {
+	DeviceSize        uint64                 `json:"deviceSize"`
+	DeviceAllocated   uint64                 `json:"deviceAllocated"`
+	DeviceUnallocated uint64                 `json:"deviceUnallocated"`
+	Used              uint64                 `json:"used"`
+	FreeEstimated     uint64                 `json:"freeEstimated"`
+	FreeEstimatedMin  uint64                 `json:"freeEstimatedMin"`
+	DataRatio         float64                `json:"dataRatio"`
+	MetadataRatio     float64                `json:"metadataRatio"`
+	BlockGroups       []BtrfsBlockGroupUsage `json:"blockGroups"`
+	Unallocated       []BtrfsDeviceSpace     `json:"unallocated"`
+}
+
+//ScrubProgress represents the progress of a scrub of a btrfs volume
+type ScrubProgress struct {
+	BytesScrubbed       uint64 `json:"bytesScrubbed"`
+	BytesToScrub        uint64 `json:"bytesToScrub"`
+	DataExtentsScrubbed uint64 `json:"dataExtentsScrubbed"`
+	TreeExtentsScrubbed uint64 `json:"treeExtentsScrubbed"`
+	ReadErrors          uint64 `json:"readErrors"`
+	CSumErrors          uint64 `json:"csumErrors"`
+	VerifyErrors        uint64 `json:"verifyErrors"`
+	SuperErrors         uint64 `json:"superErrors"`
+	CorrectedErrors     uint64 `json:"correctedErrors"`
+	UncorrectableErrors uint64 `json:"uncorrectableErrors"`
+}
+
+//BalanceFilter selects the chunks of a block group type that are balanced.
+//Unset fields do not restrict the selection.
+type BalanceFilter struct {
+	//Usage selects chunks that are used at most this many percent
+	Usage *int `json:"usage,omitempty"`
+	//DevID selects chunks that have a stripe on the device
+	DevID *int `json:"devid,omitempty"`
+	//Profiles selects chunks with one of the RAID profiles
+	Profiles []string `json:"profiles,omitempty"`
+	//Convert converts the selected chunks to the RAID profile
+	Convert string `json:"convert,omitempty"`
+	//Soft skips chunks that already have the target profile
+	Soft bool `json:"soft,omitempty"`
+}
+
+//BalanceProgress represents the progress of a balance of a btrfs volume
+type BalanceProgress struct {
+	ChunksExpected   uint64 `json:"chunksExpected"`
+	ChunksConsidered uint64 `json:"chunksConsidered"`
+	ChunksRelocated  uint64 `json:"chunksRelocated"`
+	PercentLeft      int    `json:"percentLeft"`
+}
+
+//DeviceRemoveProgress represents the progress of a removal of devices from a
+//btrfs volume
+type DeviceRemoveProgress struct {
+	BytesToRelocate uint64 `json:"bytesToRelocate"`
+	BytesRemaining  uint64 `json:"bytesRemaining"`
+}
+
+//DeviceResizeProgress represents the progress of a resize of a btrfs device,
+//After is only set once the resize is finished
+type DeviceResizeProgress struct {
+	Before BtrfsDeviceSize `json:"before"`
+	After  BtrfsDeviceSize `json:"after"`
+}
+
+//ReplaceProgress represents the progress of a replace of a btrfs device
+type ReplaceProgress struct {
+	PercentDone             float64 `json:"percentDone"`
+	WriteErrors             uint64  `json:"writeErrors"`
+	UncorrectableReadErrors uint64  `json:"uncorrectableReadErrors"`
+}
+
+//BtrfsVolumeCreateSpec describes a btrfs volume to be created by mkfs.btrfs.
+//Empty fields leave the mkfs.btrfs defaults in place.
+type BtrfsVolumeCreateSpec struct {
+	Paths           []string `json:"paths"`
+	Label           string   `json:"label"`
+	DataProfile     string   `json:"dataProfile"`
+	MetadataProfile string   `json:"metadataProfile"`
+	NodeSize        uint32   `json:"nodeSize"`
+	Features        []string `json:"features"`
+}
+
+//WipedSignature describes a filesystem, RAID or partition table signature
+//found by wipefs on a device
+type WipedSignature struct {
+	Path   string   `json:"path"`
+	Offset string   `json:"offset"`
+	UUID   UUIDType `json:"UUID"`
+	Label  string   `json:"label"`
+	Type   string   `json:"type"`
+}
+
+//BtrfsVolumeCreatePlan lists the signatures wiped by the creation of a volume.
+//The Token has to be sent with the create request before ExpiresAt.
+type BtrfsVolumeCreatePlan struct {
+	Spec       BtrfsVolumeCreateSpec `json:"spec"`
+	Signatures []WipedSignature      `json:"signatures"`
+	Token      string                `json:"token"`
+	ExpiresAt  time.Time             `json:"expiresAt"`
+}
+
+//BtrfsDeviceSize describes the size of a device of a btrfs volume and the bytes
+//allocated to chunks on it
+type BtrfsDeviceSize struct {
+	DevID     uint64 `json:"devid"`
+	Path      string `json:"path"`
+	Size      uint64 `json:"size"`
+	Slack     uint64 `json:"slack"`
+	Allocated uint64 `json:"allocated"`
+}
+
+//QgroupUsage contains the bytes accounted to a qgroup
+type QgroupUsage struct {
+	//Referenced is the size of all data the qgroup refers to
+	Referenced uint64 `json:"referenced"`
+	//Exclusive is the size of the data that is not shared with other qgroups
+	Exclusive uint64 `json:"exclusive"`
+}
+
+//Qgroup represents a quota group of a btrfs volume. The qgroups of level 0
+//belong to subvolumes, higher levels group other qgroups.
+type Qgroup struct {
+	ID string `json:"id"`
+	QgroupUsage
+	MaxReferenced *uint64  `json:"maxReferenced,omitempty"`
+	MaxExclusive  *uint64  `json:"maxExclusive,omitempty"`
+	Parents       []string `json:"parents"`
+	Children      []string `json:"children"`
+}
+
+//StreamID identifies a stream relayed by the master between two storage servers
+type StreamID uint64
+
+//MasterServerID is the server ID in notifications of tasks run by the master
+const MasterServerID StorageServerID = -1
+
+//ReplicatedSnapshot describes a snapshot received on the target of a
+//replication. The received copy has a received UUID equal to SourceUUID.
+type ReplicatedSnapshot struct {
+	SourcePath   string    `json:"sourcePath"`
+	SourceUUID   UUIDType  `json:"sourceUUID"`
+	ReceivedPath string    `json:"receivedPath"`
+	ReceivedUUID UUIDType  `json:"receivedUUID"`
+	ParentUUID   UUIDType  `json:"parentUUID,omitempty"`
+	Bytes        uint64    `json:"bytes"`
+	Time         time.Time `json:"time"`
+}
+
+//Replication describes the snapshots replicated from a volume to a directory
+//on a volume of another storage server, oldest first
+type Replication struct {
+	SourceVolumeUUID UUIDType             `json:"sourceVolumeUUID"`
+	TargetVolumeUUID UUIDType             `json:"targetVolumeUUID"`
+	TargetPath       string               `json:"targetPath"`
+	Snapshots        []ReplicatedSnapshot `json:"snapshots"`
+}
+
+//ReplicationProgress represents the progress of a replication of a snapshot.
+//ParentPath is set if only the changes since the parent snapshot are sent.
+type ReplicationProgress struct {
+	BytesTransferred uint64 `json:"bytesTransferred"`
+	ParentPath       string `json:"parentPath,omitempty"`
+}
+
+//BackupStream describes a btrfs send stream stored in a backup target directory.
+//A full stream starts a chain, every incremental stream of the chain depends
+//on its parent stream.
+type BackupStream struct {
+	ID           string    `json:"id"`
+	Chain        string    `json:"chain"`
+	ParentID     string    `json:"parentID,omitempty"`
+	VolumeUUID   UUIDType  `json:"volumeUUID"`
+	SourceUUID   UUIDType  `json:"sourceUUID"`
+	SnapshotPath string    `json:"snapshotPath"`
+	SnapshotUUID UUIDType  `json:"snapshotUUID"`
+	File         string    `json:"file"`
+	Size         uint64    `json:"size"`
+	SHA256       string    `json:"sha256"`
+	Time         time.Time `json:"time"`
+}
+
+//BackupProgress represents the progress of a backup of a snapshot
+type BackupProgress struct {
+	BytesWritten uint64 `json:"bytesWritten"`
+	ParentID     string `json:"parentID,omitempty"`
+}
+
+//BackupStreamFailure describes a stream that failed the verification of a chain
+type BackupStreamFailure struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+//BackupVerifyProgress represents the progress of a verification of a backup chain
+type BackupVerifyProgress struct {
+	StreamsChecked int                   `json:"streamsChecked"`
+	StreamsTotal   int                   `json:"streamsTotal"`
+	Failures       []BackupStreamFailure `json:"failures"`
+}
+
+//States of a stream of a restore
+const (
+	RestoreStreamPending   = "pending"
+	RestoreStreamPresent   = "present"
+	RestoreStreamReceiving = "receiving"
+	RestoreStreamReceived  = "received"
+	RestoreStreamFailed    = "failed"
+)
+
+//RestoreStreamProgress represents the state of a stream of a restore. A
+//stream whose snapshot was already received on the volume is present.
+type RestoreStreamProgress struct {
+	ID        string `json:"id"`
+	State     string `json:"state"`
+	BytesDone uint64 `json:"bytesDone"`
+	Size      uint64 `json:"size"`
+	Error     string `json:"error,omitempty"`
+}
+
+//RestoreProgress represents the progress of a restore of a backup chain,
+//Subvolume is set once the restored subvolume was received
+type RestoreProgress struct {
+	Streams   []RestoreStreamProgress `json:"streams"`
+	Subvolume *BtrfsSubVolume         `json:"subvolume,omitempty"`
+}
+
+//RetentionRules represent the numbers of snapshots kept by a retention policy.
+//KeepLast keeps the newest snapshots, the other counts keep the newest snapshot
+//of each of the most recent hours, days, weeks, months and years.
+type RetentionRules struct {
+	KeepLast int `json:"keepLast"`
+	Hourly   int `json:"hourly"`
+	Daily    int `json:"daily"`
+	Weekly   int `json:"weekly"`
+	Monthly  int `json:"monthly"`
+	Yearly   int `json:"yearly"`
+}
+
+//RetentionPolicy describes the retention of the read-only snapshots of the
+//subvolume at Subvolume, or of every subvolume whose path matches PathGlob, on
+//a volume of a storage server
+type RetentionPolicy struct {
+	ID         string          `json:"id"`
+	ServerID   StorageServerID `json:"serverID"`
+	VolumeUUID UUIDType        `json:"volumeUUID"`
+	Subvolume  string          `json:"subvolume,omitempty"`
+	PathGlob   string          `json:"pathGlob,omitempty"`
+	Rules      RetentionRules  `json:"rules"`
+}
+
+//RetentionDecision describes whether a snapshot of the subvolume at
+//SubvolumePath is kept, the rules keeping it are listed in Reasons. Error is
+//set if the deletion of the snapshot failed.
+type RetentionDecision struct {
+	SubvolumePath string    `json:"subvolumePath"`
+	SnapshotPath  string    `json:"snapshotPath"`
+	SnapshotUUID  UUIDType  `json:"snapshotUUID"`
+	CreationTime  time.Time `json:"creationTime"`
+	Keep          bool      `json:"keep"`
+	Reasons       []string  `json:"reasons"`
+	Deleted       bool      `json:"deleted"`
+	Error         string    `json:"error,omitempty"`
+}
+
+//Actions of a schedule
+const (
+	ScheduleActionSnapshot = "snapshot"
+	ScheduleActionScrub    = "scrub"
+	ScheduleActionBalance  = "balance"
+	ScheduleActionBackup   = "backup"
+)
+
+//Catch-up policies of a schedule, they decide what happens to the runs missed
+//while the master was down
+const (
+	//CatchUpSkip skips the missed runs
+	CatchUpSkip = "skip"
+	//CatchUpOnce runs a schedule once for all its missed runs
+	CatchUpOnce = "once"
+	//CatchUpAll runs every missed run, up to a limit
+	CatchUpAll = "all"
+)
+
+//Outcomes of a run of a schedule
+const (
+	RunOutcomeRunning   = "running"
+	RunOutcomeSucceeded = "succeeded"
+	RunOutcomeFailed    = "failed"
+	RunOutcomeSkipped   = "skipped"
+)
+
+//Schedule describes an action run by the master whenever the cron expression
+//matches in the timezone. Snapshots are created read-only in SnapshotDir, a
+//backup snapshots the subvolume and then backs the snapshot up to BackupDir.
+//BalanceUsage, if set, limits a balance to chunks used at most that many percent.
+type Schedule struct {
+	ID           string          `json:"id"`
+	Name         string          `json:"name"`
+	Cron         string          `json:"cron"`
+	Timezone     string          `json:"timezone"`
+	ServerID     StorageServerID `json:"serverID"`
+	VolumeUUID   UUIDType        `json:"volumeUUID"`
+	Subvolume    string          `json:"subvolume,omitempty"`
+	Action       string          `json:"action"`
+	SnapshotDir  string          `json:"snapshotDir,omitempty"`
+	BackupDir    string          `json:"backupDir,omitempty"`
+	BalanceUsage int             `json:"balanceUsage,omitempty"`
+	CatchUp      string          `json:"catchUp"`
+	Enabled      bool            `json:"enabled"`
+	//LastScheduled is the time of the last run that was due
+	LastScheduled time.Time `json:"lastScheduled"`
+}
+
+//ScheduleRun describes a run of a schedule. TaskID is set for the actions run
+//as a task on the storage server, the run ends with the task. Local runs were
+//run by the storage server while it was disconnected from the master.
+type ScheduleRun struct {
+	ScheduleID    string    `json:"scheduleID"`
+	ScheduledTime time.Time `json:"scheduledTime"`
+	StartTime     time.Time `json:"startTime"`
+	EndTime       time.Time `json:"endTime"`
+	Outcome       string    `json:"outcome"`
+	Error         string    `json:"error,omitempty"`
+	TaskID        uint64    `json:"taskID,omitempty"`
+	Local         bool      `json:"local"`
 }
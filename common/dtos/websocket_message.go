@@ -6,6 +6,8 @@ import (
 	"log"
 	"reflect"
 	"strconv"
+
+	"github.com/djarek/btrfs-volume-manager/common/tasks"
 )
 
 //WebSocketMessageType represents the type of the message.
@@ -25,6 +27,56 @@ const (
 	WSMsgBtrfsSubvolumeCreateRequest      = 10
 	WSMsgBtrfsSubvolumeDeleteRequest      = 11
 	WSMsgBtrfsSubvolumeSnapshotRequest    = 12
+	WSMsgBtrfsVolumeUsageRequest          = 13
+	WSMsgBtrfsDeviceStatsRequest          = 14
+	WSMsgTaskStatusRequest                = 15
+	WSMsgTaskPauseRequest                 = 16
+	WSMsgTaskResumeRequest                = 17
+	WSMsgTaskCancelRequest                = 18
+	WSMsgBtrfsScrubStartRequest           = 19
+	WSMsgBtrfsBalanceStartRequest         = 20
+	WSMsgBtrfsProfileConvertRequest       = 21
+	WSMsgBtrfsDeviceAddRequest            = 22
+	WSMsgBtrfsDeviceRemoveRequest         = 23
+	WSMsgUnusedBlockDeviceListRequest     = 24
+	WSMsgBtrfsReplaceStartRequest         = 25
+	WSMsgBtrfsVolumeCreatePlanRequest     = 26
+	WSMsgBtrfsVolumeCreateRequest         = 27
+	WSMsgBtrfsDeviceResizeRequest         = 28
+	WSMsgBtrfsVolumeRelabelRequest        = 29
+	WSMsgBtrfsQuotaSetRequest             = 30
+	WSMsgBtrfsQgroupListRequest           = 31
+	WSMsgBtrfsQgroupLimitRequest          = 32
+	WSMsgBtrfsQgroupCreateRequest         = 33
+	WSMsgBtrfsQgroupAssignRequest         = 34
+	WSMsgBtrfsQgroupDestroyRequest        = 35
+	WSMsgBtrfsPropertyGetRequest          = 36
+	WSMsgBtrfsPropertySetRequest          = 37
+	WSMsgBtrfsSubvolumeSetDefaultRequest  = 38
+	WSMsgReplicationStartRequest          = 39
+	WSMsgReplicationListRequest           = 40
+	WSMsgBtrfsSendStartRequest            = 41
+	WSMsgBtrfsReceiveStartRequest         = 42
+	WSMsgBackupStartRequest               = 43
+	WSMsgBackupCatalogRequest             = 44
+	WSMsgBackupVerifyRequest              = 45
+	WSMsgBackupPruneRequest               = 46
+	WSMsgBackupRestoreRequest             = 47
+	WSMsgRetentionPolicySetRequest        = 48
+	WSMsgRetentionPolicyListRequest       = 49
+	WSMsgRetentionPolicyDeleteRequest     = 50
+	WSMsgRetentionApplyRequest            = 51
+	WSMsgScheduleSetRequest               = 52
+	WSMsgScheduleListRequest              = 53
+	WSMsgScheduleDeleteRequest            = 54
+	WSMsgScheduleRunsRequest              = 55
+	WSMsgScheduleSyncRequest              = 56
+	WSMsgBtrfsMountRequest                = 57
+	WSMsgBtrfsUnmountRequest              = 58
+	WSMsgFstabListRequest                 = 59
+	WSMsgFstabEntrySetRequest             = 60
+	WSMsgFstabEntryRemoveRequest          = 61
+	WSMsgFstabDriftRequest                = 62
 )
 
 //WSMsgResponse MessageType values
@@ -40,6 +92,67 @@ const (
 	WSMsgBtrfsSubvolumeCreateResponse      = 10010
 	WSMsgBtrfsSubvolumeDeleteResponse      = 10011
 	WSMsgBtrfsSubvolumeSnapshotResponse    = 10012
+	WSMsgBtrfsVolumeUsageResponse          = 10013
+	WSMsgBtrfsDeviceStatsResponse          = 10014
+	WSMsgTaskStatusResponse                = 10015
+	WSMsgTaskPauseResponse                 = 10016
+	WSMsgTaskResumeResponse                = 10017
+	WSMsgTaskCancelResponse                = 10018
+	WSMsgBtrfsScrubStartResponse           = 10019
+	WSMsgBtrfsBalanceStartResponse         = 10020
+	WSMsgBtrfsProfileConvertResponse       = 10021
+	WSMsgBtrfsDeviceAddResponse            = 10022
+	WSMsgBtrfsDeviceRemoveResponse         = 10023
+	WSMsgUnusedBlockDeviceListResponse     = 10024
+	WSMsgBtrfsReplaceStartResponse         = 10025
+	WSMsgBtrfsVolumeCreatePlanResponse     = 10026
+	WSMsgBtrfsVolumeCreateResponse         = 10027
+	WSMsgBtrfsDeviceResizeResponse         = 10028
+	WSMsgBtrfsVolumeRelabelResponse        = 10029
+	WSMsgBtrfsQuotaSetResponse             = 10030
+	WSMsgBtrfsQgroupListResponse           = 10031
+	WSMsgBtrfsQgroupLimitResponse          = 10032
+	WSMsgBtrfsQgroupCreateResponse         = 10033
+	WSMsgBtrfsQgroupAssignResponse         = 10034
+	WSMsgBtrfsQgroupDestroyResponse        = 10035
+	WSMsgBtrfsPropertyGetResponse          = 10036
+	WSMsgBtrfsPropertySetResponse          = 10037
+	WSMsgBtrfsSubvolumeSetDefaultResponse  = 10038
+	WSMsgReplicationStartResponse          = 10039
+	WSMsgReplicationListResponse           = 10040
+	WSMsgBtrfsSendStartResponse            = 10041
+	WSMsgBtrfsReceiveStartResponse         = 10042
+	WSMsgBackupStartResponse               = 10043
+	WSMsgBackupCatalogResponse             = 10044
+	WSMsgBackupVerifyResponse              = 10045
+	WSMsgBackupPruneResponse               = 10046
+	WSMsgBackupRestoreResponse             = 10047
+	WSMsgRetentionPolicySetResponse        = 10048
+	WSMsgRetentionPolicyListResponse       = 10049
+	WSMsgRetentionPolicyDeleteResponse     = 10050
+	WSMsgRetentionApplyResponse            = 10051
+	WSMsgScheduleSetResponse               = 10052
+	WSMsgScheduleListResponse              = 10053
+	WSMsgScheduleDeleteResponse            = 10054
+	WSMsgScheduleRunsResponse              = 10055
+	WSMsgScheduleSyncResponse              = 10056
+	WSMsgBtrfsMountResponse                = 10057
+	WSMsgBtrfsUnmountResponse              = 10058
+	WSMsgFstabListResponse                 = 10059
+	WSMsgFstabEntrySetResponse             = 10060
+	WSMsgFstabEntryRemoveResponse          = 10061
+	WSMsgFstabDriftResponse                = 10062
+)
+
+//WSMsgNotification MessageType values, notifications are not sent in response
+//to a request
+const (
+	WSMsgTaskStatusNotification         = 20000
+	WSMsgBtrfsVolumeChangedNotification = 20001
+	WSMsgBtrfsStreamChunkNotification   = 20002
+	WSMsgBtrfsStreamAckNotification     = 20003
+	WSMsgBtrfsReceiveResultNotification = 20004
+	WSMsgScheduleSyncNotification       = 20005
 )
 
 func init() {
@@ -75,6 +188,163 @@ func init() {
 	RegisterMessageType(WSMsgBtrfsSubvolumeSnapshotRequest, BtrfsSubvolumeSnapshotRequest{})
 	RegisterMessageType(WSMsgBtrfsSubvolumeSnapshotResponse, BtrfsSubvolumeSnapshotResponse{})
 
+	RegisterMessageType(WSMsgBtrfsVolumeUsageRequest, BtrfsVolumeUsageRequest{})
+	RegisterMessageType(WSMsgBtrfsVolumeUsageResponse, BtrfsVolumeUsageResponse{})
+
+	RegisterMessageType(WSMsgBtrfsDeviceStatsRequest, BtrfsDeviceStatsRequest{})
+	RegisterMessageType(WSMsgBtrfsDeviceStatsResponse, BtrfsDeviceStatsResponse{})
+
+	RegisterMessageType(WSMsgTaskStatusRequest, TaskStatusRequest{})
+	RegisterMessageType(WSMsgTaskStatusResponse, TaskStatusResponse{})
+
+	RegisterMessageType(WSMsgTaskPauseRequest, TaskPauseRequest{})
+	RegisterMessageType(WSMsgTaskPauseResponse, TaskPauseResponse{})
+
+	RegisterMessageType(WSMsgTaskResumeRequest, TaskResumeRequest{})
+	RegisterMessageType(WSMsgTaskResumeResponse, TaskResumeResponse{})
+
+	RegisterMessageType(WSMsgTaskCancelRequest, TaskCancelRequest{})
+	RegisterMessageType(WSMsgTaskCancelResponse, TaskCancelResponse{})
+
+	RegisterMessageType(WSMsgBtrfsScrubStartRequest, BtrfsScrubStartRequest{})
+	RegisterMessageType(WSMsgBtrfsScrubStartResponse, BtrfsScrubStartResponse{})
+
+	RegisterMessageType(WSMsgBtrfsBalanceStartRequest, BtrfsBalanceStartRequest{})
+	RegisterMessageType(WSMsgBtrfsBalanceStartResponse, BtrfsBalanceStartResponse{})
+
+	RegisterMessageType(WSMsgBtrfsProfileConvertRequest, BtrfsProfileConvertRequest{})
+	RegisterMessageType(WSMsgBtrfsProfileConvertResponse, BtrfsProfileConvertResponse{})
+
+	RegisterMessageType(WSMsgBtrfsDeviceAddRequest, BtrfsDeviceAddRequest{})
+	RegisterMessageType(WSMsgBtrfsDeviceAddResponse, BtrfsDeviceAddResponse{})
+
+	RegisterMessageType(WSMsgBtrfsDeviceRemoveRequest, BtrfsDeviceRemoveRequest{})
+	RegisterMessageType(WSMsgBtrfsDeviceRemoveResponse, BtrfsDeviceRemoveResponse{})
+
+	RegisterMessageType(WSMsgUnusedBlockDeviceListRequest, UnusedBlockDeviceListRequest{})
+	RegisterMessageType(WSMsgUnusedBlockDeviceListResponse, UnusedBlockDeviceListResponse{})
+
+	RegisterMessageType(WSMsgBtrfsReplaceStartRequest, BtrfsReplaceStartRequest{})
+	RegisterMessageType(WSMsgBtrfsReplaceStartResponse, BtrfsReplaceStartResponse{})
+
+	RegisterMessageType(WSMsgBtrfsVolumeCreatePlanRequest, BtrfsVolumeCreatePlanRequest{})
+	RegisterMessageType(WSMsgBtrfsVolumeCreatePlanResponse, BtrfsVolumeCreatePlanResponse{})
+
+	RegisterMessageType(WSMsgBtrfsVolumeCreateRequest, BtrfsVolumeCreateRequest{})
+	RegisterMessageType(WSMsgBtrfsVolumeCreateResponse, BtrfsVolumeCreateResponse{})
+
+	RegisterMessageType(WSMsgBtrfsDeviceResizeRequest, BtrfsDeviceResizeRequest{})
+	RegisterMessageType(WSMsgBtrfsDeviceResizeResponse, BtrfsDeviceResizeResponse{})
+
+	RegisterMessageType(WSMsgBtrfsVolumeRelabelRequest, BtrfsVolumeRelabelRequest{})
+	RegisterMessageType(WSMsgBtrfsVolumeRelabelResponse, BtrfsVolumeRelabelResponse{})
+
+	RegisterMessageType(WSMsgBtrfsQuotaSetRequest, BtrfsQuotaSetRequest{})
+	RegisterMessageType(WSMsgBtrfsQuotaSetResponse, BtrfsQuotaSetResponse{})
+
+	RegisterMessageType(WSMsgBtrfsQgroupListRequest, BtrfsQgroupListRequest{})
+	RegisterMessageType(WSMsgBtrfsQgroupListResponse, BtrfsQgroupListResponse{})
+
+	RegisterMessageType(WSMsgBtrfsQgroupLimitRequest, BtrfsQgroupLimitRequest{})
+	RegisterMessageType(WSMsgBtrfsQgroupLimitResponse, BtrfsQgroupLimitResponse{})
+
+	RegisterMessageType(WSMsgBtrfsQgroupCreateRequest, BtrfsQgroupCreateRequest{})
+	RegisterMessageType(WSMsgBtrfsQgroupCreateResponse, BtrfsQgroupCreateResponse{})
+
+	RegisterMessageType(WSMsgBtrfsQgroupAssignRequest, BtrfsQgroupAssignRequest{})
+	RegisterMessageType(WSMsgBtrfsQgroupAssignResponse, BtrfsQgroupAssignResponse{})
+
+	RegisterMessageType(WSMsgBtrfsQgroupDestroyRequest, BtrfsQgroupDestroyRequest{})
+	RegisterMessageType(WSMsgBtrfsQgroupDestroyResponse, BtrfsQgroupDestroyResponse{})
+
+	RegisterMessageType(WSMsgBtrfsPropertyGetRequest, BtrfsPropertyGetRequest{})
+	RegisterMessageType(WSMsgBtrfsPropertyGetResponse, BtrfsPropertyGetResponse{})
+
+	RegisterMessageType(WSMsgBtrfsPropertySetRequest, BtrfsPropertySetRequest{})
+	RegisterMessageType(WSMsgBtrfsPropertySetResponse, BtrfsPropertySetResponse{})
+
+	RegisterMessageType(WSMsgBtrfsSubvolumeSetDefaultRequest, BtrfsSubvolumeSetDefaultRequest{})
+	RegisterMessageType(WSMsgBtrfsSubvolumeSetDefaultResponse, BtrfsSubvolumeSetDefaultResponse{})
+
+	RegisterMessageType(WSMsgReplicationStartRequest, ReplicationStartRequest{})
+	RegisterMessageType(WSMsgReplicationStartResponse, ReplicationStartResponse{})
+
+	RegisterMessageType(WSMsgReplicationListRequest, ReplicationListRequest{})
+	RegisterMessageType(WSMsgReplicationListResponse, ReplicationListResponse{})
+
+	RegisterMessageType(WSMsgBtrfsSendStartRequest, BtrfsSendStartRequest{})
+	RegisterMessageType(WSMsgBtrfsSendStartResponse, BtrfsSendStartResponse{})
+
+	RegisterMessageType(WSMsgBtrfsReceiveStartRequest, BtrfsReceiveStartRequest{})
+	RegisterMessageType(WSMsgBtrfsReceiveStartResponse, BtrfsReceiveStartResponse{})
+
+	RegisterMessageType(WSMsgBackupStartRequest, BackupStartRequest{})
+	RegisterMessageType(WSMsgBackupStartResponse, BackupStartResponse{})
+
+	RegisterMessageType(WSMsgBackupCatalogRequest, BackupCatalogRequest{})
+	RegisterMessageType(WSMsgBackupCatalogResponse, BackupCatalogResponse{})
+
+	RegisterMessageType(WSMsgBackupVerifyRequest, BackupVerifyRequest{})
+	RegisterMessageType(WSMsgBackupVerifyResponse, BackupVerifyResponse{})
+
+	RegisterMessageType(WSMsgBackupPruneRequest, BackupPruneRequest{})
+	RegisterMessageType(WSMsgBackupPruneResponse, BackupPruneResponse{})
+
+	RegisterMessageType(WSMsgBackupRestoreRequest, BackupRestoreRequest{})
+	RegisterMessageType(WSMsgBackupRestoreResponse, BackupRestoreResponse{})
+
+	RegisterMessageType(WSMsgRetentionPolicySetRequest, RetentionPolicySetRequest{})
+	RegisterMessageType(WSMsgRetentionPolicySetResponse, RetentionPolicySetResponse{})
+
+	RegisterMessageType(WSMsgRetentionPolicyListRequest, RetentionPolicyListRequest{})
+	RegisterMessageType(WSMsgRetentionPolicyListResponse, RetentionPolicyListResponse{})
+
+	RegisterMessageType(WSMsgRetentionPolicyDeleteRequest, RetentionPolicyDeleteRequest{})
+	RegisterMessageType(WSMsgRetentionPolicyDeleteResponse, RetentionPolicyDeleteResponse{})
+
+	RegisterMessageType(WSMsgRetentionApplyRequest, RetentionApplyRequest{})
+	RegisterMessageType(WSMsgRetentionApplyResponse, RetentionApplyResponse{})
+
+	RegisterMessageType(WSMsgScheduleSetRequest, ScheduleSetRequest{})
+	RegisterMessageType(WSMsgScheduleSetResponse, ScheduleSetResponse{})
+
+	RegisterMessageType(WSMsgScheduleListRequest, ScheduleListRequest{})
+	RegisterMessageType(WSMsgScheduleListResponse, ScheduleListResponse{})
+
+	RegisterMessageType(WSMsgScheduleDeleteRequest, ScheduleDeleteRequest{})
+	RegisterMessageType(WSMsgScheduleDeleteResponse, ScheduleDeleteResponse{})
+
+	RegisterMessageType(WSMsgScheduleRunsRequest, ScheduleRunsRequest{})
+	RegisterMessageType(WSMsgScheduleRunsResponse, ScheduleRunsResponse{})
+
+	RegisterMessageType(WSMsgScheduleSyncRequest, ScheduleSyncRequest{})
+	RegisterMessageType(WSMsgScheduleSyncResponse, ScheduleSyncResponse{})
+
+	RegisterMessageType(WSMsgBtrfsMountRequest, BtrfsMountRequest{})
+	RegisterMessageType(WSMsgBtrfsMountResponse, BtrfsMountResponse{})
+
+	RegisterMessageType(WSMsgBtrfsUnmountRequest, BtrfsUnmountRequest{})
+	RegisterMessageType(WSMsgBtrfsUnmountResponse, BtrfsUnmountResponse{})
+
+	RegisterMessageType(WSMsgFstabListRequest, FstabListRequest{})
+	RegisterMessageType(WSMsgFstabListResponse, FstabListResponse{})
+
+	RegisterMessageType(WSMsgFstabEntrySetRequest, FstabEntrySetRequest{})
+	RegisterMessageType(WSMsgFstabEntrySetResponse, FstabEntrySetResponse{})
+
+	RegisterMessageType(WSMsgFstabEntryRemoveRequest, FstabEntryRemoveRequest{})
+	RegisterMessageType(WSMsgFstabEntryRemoveResponse, FstabEntryRemoveResponse{})
+
+	RegisterMessageType(WSMsgFstabDriftRequest, FstabDriftRequest{})
+	RegisterMessageType(WSMsgFstabDriftResponse, FstabDriftResponse{})
+
+	RegisterMessageType(WSMsgTaskStatusNotification, TaskStatusNotification{})
+	RegisterMessageType(WSMsgBtrfsVolumeChangedNotification, BtrfsVolumeChangedNotification{})
+	RegisterMessageType(WSMsgBtrfsStreamChunkNotification, BtrfsStreamChunkNotification{})
+	RegisterMessageType(WSMsgBtrfsStreamAckNotification, BtrfsStreamAckNotification{})
+	RegisterMessageType(WSMsgBtrfsReceiveResultNotification, BtrfsReceiveResultNotification{})
+	RegisterMessageType(WSMsgScheduleSyncNotification, ScheduleSyncNotification{})
+
 	RegisterMessageType(WSMsgError, Error{})
 }
 
@@ -152,13 +422,17 @@ register it in the server tracker*/
 type StorageServerRegistrationRequest struct {
 	BasePayload `json:"-"`
 	ServerName  string `json:"serverName"`
+	//Token is the reconnect token issued at an earlier registration, the storage server gets its ID back with it
+	Token string `json:"token,omitempty"`
 }
 
 /*StorageServerRegistrationResponse represents a request from a storage server to
-register it in the server tracker*/
+register it in the server tracker. The Token is issued when a storage server is
+registered without a known token and has to be kept by the storage server.*/
 type StorageServerRegistrationResponse struct {
 	BasePayload `json:"-"`
 	AssignedID  StorageServerID `json:"assignedID"`
+	Token       string          `json:"token"`
 }
 
 /*BlockDeviceRescanRequest represents a request to the slave to perform a scan
@@ -222,6 +496,8 @@ type BtrfsSubvolumeListRequest struct {
 	BasePayload
 	ServerID   StorageServerID `json:"serverID"`
 	VolumeUUID UUIDType        `json:"volumeUUID"`
+	//WithUsage requests the qgroup usage of every subvolume, if quotas are enabled
+	WithUsage bool `json:"withUsage"`
 }
 
 /*BtrfsSubvolumeListResponse represents a response to the client with the list of
@@ -231,19 +507,25 @@ type BtrfsSubvolumeListResponse struct {
 	Subvolumes []BtrfsSubVolume `json:"subvolumes"`
 }
 
+/*IDContainer should be embedded into requests that are forwarded by the master
+to a storage server.*/
 type IDContainer struct {
 	ServerID StorageServerID `json:"serverID"`
 }
 
-func (i *IDContainer) getServerID() StorageServerID {
+//GetServerID returns the ID of the storage server the request is addressed to
+func (i *IDContainer) GetServerID() StorageServerID {
 	return i.ServerID
 }
 
+/*VolumeUUIDContainer should be embedded into requests that refer to a
+particular btrfs volume.*/
 type VolumeUUIDContainer struct {
 	VolumeUUID UUIDType `json:"volumeUUID"`
 }
 
-func (v *VolumeUUIDContainer) getVolumeUUID() UUIDType {
+//GetVolumeUUID returns the UUID of the volume the request refers to
+func (v *VolumeUUIDContainer) GetVolumeUUID() UUIDType {
 	return v.VolumeUUID
 }
 
@@ -269,25 +551,960 @@ type BtrfsSubvolumeDeleteResponse struct {
 	BasePayload
 }
 
+/*BtrfsSubvolumeSnapshotRequest represents a request from the client to snapshot
+the subvolume at RelativePath to TargetPath. A ReadOnly snapshot cannot be
+modified, which is required to send it. In Recursive mode the nested subvolumes
+are snapshotted into the matching places of the target.*/
 type BtrfsSubvolumeSnapshotRequest struct {
 	BasePayload
 	IDContainer
 	VolumeUUIDContainer
 	RelativePath string
 	TargetPath   string
+	ReadOnly     bool `json:"readOnly"`
+	Recursive    bool `json:"recursive"`
 }
 
 type BtrfsSubvolumeSnapshotResponse struct {
 	BasePayload
 }
 
+/*BtrfsVolumeUsageRequest represents a request from the client to retrieve the
+space usage of a btrfs volume.*/
+type BtrfsVolumeUsageRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+}
+
+/*BtrfsVolumeUsageResponse represents a response to the client with the space
+usage of a btrfs volume, split by block group type and RAID profile.*/
+type BtrfsVolumeUsageResponse struct {
+	BasePayload
+	Usage BtrfsVolumeUsage `json:"usage"`
+}
+
+/*BtrfsDeviceStatsRequest represents a request from the client to retrieve the
+error counters of all devices of a btrfs volume. If Reset is set, the counters
+are zeroed after being read.*/
+type BtrfsDeviceStatsRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	Reset bool `json:"reset"`
+}
+
+/*BtrfsDeviceStatsResponse represents a response to the client with the devices
+of a btrfs volume and their error counters.*/
+type BtrfsDeviceStatsResponse struct {
+	BasePayload
+	BlockDevices []BlockDevice `json:"blockDevices"`
+}
+
+/*TaskIDContainer should be embedded into requests that refer to a task running
+on a storage server.*/
+type TaskIDContainer struct {
+	TaskID tasks.TaskID `json:"taskID"`
+}
+
+/*TaskContainer should be embedded into responses that carry the state of a
+task. The master subscribes the requesting client to the task's notifications.*/
+type TaskContainer struct {
+	Task tasks.Task `json:"task"`
+}
+
+//GetTask returns the task carried by the response
+func (t *TaskContainer) GetTask() tasks.Task {
+	return t.Task
+}
+
+/*TaskStatusRequest represents a request from the client to retrieve the state
+of a task.*/
+type TaskStatusRequest struct {
+	BasePayload
+	IDContainer
+	TaskIDContainer
+}
+
+/*TaskStatusResponse represents a response to the client with the state of a
+task.*/
+type TaskStatusResponse struct {
+	BasePayload
+	TaskContainer
+}
+
+/*TaskPauseRequest represents a request from the client to pause a task.*/
+type TaskPauseRequest struct {
+	BasePayload
+	IDContainer
+	TaskIDContainer
+}
+
+/*TaskPauseResponse represents a response to the client with the state of the
+task after the pause was requested.*/
+type TaskPauseResponse struct {
+	BasePayload
+	TaskContainer
+}
+
+/*TaskResumeRequest represents a request from the client to resume a paused
+task.*/
+type TaskResumeRequest struct {
+	BasePayload
+	IDContainer
+	TaskIDContainer
+}
+
+/*TaskResumeResponse represents a response to the client with the state of the
+resumed task.*/
+type TaskResumeResponse struct {
+	BasePayload
+	TaskContainer
+}
+
+/*TaskCancelRequest represents a request from the client to cancel a running or
+paused task.*/
+type TaskCancelRequest struct {
+	BasePayload
+	IDContainer
+	TaskIDContainer
+}
+
+/*TaskCancelResponse represents a response to the client with the state of the
+task after the cancellation was requested.*/
+type TaskCancelResponse struct {
+	BasePayload
+	TaskContainer
+}
+
+/*TaskStatusNotification is sent by the storage server whenever the state or
+progress of one of its tasks changes. The master passes it on to the clients
+subscribed to the task.*/
+type TaskStatusNotification struct {
+	BasePayload
+	IDContainer
+	TaskContainer
+}
+
+/*BtrfsScrubStartRequest represents a request from the client to start a scrub
+of a btrfs volume.*/
+type BtrfsScrubStartRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	ReadOnly bool `json:"readOnly"`
+}
+
+/*BtrfsScrubStartResponse represents a response to the client with the task
+that runs the scrub. The task's progress is a ScrubProgress.*/
+type BtrfsScrubStartResponse struct {
+	BasePayload
+	TaskContainer
+}
+
+/*BtrfsBalanceStartRequest represents a request from the client to balance a
+btrfs volume. Only the block group types with a filter are balanced, if no
+filter is given all chunks are balanced. Balancing system chunks requires Force.*/
+type BtrfsBalanceStartRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	Data     *BalanceFilter `json:"data,omitempty"`
+	Metadata *BalanceFilter `json:"metadata,omitempty"`
+	System   *BalanceFilter `json:"system,omitempty"`
+	Force    bool           `json:"force"`
+}
+
+/*BtrfsBalanceStartResponse represents a response to the client with the task
+that runs the balance. The task's progress is a BalanceProgress.*/
+type BtrfsBalanceStartResponse struct {
+	BasePayload
+	TaskContainer
+}
+
+/*BtrfsProfileConvertRequest represents a request from the client to convert the
+data and/or metadata of a btrfs volume to another RAID profile. An empty profile
+leaves the block group type untouched. Reducing the redundancy of metadata
+requires Force.*/
+type BtrfsProfileConvertRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	DataProfile     string `json:"dataProfile"`
+	MetadataProfile string `json:"metadataProfile"`
+	Force           bool   `json:"force"`
+}
+
+/*BtrfsProfileConvertResponse represents a response to the client with the
+balance task that performs the conversion.*/
+type BtrfsProfileConvertResponse struct {
+	BasePayload
+	TaskContainer
+}
+
+/*BtrfsDeviceAddRequest represents a request from the client to add block
+devices to a btrfs volume. Devices that are mounted, contain a filesystem or
+hold partitions are refused unless Force is set.*/
+type BtrfsDeviceAddRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	Paths []string `json:"paths"`
+	Force bool     `json:"force"`
+}
+
+/*BtrfsDeviceAddResponse represents a response to the client with the devices
+of the btrfs volume after the addition.*/
+type BtrfsDeviceAddResponse struct {
+	BasePayload
+	BlockDevices []BlockDevice `json:"blockDevices"`
+}
+
+/*BtrfsDeviceRemoveRequest represents a request from the client to remove block
+devices from a btrfs volume.*/
+type BtrfsDeviceRemoveRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	Paths []string `json:"paths"`
+}
+
+/*BtrfsDeviceRemoveResponse represents a response to the client with the task
+that relocates the data off the removed devices. The task's progress is a
+DeviceRemoveProgress.*/
+type BtrfsDeviceRemoveResponse struct {
+	BasePayload
+	TaskContainer
+}
+
+/*UnusedBlockDeviceListRequest represents a request from the client to list the
+block devices of a storage server that can be added to a volume or used as the
+target of a replace.*/
+type UnusedBlockDeviceListRequest struct {
+	BasePayload
+	IDContainer
+}
+
+/*UnusedBlockDeviceListResponse represents a response to the client with the
+block devices that contain no filesystem, are not mounted and hold no partitions.*/
+type UnusedBlockDeviceListResponse struct {
+	BasePayload
+	BlockDevices []BlockDevice `json:"blockDevices"`
+}
+
+/*BtrfsReplaceStartRequest represents a request from the client to replace a
+device of a btrfs volume. Source is the path of the replaced device or, if it is
+missing, its devid. Target is the path of an unused device, a device that is
+mounted or contains a filesystem is only accepted with Force.*/
+type BtrfsReplaceStartRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Force  bool   `json:"force"`
+}
+
+/*BtrfsReplaceStartResponse represents a response to the client with the task
+that runs the replace. The task's progress is a ReplaceProgress.*/
+type BtrfsReplaceStartResponse struct {
+	BasePayload
+	TaskContainer
+}
+
+/*BtrfsVolumeCreatePlanRequest represents a request from the client to plan the
+creation of a btrfs volume. Nothing is written to the devices.*/
+type BtrfsVolumeCreatePlanRequest struct {
+	BasePayload
+	IDContainer
+	Spec BtrfsVolumeCreateSpec `json:"spec"`
+}
+
+/*BtrfsVolumeCreatePlanResponse represents a response to the client with the
+signatures that the creation will wipe and the confirmation token.*/
+type BtrfsVolumeCreatePlanResponse struct {
+	BasePayload
+	Plan BtrfsVolumeCreatePlan `json:"plan"`
+}
+
+/*BtrfsVolumeCreateRequest represents a request from the client to create a btrfs
+volume. The Spec has to be the planned one and Token the plan's confirmation
+token. The creation is refused if the signatures on the devices changed since
+the plan was made.*/
+type BtrfsVolumeCreateRequest struct {
+	BasePayload
+	IDContainer
+	Spec  BtrfsVolumeCreateSpec `json:"spec"`
+	Token string                `json:"token"`
+}
+
+/*BtrfsVolumeCreateResponse represents a response to the client with the created
+btrfs volume.*/
+type BtrfsVolumeCreateResponse struct {
+	BasePayload
+	BtrfsVolume BtrfsVolume `json:"btrfsVolume"`
+}
+
+/*BtrfsDeviceResizeRequest represents a request from the client to grow or shrink
+the device with the given devid of a mounted btrfs volume to Size bytes. If Max
+is set, the device is grown to the size of the underlying block device.*/
+type BtrfsDeviceResizeRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	DevID uint64 `json:"devid"`
+	Size  uint64 `json:"size"`
+	Max   bool   `json:"max"`
+}
+
+/*BtrfsDeviceResizeResponse represents a response to the client with the task
+that resizes the device. The task's progress is a DeviceResizeProgress.*/
+type BtrfsDeviceResizeResponse struct {
+	BasePayload
+	TaskContainer
+}
+
+/*BtrfsVolumeRelabelRequest represents a request from the client to change the
+label of a btrfs volume. Mounted and unmounted volumes can be relabeled.*/
+type BtrfsVolumeRelabelRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	Label string `json:"label"`
+}
+
+/*BtrfsVolumeRelabelResponse represents a response to the client with the
+relabeled btrfs volume.*/
+type BtrfsVolumeRelabelResponse struct {
+	BasePayload
+	BtrfsVolume BtrfsVolume `json:"btrfsVolume"`
+}
+
+/*BtrfsVolumeChangedNotification is sent by the storage server when the
+properties of one of its volumes change. The master updates its inventory and
+passes it on to the clients that listed the server's volumes.*/
+type BtrfsVolumeChangedNotification struct {
+	BasePayload
+	IDContainer
+	BtrfsVolume BtrfsVolume `json:"btrfsVolume"`
+}
+
+/*BtrfsQuotaSetRequest represents a request from the client to enable or disable
+quotas on a btrfs volume.*/
+type BtrfsQuotaSetRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	Enabled bool `json:"enabled"`
+}
+
+/*BtrfsQuotaSetResponse represents a response to the client confirming that
+quotas were enabled or disabled.*/
+type BtrfsQuotaSetResponse struct {
+	BasePayload
+}
+
+/*BtrfsQgroupListRequest represents a request from the client to list the
+qgroups of a btrfs volume.*/
+type BtrfsQgroupListRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+}
+
+/*BtrfsQgroupListResponse represents a response to the client with the qgroups
+of a btrfs volume and their usage.*/
+type BtrfsQgroupListResponse struct {
+	BasePayload
+	Qgroups []Qgroup `json:"qgroups"`
+}
+
+/*BtrfsQgroupLimitRequest represents a request from the client to set the size
+limit of a qgroup. A nil Limit clears the limit. If Exclusive is set, the
+exclusive bytes are limited instead of the referenced bytes.*/
+type BtrfsQgroupLimitRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	QgroupID  string  `json:"qgroupID"`
+	Limit     *uint64 `json:"limit"`
+	Exclusive bool    `json:"exclusive"`
+}
+
+/*BtrfsQgroupLimitResponse represents a response to the client with the qgroup
+after its limit was changed.*/
+type BtrfsQgroupLimitResponse struct {
+	BasePayload
+	Qgroup Qgroup `json:"qgroup"`
+}
+
+/*BtrfsQgroupCreateRequest represents a request from the client to create a
+qgroup of level 1 or higher that groups the member qgroups, for example the
+level 0 qgroups of several subvolumes.*/
+type BtrfsQgroupCreateRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	QgroupID string   `json:"qgroupID"`
+	Members  []string `json:"members"`
+}
+
+/*BtrfsQgroupCreateResponse represents a response to the client with the created
+qgroup.*/
+type BtrfsQgroupCreateResponse struct {
+	BasePayload
+	Qgroup Qgroup `json:"qgroup"`
+}
+
+/*BtrfsQgroupAssignRequest represents a request from the client to add members
+to a qgroup of level 1 or higher, or to remove them if Remove is set.*/
+type BtrfsQgroupAssignRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	QgroupID string   `json:"qgroupID"`
+	Members  []string `json:"members"`
+	Remove   bool     `json:"remove"`
+}
+
+/*BtrfsQgroupAssignResponse represents a response to the client with the qgroup
+after its members changed.*/
+type BtrfsQgroupAssignResponse struct {
+	BasePayload
+	Qgroup Qgroup `json:"qgroup"`
+}
+
+/*BtrfsQgroupDestroyRequest represents a request from the client to destroy a
+qgroup of level 1 or higher.*/
+type BtrfsQgroupDestroyRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	QgroupID string `json:"qgroupID"`
+}
+
+/*BtrfsQgroupDestroyResponse represents a response to the client confirming the
+destruction of the qgroup.*/
+type BtrfsQgroupDestroyResponse struct {
+	BasePayload
+}
+
+/*BtrfsPropertyGetRequest represents a request from the client to retrieve the
+properties of a subvolume: ro and compression. The label of the volume is
+included if RelativePath refers to the top level subvolume.*/
+type BtrfsPropertyGetRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	RelativePath string `json:"relativePath"`
+}
+
+/*BtrfsPropertyGetResponse represents a response to the client with the
+properties of a subvolume.*/
+type BtrfsPropertyGetResponse struct {
+	BasePayload
+	Properties map[string]string `json:"properties"`
+}
+
+/*BtrfsPropertySetRequest represents a request from the client to set properties
+of a subvolume. An empty compression value disables compression. The label can
+only be set on the top level subvolume.*/
+type BtrfsPropertySetRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	RelativePath string            `json:"relativePath"`
+	Properties   map[string]string `json:"properties"`
+}
+
+/*BtrfsPropertySetResponse represents a response to the client with the
+properties of the subvolume after the change.*/
+type BtrfsPropertySetResponse struct {
+	BasePayload
+	Properties map[string]string `json:"properties"`
+}
+
+/*BtrfsSubvolumeSetDefaultRequest represents a request from the client to set
+the subvolume that is mounted when no subvolume is given in the mount options.*/
+type BtrfsSubvolumeSetDefaultRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	SubVolID int `json:"subVolID"`
+}
+
+/*BtrfsSubvolumeSetDefaultResponse represents a response to the client
+confirming the change of the default subvolume.*/
+type BtrfsSubvolumeSetDefaultResponse struct {
+	BasePayload
+}
+
+/*ReplicationStartRequest represents a request from the client to replicate the
+read-only snapshot at SourcePath to the directory TargetPath on a volume of
+another storage server. If an earlier snapshot of the same replication is still
+present on both servers, only the changes since that snapshot are sent.*/
+type ReplicationStartRequest struct {
+	BasePayload
+	SourceServerID   StorageServerID `json:"sourceServerID"`
+	SourceVolumeUUID UUIDType        `json:"sourceVolumeUUID"`
+	SourcePath       string          `json:"sourcePath"`
+	TargetServerID   StorageServerID `json:"targetServerID"`
+	TargetVolumeUUID UUIDType        `json:"targetVolumeUUID"`
+	TargetPath       string          `json:"targetPath"`
+}
+
+/*ReplicationStartResponse represents a response to the client with the task
+of the replication. The task is run by the master, its server ID is
+MasterServerID.*/
+type ReplicationStartResponse struct {
+	BasePayload
+	TaskContainer
+}
+
+/*ReplicationListRequest represents a request from the client to retrieve the
+replications tracked by the master.*/
+type ReplicationListRequest struct {
+	BasePayload
+}
+
+/*ReplicationListResponse represents a response to the client with the
+replications and the snapshots replicated so far.*/
+type ReplicationListResponse struct {
+	BasePayload
+	Replications []Replication `json:"replications"`
+}
+
+/*StreamIDContainer should be embedded into messages that belong to a stream
+relayed by the master.*/
+type StreamIDContainer struct {
+	StreamID StreamID `json:"streamID"`
+}
+
+/*BtrfsSendStartRequest represents a request from the master to start a btrfs
+send of the read-only snapshot at RelativePath. If ParentPath is set, only the
+changes since the parent snapshot are sent. The stream is sent to the master in
+BtrfsStreamChunkNotifications.*/
+type BtrfsSendStartRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	StreamIDContainer
+	RelativePath string `json:"relativePath"`
+	ParentPath   string `json:"parentPath"`
+}
+
+/*BtrfsSendStartResponse represents a response to the master confirming that
+the send started.*/
+type BtrfsSendStartResponse struct {
+	BasePayload
+}
+
+/*BtrfsReceiveStartRequest represents a request from the master to start a
+btrfs receive into the directory TargetPath. The received subvolume is named
+Name and its received UUID is SourceUUID, the StreamUUID of the sent snapshot.*/
+type BtrfsReceiveStartRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	StreamIDContainer
+	TargetPath string   `json:"targetPath"`
+	Name       string   `json:"name"`
+	SourceUUID UUIDType `json:"sourceUUID"`
+}
+
+/*BtrfsReceiveStartResponse represents a response to the master confirming that
+the receive is ready for the stream.*/
+type BtrfsReceiveStartResponse struct {
+	BasePayload
+}
+
+/*BackupStartRequest represents a request from the client to back up the
+read-only snapshot at RelativePath to the backup target directory TargetDir on
+the storage server. The stream is incremental if an earlier snapshot of the
+same subvolume was backed up to the target, unless Full is set. The target
+directories of all the backup messages have to be inside the backup directory
+configured on the storage server, a relative one is taken relative to it.*/
+type BackupStartRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	RelativePath string `json:"relativePath"`
+	TargetDir    string `json:"targetDir"`
+	Full         bool   `json:"full"`
+}
+
+/*BackupStartResponse represents a response to the client with the task of the
+backup.*/
+type BackupStartResponse struct {
+	BasePayload
+	TaskContainer
+}
+
+/*BackupCatalogRequest represents a request from the client to retrieve the
+catalog of the streams stored in a backup target directory.*/
+type BackupCatalogRequest struct {
+	BasePayload
+	IDContainer
+	TargetDir string `json:"targetDir"`
+}
+
+/*BackupCatalogResponse represents a response to the client with the streams
+stored in a backup target directory.*/
+type BackupCatalogResponse struct {
+	BasePayload
+	Streams []BackupStream `json:"streams"`
+}
+
+/*BackupVerifyRequest represents a request from the client to verify the
+checksums and the dependencies of the streams of a backup chain.*/
+type BackupVerifyRequest struct {
+	BasePayload
+	IDContainer
+	TargetDir string `json:"targetDir"`
+	Chain     string `json:"chain"`
+}
+
+/*BackupVerifyResponse represents a response to the client with the task of the
+verification. The failed streams are reported in its progress.*/
+type BackupVerifyResponse struct {
+	BasePayload
+	TaskContainer
+}
+
+/*BackupPruneRequest represents a request from the client to delete the oldest
+chains of a backup target directory, keeping KeepChains chains of every
+subvolume. If DryRun is set nothing is deleted.*/
+type BackupPruneRequest struct {
+	BasePayload
+	IDContainer
+	TargetDir  string `json:"targetDir"`
+	KeepChains int    `json:"keepChains"`
+	DryRun     bool   `json:"dryRun"`
+}
+
+/*BackupPruneResponse represents a response to the client with the streams that
+were deleted, or would be deleted in a dry run.*/
+type BackupPruneResponse struct {
+	BasePayload
+	Removed []BackupStream `json:"removed"`
+}
+
+/*BackupRestoreRequest represents a request from the client to restore the
+snapshot of the catalog entry BackupStreamID of the backup target directory.
+The full stream of its chain and the incremental streams leading to it are
+received into the directory TargetPath of the volume. If Writable is set the
+restored subvolume is made writable, if MountPath is set it is mounted there
+with MountOptions in place of the subvolume mounted before.*/
+type BackupRestoreRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	TargetDir      string   `json:"targetDir"`
+	BackupStreamID string   `json:"backupStreamID"`
+	TargetPath     string   `json:"targetPath"`
+	Writable       bool     `json:"writable"`
+	MountPath      string   `json:"mountPath"`
+	MountOptions   []string `json:"mountOptions,omitempty"`
+}
+
+/*BackupRestoreResponse represents a response to the client with the task of the
+restore. The state of every stream is reported in its progress.*/
+type BackupRestoreResponse struct {
+	BasePayload
+	TaskContainer
+}
+
+/*RetentionPolicySetRequest represents a request from the client to store a
+retention policy on the master. A policy without an ID is added, otherwise the
+policy with the ID is replaced.*/
+type RetentionPolicySetRequest struct {
+	BasePayload
+	Policy RetentionPolicy `json:"policy"`
+}
+
+/*RetentionPolicySetResponse represents a response to the client with the stored
+policy.*/
+type RetentionPolicySetResponse struct {
+	BasePayload
+	Policy RetentionPolicy `json:"policy"`
+}
+
+/*RetentionPolicyListRequest represents a request from the client to retrieve
+the retention policies stored on the master.*/
+type RetentionPolicyListRequest struct {
+	BasePayload
+}
+
+/*RetentionPolicyListResponse represents a response to the client with the
+retention policies.*/
+type RetentionPolicyListResponse struct {
+	BasePayload
+	Policies []RetentionPolicy `json:"policies"`
+}
+
+/*RetentionPolicyDeleteRequest represents a request from the client to delete a
+retention policy. The snapshots of its subvolumes are not affected.*/
+type RetentionPolicyDeleteRequest struct {
+	BasePayload
+	PolicyID string `json:"policyID"`
+}
+
+/*RetentionPolicyDeleteResponse represents a response to the client if the
+policy was deleted.*/
+type RetentionPolicyDeleteResponse struct {
+	BasePayload
+}
+
+/*RetentionApplyRequest represents a request from the client to apply a
+retention policy, the snapshots it does not keep are deleted. In a DryRun
+nothing is deleted.*/
+type RetentionApplyRequest struct {
+	BasePayload
+	PolicyID string `json:"policyID"`
+	DryRun   bool   `json:"dryRun"`
+}
+
+/*RetentionApplyResponse represents a response to the client with the decision
+made about every snapshot the policy applies to.*/
+type RetentionApplyResponse struct {
+	BasePayload
+	Decisions []RetentionDecision `json:"decisions"`
+}
+
+/*ScheduleSetRequest represents a request from the client to store a schedule
+on the master. A schedule without an ID is added, otherwise the schedule with
+the ID is replaced. Runs that were due before the schedule was stored are not
+caught up.*/
+type ScheduleSetRequest struct {
+	BasePayload
+	Schedule Schedule `json:"schedule"`
+}
+
+/*ScheduleSetResponse represents a response to the client with the stored
+schedule.*/
+type ScheduleSetResponse struct {
+	BasePayload
+	Schedule Schedule `json:"schedule"`
+}
+
+/*ScheduleListRequest represents a request from the client to retrieve the
+schedules stored on the master.*/
+type ScheduleListRequest struct {
+	BasePayload
+}
+
+/*ScheduleListResponse represents a response to the client with the schedules.*/
+type ScheduleListResponse struct {
+	BasePayload
+	Schedules []Schedule `json:"schedules"`
+}
+
+/*ScheduleDeleteRequest represents a request from the client to delete a
+schedule. Its recorded runs are kept.*/
+type ScheduleDeleteRequest struct {
+	BasePayload
+	ScheduleID string `json:"scheduleID"`
+}
+
+/*ScheduleDeleteResponse represents a response to the client if the schedule
+was deleted.*/
+type ScheduleDeleteResponse struct {
+	BasePayload
+}
+
+/*ScheduleRunsRequest represents a request from the client to retrieve the most
+recent Limit runs of a schedule.*/
+type ScheduleRunsRequest struct {
+	BasePayload
+	ScheduleID string `json:"scheduleID"`
+	Limit      int    `json:"limit"`
+}
+
+/*ScheduleRunsResponse represents a response to the client with the runs of the
+schedule, newest first.*/
+type ScheduleRunsResponse struct {
+	BasePayload
+	Runs []ScheduleRun `json:"runs"`
+}
+
+/*ScheduleSyncRequest represents a request from a storage server to the master,
+sent whenever it connects. Runs are the runs of schedules the storage server ran
+on its own while it was disconnected.*/
+type ScheduleSyncRequest struct {
+	BasePayload
+	Runs []ScheduleRun `json:"runs"`
+}
+
+/*ScheduleSyncResponse represents a response to the storage server with the
+schedules of its volumes, once the uploaded runs were recorded.*/
+type ScheduleSyncResponse struct {
+	BasePayload
+	Schedules []Schedule `json:"schedules"`
+}
+
+/*BtrfsMountRequest represents a request from the client to mount a subvolume
+of the volume at MountPath. Subvolume is the path of the subvolume relative to
+the top level of the volume, the default subvolume is mounted if it is empty.
+Options are the mount options, e.g. "compress=zstd:3" or "noatime".*/
+type BtrfsMountRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	Subvolume string   `json:"subvolume,omitempty"`
+	MountPath string   `json:"mountPath"`
+	Options   []string `json:"options,omitempty"`
+	//Persist adds a managed entry for the mount to the fstab
+	Persist bool `json:"persist,omitempty"`
+}
+
+/*BtrfsMountResponse represents a response to the client containing the mount
+point as listed by the storage server.*/
+type BtrfsMountResponse struct {
+	BasePayload
+	MountPoint MountPoint `json:"mountPoint"`
+}
+
+/*BtrfsUnmountRequest represents a request from the client to unmount the file
+system mounted at MountPath. A lazy unmount detaches the mount point even if it
+is busy.*/
+type BtrfsUnmountRequest struct {
+	BasePayload
+	IDContainer
+	MountPath string `json:"mountPath"`
+	Lazy      bool   `json:"lazy,omitempty"`
+	//Persist removes the managed fstab entry of the mount as well, if there is one
+	Persist bool `json:"persist,omitempty"`
+}
+
+/*BtrfsUnmountResponse represents a response to the client confirming the
+unmount.*/
+type BtrfsUnmountResponse struct {
+	BasePayload
+}
+
+/*FstabListRequest represents a request from the client to list the fstab
+entries and the btrfs mounts of a storage server.*/
+type FstabListRequest struct {
+	BasePayload
+	IDContainer
+}
+
+/*FstabListResponse represents a response to the client containing the fstab
+entries and the live btrfs mounts, without the root mounts of the volumes.*/
+type FstabListResponse struct {
+	BasePayload
+	Entries []MountEntry `json:"entries"`
+	Mounts  []MountEntry `json:"mounts"`
+}
+
+/*FstabEntrySetRequest represents a request from the client to add or update the
+managed fstab entry mounting the subvolume of the volume at MountPath.*/
+type FstabEntrySetRequest struct {
+	BasePayload
+	IDContainer
+	VolumeUUIDContainer
+	Subvolume string   `json:"subvolume,omitempty"`
+	MountPath string   `json:"mountPath"`
+	Options   []string `json:"options,omitempty"`
+}
+
+/*FstabEntrySetResponse represents a response to the client containing the
+written fstab entry.*/
+type FstabEntrySetResponse struct {
+	BasePayload
+	Entry MountEntry `json:"entry"`
+}
+
+/*FstabEntryRemoveRequest represents a request from the client to remove the
+managed fstab entry of MountPath.*/
+type FstabEntryRemoveRequest struct {
+	BasePayload
+	IDContainer
+	MountPath string `json:"mountPath"`
+}
+
+/*FstabEntryRemoveResponse represents a response to the client confirming the
+removal of the fstab entry.*/
+type FstabEntryRemoveResponse struct {
+	BasePayload
+}
+
+/*FstabDriftRequest represents a request from the client to compare the btrfs
+entries of the fstab of a storage server with its live mounts.*/
+type FstabDriftRequest struct {
+	BasePayload
+	IDContainer
+}
+
+/*FstabDriftResponse represents a response to the client listing the
+differences between the fstab and the live mounts.*/
+type FstabDriftResponse struct {
+	BasePayload
+	Drift []FstabDrift `json:"drift"`
+}
+
+/*BtrfsStreamChunkNotification carries a part of a send stream from the sending
+storage server to the master, which relays it to the receiving one. The last
+chunk has EOF set, Error is set if the send failed.*/
+type BtrfsStreamChunkNotification struct {
+	BasePayload
+	StreamIDContainer
+	Seq   uint64 `json:"seq"`
+	Data  []byte `json:"data"`
+	EOF   bool   `json:"eof"`
+	Error string `json:"error,omitempty"`
+}
+
+/*BtrfsStreamAckNotification confirms that the receiving storage server wrote
+the chunk Seq, the master relays it to the sending one. If Abort is set the
+receive failed and the send has to stop.*/
+type BtrfsStreamAckNotification struct {
+	BasePayload
+	StreamIDContainer
+	Seq   uint64 `json:"seq"`
+	Abort bool   `json:"abort"`
+	Error string `json:"error,omitempty"`
+}
+
+/*BtrfsReceiveResultNotification represents a notification from the receiving
+storage server that the receive finished. Subvolume is the received snapshot,
+it is nil if Error is set.*/
+type BtrfsReceiveResultNotification struct {
+	BasePayload
+	StreamIDContainer
+	Subvolume *BtrfsSubVolume `json:"subvolume,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+/*ScheduleSyncNotification is sent by the master to a storage server whenever
+the schedules of its volumes change. The storage server runs them on its own
+while it is disconnected from the master.*/
+type ScheduleSyncNotification struct {
+	BasePayload
+	Schedules []Schedule `json:"schedules"`
+}
+
 /*Error represents an error that occured in the higher layers and is supposed
 to be sent to the client. The subsystem string indicates which entity emitted
 the error.*/
 type Error struct {
 	BasePayload `json:"-"`
-	Subsystem   string `json:"subsystem"`
-	Details     string `json:"details"`
+	Subsystem   string       `json:"subsystem"`
+	Details     string       `json:"details"`
+	Code        string       `json:"code,omitempty"`
+	Causes      []ErrorCause `json:"causes,omitempty"`
+}
+
+/*ErrorCause describes one of the reasons a request was refused. Required and
+Available are set when the cause is a missing resource (devices, bytes), PID and
+Command when it is a process.*/
+type ErrorCause struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Required  uint64 `json:"required,omitempty"`
+	Available uint64 `json:"available,omitempty"`
+	PID       int    `json:"pid,omitempty"`
+	Command   string `json:"command,omitempty"`
 }
 
 func (e Error) Error() string {
@@ -30,6 +30,7 @@ type Context struct {
 	requestsMtx   sync.Mutex
 	requests      map[int64]chan<- dtos.WebSocketMessage
 	nextRequestID int64
+	closed        bool
 }
 
 //GetSessionData retrieves a value from this session context
@@ -51,7 +52,8 @@ func (c *Context) SetSessionData(key string, data interface{}) {
 
 /*NewRequest registers a new request to be sent. The returned channel is used to
 receive the incoming response. The ID returned from this function has to be used
-as the value for WebSocketMessage.RequestID. */
+as the value for WebSocketMessage.RequestID. If the connection is already closed,
+the returned channel is closed as well. */
 func (c *Context) NewRequest() (int64, <-chan dtos.WebSocketMessage) {
 	responseChannel := make(chan dtos.WebSocketMessage, 1)
 
@@ -59,6 +61,10 @@ func (c *Context) NewRequest() (int64, <-chan dtos.WebSocketMessage) {
 	defer c.requestsMtx.Unlock()
 	requestID := c.nextRequestID
 	c.nextRequestID++
+	if c.closed {
+		close(responseChannel)
+		return requestID, responseChannel
+	}
 	c.requests[requestID] = responseChannel
 	return requestID, responseChannel
 }
@@ -84,6 +90,7 @@ func (c *Context) OnClose() {
 		close(responseChannel)
 	}
 	c.requests = nil
+	c.closed = true
 }
 
 //NewContext constructs a new valid Context object
@@ -0,0 +1,150 @@
+package schedules
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//cronSearchLimit bounds the search of the next match of an expression that never matches, e.g. on February 30th
+const cronSearchLimit = 5
+
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+//cronField is the bit set of the values matched by a field of a cron expression
+type cronField uint64
+
+func (f cronField) has(value int) bool {
+	return f&(1<<uint(value)) != 0
+}
+
+/*Cron is a parsed cron expression with the fields minute, hour, day of
+month, month and day of week. As in cron, a day matches either of the day fields
+if both are restricted.*/
+type Cron struct {
+	minute, hour, dom, month, dow cronField
+	domRestricted, dowRestricted  bool
+}
+
+/*parseCronField parses a comma separated list of values, ranges and *, each
+optionally followed by a /step.*/
+func parseCronField(field string, min int, max int) (cronField, error) {
+	var set cronField
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, errors.New("Invalid step in cron field: " + field)
+			}
+			part = part[:i]
+		}
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			low, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, errors.New("Invalid value in cron field: " + field)
+			}
+			high = low
+			if len(bounds) == 2 {
+				high, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return 0, errors.New("Invalid range in cron field: " + field)
+				}
+			} else if step > 1 {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, errors.New("Value out of range in cron field: " + field)
+		}
+		for value := low; value <= high; value += step {
+			set |= 1 << uint(value)
+		}
+	}
+	return set, nil
+}
+
+//ParseCron parses a five field cron expression or one of the @hourly, @daily, @weekly, @monthly and @yearly macros
+func ParseCron(expr string) (*Cron, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New("A cron expression needs 5 fields: " + expr)
+	}
+	var c Cron
+	var err error
+	ranges := []struct {
+		field    *cronField
+		min, max int
+	}{
+		{&c.minute, 0, 59},
+		{&c.hour, 0, 23},
+		{&c.dom, 1, 31},
+		{&c.month, 1, 12},
+		{&c.dow, 0, 7},
+	}
+	for i, r := range ranges {
+		*r.field, err = parseCronField(fields[i], r.min, r.max)
+		if err != nil {
+			return nil, err
+		}
+	}
+	//Sunday is both 0 and 7
+	if c.dow.has(7) {
+		c.dow |= 1
+	}
+	c.domRestricted = !strings.HasPrefix(fields[2], "*")
+	c.dowRestricted = !strings.HasPrefix(fields[4], "*")
+	return &c, nil
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom.has(t.Day())
+	dow := c.dow.has(int(t.Weekday()))
+	if c.domRestricted && c.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+/*Next returns the first time after t the schedule matches, in the location of
+t. The zero time is returned if it does not match within cronSearchLimit years.
+Times skipped by a daylight saving change do not match.*/
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	limit := t.AddDate(cronSearchLimit, 0, 0)
+	for t.Before(limit) {
+		var next time.Time
+		switch {
+		case !c.month.has(int(t.Month())):
+			next = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case !c.hour.has(t.Hour()):
+			next = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case !c.minute.has(t.Minute()):
+			next = t.Add(time.Minute)
+		default:
+			return t
+		}
+		if !next.After(t) {
+			next = t.Add(time.Minute)
+		}
+		t = next
+	}
+	return time.Time{}
+}
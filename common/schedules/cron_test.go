@@ -0,0 +1,78 @@
+package schedules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCronField(t *testing.T) {
+	set, err := parseCronField("1,5-7,*/20", 0, 59)
+	assert.NoError(t, err)
+	for _, value := range []int{0, 1, 5, 6, 7, 20, 40} {
+		assert.True(t, set.has(value), "%d", value)
+	}
+	assert.False(t, set.has(2))
+	assert.False(t, set.has(59))
+
+	set, err = parseCronField("10/25", 0, 59)
+	assert.NoError(t, err)
+	assert.Equal(t, cronField(1<<10|1<<35), set)
+
+	for _, field := range []string{"", "60", "5-1", "*/0", "a", "1-b"} {
+		_, err = parseCronField(field, 0, 59)
+		assert.Error(t, err, field)
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	_, err := ParseCron("* * * *")
+	assert.Error(t, err)
+
+	c, err := ParseCron("@weekly")
+	assert.NoError(t, err)
+	assert.True(t, c.dow.has(0))
+	assert.True(t, c.dowRestricted)
+	assert.False(t, c.domRestricted)
+
+	c, err = ParseCron("0 0 * * 7")
+	assert.NoError(t, err)
+	assert.True(t, c.dow.has(0))
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(month time.Month, day int, hour int, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	start := at(time.October, 18, 10, 30)
+
+	c, _ := ParseCron("*/15 * * * *")
+	assert.Equal(t, at(time.October, 18, 10, 45), c.Next(start))
+	assert.Equal(t, at(time.October, 18, 10, 45), c.Next(start.Add(5*time.Second)))
+
+	c, _ = ParseCron("0 3 * * *")
+	assert.Equal(t, at(time.October, 19, 3, 0), c.Next(start))
+
+	//either the 1st or a Monday
+	c, _ = ParseCron("0 0 1 * 1")
+	assert.Equal(t, at(time.October, 19, 0, 0), c.Next(start))
+	assert.Equal(t, at(time.November, 1, 0, 0), c.Next(at(time.October, 26, 0, 0)))
+
+	c, _ = ParseCron("30 12 * 2 *")
+	assert.Equal(t, time.Date(2027, time.February, 1, 12, 30, 0, 0, time.UTC), c.Next(start))
+
+	c, _ = ParseCron("0 0 30 2 *")
+	assert.True(t, c.Next(start).IsZero())
+}
+
+func TestCronNextDaylightSaving(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Warsaw")
+	if err != nil {
+		t.Skip("no timezone database")
+	}
+	c, _ := ParseCron("30 2 * * *")
+	//2:30 does not exist on March 29th
+	next := c.Next(time.Date(2026, time.March, 28, 12, 0, 0, 0, loc))
+	assert.Equal(t, time.Date(2026, time.March, 30, 2, 30, 0, 0, loc), next)
+}
@@ -0,0 +1,127 @@
+package schedules
+
+import (
+	"errors"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+)
+
+const (
+	//MissedRunGrace is how late a run may be started before it counts as missed
+	MissedRunGrace = 2 * time.Minute
+	//MaxCatchUpRuns limits the missed runs of a schedule that are run with CatchUpAll
+	MaxCatchUpRuns = 10
+	/*snapshotTimeFormat is appended to the name of a scheduled snapshot. The UTC
+	offset tells apart the runs at the same local time when the clocks go back.*/
+	snapshotTimeFormat = "2006-01-02_1504-0700"
+)
+
+var (
+	//ErrUnknownAction indicates that the action of a schedule is not supported
+	ErrUnknownAction = errors.New("Unknown schedule action")
+	//ErrUnknownCatchUp indicates that the catch-up policy of a schedule is not supported
+	ErrUnknownCatchUp = errors.New("Unknown catch-up policy")
+	//ErrMissingSubvolume indicates that the action needs a subvolume and a snapshot directory
+	ErrMissingSubvolume = errors.New("The action needs a subvolume and a snapshot directory")
+	//ErrBackupDirNotAbsolute indicates that the backup directory is not an absolute path
+	ErrBackupDirNotAbsolute = errors.New("The backup directory has to be an absolute path")
+	//ErrInvalidBalanceUsage indicates that the balance usage is not a percentage
+	ErrInvalidBalanceUsage = errors.New("The balance usage has to be between 0 and 100")
+)
+
+//Validate checks the cron expression, the timezone and the parameters of the action
+func Validate(schedule dtos.Schedule) error {
+	if _, err := ParseCron(schedule.Cron); err != nil {
+		return err
+	}
+	if _, err := time.LoadLocation(schedule.Timezone); err != nil {
+		return err
+	}
+	switch schedule.Action {
+	case dtos.ScheduleActionScrub:
+	case dtos.ScheduleActionBalance:
+		if schedule.BalanceUsage < 0 || schedule.BalanceUsage > 100 {
+			return ErrInvalidBalanceUsage
+		}
+	case dtos.ScheduleActionSnapshot, dtos.ScheduleActionBackup:
+		if schedule.Subvolume == "" || schedule.SnapshotDir == "" {
+			return ErrMissingSubvolume
+		}
+		if schedule.Action == dtos.ScheduleActionBackup && !filepath.IsAbs(schedule.BackupDir) {
+			return ErrBackupDirNotAbsolute
+		}
+	default:
+		return ErrUnknownAction
+	}
+	switch schedule.CatchUp {
+	case "", dtos.CatchUpSkip, dtos.CatchUpOnce, dtos.CatchUpAll:
+		return nil
+	default:
+		return ErrUnknownCatchUp
+	}
+}
+
+//Parse parses the cron expression and loads the timezone of the schedule
+func Parse(cronExpr string, timezone string) (*Cron, *time.Location, error) {
+	cron, err := ParseCron(cronExpr)
+	if err != nil {
+		return nil, nil, err
+	}
+	loc, err := time.LoadLocation(timezone)
+	return cron, loc, err
+}
+
+/*DueRuns returns the times the schedule matched after last and until now, at
+most the latest limit ones, and the number of all of them.*/
+func DueRuns(cron *Cron, last time.Time, now time.Time, limit int) ([]time.Time, int) {
+	var due []time.Time
+	total := 0
+	for t := cron.Next(last); !t.IsZero() && !t.After(now); t = cron.Next(t) {
+		total++
+		due = append(due, t)
+		if len(due) > limit {
+			due = due[1:]
+		}
+	}
+	return due, total
+}
+
+/*PlanCatchUp decides which of the due runs are started. Runs later than
+MissedRunGrace were missed, what happens to them depends on the catch-up
+policy. The latest due runs are passed in due, total counts all of them. The
+number of skipped runs is returned as well.*/
+func PlanCatchUp(due []time.Time, total int, now time.Time, policy string) ([]time.Time, int) {
+	var missed, current []time.Time
+	for _, t := range due {
+		if now.Sub(t) > MissedRunGrace {
+			missed = append(missed, t)
+		} else {
+			current = append(current, t)
+		}
+	}
+	missedTotal := total - len(current)
+
+	switch policy {
+	case dtos.CatchUpOnce:
+		if len(current) == 0 && len(missed) > 0 {
+			return missed[len(missed)-1:], missedTotal - 1
+		}
+	case dtos.CatchUpAll:
+		if len(missed) > MaxCatchUpRuns {
+			missed = missed[len(missed)-MaxCatchUpRuns:]
+		}
+		return append(missed, current...), missedTotal - len(missed)
+	}
+	return current, missedTotal
+}
+
+/*SnapshotPath returns the path of the snapshot of the subvolume taken by a run
+scheduled at t. The master and the storage servers name the snapshots alike.*/
+func SnapshotPath(subvolume string, snapshotDir string, t time.Time) string {
+	name := path.Base(path.Clean("/" + subvolume))
+	return strings.TrimPrefix(path.Join(snapshotDir, name+"@"+t.Format(snapshotTimeFormat)), "/")
+}
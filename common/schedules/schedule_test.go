@@ -0,0 +1,83 @@
+package schedules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSchedule(t *testing.T) {
+	snapshot := dtos.Schedule{Cron: "@daily", Action: dtos.ScheduleActionSnapshot, Subvolume: "home", SnapshotDir: "snapshots"}
+	assert.NoError(t, Validate(snapshot))
+
+	backup := snapshot
+	backup.Action = dtos.ScheduleActionBackup
+	assert.Equal(t, ErrBackupDirNotAbsolute, Validate(backup))
+	backup.BackupDir = "/mnt/backup"
+	backup.CatchUp = dtos.CatchUpAll
+	backup.Timezone = "UTC"
+	assert.NoError(t, Validate(backup))
+
+	invalid := []dtos.Schedule{
+		{Cron: "* * *", Action: dtos.ScheduleActionScrub},
+		{Cron: "@daily", Action: dtos.ScheduleActionScrub, Timezone: "Nowhere/Nothing"},
+		{Cron: "@daily", Action: "defragment"},
+		{Cron: "@daily", Action: dtos.ScheduleActionSnapshot, Subvolume: "home"},
+		{Cron: "@daily", Action: dtos.ScheduleActionBalance, BalanceUsage: 101},
+		{Cron: "@daily", Action: dtos.ScheduleActionScrub, CatchUp: "sometimes"},
+	}
+	for _, schedule := range invalid {
+		assert.Error(t, Validate(schedule), "%v", schedule)
+	}
+}
+
+func TestDueRuns(t *testing.T) {
+	c, _ := ParseCron("0 * * * *")
+	last := time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	due, total := DueRuns(c, last, last.Add(5*time.Hour+30*time.Minute), 2)
+	assert.Equal(t, 5, total)
+	assert.Equal(t, []time.Time{last.Add(4 * time.Hour), last.Add(5 * time.Hour)}, due)
+
+	due, total = DueRuns(c, last, last.Add(30*time.Minute), 2)
+	assert.Equal(t, 0, total)
+	assert.Empty(t, due)
+}
+
+func TestPlanCatchUp(t *testing.T) {
+	now := time.Date(2026, time.October, 18, 12, 0, 30, 0, time.UTC)
+	missed := []time.Time{now.Add(-3 * time.Hour), now.Add(-2 * time.Hour), now.Add(-time.Hour)}
+	current := now.Add(-30 * time.Second)
+	withCurrent := append(append([]time.Time{}, missed...), current)
+
+	started, skipped := PlanCatchUp(withCurrent, 4, now, dtos.CatchUpSkip)
+	assert.Equal(t, []time.Time{current}, started)
+	assert.Equal(t, 3, skipped)
+
+	started, skipped = PlanCatchUp(missed, 3, now, dtos.CatchUpSkip)
+	assert.Empty(t, started)
+	assert.Equal(t, 3, skipped)
+
+	started, skipped = PlanCatchUp(missed, 3, now, dtos.CatchUpOnce)
+	assert.Equal(t, missed[2:], started)
+	assert.Equal(t, 2, skipped)
+
+	started, skipped = PlanCatchUp(withCurrent, 4, now, dtos.CatchUpOnce)
+	assert.Equal(t, []time.Time{current}, started)
+	assert.Equal(t, 3, skipped)
+
+	started, skipped = PlanCatchUp(withCurrent, 20, now, dtos.CatchUpAll)
+	assert.Equal(t, withCurrent, started)
+	assert.Equal(t, 16, skipped)
+}
+
+func TestSnapshotPath(t *testing.T) {
+	at := time.Date(2026, time.October, 18, 9, 5, 0, 0, time.UTC)
+	assert.Equal(t, "snapshots/home@2026-10-18_0905+0000", SnapshotPath("/data/home/", "/snapshots", at))
+
+	summer := time.Date(2026, time.October, 25, 2, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	winter := time.Date(2026, time.October, 25, 2, 30, 0, 0, time.FixedZone("CET", 60*60))
+	assert.Equal(t, "home@2026-10-25_0230+0200", SnapshotPath("home", "", summer))
+	assert.Equal(t, "home@2026-10-25_0230+0100", SnapshotPath("home", "", winter))
+}
@@ -1,8 +1,36 @@
 package tasks
 
+import "time"
+
 //TaskID represents the unique ID of a task
 type TaskID uint64
 
+//State represents the lifecycle state of a task
+type State string
+
+//State values
+const (
+	StateRunning   State = "running"
+	StatePaused    State = "paused"
+	StateFinished  State = "finished"
+	StateFailed    State = "failed"
+	StateCancelled State = "cancelled"
+)
+
+//IsActive reports whether a task in this state may still make progress
+func (s State) IsActive() bool {
+	return s == StateRunning || s == StatePaused
+}
+
 //Task represents an asynchronous server task
 type Task struct {
+	ID        TaskID    `json:"id"`
+	Kind      string    `json:"kind"`
+	Target    string    `json:"target"`
+	State     State     `json:"state"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	Error     string    `json:"error,omitempty"`
+	//Progress contains the operation-specific progress report
+	Progress interface{} `json:"progress,omitempty"`
 }
@@ -0,0 +1,318 @@
+package tasks
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+/*maxEndedTasks is how many of the tasks that ended are kept, so that their
+outcome can still be queried. The oldest ones are removed first.*/
+const maxEndedTasks = 100
+
+var (
+	//ErrTaskNotFound indicates that there is no task with the requested ID
+	ErrTaskNotFound = errors.New("Task not found")
+	//ErrAlreadyRunning indicates that an active task of the same kind already
+	//exists for the target
+	ErrAlreadyRunning = errors.New("A task of this kind is already active for the target")
+	//ErrNotSupported indicates that the operation does not support the action
+	ErrNotSupported = errors.New("Action not supported by the task")
+	//ErrInvalidState indicates that the action is not allowed in the task's state
+	ErrInvalidState = errors.New("Action not allowed in the current task state")
+)
+
+/*Operation is the operation-specific part of a task. Run blocks until the
+operation finishes or is paused or cancelled.*/
+type Operation interface {
+	Run() error
+	Progress() (interface{}, error)
+}
+
+/*PausableOperation is an Operation that can be paused. Pause must make a
+blocking Run or Resume call return, Resume blocks just like Run.*/
+type PausableOperation interface {
+	Operation
+	Pause() error
+	Resume() error
+}
+
+/*CancellableOperation is an Operation that can be cancelled. Cancel must make a
+blocking Run or Resume call return. It may be called on a paused operation.*/
+type CancellableOperation interface {
+	Operation
+	Cancel() error
+}
+
+//NotifyFunc is called whenever the state or the progress of a task changes
+type NotifyFunc func(Task)
+
+/*Tracker runs operations as tasks and tracks their state. Only the most recent
+tasks that ended are kept. All methods are thread-safe.*/
+type Tracker interface {
+	Start(kind string, target string, op Operation) (Task, error)
+	Pause(ID TaskID) (Task, error)
+	Resume(ID TaskID) (Task, error)
+	Cancel(ID TaskID) (Task, error)
+	Get(ID TaskID) (Task, bool)
+	GetAll() []Task
+}
+
+type trackedTask struct {
+	task            Task
+	op              Operation
+	pauseRequested  bool
+	cancelRequested bool
+}
+
+type tracker struct {
+	mtx              sync.Mutex
+	tasks            map[TaskID]*trackedTask
+	nextID           TaskID
+	ended            []TaskID
+	maxEnded         int
+	progressInterval time.Duration
+	notify           NotifyFunc
+}
+
+/*NewTracker constructs a new valid Tracker. The progress of every running task
+is polled and reported through notify every progressInterval.*/
+func NewTracker(progressInterval time.Duration, notify NotifyFunc) Tracker {
+	return &tracker{
+		tasks:            make(map[TaskID]*trackedTask),
+		nextID:           1,
+		maxEnded:         maxEndedTasks,
+		progressInterval: progressInterval,
+		notify:           notify,
+	}
+}
+
+func (t *tracker) Start(kind string, target string, op Operation) (Task, error) {
+	t.mtx.Lock()
+	for _, tracked := range t.tasks {
+		if tracked.task.Kind == kind && tracked.task.Target == target && tracked.task.State.IsActive() {
+			t.mtx.Unlock()
+			return Task{}, ErrAlreadyRunning
+		}
+	}
+
+	tracked := &trackedTask{
+		task: Task{
+			ID:        t.nextID,
+			Kind:      kind,
+			Target:    target,
+			State:     StateRunning,
+			StartTime: time.Now(),
+		},
+		op: op,
+	}
+	t.nextID++
+	t.tasks[tracked.task.ID] = tracked
+	task := tracked.task
+	t.mtx.Unlock()
+
+	go t.run(tracked, op.Run)
+	t.notify(task)
+	return task, nil
+}
+
+func (t *tracker) Pause(ID TaskID) (Task, error) {
+	t.mtx.Lock()
+	tracked, ok := t.tasks[ID]
+	if !ok {
+		t.mtx.Unlock()
+		return Task{}, ErrTaskNotFound
+	}
+	op, ok := tracked.op.(PausableOperation)
+	if !ok {
+		t.mtx.Unlock()
+		return Task{}, ErrNotSupported
+	}
+	if tracked.task.State != StateRunning || tracked.pauseRequested {
+		t.mtx.Unlock()
+		return Task{}, ErrInvalidState
+	}
+	tracked.pauseRequested = true
+	t.mtx.Unlock()
+
+	err := op.Pause()
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if err != nil {
+		tracked.pauseRequested = false
+		return Task{}, err
+	}
+	return tracked.task, nil
+}
+
+func (t *tracker) Resume(ID TaskID) (Task, error) {
+	t.mtx.Lock()
+	tracked, ok := t.tasks[ID]
+	if !ok {
+		t.mtx.Unlock()
+		return Task{}, ErrTaskNotFound
+	}
+	op, ok := tracked.op.(PausableOperation)
+	if !ok {
+		t.mtx.Unlock()
+		return Task{}, ErrNotSupported
+	}
+	if tracked.task.State != StatePaused {
+		t.mtx.Unlock()
+		return Task{}, ErrInvalidState
+	}
+	tracked.pauseRequested = false
+	tracked.task.State = StateRunning
+	task := tracked.task
+	t.mtx.Unlock()
+
+	go t.run(tracked, op.Resume)
+	t.notify(task)
+	return task, nil
+}
+
+func (t *tracker) Cancel(ID TaskID) (Task, error) {
+	t.mtx.Lock()
+	tracked, ok := t.tasks[ID]
+	if !ok {
+		t.mtx.Unlock()
+		return Task{}, ErrTaskNotFound
+	}
+	op, ok := tracked.op.(CancellableOperation)
+	if !ok {
+		t.mtx.Unlock()
+		return Task{}, ErrNotSupported
+	}
+	if !tracked.task.State.IsActive() || tracked.cancelRequested {
+		t.mtx.Unlock()
+		return Task{}, ErrInvalidState
+	}
+	tracked.cancelRequested = true
+	wasPaused := tracked.task.State == StatePaused
+	t.mtx.Unlock()
+
+	err := op.Cancel()
+
+	t.mtx.Lock()
+	if err != nil {
+		tracked.cancelRequested = false
+		t.mtx.Unlock()
+		return Task{}, err
+	}
+	if !wasPaused {
+		//The goroutine running the operation finishes the task
+		task := tracked.task
+		t.mtx.Unlock()
+		return task, nil
+	}
+	tracked.task.State = StateCancelled
+	tracked.task.EndTime = time.Now()
+	t.retire(tracked.task.ID)
+	task := tracked.task
+	t.mtx.Unlock()
+
+	t.notify(task)
+	return task, nil
+}
+
+func (t *tracker) Get(ID TaskID) (Task, bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	tracked, ok := t.tasks[ID]
+	if !ok {
+		return Task{}, false
+	}
+	return tracked.task, true
+}
+
+func (t *tracker) GetAll() []Task {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	var ret []Task
+	for _, tracked := range t.tasks {
+		ret = append(ret, tracked.task)
+	}
+	return ret
+}
+
+/*run calls the blocking runFunc (Run or Resume of the operation) and reports
+the progress periodically until it returns.*/
+func (t *tracker) run(tracked *trackedTask, runFunc func() error) {
+	done := make(chan error, 1)
+	go func() {
+		done <- runFunc()
+	}()
+
+	ticker := time.NewTicker(t.progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			t.finish(tracked, err)
+			return
+		case <-ticker.C:
+			t.updateProgress(tracked)
+		}
+	}
+}
+
+func (t *tracker) updateProgress(tracked *trackedTask) {
+	progress, err := tracked.op.Progress()
+	if err != nil {
+		log.Printf("Unable to retrieve the progress of task %d: %s\n", tracked.task.ID, err)
+		return
+	}
+
+	t.mtx.Lock()
+	if tracked.task.State != StateRunning {
+		t.mtx.Unlock()
+		return
+	}
+	tracked.task.Progress = progress
+	task := tracked.task
+	t.mtx.Unlock()
+
+	t.notify(task)
+}
+
+func (t *tracker) finish(tracked *trackedTask, runErr error) {
+	progress, err := tracked.op.Progress()
+
+	t.mtx.Lock()
+	if err == nil {
+		tracked.task.Progress = progress
+	}
+	switch {
+	case tracked.cancelRequested:
+		tracked.task.State = StateCancelled
+	case tracked.pauseRequested:
+		tracked.task.State = StatePaused
+	case runErr != nil:
+		tracked.task.State = StateFailed
+		tracked.task.Error = runErr.Error()
+	default:
+		tracked.task.State = StateFinished
+	}
+	if !tracked.task.State.IsActive() {
+		tracked.task.EndTime = time.Now()
+		t.retire(tracked.task.ID)
+	}
+	task := tracked.task
+	t.mtx.Unlock()
+
+	t.notify(task)
+}
+
+/*retire records that the task ended and removes the oldest ended tasks beyond
+maxEnded. It has to be called with the mutex locked.*/
+func (t *tracker) retire(ID TaskID) {
+	t.ended = append(t.ended, ID)
+	for len(t.ended) > t.maxEnded {
+		delete(t.tasks, t.ended[0])
+		t.ended = t.ended[1:]
+	}
+}
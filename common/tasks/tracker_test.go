@@ -0,0 +1,181 @@
+package tasks
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testTimeout = time.Second
+
+//fakeOperation blocks in Run and Resume until it is stopped
+type fakeOperation struct {
+	stop     chan error
+	progress int
+}
+
+func newFakeOperation() *fakeOperation {
+	return &fakeOperation{stop: make(chan error, 1)}
+}
+
+func (f *fakeOperation) Run() error {
+	return <-f.stop
+}
+
+func (f *fakeOperation) Progress() (interface{}, error) {
+	return f.progress, nil
+}
+
+func (f *fakeOperation) Pause() error {
+	f.stop <- nil
+	return nil
+}
+
+func (f *fakeOperation) Resume() error {
+	return <-f.stop
+}
+
+func (f *fakeOperation) Cancel() error {
+	select {
+	case f.stop <- nil:
+	default:
+	}
+	return nil
+}
+
+type notificationRecorder struct {
+	received chan Task
+}
+
+func newNotificationRecorder() *notificationRecorder {
+	return &notificationRecorder{received: make(chan Task, 16)}
+}
+
+func (n *notificationRecorder) notify(task Task) {
+	n.received <- task
+}
+
+func (n *notificationRecorder) waitForState(t *testing.T, state State) Task {
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case task := <-n.received:
+			if task.State == state {
+				return task
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for task state %s", state)
+			return Task{}
+		}
+	}
+}
+
+func TestTrackerRunToCompletion(t *testing.T) {
+	n := newNotificationRecorder()
+	tracker := NewTracker(time.Hour, n.notify)
+	op := newFakeOperation()
+	op.progress = 42
+
+	task, err := tracker.Start("scrub", "volume", op)
+	assert.NoError(t, err)
+	assert.EqualValues(t, StateRunning, task.State)
+
+	op.stop <- nil
+	finished := n.waitForState(t, StateFinished)
+	assert.EqualValues(t, task.ID, finished.ID)
+	assert.EqualValues(t, 42, finished.Progress)
+	assert.False(t, finished.EndTime.IsZero())
+}
+
+func TestTrackerRunFailure(t *testing.T) {
+	n := newNotificationRecorder()
+	tracker := NewTracker(time.Hour, n.notify)
+	op := newFakeOperation()
+
+	_, err := tracker.Start("scrub", "volume", op)
+	assert.NoError(t, err)
+
+	op.stop <- errors.New("device missing")
+	failed := n.waitForState(t, StateFailed)
+	assert.EqualValues(t, "device missing", failed.Error)
+}
+
+func TestTrackerRemovesOldEndedTasks(t *testing.T) {
+	n := newNotificationRecorder()
+	tr := NewTracker(time.Hour, n.notify)
+	tr.(*tracker).maxEnded = 2
+
+	var started []Task
+	for i := 0; i < 3; i++ {
+		op := newFakeOperation()
+		task, err := tr.Start("scrub", "volume", op)
+		assert.NoError(t, err)
+		started = append(started, task)
+		op.stop <- nil
+		n.waitForState(t, StateFinished)
+	}
+	running, err := tr.Start("scrub", "volume", newFakeOperation())
+	assert.NoError(t, err)
+
+	_, ok := tr.Get(started[0].ID)
+	assert.False(t, ok, "the oldest ended task is removed")
+	for _, task := range append(started[1:], running) {
+		_, ok = tr.Get(task.ID)
+		assert.True(t, ok)
+	}
+	assert.Len(t, tr.GetAll(), 3)
+}
+
+func TestTrackerRejectsDuplicateTarget(t *testing.T) {
+	n := newNotificationRecorder()
+	tracker := NewTracker(time.Hour, n.notify)
+
+	_, err := tracker.Start("balance", "volume", newFakeOperation())
+	assert.NoError(t, err)
+	_, err = tracker.Start("balance", "volume", newFakeOperation())
+	assert.Equal(t, ErrAlreadyRunning, err)
+	_, err = tracker.Start("balance", "other volume", newFakeOperation())
+	assert.NoError(t, err)
+}
+
+func TestTrackerPauseResumeCancel(t *testing.T) {
+	n := newNotificationRecorder()
+	tracker := NewTracker(time.Hour, n.notify)
+	op := newFakeOperation()
+
+	task, err := tracker.Start("scrub", "volume", op)
+	assert.NoError(t, err)
+
+	_, err = tracker.Resume(task.ID)
+	assert.Equal(t, ErrInvalidState, err)
+
+	_, err = tracker.Pause(task.ID)
+	assert.NoError(t, err)
+	n.waitForState(t, StatePaused)
+
+	task, err = tracker.Resume(task.ID)
+	assert.NoError(t, err)
+	assert.EqualValues(t, StateRunning, task.State)
+
+	_, err = tracker.Pause(task.ID)
+	assert.NoError(t, err)
+	n.waitForState(t, StatePaused)
+
+	task, err = tracker.Cancel(task.ID)
+	assert.NoError(t, err)
+	assert.EqualValues(t, StateCancelled, task.State)
+
+	stored, ok := tracker.Get(task.ID)
+	assert.True(t, ok)
+	assert.EqualValues(t, StateCancelled, stored.State)
+}
+
+func TestTrackerUnknownTask(t *testing.T) {
+	tracker := NewTracker(time.Hour, func(Task) {})
+	_, err := tracker.Pause(1)
+	assert.Equal(t, ErrTaskNotFound, err)
+	_, ok := tracker.Get(1)
+	assert.False(t, ok)
+}
@@ -13,12 +13,26 @@ import (
 
 const dbName = "btrfs"
 const usersCollectionName = "users"
+const volumesCollectionName = "volumes"
+const replicationsCollectionName = "replications"
+const retentionPoliciesCollectionName = "retentionPolicies"
+const schedulesCollectionName = "schedules"
+const scheduleRunsCollectionName = "scheduleRuns"
+const storageServersCollectionName = "storageServers"
+const countersCollectionName = "counters"
+const storageServerIDCounter = "storageServerID"
 
 var (
-	connected = false
-	session   *mgo.Session
-	db        *mgo.Database
-	UsersRepo UsersRepository
+	connected        = false
+	session          *mgo.Session
+	db               *mgo.Database
+	UsersRepo        UsersRepository
+	VolumesRepo      VolumesRepository
+	ReplicationsRepo ReplicationsRepository
+	RetentionRepo    RetentionPoliciesRepository
+	SchedulesRepo    SchedulesRepository
+	ScheduleRunsRepo ScheduleRunsRepository
+	ServersRepo      StorageServersRepository
 )
 
 // UsersRepository is a collection of users
@@ -34,6 +48,219 @@ func (repo UsersRepository) FindUserByUsername(username string) (models.User, er
 	return result, err
 }
 
+// VolumesRepository is a collection of btrfs volumes
+type VolumesRepository struct {
+	coll *mgo.Collection
+}
+
+// SaveVolume inserts the volume or updates the stored volume with the same UUID,
+// recording the storage server the volume was last seen on.
+func (repo VolumesRepository) SaveVolume(vol models.BtrfsVolume) error {
+	_, err := repo.coll.Upsert(bson.M{"uuid": vol.UUID}, bson.M{"$set": bson.M{
+		"serverID": vol.ServerID,
+		"label":    vol.Label,
+	}})
+	return err
+}
+
+// ReplicationsRepository is a collection of replications between storage servers
+type ReplicationsRepository struct {
+	coll *mgo.Collection
+}
+
+func replicationSelector(sourceVolumeUUID string, targetVolumeUUID string, targetPath string) bson.M {
+	return bson.M{
+		"sourceVolumeUUID": sourceVolumeUUID,
+		"targetVolumeUUID": targetVolumeUUID,
+		"targetPath":       targetPath,
+	}
+}
+
+// FindReplication finds the replication from the source volume to the target
+// path on the target volume. If there is none, an empty replication is returned.
+func (repo ReplicationsRepository) FindReplication(sourceVolumeUUID string, targetVolumeUUID string, targetPath string) (models.Replication, error) {
+	result := models.Replication{}
+	err := repo.coll.Find(replicationSelector(sourceVolumeUUID, targetVolumeUUID, targetPath)).One(&result)
+	if err == mgo.ErrNotFound {
+		return models.Replication{
+			SourceVolumeUUID: sourceVolumeUUID,
+			TargetVolumeUUID: targetVolumeUUID,
+			TargetPath:       targetPath,
+		}, nil
+	}
+	return result, err
+}
+
+// FindReplications returns all replications.
+func (repo ReplicationsRepository) FindReplications() ([]models.Replication, error) {
+	var results []models.Replication
+	err := repo.coll.Find(nil).All(&results)
+	return results, err
+}
+
+// AddReplicatedSnapshot appends the snapshot to the replication, which is
+// inserted if it does not exist yet.
+func (repo ReplicationsRepository) AddReplicatedSnapshot(r models.Replication, snapshot models.ReplicatedSnapshot) error {
+	_, err := repo.coll.Upsert(replicationSelector(r.SourceVolumeUUID, r.TargetVolumeUUID, r.TargetPath),
+		bson.M{"$push": bson.M{"snapshots": snapshot}})
+	return err
+}
+
+// RetentionPoliciesRepository is a collection of snapshot retention policies
+type RetentionPoliciesRepository struct {
+	coll *mgo.Collection
+}
+
+// FindPolicies returns all retention policies, in the order they were added.
+func (repo RetentionPoliciesRepository) FindPolicies() ([]models.RetentionPolicy, error) {
+	var results []models.RetentionPolicy
+	err := repo.coll.Find(nil).Sort("_id").All(&results)
+	return results, err
+}
+
+// FindPolicy finds the retention policy with the ID.
+func (repo RetentionPoliciesRepository) FindPolicy(ID bson.ObjectId) (models.RetentionPolicy, error) {
+	result := models.RetentionPolicy{}
+	err := repo.coll.FindId(ID).One(&result)
+	return result, err
+}
+
+// SavePolicy inserts the policy if it has no ID, otherwise the stored policy is
+// replaced. The saved policy is returned.
+func (repo RetentionPoliciesRepository) SavePolicy(policy models.RetentionPolicy) (models.RetentionPolicy, error) {
+	if policy.ID == "" {
+		policy.ID = bson.NewObjectId()
+		return policy, repo.coll.Insert(policy)
+	}
+	return policy, repo.coll.UpdateId(policy.ID, policy)
+}
+
+// DeletePolicy deletes the retention policy with the ID.
+func (repo RetentionPoliciesRepository) DeletePolicy(ID bson.ObjectId) error {
+	return repo.coll.RemoveId(ID)
+}
+
+// SchedulesRepository is a collection of schedules run by the master
+type SchedulesRepository struct {
+	coll *mgo.Collection
+}
+
+// FindSchedules returns all schedules, in the order they were added.
+func (repo SchedulesRepository) FindSchedules() ([]models.Schedule, error) {
+	var results []models.Schedule
+	err := repo.coll.Find(nil).Sort("_id").All(&results)
+	return results, err
+}
+
+// FindSchedule finds the schedule with the ID.
+func (repo SchedulesRepository) FindSchedule(ID bson.ObjectId) (models.Schedule, error) {
+	result := models.Schedule{}
+	err := repo.coll.FindId(ID).One(&result)
+	return result, err
+}
+
+// SaveSchedule inserts the schedule if it has no ID, otherwise the stored
+// schedule is replaced. The saved schedule is returned.
+func (repo SchedulesRepository) SaveSchedule(schedule models.Schedule) (models.Schedule, error) {
+	if schedule.ID == "" {
+		schedule.ID = bson.NewObjectId()
+		return schedule, repo.coll.Insert(schedule)
+	}
+	return schedule, repo.coll.UpdateId(schedule.ID, schedule)
+}
+
+// SetLastScheduled records the time of the last run of the schedule that was due.
+func (repo SchedulesRepository) SetLastScheduled(ID bson.ObjectId, t time.Time) error {
+	return repo.coll.UpdateId(ID, bson.M{"$set": bson.M{"lastScheduled": t}})
+}
+
+// AdvanceLastScheduled records the time of the last run of the schedule that
+// was due, unless a later one is already recorded.
+func (repo SchedulesRepository) AdvanceLastScheduled(ID bson.ObjectId, t time.Time) error {
+	return repo.coll.UpdateId(ID, bson.M{"$max": bson.M{"lastScheduled": t}})
+}
+
+// DeleteSchedule deletes the schedule with the ID.
+func (repo SchedulesRepository) DeleteSchedule(ID bson.ObjectId) error {
+	return repo.coll.RemoveId(ID)
+}
+
+// ScheduleRunsRepository is a collection of the runs of schedules
+type ScheduleRunsRepository struct {
+	coll *mgo.Collection
+}
+
+// SaveRun inserts the run if it has no ID, otherwise the stored run is
+// replaced. The saved run is returned.
+func (repo ScheduleRunsRepository) SaveRun(run models.ScheduleRun) (models.ScheduleRun, error) {
+	if run.ID == "" {
+		run.ID = bson.NewObjectId()
+		return run, repo.coll.Insert(run)
+	}
+	return run, repo.coll.UpdateId(run.ID, run)
+}
+
+// AddLocalRun inserts a run uploaded by a storage server. A run uploaded again
+// replaces the stored one.
+func (repo ScheduleRunsRepository) AddLocalRun(run models.ScheduleRun) error {
+	run.Local = true
+	_, err := repo.coll.Upsert(bson.M{
+		"scheduleID":    run.ScheduleID,
+		"scheduledTime": run.ScheduledTime,
+		"local":         true,
+	}, run)
+	return err
+}
+
+// FindRuns returns the most recent runs of the schedule, newest first.
+func (repo ScheduleRunsRepository) FindRuns(scheduleID bson.ObjectId, limit int) ([]models.ScheduleRun, error) {
+	var results []models.ScheduleRun
+	err := repo.coll.Find(bson.M{"scheduleID": scheduleID}).Sort("-scheduledTime", "-_id").Limit(limit).All(&results)
+	return results, err
+}
+
+// StorageServersRepository is a collection of the identities of storage servers
+type StorageServersRepository struct {
+	coll     *mgo.Collection
+	counters *mgo.Collection
+}
+
+// FindServerByTokenHash finds the storage server the reconnect token with the
+// hash was issued to.
+func (repo StorageServersRepository) FindServerByTokenHash(tokenHash string) (models.StorageServer, error) {
+	result := models.StorageServer{}
+	err := repo.coll.Find(bson.M{"tokenHash": tokenHash}).One(&result)
+	return result, err
+}
+
+// AddServer inserts a storage server with the next unused server ID. The saved
+// server is returned.
+func (repo StorageServersRepository) AddServer(name string, tokenHash string) (models.StorageServer, error) {
+	var counter struct {
+		Seq int32 `bson:"seq"`
+	}
+	_, err := repo.counters.FindId(storageServerIDCounter).Apply(mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"seq": 1}},
+		Upsert:    true,
+		ReturnNew: true,
+	}, &counter)
+	if err != nil {
+		return models.StorageServer{}, err
+	}
+	server := models.StorageServer{
+		ID:        bson.NewObjectId(),
+		ServerID:  counter.Seq,
+		Name:      name,
+		TokenHash: tokenHash,
+	}
+	return server, repo.coll.Insert(server)
+}
+
+// SetServerName records the name a storage server registered with.
+func (repo StorageServersRepository) SetServerName(ID bson.ObjectId, name string) error {
+	return repo.coll.UpdateId(ID, bson.M{"$set": bson.M{"name": name}})
+}
+
 // Function that connects database and basically all necessary initialization
 // processes.
 func StartDB() {
@@ -47,6 +274,13 @@ func StartDB() {
 	connected = true
 	session.SetMode(mgo.Monotonic, true)
 	UsersRepo.coll = session.DB(dbName).C(usersCollectionName)
+	VolumesRepo.coll = session.DB(dbName).C(volumesCollectionName)
+	ReplicationsRepo.coll = session.DB(dbName).C(replicationsCollectionName)
+	RetentionRepo.coll = session.DB(dbName).C(retentionPoliciesCollectionName)
+	SchedulesRepo.coll = session.DB(dbName).C(schedulesCollectionName)
+	ScheduleRunsRepo.coll = session.DB(dbName).C(scheduleRunsCollectionName)
+	ServersRepo.coll = session.DB(dbName).C(storageServersCollectionName)
+	ServersRepo.counters = session.DB(dbName).C(countersCollectionName)
 
 	// Unique index
 	index := mgo.Index{
@@ -60,6 +294,29 @@ func StartDB() {
 	if err != nil {
 		panic(err)
 	}
+	err = VolumesRepo.coll.EnsureIndex(mgo.Index{Key: []string{"uuid"}, Unique: true})
+	if err != nil {
+		panic(err)
+	}
+	err = ReplicationsRepo.coll.EnsureIndex(mgo.Index{
+		Key:    []string{"sourceVolumeUUID", "targetVolumeUUID", "targetPath"},
+		Unique: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	err = ScheduleRunsRepo.coll.EnsureIndex(mgo.Index{Key: []string{"scheduleID", "-scheduledTime"}})
+	if err != nil {
+		panic(err)
+	}
+	err = ServersRepo.coll.EnsureIndex(mgo.Index{Key: []string{"serverID"}, Unique: true})
+	if err != nil {
+		panic(err)
+	}
+	err = ServersRepo.coll.EnsureIndex(mgo.Index{Key: []string{"tokenHash"}, Unique: true})
+	if err != nil {
+		panic(err)
+	}
 
 	// Initialize data base if it is empty
 	var results []models.User
@@ -0,0 +1,49 @@
+package fstab
+
+import (
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/common/request"
+	"github.com/djarek/btrfs-volume-manager/common/router"
+	"github.com/djarek/btrfs-volume-manager/master/storageservers"
+)
+
+const fstabSubsystem = "fstab"
+
+/*controller compares the fstab of the storage servers with their live mounts.
+The fstab entries themselves are changed by forwarding the requests to the
+storage servers.*/
+type controller struct {
+	serverTracker storageservers.Tracker
+}
+
+//NewController constructs a new valid controller reporting the fstab drift of the tracked servers
+func NewController(tracker storageservers.Tracker) router.HandlerExporter {
+	return &controller{serverTracker: tracker}
+}
+
+func (c *controller) ExportHandlers(adder router.HandlerAdder) {
+	adder.AddHandler(dtos.WSMsgFstabDriftRequest, c.onFstabDriftRequest)
+}
+
+/*onFstabDriftRequest lists the fstab and the mounts of the storage server in the
+background and responds with the differences.*/
+func (c *controller) onFstabDriftRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	serverID := msg.Payload.(*dtos.FstabDriftRequest).ServerID
+	serverCtx, ok := c.serverTracker.GetServerContext(serverID)
+	if !ok {
+		storageservers.SendError(ctx, msg.RequestID, fstabSubsystem, storageservers.ErrServerNotConnected)
+		return
+	}
+	go func() {
+		listRequest := &dtos.FstabListRequest{}
+		listRequest.ServerID = serverID
+		response, err := storageservers.RequestSlave(serverCtx, listRequest)
+		if err != nil {
+			storageservers.SendError(ctx, msg.RequestID, fstabSubsystem, err)
+			return
+		}
+		list := response.Payload.(*dtos.FstabListResponse)
+		drift := &dtos.FstabDriftResponse{Drift: computeDrift(list.Entries, list.Mounts)}
+		ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, drift))
+	}()
+}
@@ -0,0 +1,128 @@
+package fstab
+
+import (
+	"path"
+	"strings"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+)
+
+/*ignoredOptions are fstab options that are not shown in the options of a live
+mount, either because they are defaults or because mount(8) handles them.*/
+var ignoredOptions = map[string]bool{
+	"defaults":   true,
+	"auto":       true,
+	"noauto":     true,
+	"nofail":     true,
+	"_netdev":    true,
+	"user":       true,
+	"nouser":     true,
+	"users":      true,
+	"rw":         true,
+	"suid":       true,
+	"dev":        true,
+	"exec":       true,
+	"async":      true,
+	"atime":      true,
+	"diratime":   true,
+	"norelatime": true,
+}
+
+//normalizeOption makes the subvolume options of the fstab and the mtab comparable
+func normalizeOption(opt string) string {
+	if strings.HasPrefix(opt, "subvol=") {
+		return "subvol=/" + strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(opt, "subvol=")), "/")
+	}
+	return opt
+}
+
+/*hasOption tells whether the live options contain the fstab option. An option
+without a value matches the option with any value, an option with a value
+matches the option with a more specific value, e.g. compress=zstd matches
+compress=zstd:3.*/
+func hasOption(live []string, opt string) bool {
+	opt = normalizeOption(opt)
+	for _, l := range live {
+		l = normalizeOption(l)
+		if l == opt || strings.HasPrefix(l, opt+":") ||
+			(!strings.Contains(opt, "=") && strings.HasPrefix(l, opt+"=")) {
+			return true
+		}
+	}
+	return false
+}
+
+//missingOptions returns the fstab options the live mount lacks
+func missingOptions(fstabOptions string, liveOptions string) []string {
+	live := strings.Split(liveOptions, ",")
+	var missing []string
+	for _, opt := range strings.Split(fstabOptions, ",") {
+		if opt == "" || ignoredOptions[opt] || strings.HasPrefix(opt, "x-") || strings.HasPrefix(opt, "comment=") {
+			continue
+		}
+		if !hasOption(live, opt) {
+			missing = append(missing, opt)
+		}
+	}
+	return missing
+}
+
+/*computeDrift compares the btrfs entries of the fstab with the live btrfs mounts.
+Entries mounted only on demand (noauto) are not expected to be mounted.*/
+func computeDrift(entries []dtos.MountEntry, mounts []dtos.MountEntry) []dtos.FstabDrift {
+	drift := []dtos.FstabDrift{}
+	live := make(map[string]dtos.MountEntry)
+	for _, mount := range mounts {
+		live[mount.MountPath] = mount
+	}
+	inFstab := make(map[string]bool)
+
+	for i := range entries {
+		entry := &entries[i]
+		if entry.MountType != "btrfs" {
+			continue
+		}
+		inFstab[entry.MountPath] = true
+		mount, mounted := live[entry.MountPath]
+		if !mounted {
+			if !hasOption(strings.Split(entry.MountOptions, ","), "noauto") {
+				drift = append(drift, dtos.FstabDrift{
+					Kind:      dtos.FstabDriftNotMounted,
+					MountPath: entry.MountPath,
+					Entry:     entry,
+				})
+			}
+			continue
+		}
+		if entry.VolumeUUID != "" && mount.VolumeUUID != "" && entry.VolumeUUID != mount.VolumeUUID {
+			drift = append(drift, dtos.FstabDrift{
+				Kind:      dtos.FstabDriftVolumeDiffers,
+				MountPath: entry.MountPath,
+				Entry:     entry,
+				Mount:     &mount,
+				Details:   "The fstab mounts " + string(entry.VolumeUUID) + ", " + string(mount.VolumeUUID) + " is mounted",
+			})
+			continue
+		}
+		if missing := missingOptions(entry.MountOptions, mount.MountOptions); len(missing) > 0 {
+			drift = append(drift, dtos.FstabDrift{
+				Kind:      dtos.FstabDriftOptionsDiffer,
+				MountPath: entry.MountPath,
+				Entry:     entry,
+				Mount:     &mount,
+				Details:   "The live mount lacks the options: " + strings.Join(missing, ","),
+			})
+		}
+	}
+
+	for i := range mounts {
+		if !inFstab[mounts[i].MountPath] {
+			drift = append(drift, dtos.FstabDrift{
+				Kind:      dtos.FstabDriftNotInFstab,
+				MountPath: mounts[i].MountPath,
+				Mount:     &mounts[i],
+			})
+		}
+	}
+	return drift
+}
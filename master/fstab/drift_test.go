@@ -0,0 +1,54 @@
+package fstab
+
+import (
+	"testing"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/stretchr/testify/assert"
+)
+
+func mountEntry(UUID dtos.UUIDType, mountPath string, mountType string, options string) dtos.MountEntry {
+	return dtos.MountEntry{
+		MountPoint: dtos.MountPoint{MountPath: mountPath, MountType: mountType, MountOptions: options},
+		VolumeUUID: UUID,
+	}
+}
+
+func TestMissingOptions(t *testing.T) {
+	live := "rw,noatime,compress=zstd:3,ssd,space_cache=v2,subvolid=256,subvol=/home"
+	assert.Empty(t, missingOptions("defaults,noatime,compress=zstd,space_cache,subvol=home,nofail,x-systemd.automount", live))
+	assert.Empty(t, missingOptions("compress=zstd:3,subvol=/home/", live))
+	assert.EqualValues(t, []string{"compress=zlib", "subvol=/var", "autodefrag"},
+		missingOptions("compress=zlib,subvol=/var,autodefrag", live))
+}
+
+func TestComputeDrift(t *testing.T) {
+	entries := []dtos.MountEntry{
+		mountEntry("root", "/", "ext4", "errors=remount-ro"),
+		mountEntry("vol", "/srv/home", "btrfs", "noatime,subvol=/home"),
+		mountEntry("vol", "/srv/var", "btrfs", "compress=zstd,subvol=/var"),
+		mountEntry("vol", "/srv/backup", "btrfs", "subvol=/backup"),
+		mountEntry("vol", "/srv/archive", "btrfs", "noauto,subvol=/archive"),
+		mountEntry("vol", "/srv/data", "btrfs", "defaults"),
+	}
+	mounts := []dtos.MountEntry{
+		mountEntry("vol", "/srv/home", "btrfs", "rw,noatime,subvolid=256,subvol=/home"),
+		mountEntry("vol", "/srv/var", "btrfs", "rw,relatime,subvolid=257,subvol=/var"),
+		mountEntry("other", "/srv/data", "btrfs", "rw,relatime,subvolid=5,subvol=/"),
+		mountEntry("vol", "/srv/tmp", "btrfs", "rw,relatime,subvolid=258,subvol=/tmp"),
+	}
+
+	drift := computeDrift(entries, mounts)
+	assert.Len(t, drift, 4)
+	assert.Equal(t, dtos.FstabDriftOptionsDiffer, drift[0].Kind)
+	assert.Equal(t, "/srv/var", drift[0].MountPath)
+	assert.Equal(t, "The live mount lacks the options: compress=zstd", drift[0].Details)
+	assert.Equal(t, dtos.FstabDriftNotMounted, drift[1].Kind)
+	assert.Equal(t, "/srv/backup", drift[1].MountPath)
+	assert.Nil(t, drift[1].Mount)
+	assert.Equal(t, dtos.FstabDriftVolumeDiffers, drift[2].Kind)
+	assert.Equal(t, "/srv/data", drift[2].MountPath)
+	assert.Equal(t, dtos.FstabDriftNotInFstab, drift[3].Kind)
+	assert.Equal(t, "/srv/tmp", drift[3].MountPath)
+	assert.Nil(t, drift[3].Entry)
+}
@@ -11,6 +11,11 @@ import (
 
 	"github.com/djarek/btrfs-volume-manager/master/authentication"
 	"github.com/djarek/btrfs-volume-manager/master/db"
+	"github.com/djarek/btrfs-volume-manager/master/fstab"
+	"github.com/djarek/btrfs-volume-manager/master/notifications"
+	"github.com/djarek/btrfs-volume-manager/master/replication"
+	"github.com/djarek/btrfs-volume-manager/master/retention"
+	"github.com/djarek/btrfs-volume-manager/master/scheduler"
 	"github.com/djarek/btrfs-volume-manager/master/storageservers"
 	"github.com/djarek/btrfs-volume-manager/master/storageservers/blockdevices"
 
@@ -27,10 +32,23 @@ func setupAuth(r *router.Router) {
 
 func setupServerTracker(r *router.Router) {
 	tracker := storageservers.NewTracker()
-	serverController := storageservers.NewController(tracker)
-	blockDevController := blockdevices.NewController(tracker)
+	hub := notifications.NewHub()
+	serverController := storageservers.NewController(tracker, db.ServersRepo)
+	blockDevController := blockdevices.NewController(tracker, hub)
+	notificationController := notifications.NewController(hub, db.VolumesRepo)
+	replicationController := replication.NewController(tracker, hub, db.ReplicationsRepo)
+	retentionController := retention.NewController(retention.NewEngine(tracker, db.RetentionRepo))
+	taskScheduler := scheduler.NewScheduler(tracker, db.SchedulesRepo, db.ScheduleRunsRepo)
+	schedulerController := scheduler.NewController(taskScheduler)
+	fstabController := fstab.NewController(tracker)
 	blockDevController.ExportHandlers(r)
 	serverController.ExportHandlers(r)
+	notificationController.ExportHandlers(r)
+	replicationController.ExportHandlers(r)
+	retentionController.ExportHandlers(r)
+	schedulerController.ExportHandlers(r)
+	fstabController.ExportHandlers(r)
+	taskScheduler.Start()
 }
 
 func main() {
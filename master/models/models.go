@@ -16,10 +16,14 @@ type User struct {
 	RegistrationDate time.Time     `bson:"registrationDate"`
 }
 
-// StorageServer represents a Network Attached Storage device
+// StorageServer represents a Network Attached Storage device. The storage
+// server gets its ServerID back by presenting the reconnect token whose SHA-256
+// hash is TokenHash.
 type StorageServer struct {
-	ID   bson.ObjectId `bson:"_id,omitempty"`
-	Name string        `bson:"name"`
+	ID        bson.ObjectId `bson:"_id,omitempty"`
+	ServerID  int32         `bson:"serverID"`
+	Name      string        `bson:"name"`
+	TokenHash string        `bson:"tokenHash"`
 }
 
 // BlockDevice represents a block device retrieved by blkid probe
@@ -34,7 +38,84 @@ type BlockDevice struct {
 // BtrfsVolume represents a filesystem volume which can potentially span over
 // multiple devices
 type BtrfsVolume struct {
-	ID     bson.ObjectId `bson:"_id,omitempty"`
-	ServID bson.ObjectId `bson:"servID"` // can be empty
-	Label  string        `bson:"label"`
+	ID       bson.ObjectId `bson:"_id,omitempty"`
+	ServerID int32         `bson:"serverID"`
+	UUID     string        `bson:"uuid"`
+	Label    string        `bson:"label"`
+}
+
+// ReplicatedSnapshot represents a snapshot received on the target of a
+// replication, its copy has a received UUID equal to SourceUUID, or to the
+// received UUID of the source snapshot if it was received itself
+type ReplicatedSnapshot struct {
+	SourcePath   string    `bson:"sourcePath"`
+	SourceUUID   string    `bson:"sourceUUID"`
+	ReceivedPath string    `bson:"receivedPath"`
+	ReceivedUUID string    `bson:"receivedUUID"`
+	ParentUUID   string    `bson:"parentUUID"` // empty for a full send
+	Bytes        uint64    `bson:"bytes"`
+	Time         time.Time `bson:"time"`
+}
+
+// Replication represents the snapshots replicated from a volume to a directory
+// on a volume of another storage server, oldest first
+type Replication struct {
+	ID               bson.ObjectId        `bson:"_id,omitempty"`
+	SourceVolumeUUID string               `bson:"sourceVolumeUUID"`
+	TargetVolumeUUID string               `bson:"targetVolumeUUID"`
+	TargetPath       string               `bson:"targetPath"`
+	Snapshots        []ReplicatedSnapshot `bson:"snapshots"`
+}
+
+// RetentionRules represents the numbers of snapshots kept by a retention policy
+type RetentionRules struct {
+	KeepLast int `bson:"keepLast"`
+	Hourly   int `bson:"hourly"`
+	Daily    int `bson:"daily"`
+	Weekly   int `bson:"weekly"`
+	Monthly  int `bson:"monthly"`
+	Yearly   int `bson:"yearly"`
+}
+
+// RetentionPolicy represents the retention of the snapshots of a subvolume, or
+// of the subvolumes matching PathGlob, on a volume of a storage server
+type RetentionPolicy struct {
+	ID         bson.ObjectId  `bson:"_id,omitempty"`
+	ServerID   int32          `bson:"serverID"`
+	VolumeUUID string         `bson:"volumeUUID"`
+	Subvolume  string         `bson:"subvolume"`
+	PathGlob   string         `bson:"pathGlob"`
+	Rules      RetentionRules `bson:"rules"`
+}
+
+// Schedule represents an action run by the master whenever the cron
+// expression matches in the timezone
+type Schedule struct {
+	ID            bson.ObjectId `bson:"_id,omitempty"`
+	Name          string        `bson:"name"`
+	Cron          string        `bson:"cron"`
+	Timezone      string        `bson:"timezone"`
+	ServerID      int32         `bson:"serverID"`
+	VolumeUUID    string        `bson:"volumeUUID"`
+	Subvolume     string        `bson:"subvolume"`
+	Action        string        `bson:"action"`
+	SnapshotDir   string        `bson:"snapshotDir"`
+	BackupDir     string        `bson:"backupDir"`
+	BalanceUsage  int           `bson:"balanceUsage"`
+	CatchUp       string        `bson:"catchUp"`
+	Enabled       bool          `bson:"enabled"`
+	LastScheduled time.Time     `bson:"lastScheduled"`
+}
+
+// ScheduleRun represents a run of a schedule and its outcome
+type ScheduleRun struct {
+	ID            bson.ObjectId `bson:"_id,omitempty"`
+	ScheduleID    bson.ObjectId `bson:"scheduleID"`
+	ScheduledTime time.Time     `bson:"scheduledTime"`
+	StartTime     time.Time     `bson:"startTime"`
+	EndTime       time.Time     `bson:"endTime"`
+	Outcome       string        `bson:"outcome"`
+	Error         string        `bson:"error"`
+	TaskID        uint64        `bson:"taskID"`
+	Local         bool          `bson:"local"`
 }
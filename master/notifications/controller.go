@@ -0,0 +1,70 @@
+package notifications
+
+import (
+	"log"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/common/request"
+	"github.com/djarek/btrfs-volume-manager/common/router"
+	"github.com/djarek/btrfs-volume-manager/master/models"
+	"github.com/djarek/btrfs-volume-manager/master/storageservers"
+)
+
+type volumeSaver interface {
+	SaveVolume(models.BtrfsVolume) error
+}
+
+type controller struct {
+	hub     Hub
+	volumes volumeSaver
+}
+
+/*NewController constructs a new valid controller. Changed volumes are saved
+to v before they are published.*/
+func NewController(h Hub, v volumeSaver) router.HandlerExporter {
+	return &controller{hub: h, volumes: v}
+}
+
+func (c *controller) ExportHandlers(adder router.HandlerAdder) {
+	adder.AddHandler(dtos.WSMsgTaskStatusNotification, c.onTaskStatusNotification)
+	adder.AddHandler(dtos.WSMsgBtrfsVolumeChangedNotification, c.onBtrfsVolumeChangedNotification)
+	adder.AddOnCloseHandler(c.onConnectionClose)
+}
+
+/*onTaskStatusNotification publishes the task of the storage server that sent
+the notification. Notifications from connections other than registered storage
+servers are dropped.*/
+func (c *controller) onTaskStatusNotification(ctx *request.Context, msg dtos.WebSocketMessage) {
+	serverID, ok := storageservers.ServerID(ctx)
+	if !ok {
+		log.Println("Dropping a task status notification from an unregistered connection")
+		return
+	}
+	notification := msg.Payload.(*dtos.TaskStatusNotification)
+	c.hub.PublishTask(serverID, notification.Task)
+}
+
+/*onBtrfsVolumeChangedNotification saves and publishes the volume of the storage
+server that sent the notification. Notifications from connections other than
+registered storage servers are dropped.*/
+func (c *controller) onBtrfsVolumeChangedNotification(ctx *request.Context, msg dtos.WebSocketMessage) {
+	serverID, ok := storageservers.ServerID(ctx)
+	if !ok {
+		log.Println("Dropping a volume changed notification from an unregistered connection")
+		return
+	}
+	notification := msg.Payload.(*dtos.BtrfsVolumeChangedNotification)
+	err := c.volumes.SaveVolume(models.BtrfsVolume{
+		ServerID: int32(serverID),
+		UUID:     string(notification.BtrfsVolume.UUID),
+		Label:    notification.BtrfsVolume.Label,
+	})
+	if err != nil {
+		log.Println(err)
+	}
+	c.hub.PublishVolume(serverID, notification.BtrfsVolume)
+}
+
+func (c *controller) onConnectionClose(ctx *request.Context, msg dtos.WebSocketMessage) {
+	c.hub.Unsubscribe(ctx)
+}
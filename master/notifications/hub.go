@@ -0,0 +1,127 @@
+package notifications
+
+import (
+	"sync"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/common/request"
+	"github.com/djarek/btrfs-volume-manager/common/tasks"
+)
+
+type taskKey struct {
+	serverID dtos.StorageServerID
+	taskID   tasks.TaskID
+}
+
+type subscriberSet map[*request.Context]struct{}
+
+/*Hub passes notifications received from storage servers on to the clients
+subscribed to them.*/
+type Hub interface {
+	SubscribeTask(serverID dtos.StorageServerID, taskID tasks.TaskID, ctx *request.Context)
+	SubscribeVolumes(serverID dtos.StorageServerID, ctx *request.Context)
+	Unsubscribe(ctx *request.Context)
+	PublishTask(serverID dtos.StorageServerID, task tasks.Task)
+	PublishVolume(serverID dtos.StorageServerID, vol dtos.BtrfsVolume)
+}
+
+type hub struct {
+	mtx               sync.Mutex
+	taskSubscribers   map[taskKey]subscriberSet
+	volumeSubscribers map[dtos.StorageServerID]subscriberSet
+}
+
+/*NewHub constructs a new valid Hub*/
+func NewHub() Hub {
+	return &hub{
+		taskSubscribers:   make(map[taskKey]subscriberSet),
+		volumeSubscribers: make(map[dtos.StorageServerID]subscriberSet),
+	}
+}
+
+func (h *hub) SubscribeTask(serverID dtos.StorageServerID, taskID tasks.TaskID, ctx *request.Context) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	key := taskKey{serverID: serverID, taskID: taskID}
+	subscribers, ok := h.taskSubscribers[key]
+	if !ok {
+		subscribers = make(subscriberSet)
+		h.taskSubscribers[key] = subscribers
+	}
+	subscribers[ctx] = struct{}{}
+}
+
+/*SubscribeVolumes subscribes the client to the changes of all volumes of the
+storage server.*/
+func (h *hub) SubscribeVolumes(serverID dtos.StorageServerID, ctx *request.Context) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	subscribers, ok := h.volumeSubscribers[serverID]
+	if !ok {
+		subscribers = make(subscriberSet)
+		h.volumeSubscribers[serverID] = subscribers
+	}
+	subscribers[ctx] = struct{}{}
+}
+
+func (h *hub) Unsubscribe(ctx *request.Context) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	for key, subscribers := range h.taskSubscribers {
+		delete(subscribers, ctx)
+		if len(subscribers) == 0 {
+			delete(h.taskSubscribers, key)
+		}
+	}
+	for serverID, subscribers := range h.volumeSubscribers {
+		delete(subscribers, ctx)
+		if len(subscribers) == 0 {
+			delete(h.volumeSubscribers, serverID)
+		}
+	}
+}
+
+/*PublishTask sends the task state to all subscribed clients. Once the task is
+no longer active, no more notifications are expected and the subscriptions are
+dropped.*/
+func (h *hub) PublishTask(serverID dtos.StorageServerID, task tasks.Task) {
+	key := taskKey{serverID: serverID, taskID: task.ID}
+
+	h.mtx.Lock()
+	var ctxList []*request.Context
+	for ctx := range h.taskSubscribers[key] {
+		ctxList = append(ctxList, ctx)
+	}
+	if !task.State.IsActive() {
+		delete(h.taskSubscribers, key)
+	}
+	h.mtx.Unlock()
+
+	notification := &dtos.TaskStatusNotification{}
+	notification.ServerID = serverID
+	notification.Task = task
+	msg := dtos.NewWebSocketMessage(0, notification)
+	for _, ctx := range ctxList {
+		ctx.SendAsync(msg)
+	}
+}
+
+//PublishVolume sends the changed volume to all clients subscribed to the server's volumes
+func (h *hub) PublishVolume(serverID dtos.StorageServerID, vol dtos.BtrfsVolume) {
+	h.mtx.Lock()
+	var ctxList []*request.Context
+	for ctx := range h.volumeSubscribers[serverID] {
+		ctxList = append(ctxList, ctx)
+	}
+	h.mtx.Unlock()
+
+	notification := &dtos.BtrfsVolumeChangedNotification{BtrfsVolume: vol}
+	notification.ServerID = serverID
+	msg := dtos.NewWebSocketMessage(0, notification)
+	for _, ctx := range ctxList {
+		ctx.SendAsync(msg)
+	}
+}
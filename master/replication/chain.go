@@ -0,0 +1,75 @@
+package replication
+
+import (
+	"path"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/master/models"
+)
+
+//findSubvolume finds the subvolume at the path relative to the volume root
+func findSubvolume(subvols []dtos.BtrfsSubVolume, relativePath string) (dtos.BtrfsSubVolume, bool) {
+	relativePath = path.Clean("/" + relativePath)
+	for _, subvol := range subvols {
+		if path.Clean("/"+subvol.RelativePath) == relativePath {
+			return subvol, true
+		}
+	}
+	return dtos.BtrfsSubVolume{}, false
+}
+
+//isReceived reports whether a copy of the snapshot was received
+func isReceived(subvols []dtos.BtrfsSubVolume, snapshot dtos.BtrfsSubVolume) bool {
+	UUID := snapshot.StreamUUID()
+	for _, subvol := range subvols {
+		if UUID != "" && subvol.ReceivedUUID == UUID {
+			return true
+		}
+	}
+	return false
+}
+
+/*selectParent returns the most recently replicated snapshot that can be the
+parent of an incremental send of the snapshot. The parent has to be still
+present and read-only on the source, and its copy, found by received UUID, has
+to be still present on the target. The received UUID is the one the source
+snapshot itself was received with, if any. The source path of the returned parent is
+the current one, the snapshot may have been moved since it was replicated.*/
+func selectParent(replication models.Replication, sourceSubvols []dtos.BtrfsSubVolume,
+	targetSubvols []dtos.BtrfsSubVolume, snapshot dtos.BtrfsSubVolume) (*models.ReplicatedSnapshot, bool) {
+
+	for i := len(replication.Snapshots) - 1; i >= 0; i-- {
+		candidate := replication.Snapshots[i]
+		if candidate.SourceUUID == string(snapshot.UUID) {
+			continue
+		}
+		for _, subvol := range sourceSubvols {
+			if string(subvol.UUID) == candidate.SourceUUID && subvol.ReadOnly && isReceived(targetSubvols, subvol) {
+				candidate.SourcePath = subvol.RelativePath
+				return &candidate, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func toDTO(replication models.Replication) dtos.Replication {
+	r := dtos.Replication{
+		SourceVolumeUUID: dtos.UUIDType(replication.SourceVolumeUUID),
+		TargetVolumeUUID: dtos.UUIDType(replication.TargetVolumeUUID),
+		TargetPath:       replication.TargetPath,
+		Snapshots:        []dtos.ReplicatedSnapshot{},
+	}
+	for _, snapshot := range replication.Snapshots {
+		r.Snapshots = append(r.Snapshots, dtos.ReplicatedSnapshot{
+			SourcePath:   snapshot.SourcePath,
+			SourceUUID:   dtos.UUIDType(snapshot.SourceUUID),
+			ReceivedPath: snapshot.ReceivedPath,
+			ReceivedUUID: dtos.UUIDType(snapshot.ReceivedUUID),
+			ParentUUID:   dtos.UUIDType(snapshot.ParentUUID),
+			Bytes:        snapshot.Bytes,
+			Time:         snapshot.Time,
+		})
+	}
+	return r
+}
@@ -0,0 +1,71 @@
+package replication
+
+import (
+	"testing"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/master/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectParent(t *testing.T) {
+	replication := models.Replication{Snapshots: []models.ReplicatedSnapshot{
+		{SourcePath: "snapshots/1", SourceUUID: "snap1"},
+		{SourcePath: "snapshots/2", SourceUUID: "snap2"},
+		{SourcePath: "snapshots/3", SourceUUID: "snap3"},
+	}}
+	snapshot := dtos.BtrfsSubVolume{RelativePath: "snapshots/4", UUID: "snap4", ReadOnly: true}
+	source := []dtos.BtrfsSubVolume{
+		{RelativePath: "old/1", UUID: "snap1", ReadOnly: true},
+		{RelativePath: "snapshots/2", UUID: "snap2", ReadOnly: true},
+		{RelativePath: "snapshots/3", UUID: "snap3"},
+		snapshot,
+	}
+	target := []dtos.BtrfsSubVolume{
+		{RelativePath: "backup/1", ReceivedUUID: "snap1"},
+		{RelativePath: "backup/3", ReceivedUUID: "snap3"},
+	}
+
+	//snap3 is no longer read-only, snap2 is gone from the target
+	parent, ok := selectParent(replication, source, target, snapshot)
+	assert.True(t, ok)
+	assert.EqualValues(t, "snap1", parent.SourceUUID)
+	assert.EqualValues(t, "old/1", parent.SourcePath)
+
+	_, ok = selectParent(replication, source, target[1:], snapshot)
+	assert.False(t, ok)
+
+	_, ok = selectParent(models.Replication{}, source, target, snapshot)
+	assert.False(t, ok)
+}
+
+func TestSelectParentOfReceivedSnapshots(t *testing.T) {
+	//the snapshots on the source were restored from a backup
+	replication := models.Replication{Snapshots: []models.ReplicatedSnapshot{
+		{SourcePath: "snapshots/1", SourceUUID: "snap1"},
+	}}
+	snapshot := dtos.BtrfsSubVolume{RelativePath: "snapshots/2", UUID: "snap2", ReceivedUUID: "orig2", ReadOnly: true}
+	source := []dtos.BtrfsSubVolume{
+		{RelativePath: "snapshots/1", UUID: "snap1", ReceivedUUID: "orig1", ReadOnly: true},
+		snapshot,
+	}
+	target := []dtos.BtrfsSubVolume{{RelativePath: "backup/1", ReceivedUUID: "orig1"}}
+
+	parent, ok := selectParent(replication, source, target, snapshot)
+	assert.True(t, ok)
+	assert.EqualValues(t, "snap1", parent.SourceUUID)
+	assert.True(t, isReceived(target, source[0]))
+	assert.False(t, isReceived(target, snapshot))
+	assert.True(t, isReceived([]dtos.BtrfsSubVolume{{ReceivedUUID: "orig2"}}, snapshot))
+	assert.False(t, isReceived([]dtos.BtrfsSubVolume{{ReceivedUUID: "snap2"}}, snapshot))
+}
+
+func TestFindSubvolume(t *testing.T) {
+	subvols := []dtos.BtrfsSubVolume{{RelativePath: "snapshots/1", UUID: "snap1"}}
+	subvol, ok := findSubvolume(subvols, "/snapshots/1/")
+	assert.True(t, ok)
+	assert.EqualValues(t, "snap1", subvol.UUID)
+
+	_, ok = findSubvolume(subvols, "snapshots")
+	assert.False(t, ok)
+}
@@ -0,0 +1,237 @@
+package replication
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/common/request"
+	"github.com/djarek/btrfs-volume-manager/common/router"
+	"github.com/djarek/btrfs-volume-manager/common/tasks"
+	"github.com/djarek/btrfs-volume-manager/master/models"
+	"github.com/djarek/btrfs-volume-manager/master/notifications"
+	"github.com/djarek/btrfs-volume-manager/master/storageservers"
+)
+
+const (
+	replicationSubsystem = "replication"
+	replicationTaskKind  = "replication"
+	taskProgressInterval = 5 * time.Second
+)
+
+var (
+	//ErrSnapshotNotFound indicates that there is no snapshot at the source path
+	ErrSnapshotNotFound = errors.New("Snapshot not found")
+	//ErrAlreadyReplicated indicates that the target already received the snapshot
+	ErrAlreadyReplicated = errors.New("The snapshot was already replicated to the target")
+)
+
+type replicationStore interface {
+	FindReplication(sourceVolumeUUID string, targetVolumeUUID string, targetPath string) (models.Replication, error)
+	FindReplications() ([]models.Replication, error)
+	AddReplicatedSnapshot(models.Replication, models.ReplicatedSnapshot) error
+}
+
+/*controller replicates snapshots between storage servers. The master runs the
+replications as tasks and relays the send streams, the storage servers never
+connect to each other.*/
+type controller struct {
+	serverTracker   storageservers.Tracker
+	notificationHub notifications.Hub
+	replications    replicationStore
+	tracker         tasks.Tracker
+
+	mtx          sync.Mutex
+	streams      map[dtos.StreamID]*stream
+	nextStreamID dtos.StreamID
+}
+
+/*NewController constructs a new valid controller. Replicated snapshots are
+recorded in r.*/
+func NewController(tracker storageservers.Tracker, hub notifications.Hub, r replicationStore) router.HandlerExporter {
+	c := &controller{
+		serverTracker:   tracker,
+		notificationHub: hub,
+		replications:    r,
+		streams:         make(map[dtos.StreamID]*stream),
+	}
+	c.tracker = tasks.NewTracker(taskProgressInterval, func(task tasks.Task) {
+		hub.PublishTask(dtos.MasterServerID, task)
+	})
+	return c
+}
+
+func (c *controller) ExportHandlers(adder router.HandlerAdder) {
+	adder.AddHandler(dtos.WSMsgReplicationStartRequest, c.onReplicationStartRequest)
+	adder.AddHandler(dtos.WSMsgReplicationListRequest, c.onReplicationListRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsSendStartResponse, router.DefaultResponseHandler)
+	adder.AddHandler(dtos.WSMsgBtrfsReceiveStartResponse, router.DefaultResponseHandler)
+	adder.AddHandler(dtos.WSMsgBtrfsStreamChunkNotification, c.onBtrfsStreamChunkNotification)
+	adder.AddHandler(dtos.WSMsgBtrfsStreamAckNotification, c.onBtrfsStreamAckNotification)
+	adder.AddHandler(dtos.WSMsgBtrfsReceiveResultNotification, c.onBtrfsReceiveResultNotification)
+	adder.AddOnCloseHandler(c.onConnectionClose)
+}
+
+func listSubvolumes(ctx *request.Context, serverID dtos.StorageServerID, UUID dtos.UUIDType) ([]dtos.BtrfsSubVolume, error) {
+	response, err := storageservers.RequestSlave(ctx, &dtos.BtrfsSubvolumeListRequest{ServerID: serverID, VolumeUUID: UUID})
+	if err != nil {
+		return nil, err
+	}
+	return response.Payload.(*dtos.BtrfsSubvolumeListResponse).Subvolumes, nil
+}
+
+func (c *controller) addStream(s *stream) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.streams[s.id] = s
+}
+
+func (c *controller) removeStream(ID dtos.StreamID) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	delete(c.streams, ID)
+}
+
+func (c *controller) getStream(ID dtos.StreamID) (*stream, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	s, ok := c.streams[ID]
+	return s, ok
+}
+
+func (c *controller) newStreamID() dtos.StreamID {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.nextStreamID++
+	return c.nextStreamID
+}
+
+/*startReplication looks up the snapshot and the replication chain and starts
+the replication task. The send is incremental if a parent is found.*/
+func (c *controller) startReplication(r dtos.ReplicationStartRequest) (tasks.Task, error) {
+	source, ok := c.serverTracker.GetServerContext(r.SourceServerID)
+	if !ok {
+		return tasks.Task{}, storageservers.ErrServerNotConnected
+	}
+	target, ok := c.serverTracker.GetServerContext(r.TargetServerID)
+	if !ok {
+		return tasks.Task{}, storageservers.ErrServerNotConnected
+	}
+	sourceSubvols, err := listSubvolumes(source, r.SourceServerID, r.SourceVolumeUUID)
+	if err != nil {
+		return tasks.Task{}, err
+	}
+	targetSubvols, err := listSubvolumes(target, r.TargetServerID, r.TargetVolumeUUID)
+	if err != nil {
+		return tasks.Task{}, err
+	}
+	snapshot, ok := findSubvolume(sourceSubvols, r.SourcePath)
+	if !ok {
+		return tasks.Task{}, ErrSnapshotNotFound
+	}
+	if isReceived(targetSubvols, snapshot) {
+		return tasks.Task{}, ErrAlreadyReplicated
+	}
+
+	replication, err := c.replications.FindReplication(string(r.SourceVolumeUUID), string(r.TargetVolumeUUID), r.TargetPath)
+	if err != nil {
+		return tasks.Task{}, err
+	}
+	parent, _ := selectParent(replication, sourceSubvols, targetSubvols, snapshot)
+	op := &replicationOperation{
+		c:              c,
+		stream:         newStream(c.newStreamID(), source, target),
+		sourceServerID: r.SourceServerID,
+		targetServerID: r.TargetServerID,
+		replication:    replication,
+		snapshot:       snapshot,
+		parent:         parent,
+	}
+	return c.tracker.Start(replicationTaskKind, string(r.TargetVolumeUUID)+":"+r.TargetPath, op)
+}
+
+func (c *controller) onReplicationStartRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	startRequest := *msg.Payload.(*dtos.ReplicationStartRequest)
+	go func() {
+		task, err := c.startReplication(startRequest)
+		if err != nil {
+			storageservers.SendError(ctx, msg.RequestID, replicationSubsystem, err)
+			return
+		}
+		c.notificationHub.SubscribeTask(dtos.MasterServerID, task.ID, ctx)
+		response := &dtos.ReplicationStartResponse{}
+		response.Task = task
+		ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+	}()
+}
+
+func (c *controller) onReplicationListRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	replications, err := c.replications.FindReplications()
+	if err != nil {
+		storageservers.SendError(ctx, msg.RequestID, replicationSubsystem, err)
+		return
+	}
+	response := &dtos.ReplicationListResponse{Replications: []dtos.Replication{}}
+	for _, replication := range replications {
+		response.Replications = append(response.Replications, toDTO(replication))
+	}
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}
+
+//onBtrfsStreamChunkNotification relays a chunk from the source to the target
+func (c *controller) onBtrfsStreamChunkNotification(ctx *request.Context, msg dtos.WebSocketMessage) {
+	chunk := msg.Payload.(*dtos.BtrfsStreamChunkNotification)
+	s, ok := c.getStream(chunk.StreamID)
+	if !ok || s.source != ctx || s.finished() {
+		return
+	}
+	atomic.AddUint64(&s.bytes, uint64(len(chunk.Data)))
+	s.target.SendAsync(msg)
+	if chunk.Error != "" {
+		s.finish(nil, errors.New(chunk.Error))
+	}
+}
+
+//onBtrfsStreamAckNotification relays an acknowledgement from the target to the source
+func (c *controller) onBtrfsStreamAckNotification(ctx *request.Context, msg dtos.WebSocketMessage) {
+	ack := msg.Payload.(*dtos.BtrfsStreamAckNotification)
+	s, ok := c.getStream(ack.StreamID)
+	if !ok || s.target != ctx {
+		return
+	}
+	s.source.SendAsync(msg)
+	if ack.Abort {
+		s.finish(nil, errors.New(ack.Error))
+	}
+}
+
+func (c *controller) onBtrfsReceiveResultNotification(ctx *request.Context, msg dtos.WebSocketMessage) {
+	result := msg.Payload.(*dtos.BtrfsReceiveResultNotification)
+	s, ok := c.getStream(result.StreamID)
+	if !ok || s.target != ctx {
+		return
+	}
+	if result.Error != "" {
+		s.finish(nil, errors.New(result.Error))
+	} else if result.Subvolume != nil {
+		s.finish(result.Subvolume, nil)
+	}
+}
+
+//onConnectionClose aborts the streams of a disconnected storage server
+func (c *controller) onConnectionClose(ctx *request.Context, msg dtos.WebSocketMessage) {
+	c.mtx.Lock()
+	var aborted []*stream
+	for _, s := range c.streams {
+		if s.source == ctx || s.target == ctx {
+			aborted = append(aborted, s)
+		}
+	}
+	c.mtx.Unlock()
+
+	for _, s := range aborted {
+		s.abort(storageservers.ErrServerDisconnected)
+	}
+}
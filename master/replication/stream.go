@@ -0,0 +1,167 @@
+package replication
+
+import (
+	"errors"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/common/request"
+	"github.com/djarek/btrfs-volume-manager/master/models"
+	"github.com/djarek/btrfs-volume-manager/master/storageservers"
+)
+
+var errReplicationCancelled = errors.New("The replication was cancelled")
+
+/*stream relays a btrfs send stream from the source storage server to the
+target one. It is finished by the result of the receive or the first error.*/
+type stream struct {
+	id     dtos.StreamID
+	source *request.Context
+	target *request.Context
+	bytes  uint64
+
+	mtx    sync.Mutex
+	done   chan struct{}
+	subvol dtos.BtrfsSubVolume
+	err    error
+}
+
+func newStream(ID dtos.StreamID, source *request.Context, target *request.Context) *stream {
+	return &stream{id: ID, source: source, target: target, done: make(chan struct{})}
+}
+
+//finish sets the result of the stream, only the first result is kept
+func (s *stream) finish(subvol *dtos.BtrfsSubVolume, err error) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+	if subvol != nil {
+		s.subvol = *subvol
+	}
+	s.err = err
+	close(s.done)
+	return true
+}
+
+/*abort finishes the stream with the error and stops the send and the receive.
+The receive deletes the incomplete snapshot.*/
+func (s *stream) abort(err error) {
+	if !s.finish(nil, err) {
+		return
+	}
+	ack := &dtos.BtrfsStreamAckNotification{Abort: true, Error: err.Error()}
+	ack.StreamID = s.id
+	s.source.SendAsync(dtos.NewWebSocketMessage(0, ack))
+	chunk := &dtos.BtrfsStreamChunkNotification{EOF: true, Error: err.Error()}
+	chunk.StreamID = s.id
+	s.target.SendAsync(dtos.NewWebSocketMessage(0, chunk))
+}
+
+func (s *stream) finished() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+//wait blocks until the stream is finished and returns the received subvolume
+func (s *stream) wait() (dtos.BtrfsSubVolume, error) {
+	<-s.done
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.subvol, s.err
+}
+
+func (s *stream) transferred() uint64 {
+	return atomic.LoadUint64(&s.bytes)
+}
+
+/*replicationOperation replicates a snapshot over a stream. It satisfies the
+tasks.CancellableOperation interface.*/
+type replicationOperation struct {
+	c              *controller
+	stream         *stream
+	sourceServerID dtos.StorageServerID
+	targetServerID dtos.StorageServerID
+	replication    models.Replication
+	snapshot       dtos.BtrfsSubVolume
+	parent         *models.ReplicatedSnapshot
+}
+
+/*Run starts the receive on the target and then the send on the source, and
+blocks until the snapshot is received. The received snapshot is appended to the
+replication's chain, so that the next run can be incremental.*/
+func (o *replicationOperation) Run() error {
+	s := o.stream
+	o.c.addStream(s)
+	defer o.c.removeStream(s.id)
+
+	receive := &dtos.BtrfsReceiveStartRequest{
+		TargetPath: o.replication.TargetPath,
+		Name:       path.Base(path.Clean("/" + o.snapshot.RelativePath)),
+		SourceUUID: o.snapshot.StreamUUID(),
+	}
+	receive.ServerID = o.targetServerID
+	receive.VolumeUUID = dtos.UUIDType(o.replication.TargetVolumeUUID)
+	receive.StreamID = s.id
+	_, err := storageservers.RequestSlave(s.target, receive)
+	if err != nil {
+		s.finish(nil, err)
+		return err
+	}
+
+	send := &dtos.BtrfsSendStartRequest{RelativePath: o.snapshot.RelativePath}
+	if o.parent != nil {
+		send.ParentPath = o.parent.SourcePath
+	}
+	send.ServerID = o.sourceServerID
+	send.VolumeUUID = dtos.UUIDType(o.replication.SourceVolumeUUID)
+	send.StreamID = s.id
+	if !s.finished() {
+		_, err = storageservers.RequestSlave(s.source, send)
+		if err != nil {
+			s.abort(err)
+		}
+	}
+
+	subvol, err := s.wait()
+	if err != nil {
+		return err
+	}
+	snapshot := models.ReplicatedSnapshot{
+		SourcePath:   o.snapshot.RelativePath,
+		SourceUUID:   string(o.snapshot.UUID),
+		ReceivedPath: subvol.RelativePath,
+		ReceivedUUID: string(subvol.UUID),
+		Bytes:        s.transferred(),
+		Time:         time.Now(),
+	}
+	if o.parent != nil {
+		snapshot.ParentUUID = o.parent.SourceUUID
+	}
+	return o.c.replications.AddReplicatedSnapshot(o.replication, snapshot)
+}
+
+//Cancel stops the send and the receive, the incomplete snapshot is deleted
+func (o *replicationOperation) Cancel() error {
+	o.stream.abort(errReplicationCancelled)
+	return nil
+}
+
+//Progress returns the current dtos.ReplicationProgress of the replication
+func (o *replicationOperation) Progress() (interface{}, error) {
+	progress := dtos.ReplicationProgress{BytesTransferred: o.stream.transferred()}
+	if o.parent != nil {
+		progress.ParentPath = o.parent.SourcePath
+	}
+	return progress, nil
+}
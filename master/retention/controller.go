@@ -0,0 +1,92 @@
+package retention
+
+import (
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/common/request"
+	"github.com/djarek/btrfs-volume-manager/common/router"
+	"github.com/djarek/btrfs-volume-manager/master/storageservers"
+)
+
+const retentionSubsystem = "retention"
+
+type controller struct {
+	engine *Engine
+}
+
+//NewController constructs a new valid controller managing the policies of the engine
+func NewController(e *Engine) router.HandlerExporter {
+	return &controller{engine: e}
+}
+
+func (c *controller) ExportHandlers(adder router.HandlerAdder) {
+	adder.AddHandler(dtos.WSMsgRetentionPolicySetRequest, c.onRetentionPolicySetRequest)
+	adder.AddHandler(dtos.WSMsgRetentionPolicyListRequest, c.onRetentionPolicyListRequest)
+	adder.AddHandler(dtos.WSMsgRetentionPolicyDeleteRequest, c.onRetentionPolicyDeleteRequest)
+	adder.AddHandler(dtos.WSMsgRetentionApplyRequest, c.onRetentionApplyRequest)
+}
+
+func (c *controller) onRetentionPolicySetRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	policy := msg.Payload.(*dtos.RetentionPolicySetRequest).Policy
+	err := validatePolicy(policy)
+	if err != nil {
+		storageservers.SendError(ctx, msg.RequestID, retentionSubsystem, err)
+		return
+	}
+	stored := fromDTO(policy)
+	if policy.ID != "" {
+		existing, err := c.engine.findPolicy(policy.ID)
+		if err != nil {
+			storageservers.SendError(ctx, msg.RequestID, retentionSubsystem, err)
+			return
+		}
+		stored.ID = existing.ID
+	}
+	stored, err = c.engine.policies.SavePolicy(stored)
+	if err != nil {
+		storageservers.SendError(ctx, msg.RequestID, retentionSubsystem, err)
+		return
+	}
+	response := &dtos.RetentionPolicySetResponse{Policy: toDTO(stored)}
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}
+
+func (c *controller) onRetentionPolicyListRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	policies, err := c.engine.policies.FindPolicies()
+	if err != nil {
+		storageservers.SendError(ctx, msg.RequestID, retentionSubsystem, err)
+		return
+	}
+	response := &dtos.RetentionPolicyListResponse{Policies: []dtos.RetentionPolicy{}}
+	for _, policy := range policies {
+		response.Policies = append(response.Policies, toDTO(policy))
+	}
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}
+
+func (c *controller) onRetentionPolicyDeleteRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	ID := msg.Payload.(*dtos.RetentionPolicyDeleteRequest).PolicyID
+	policy, err := c.engine.findPolicy(ID)
+	if err == nil {
+		err = c.engine.policies.DeletePolicy(policy.ID)
+	}
+	if err != nil {
+		storageservers.SendError(ctx, msg.RequestID, retentionSubsystem, err)
+		return
+	}
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, &dtos.RetentionPolicyDeleteResponse{}))
+}
+
+/*onRetentionApplyRequest applies the policy in the background, the engine waits
+for the responses of the storage server.*/
+func (c *controller) onRetentionApplyRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	applyRequest := *msg.Payload.(*dtos.RetentionApplyRequest)
+	go func() {
+		decisions, err := c.engine.Apply(applyRequest.PolicyID, applyRequest.DryRun)
+		if err != nil {
+			storageservers.SendError(ctx, msg.RequestID, retentionSubsystem, err)
+			return
+		}
+		response := &dtos.RetentionApplyResponse{Decisions: decisions}
+		ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+	}()
+}
@@ -0,0 +1,124 @@
+package retention
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/common/request"
+	"github.com/djarek/btrfs-volume-manager/master/models"
+	"github.com/djarek/btrfs-volume-manager/master/storageservers"
+)
+
+var (
+	//ErrPolicyNotFound indicates that there is no retention policy with the ID
+	ErrPolicyNotFound = errors.New("Retention policy not found")
+)
+
+type policyStore interface {
+	FindPolicies() ([]models.RetentionPolicy, error)
+	FindPolicy(ID bson.ObjectId) (models.RetentionPolicy, error)
+	SavePolicy(models.RetentionPolicy) (models.RetentionPolicy, error)
+	DeletePolicy(ID bson.ObjectId) error
+}
+
+/*Engine applies the retention policies stored on the master to the snapshots
+on the storage servers.*/
+type Engine struct {
+	serverTracker storageservers.Tracker
+	policies      policyStore
+}
+
+//NewEngine constructs a new valid Engine, the policies are read from store
+func NewEngine(tracker storageservers.Tracker, store policyStore) *Engine {
+	return &Engine{serverTracker: tracker, policies: store}
+}
+
+func (e *Engine) findPolicy(ID string) (models.RetentionPolicy, error) {
+	if !bson.IsObjectIdHex(ID) {
+		return models.RetentionPolicy{}, ErrPolicyNotFound
+	}
+	policy, err := e.policies.FindPolicy(bson.ObjectIdHex(ID))
+	if err == mgo.ErrNotFound {
+		return policy, ErrPolicyNotFound
+	}
+	return policy, err
+}
+
+/*Apply evaluates the policy with the ID against the snapshots of every
+subvolume it applies to and deletes the snapshots it does not keep. Every
+decision is logged and returned, in a dry run nothing is deleted. A failed
+deletion is reported in its decision and does not stop the others.*/
+func (e *Engine) Apply(policyID string, dryRun bool) ([]dtos.RetentionDecision, error) {
+	policy, err := e.findPolicy(policyID)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := e.policies.FindPolicies()
+	if err != nil {
+		return nil, err
+	}
+	serverID := dtos.StorageServerID(policy.ServerID)
+	ctx, ok := e.serverTracker.GetServerContext(serverID)
+	if !ok {
+		return nil, storageservers.ErrServerNotConnected
+	}
+	response, err := storageservers.RequestSlave(ctx, &dtos.BtrfsSubvolumeListRequest{
+		ServerID:   serverID,
+		VolumeUUID: dtos.UUIDType(policy.VolumeUUID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	subvols := response.Payload.(*dtos.BtrfsSubvolumeListResponse).Subvolumes
+
+	decisions := []dtos.RetentionDecision{}
+	for _, subvol := range subvols {
+		applied, ok := policyFor(policies, policy.ServerID, policy.VolumeUUID, subvol.RelativePath)
+		if !ok || applied.ID != policy.ID {
+			continue
+		}
+		for _, decision := range evaluate(dtos.RetentionRules(policy.Rules), subvol.RelativePath, snapshotsOf(subvols, subvol)) {
+			if !decision.Keep && !dryRun {
+				err = e.deleteSnapshot(ctx, serverID, policy.VolumeUUID, decision.SnapshotPath)
+				if err != nil {
+					decision.Error = err.Error()
+				} else {
+					decision.Deleted = true
+				}
+			}
+			logDecision(policyID, dryRun, decision)
+			decisions = append(decisions, decision)
+		}
+	}
+	return decisions, nil
+}
+
+func (e *Engine) deleteSnapshot(ctx *request.Context, serverID dtos.StorageServerID, volumeUUID string, relativePath string) error {
+	request := &dtos.BtrfsSubvolumeDeleteRequest{RelativePath: relativePath}
+	request.ServerID = serverID
+	request.VolumeUUID = dtos.UUIDType(volumeUUID)
+	_, err := storageservers.RequestSlave(ctx, request)
+	return err
+}
+
+func logDecision(policyID string, dryRun bool, decision dtos.RetentionDecision) {
+	prefix := "Retention policy " + policyID + ": "
+	if dryRun {
+		prefix += "(dry run) "
+	}
+	switch {
+	case decision.Keep:
+		log.Printf("%skeeping %s (%s)\n", prefix, decision.SnapshotPath, strings.Join(decision.Reasons, ", "))
+	case dryRun:
+		log.Printf("%swould delete %s\n", prefix, decision.SnapshotPath)
+	case decision.Error != "":
+		log.Printf("%sfailed to delete %s: %s\n", prefix, decision.SnapshotPath, decision.Error)
+	default:
+		log.Printf("%sdeleted %s\n", prefix, decision.SnapshotPath)
+	}
+}
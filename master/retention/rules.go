@@ -0,0 +1,185 @@
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/master/models"
+)
+
+var (
+	//ErrNoRules indicates that a policy would not keep any snapshot
+	ErrNoRules = errors.New("A retention policy has to keep at least one snapshot")
+	//ErrNegativeCount indicates that a count of a policy is negative
+	ErrNegativeCount = errors.New("The counts of a retention policy cannot be negative")
+	//ErrNoTarget indicates that a policy names neither a subvolume nor a path glob
+	ErrNoTarget = errors.New("A retention policy needs either a subvolume or a path glob")
+)
+
+//period groups snapshots by the hour, day, week, month or year of their creation
+type period struct {
+	name  string
+	count func(dtos.RetentionRules) int
+	key   func(time.Time) string
+}
+
+var periods = []period{
+	{"hourly", func(r dtos.RetentionRules) int { return r.Hourly }, func(t time.Time) string {
+		return t.Format("2006-01-02 15h")
+	}},
+	{"daily", func(r dtos.RetentionRules) int { return r.Daily }, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	}},
+	{"weekly", func(r dtos.RetentionRules) int { return r.Weekly }, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}},
+	{"monthly", func(r dtos.RetentionRules) int { return r.Monthly }, func(t time.Time) string {
+		return t.Format("2006-01")
+	}},
+	{"yearly", func(r dtos.RetentionRules) int { return r.Yearly }, func(t time.Time) string {
+		return t.Format("2006")
+	}},
+}
+
+//validatePolicy checks that the policy has a target and keeps at least one snapshot
+func validatePolicy(policy dtos.RetentionPolicy) error {
+	if (policy.Subvolume == "") == (policy.PathGlob == "") {
+		return ErrNoTarget
+	}
+	if policy.PathGlob != "" {
+		if _, err := path.Match(policy.PathGlob, ""); err != nil {
+			return err
+		}
+	}
+	r := policy.Rules
+	counts := []int{r.KeepLast, r.Hourly, r.Daily, r.Weekly, r.Monthly, r.Yearly}
+	total := 0
+	for _, count := range counts {
+		if count < 0 {
+			return ErrNegativeCount
+		}
+		total += count
+	}
+	if total == 0 {
+		return ErrNoRules
+	}
+	return nil
+}
+
+//newestFirst sorts snapshots by creation time, newest first
+type newestFirst []dtos.BtrfsSubVolume
+
+func (s newestFirst) Len() int           { return len(s) }
+func (s newestFirst) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s newestFirst) Less(i, j int) bool { return s[i].CreationTime.After(s[j].CreationTime) }
+
+/*evaluate decides which snapshots of the subvolume the rules keep, newest
+first. KeepLast keeps the newest snapshots, every period count keeps the newest
+snapshot of each of the most recent periods that have a snapshot. A snapshot may
+be kept by several rules, all of them are listed.*/
+func evaluate(rules dtos.RetentionRules, subvolumePath string, snapshots []dtos.BtrfsSubVolume) []dtos.RetentionDecision {
+	sorted := append([]dtos.BtrfsSubVolume{}, snapshots...)
+	sort.Stable(newestFirst(sorted))
+
+	decisions := make([]dtos.RetentionDecision, len(sorted))
+	for i, snapshot := range sorted {
+		decisions[i] = dtos.RetentionDecision{
+			SubvolumePath: subvolumePath,
+			SnapshotPath:  snapshot.RelativePath,
+			SnapshotUUID:  snapshot.UUID,
+			CreationTime:  snapshot.CreationTime,
+			Reasons:       []string{},
+		}
+	}
+	keep := func(i int, reason string) {
+		decisions[i].Keep = true
+		decisions[i].Reasons = append(decisions[i].Reasons, reason)
+	}
+
+	for i := 0; i < len(decisions) && i < rules.KeepLast; i++ {
+		keep(i, fmt.Sprintf("last %d", i+1))
+	}
+	for _, p := range periods {
+		count := p.count(rules)
+		last := ""
+		for i := 0; i < len(decisions) && count > 0; i++ {
+			key := p.key(decisions[i].CreationTime)
+			if key == last {
+				continue
+			}
+			last = key
+			count--
+			keep(i, p.name+" "+key)
+		}
+	}
+	return decisions
+}
+
+//cleanPath returns the path relative to the volume root without leading and trailing slashes
+func cleanPath(relativePath string) string {
+	return strings.Trim(path.Clean("/"+relativePath), "/")
+}
+
+/*policyFor returns the policy applying to the subvolume at the path. A policy
+for the subvolume itself takes precedence over the policies matching it by glob,
+of which the first one applies.*/
+func policyFor(policies []models.RetentionPolicy, serverID int32, volumeUUID string, subvolumePath string) (models.RetentionPolicy, bool) {
+	subvolumePath = cleanPath(subvolumePath)
+	var globMatch *models.RetentionPolicy
+	for i, policy := range policies {
+		if policy.ServerID != serverID || policy.VolumeUUID != volumeUUID {
+			continue
+		}
+		if policy.Subvolume != "" && cleanPath(policy.Subvolume) == subvolumePath {
+			return policy, true
+		}
+		if policy.PathGlob == "" || globMatch != nil {
+			continue
+		}
+		if matched, _ := path.Match(cleanPath(policy.PathGlob), subvolumePath); matched {
+			globMatch = &policies[i]
+		}
+	}
+	if globMatch != nil {
+		return *globMatch, true
+	}
+	return models.RetentionPolicy{}, false
+}
+
+//snapshotsOf returns the read-only snapshots of the subvolume
+func snapshotsOf(subvols []dtos.BtrfsSubVolume, subvol dtos.BtrfsSubVolume) []dtos.BtrfsSubVolume {
+	var snapshots []dtos.BtrfsSubVolume
+	for _, s := range subvols {
+		if s.ReadOnly && subvol.UUID != "" && s.ParentUUID == subvol.UUID {
+			snapshots = append(snapshots, s)
+		}
+	}
+	return snapshots
+}
+
+func toDTO(policy models.RetentionPolicy) dtos.RetentionPolicy {
+	return dtos.RetentionPolicy{
+		ID:         policy.ID.Hex(),
+		ServerID:   dtos.StorageServerID(policy.ServerID),
+		VolumeUUID: dtos.UUIDType(policy.VolumeUUID),
+		Subvolume:  policy.Subvolume,
+		PathGlob:   policy.PathGlob,
+		Rules:      dtos.RetentionRules(policy.Rules),
+	}
+}
+
+func fromDTO(policy dtos.RetentionPolicy) models.RetentionPolicy {
+	return models.RetentionPolicy{
+		ServerID:   int32(policy.ServerID),
+		VolumeUUID: string(policy.VolumeUUID),
+		Subvolume:  policy.Subvolume,
+		PathGlob:   policy.PathGlob,
+		Rules:      models.RetentionRules(policy.Rules),
+	}
+}
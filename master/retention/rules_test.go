@@ -0,0 +1,99 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/master/models"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func snapshotAt(name string, t time.Time) dtos.BtrfsSubVolume {
+	return dtos.BtrfsSubVolume{RelativePath: "snapshots/" + name, UUID: dtos.UUIDType(name), ParentUUID: "home",
+		ReadOnly: true, CreationTime: t}
+}
+
+func kept(decisions []dtos.RetentionDecision) []string {
+	var paths []string
+	for _, decision := range decisions {
+		if decision.Keep {
+			paths = append(paths, decision.SnapshotPath)
+		}
+	}
+	return paths
+}
+
+func TestEvaluate(t *testing.T) {
+	day := func(d int, h int) time.Time { return time.Date(2026, time.October, d, h, 0, 0, 0, time.UTC) }
+	snapshots := []dtos.BtrfsSubVolume{
+		snapshotAt("1", day(1, 12)),
+		snapshotAt("12", day(12, 9)),
+		snapshotAt("17a", day(17, 8)),
+		snapshotAt("17b", day(17, 20)),
+		snapshotAt("18a", day(18, 6)),
+		snapshotAt("18b", day(18, 6).Add(30*time.Minute)),
+	}
+
+	decisions := evaluate(dtos.RetentionRules{KeepLast: 1}, "home", snapshots)
+	assert.Len(t, decisions, 6)
+	assert.EqualValues(t, []string{"snapshots/18b"}, kept(decisions))
+	assert.EqualValues(t, []string{"last 1"}, decisions[0].Reasons)
+
+	decisions = evaluate(dtos.RetentionRules{Daily: 3}, "home", snapshots)
+	assert.EqualValues(t, []string{"snapshots/18b", "snapshots/17b", "snapshots/12"}, kept(decisions))
+
+	//the 12th is in the same ISO week as the 18th
+	decisions = evaluate(dtos.RetentionRules{Hourly: 2, Weekly: 2, Monthly: 5}, "home", snapshots)
+	assert.EqualValues(t, []string{"snapshots/18b", "snapshots/17b", "snapshots/1"}, kept(decisions))
+	assert.EqualValues(t, []string{"hourly 2026-10-18 06h", "weekly 2026-W42", "monthly 2026-10"}, decisions[0].Reasons)
+
+	assert.Empty(t, evaluate(dtos.RetentionRules{KeepLast: 1}, "home", nil))
+}
+
+func TestValidatePolicy(t *testing.T) {
+	rules := dtos.RetentionRules{Daily: 7}
+	assert.NoError(t, validatePolicy(dtos.RetentionPolicy{Subvolume: "home", Rules: rules}))
+	assert.NoError(t, validatePolicy(dtos.RetentionPolicy{PathGlob: "srv/*", Rules: rules}))
+	assert.Equal(t, ErrNoTarget, validatePolicy(dtos.RetentionPolicy{Rules: rules}))
+	assert.Equal(t, ErrNoTarget, validatePolicy(dtos.RetentionPolicy{Subvolume: "home", PathGlob: "*", Rules: rules}))
+	assert.Error(t, validatePolicy(dtos.RetentionPolicy{PathGlob: "[", Rules: rules}))
+	assert.Equal(t, ErrNoRules, validatePolicy(dtos.RetentionPolicy{Subvolume: "home"}))
+	assert.Equal(t, ErrNegativeCount, validatePolicy(dtos.RetentionPolicy{Subvolume: "home",
+		Rules: dtos.RetentionRules{KeepLast: 3, Daily: -1}}))
+}
+
+func TestPolicyFor(t *testing.T) {
+	policies := []models.RetentionPolicy{
+		{ID: bson.ObjectId("glob1"), VolumeUUID: "vol", PathGlob: "srv/*"},
+		{ID: bson.ObjectId("glob2"), VolumeUUID: "vol", PathGlob: "/srv/www"},
+		{ID: bson.ObjectId("www"), VolumeUUID: "vol", Subvolume: "srv/www/"},
+		{ID: bson.ObjectId("other"), ServerID: 1, VolumeUUID: "vol", Subvolume: "home"},
+	}
+
+	policy, ok := policyFor(policies, 0, "vol", "/srv/www")
+	assert.True(t, ok)
+	assert.EqualValues(t, "www", policy.ID)
+
+	policy, ok = policyFor(policies, 0, "vol", "srv/db")
+	assert.True(t, ok)
+	assert.EqualValues(t, "glob1", policy.ID)
+
+	_, ok = policyFor(policies, 0, "vol", "home")
+	assert.False(t, ok)
+	_, ok = policyFor(policies, 0, "other", "srv/db")
+	assert.False(t, ok)
+}
+
+func TestSnapshotsOf(t *testing.T) {
+	home := dtos.BtrfsSubVolume{RelativePath: "home", UUID: "home"}
+	subvols := []dtos.BtrfsSubVolume{
+		home,
+		snapshotAt("1", time.Time{}),
+		{RelativePath: "snapshots/rw", UUID: "rw", ParentUUID: "home"},
+		{RelativePath: "srv", UUID: "srv"},
+	}
+	assert.EqualValues(t, subvols[1:2], snapshotsOf(subvols, home))
+	assert.Empty(t, snapshotsOf(subvols, subvols[3]))
+}
@@ -0,0 +1,161 @@
+package scheduler
+
+import (
+	"errors"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/common/request"
+	"github.com/djarek/btrfs-volume-manager/common/router"
+	"github.com/djarek/btrfs-volume-manager/common/schedules"
+	"github.com/djarek/btrfs-volume-manager/master/models"
+	"github.com/djarek/btrfs-volume-manager/master/storageservers"
+)
+
+const (
+	schedulerSubsystem = "scheduler"
+	defaultRunsLimit   = 20
+	maxRunsLimit       = 1000
+)
+
+var (
+	//ErrScheduleNotFound indicates that there is no schedule with the ID
+	ErrScheduleNotFound = errors.New("Schedule not found")
+	//ErrServerNotRegistered indicates that the sender is not a registered storage server
+	ErrServerNotRegistered = errors.New("Storage server not registered")
+)
+
+type controller struct {
+	scheduler *Scheduler
+}
+
+//NewController constructs a new valid controller managing the schedules of the scheduler
+func NewController(s *Scheduler) router.HandlerExporter {
+	return &controller{scheduler: s}
+}
+
+func (c *controller) ExportHandlers(adder router.HandlerAdder) {
+	adder.AddHandler(dtos.WSMsgScheduleSetRequest, c.onScheduleSetRequest)
+	adder.AddHandler(dtos.WSMsgScheduleListRequest, c.onScheduleListRequest)
+	adder.AddHandler(dtos.WSMsgScheduleDeleteRequest, c.onScheduleDeleteRequest)
+	adder.AddHandler(dtos.WSMsgScheduleRunsRequest, c.onScheduleRunsRequest)
+	adder.AddHandler(dtos.WSMsgScheduleSyncRequest, c.onScheduleSyncRequest)
+}
+
+func (c *controller) findSchedule(ID string) (models.Schedule, error) {
+	if !bson.IsObjectIdHex(ID) {
+		return models.Schedule{}, ErrScheduleNotFound
+	}
+	schedule, err := c.scheduler.schedules.FindSchedule(bson.ObjectIdHex(ID))
+	if err == mgo.ErrNotFound {
+		return schedule, ErrScheduleNotFound
+	}
+	return schedule, err
+}
+
+func (c *controller) onScheduleSetRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	schedule := msg.Payload.(*dtos.ScheduleSetRequest).Schedule
+	err := schedules.Validate(schedule)
+	if err != nil {
+		storageservers.SendError(ctx, msg.RequestID, schedulerSubsystem, err)
+		return
+	}
+	stored := fromDTO(schedule)
+	if schedule.ID != "" {
+		existing, err := c.findSchedule(schedule.ID)
+		if err != nil {
+			storageservers.SendError(ctx, msg.RequestID, schedulerSubsystem, err)
+			return
+		}
+		stored.ID = existing.ID
+		defer c.scheduler.pushSchedules(dtos.StorageServerID(existing.ServerID))
+	}
+	stored.LastScheduled = time.Now()
+	stored, err = c.scheduler.schedules.SaveSchedule(stored)
+	if err != nil {
+		storageservers.SendError(ctx, msg.RequestID, schedulerSubsystem, err)
+		return
+	}
+	c.scheduler.Reload()
+	if schedule.ID == "" || schedule.ServerID != toDTO(stored).ServerID {
+		c.scheduler.pushSchedules(schedule.ServerID)
+	}
+	response := &dtos.ScheduleSetResponse{Schedule: toDTO(stored)}
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}
+
+func (c *controller) onScheduleListRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	schedules, err := c.scheduler.schedules.FindSchedules()
+	if err != nil {
+		storageservers.SendError(ctx, msg.RequestID, schedulerSubsystem, err)
+		return
+	}
+	response := &dtos.ScheduleListResponse{Schedules: []dtos.Schedule{}}
+	for _, schedule := range schedules {
+		response.Schedules = append(response.Schedules, toDTO(schedule))
+	}
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}
+
+func (c *controller) onScheduleDeleteRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	schedule, err := c.findSchedule(msg.Payload.(*dtos.ScheduleDeleteRequest).ScheduleID)
+	if err == nil {
+		err = c.scheduler.schedules.DeleteSchedule(schedule.ID)
+	}
+	if err != nil {
+		storageservers.SendError(ctx, msg.RequestID, schedulerSubsystem, err)
+		return
+	}
+	c.scheduler.Reload()
+	c.scheduler.pushSchedules(dtos.StorageServerID(schedule.ServerID))
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, &dtos.ScheduleDeleteResponse{}))
+}
+
+func (c *controller) onScheduleRunsRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	runsRequest := msg.Payload.(*dtos.ScheduleRunsRequest)
+	limit := runsRequest.Limit
+	if limit <= 0 {
+		limit = defaultRunsLimit
+	} else if limit > maxRunsLimit {
+		limit = maxRunsLimit
+	}
+	if !bson.IsObjectIdHex(runsRequest.ScheduleID) {
+		storageservers.SendError(ctx, msg.RequestID, schedulerSubsystem, ErrScheduleNotFound)
+		return
+	}
+	runs, err := c.scheduler.runs.FindRuns(bson.ObjectIdHex(runsRequest.ScheduleID), limit)
+	if err != nil {
+		storageservers.SendError(ctx, msg.RequestID, schedulerSubsystem, err)
+		return
+	}
+	response := &dtos.ScheduleRunsResponse{Runs: []dtos.ScheduleRun{}}
+	for _, run := range runs {
+		response.Runs = append(response.Runs, runToDTO(run))
+	}
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}
+
+/*onScheduleSyncRequest records the runs of its own schedules uploaded by a
+storage server and responds with the schedules of its volumes.*/
+func (c *controller) onScheduleSyncRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	serverID, ok := storageservers.ServerID(ctx)
+	if !ok {
+		storageservers.SendError(ctx, msg.RequestID, schedulerSubsystem, ErrServerNotRegistered)
+		return
+	}
+	err := c.scheduler.addLocalRuns(serverID, msg.Payload.(*dtos.ScheduleSyncRequest).Runs)
+	if err != nil {
+		storageservers.SendError(ctx, msg.RequestID, schedulerSubsystem, err)
+		return
+	}
+	found, err := c.scheduler.serverSchedules(serverID)
+	if err != nil {
+		storageservers.SendError(ctx, msg.RequestID, schedulerSubsystem, err)
+		return
+	}
+	c.scheduler.Reload()
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, &dtos.ScheduleSyncResponse{Schedules: found}))
+}
@@ -0,0 +1,60 @@
+package scheduler
+
+import (
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/master/models"
+)
+
+func toDTO(schedule models.Schedule) dtos.Schedule {
+	return dtos.Schedule{
+		ID:            schedule.ID.Hex(),
+		Name:          schedule.Name,
+		Cron:          schedule.Cron,
+		Timezone:      schedule.Timezone,
+		ServerID:      dtos.StorageServerID(schedule.ServerID),
+		VolumeUUID:    dtos.UUIDType(schedule.VolumeUUID),
+		Subvolume:     schedule.Subvolume,
+		Action:        schedule.Action,
+		SnapshotDir:   schedule.SnapshotDir,
+		BackupDir:     schedule.BackupDir,
+		BalanceUsage:  schedule.BalanceUsage,
+		CatchUp:       schedule.CatchUp,
+		Enabled:       schedule.Enabled,
+		LastScheduled: schedule.LastScheduled,
+	}
+}
+
+//fromDTO converts the schedule, the default catch-up policy is CatchUpSkip
+func fromDTO(schedule dtos.Schedule) models.Schedule {
+	s := models.Schedule{
+		Name:         schedule.Name,
+		Cron:         schedule.Cron,
+		Timezone:     schedule.Timezone,
+		ServerID:     int32(schedule.ServerID),
+		VolumeUUID:   string(schedule.VolumeUUID),
+		Subvolume:    schedule.Subvolume,
+		Action:       schedule.Action,
+		SnapshotDir:  schedule.SnapshotDir,
+		BackupDir:    schedule.BackupDir,
+		BalanceUsage: schedule.BalanceUsage,
+		CatchUp:      schedule.CatchUp,
+		Enabled:      schedule.Enabled,
+	}
+	if s.CatchUp == "" {
+		s.CatchUp = dtos.CatchUpSkip
+	}
+	return s
+}
+
+func runToDTO(run models.ScheduleRun) dtos.ScheduleRun {
+	return dtos.ScheduleRun{
+		ScheduleID:    run.ScheduleID.Hex(),
+		ScheduledTime: run.ScheduledTime,
+		StartTime:     run.StartTime,
+		EndTime:       run.EndTime,
+		Outcome:       run.Outcome,
+		Error:         run.Error,
+		TaskID:        run.TaskID,
+		Local:         run.Local,
+	}
+}
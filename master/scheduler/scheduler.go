@@ -0,0 +1,352 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/common/request"
+	"github.com/djarek/btrfs-volume-manager/common/schedules"
+	"github.com/djarek/btrfs-volume-manager/common/tasks"
+	"github.com/djarek/btrfs-volume-manager/master/models"
+	"github.com/djarek/btrfs-volume-manager/master/storageservers"
+)
+
+const (
+	//maxWait is the longest the scheduler sleeps, so that clock changes are noticed
+	maxWait          = time.Minute
+	taskPollInterval = 10 * time.Second
+)
+
+var (
+	//ErrTaskCancelled indicates that the task started by a run was cancelled
+	ErrTaskCancelled = errors.New("The task was cancelled")
+)
+
+type scheduleStore interface {
+	FindSchedules() ([]models.Schedule, error)
+	FindSchedule(ID bson.ObjectId) (models.Schedule, error)
+	SaveSchedule(models.Schedule) (models.Schedule, error)
+	SetLastScheduled(ID bson.ObjectId, t time.Time) error
+	AdvanceLastScheduled(ID bson.ObjectId, t time.Time) error
+	DeleteSchedule(ID bson.ObjectId) error
+}
+
+type runStore interface {
+	SaveRun(models.ScheduleRun) (models.ScheduleRun, error)
+	AddLocalRun(models.ScheduleRun) error
+	FindRuns(scheduleID bson.ObjectId, limit int) ([]models.ScheduleRun, error)
+}
+
+/*Scheduler runs the schedules stored on the master. The actions are sent to the
+storage servers as the same requests the clients send, the outcome of every run
+is recorded. The storage servers receive the schedules of their volumes and run
+them on their own while they are disconnected, their runs are uploaded once
+they reconnect.*/
+type Scheduler struct {
+	serverTracker storageservers.Tracker
+	schedules     scheduleStore
+	runs          runStore
+	reload        chan struct{}
+}
+
+//NewScheduler constructs a new valid Scheduler, it has to be started with Start
+func NewScheduler(tracker storageservers.Tracker, schedules scheduleStore, runs runStore) *Scheduler {
+	return &Scheduler{
+		serverTracker: tracker,
+		schedules:     schedules,
+		runs:          runs,
+		reload:        make(chan struct{}, 1),
+	}
+}
+
+/*Start starts the scheduler. The runs missed while the master was down are
+handled by the catch-up policy of their schedule.*/
+func (s *Scheduler) Start() {
+	go func() {
+		for {
+			wait := s.runDue(time.Now())
+			select {
+			case <-time.After(wait):
+			case <-s.reload:
+			}
+		}
+	}()
+}
+
+//Reload makes the scheduler read the changed schedules
+func (s *Scheduler) Reload() {
+	select {
+	case s.reload <- struct{}{}:
+	default:
+	}
+}
+
+/*runDue starts the due runs of the enabled schedules and returns how long to
+wait for the next one. The time of the last due run is stored before the runs
+are started, so that no run is repeated if the master stops.*/
+func (s *Scheduler) runDue(now time.Time) time.Duration {
+	stored, err := s.schedules.FindSchedules()
+	if err != nil {
+		log.Println(err)
+		return maxWait
+	}
+	wait := maxWait
+	for _, schedule := range stored {
+		if !schedule.Enabled {
+			continue
+		}
+		cron, loc, err := schedules.Parse(schedule.Cron, schedule.Timezone)
+		if err != nil {
+			log.Printf("Schedule %s: %s\n", schedule.ID.Hex(), err)
+			continue
+		}
+		if schedule.LastScheduled.IsZero() {
+			schedule.LastScheduled = now
+			err = s.schedules.SetLastScheduled(schedule.ID, now)
+		} else {
+			err = s.startDue(schedule, cron, loc, now)
+		}
+		if err != nil {
+			log.Printf("Schedule %s: %s\n", schedule.ID.Hex(), err)
+			continue
+		}
+		next := cron.Next(now.In(loc))
+		if !next.IsZero() && next.Sub(now) < wait {
+			wait = next.Sub(now)
+		}
+	}
+	return wait
+}
+
+/*startDue starts the due runs of the schedule. The runs of a disconnected
+storage server are left to the storage server, they are recorded once it
+uploads them.*/
+func (s *Scheduler) startDue(schedule models.Schedule, cron *schedules.Cron, loc *time.Location, now time.Time) error {
+	due, total := schedules.DueRuns(cron, schedule.LastScheduled.In(loc), now, schedules.MaxCatchUpRuns+1)
+	if total == 0 {
+		return nil
+	}
+	err := s.schedules.SetLastScheduled(schedule.ID, due[len(due)-1])
+	if err != nil {
+		return err
+	}
+	if _, connected := s.serverTracker.GetServerContext(dtos.StorageServerID(schedule.ServerID)); !connected {
+		log.Printf("Schedule %s: storage server %d not connected, %d runs left to it\n",
+			schedule.ID.Hex(), schedule.ServerID, total)
+		return nil
+	}
+	started, skipped := schedules.PlanCatchUp(due, total, now, schedule.CatchUp)
+	if skipped > 0 {
+		log.Printf("Schedule %s: skipping %d missed runs\n", schedule.ID.Hex(), skipped)
+		s.saveRun(models.ScheduleRun{
+			ScheduleID:    schedule.ID,
+			ScheduledTime: due[0],
+			StartTime:     now,
+			EndTime:       now,
+			Outcome:       dtos.RunOutcomeSkipped,
+			Error:         fmt.Sprintf("%d runs were missed while the master was down", skipped),
+		})
+	}
+	for _, t := range started {
+		go s.run(schedule, t)
+	}
+	return nil
+}
+
+func (s *Scheduler) saveRun(run models.ScheduleRun) models.ScheduleRun {
+	saved, err := s.runs.SaveRun(run)
+	if err != nil {
+		log.Println(err)
+		return run
+	}
+	return saved
+}
+
+//run runs the action of the schedule and records its outcome
+func (s *Scheduler) run(schedule models.Schedule, scheduledTime time.Time) {
+	log.Printf("Schedule %s: starting the %s scheduled at %s\n", schedule.ID.Hex(), schedule.Action, scheduledTime)
+	run := s.saveRun(models.ScheduleRun{
+		ScheduleID:    schedule.ID,
+		ScheduledTime: scheduledTime,
+		StartTime:     time.Now(),
+		Outcome:       dtos.RunOutcomeRunning,
+	})
+
+	err := s.execute(schedule, scheduledTime, &run)
+	run.EndTime = time.Now()
+	if err != nil {
+		log.Printf("Schedule %s: the %s scheduled at %s failed: %s\n", schedule.ID.Hex(), schedule.Action, scheduledTime, err)
+		run.Outcome = dtos.RunOutcomeFailed
+		run.Error = err.Error()
+	} else {
+		log.Printf("Schedule %s: the %s scheduled at %s succeeded\n", schedule.ID.Hex(), schedule.Action, scheduledTime)
+		run.Outcome = dtos.RunOutcomeSucceeded
+	}
+	s.saveRun(run)
+}
+
+/*execute sends the request of the action to the storage server. The actions
+run as tasks are waited for, the task is recorded in the run once it started.*/
+func (s *Scheduler) execute(schedule models.Schedule, scheduledTime time.Time, run *models.ScheduleRun) error {
+	serverID := dtos.StorageServerID(schedule.ServerID)
+	ctx, ok := s.serverTracker.GetServerContext(serverID)
+	if !ok {
+		return storageservers.ErrServerNotConnected
+	}
+	ids := dtos.IDContainer{ServerID: serverID}
+	volume := dtos.VolumeUUIDContainer{VolumeUUID: dtos.UUIDType(schedule.VolumeUUID)}
+
+	var taskRequest dtos.PayloadType
+	switch schedule.Action {
+	case dtos.ScheduleActionSnapshot, dtos.ScheduleActionBackup:
+		snapshot := &dtos.BtrfsSubvolumeSnapshotRequest{
+			IDContainer:         ids,
+			VolumeUUIDContainer: volume,
+			RelativePath:        schedule.Subvolume,
+			TargetPath:          schedules.SnapshotPath(schedule.Subvolume, schedule.SnapshotDir, scheduledTime),
+			ReadOnly:            true,
+		}
+		_, err := storageservers.RequestSlave(ctx, snapshot)
+		if err != nil || schedule.Action == dtos.ScheduleActionSnapshot {
+			return err
+		}
+		taskRequest = &dtos.BackupStartRequest{
+			IDContainer:         ids,
+			VolumeUUIDContainer: volume,
+			RelativePath:        snapshot.TargetPath,
+			TargetDir:           schedule.BackupDir,
+		}
+	case dtos.ScheduleActionScrub:
+		taskRequest = &dtos.BtrfsScrubStartRequest{IDContainer: ids, VolumeUUIDContainer: volume}
+	case dtos.ScheduleActionBalance:
+		balance := &dtos.BtrfsBalanceStartRequest{IDContainer: ids, VolumeUUIDContainer: volume}
+		if schedule.BalanceUsage > 0 {
+			usage := schedule.BalanceUsage
+			balance.Data = &dtos.BalanceFilter{Usage: &usage}
+			balance.Metadata = &dtos.BalanceFilter{Usage: &usage}
+		}
+		taskRequest = balance
+	default:
+		return schedules.ErrUnknownAction
+	}
+
+	response, err := storageservers.RequestSlave(ctx, taskRequest)
+	if err != nil {
+		return err
+	}
+	var task tasks.Task
+	switch payload := response.Payload.(type) {
+	case *dtos.BackupStartResponse:
+		task = payload.Task
+	case *dtos.BtrfsScrubStartResponse:
+		task = payload.Task
+	case *dtos.BtrfsBalanceStartResponse:
+		task = payload.Task
+	}
+	run.TaskID = uint64(task.ID)
+	*run = s.saveRun(*run)
+	return waitTask(ctx, serverID, task)
+}
+
+//waitTask polls the state of the task until it ends
+func waitTask(ctx *request.Context, serverID dtos.StorageServerID, task tasks.Task) error {
+	for task.State.IsActive() {
+		time.Sleep(taskPollInterval)
+		status := &dtos.TaskStatusRequest{}
+		status.ServerID = serverID
+		status.TaskID = task.ID
+		response, err := storageservers.RequestSlave(ctx, status)
+		if err != nil {
+			return err
+		}
+		task = response.Payload.(*dtos.TaskStatusResponse).Task
+	}
+	switch task.State {
+	case tasks.StateFailed:
+		return errors.New(task.Error)
+	case tasks.StateCancelled:
+		return ErrTaskCancelled
+	}
+	return nil
+}
+
+//serverSchedules returns the schedules of the volumes of the storage server
+func (s *Scheduler) serverSchedules(serverID dtos.StorageServerID) ([]dtos.Schedule, error) {
+	stored, err := s.schedules.FindSchedules()
+	if err != nil {
+		return nil, err
+	}
+	found := []dtos.Schedule{}
+	for _, schedule := range stored {
+		if dtos.StorageServerID(schedule.ServerID) == serverID {
+			found = append(found, toDTO(schedule))
+		}
+	}
+	return found, nil
+}
+
+//pushSchedules sends the schedules of its volumes to the storage server, if it is connected
+func (s *Scheduler) pushSchedules(serverID dtos.StorageServerID) {
+	ctx, ok := s.serverTracker.GetServerContext(serverID)
+	if !ok {
+		return
+	}
+	found, err := s.serverSchedules(serverID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	ctx.SendAsync(dtos.NewWebSocketMessage(0, &dtos.ScheduleSyncNotification{Schedules: found}))
+}
+
+/*addLocalRuns records the runs uploaded by a storage server. The schedules are
+advanced past the uploaded runs, so that they are not caught up again. Runs of
+schedules of other storage servers are dropped.*/
+func (s *Scheduler) addLocalRuns(serverID dtos.StorageServerID, runs []dtos.ScheduleRun) error {
+	owned := make(map[bson.ObjectId]bool)
+	for _, run := range runs {
+		if !bson.IsObjectIdHex(run.ScheduleID) {
+			continue
+		}
+		ID := bson.ObjectIdHex(run.ScheduleID)
+		isOwned, checked := owned[ID]
+		if !checked {
+			schedule, err := s.schedules.FindSchedule(ID)
+			if err != nil && err != mgo.ErrNotFound {
+				return err
+			}
+			isOwned = err == nil && dtos.StorageServerID(schedule.ServerID) == serverID
+			owned[ID] = isOwned
+			if !isOwned {
+				log.Printf("Dropping the runs of schedule %s uploaded by storage server %d, it is not its schedule\n",
+					run.ScheduleID, serverID)
+			}
+		}
+		if !isOwned {
+			continue
+		}
+		err := s.runs.AddLocalRun(models.ScheduleRun{
+			ScheduleID:    ID,
+			ScheduledTime: run.ScheduledTime,
+			StartTime:     run.StartTime,
+			EndTime:       run.EndTime,
+			Outcome:       run.Outcome,
+			Error:         run.Error,
+			TaskID:        run.TaskID,
+		})
+		if err != nil {
+			return err
+		}
+		err = s.schedules.AdvanceLastScheduled(ID, run.ScheduledTime)
+		if err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
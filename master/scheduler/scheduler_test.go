@@ -0,0 +1,354 @@
+package scheduler
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/common/request"
+	"github.com/djarek/btrfs-volume-manager/common/schedules"
+	"github.com/djarek/btrfs-volume-manager/common/tasks"
+	"github.com/djarek/btrfs-volume-manager/master/models"
+	"github.com/djarek/btrfs-volume-manager/master/storageservers"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type scheduleStoreMock struct {
+	schedules     []models.Schedule
+	lastScheduled map[bson.ObjectId]time.Time
+	advanced      map[bson.ObjectId]time.Time
+}
+
+func newScheduleStoreMock(schedules ...models.Schedule) *scheduleStoreMock {
+	return &scheduleStoreMock{
+		schedules:     schedules,
+		lastScheduled: make(map[bson.ObjectId]time.Time),
+		advanced:      make(map[bson.ObjectId]time.Time),
+	}
+}
+
+func (s *scheduleStoreMock) FindSchedules() ([]models.Schedule, error) {
+	return s.schedules, nil
+}
+
+func (s *scheduleStoreMock) FindSchedule(ID bson.ObjectId) (models.Schedule, error) {
+	for _, schedule := range s.schedules {
+		if schedule.ID == ID {
+			return schedule, nil
+		}
+	}
+	return models.Schedule{}, mgo.ErrNotFound
+}
+
+func (s *scheduleStoreMock) SaveSchedule(schedule models.Schedule) (models.Schedule, error) {
+	s.schedules = append(s.schedules, schedule)
+	return schedule, nil
+}
+
+func (s *scheduleStoreMock) SetLastScheduled(ID bson.ObjectId, t time.Time) error {
+	s.lastScheduled[ID] = t
+	return nil
+}
+
+func (s *scheduleStoreMock) AdvanceLastScheduled(ID bson.ObjectId, t time.Time) error {
+	s.advanced[ID] = t
+	return nil
+}
+
+func (s *scheduleStoreMock) DeleteSchedule(ID bson.ObjectId) error {
+	return nil
+}
+
+//runStoreMock records the runs, the runs that ended are also sent to finished
+type runStoreMock struct {
+	mtx      sync.Mutex
+	saved    map[bson.ObjectId]models.ScheduleRun
+	local    []models.ScheduleRun
+	finished chan models.ScheduleRun
+}
+
+func newRunStoreMock() *runStoreMock {
+	return &runStoreMock{
+		saved:    make(map[bson.ObjectId]models.ScheduleRun),
+		finished: make(chan models.ScheduleRun, schedules.MaxCatchUpRuns+1),
+	}
+}
+
+func (r *runStoreMock) SaveRun(run models.ScheduleRun) (models.ScheduleRun, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if run.ID == "" {
+		run.ID = bson.NewObjectId()
+	}
+	r.saved[run.ID] = run
+	if run.Outcome == dtos.RunOutcomeSucceeded || run.Outcome == dtos.RunOutcomeFailed {
+		r.finished <- run
+	}
+	return run, nil
+}
+
+func (r *runStoreMock) AddLocalRun(run models.ScheduleRun) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	run.Local = true
+	r.local = append(r.local, run)
+	return nil
+}
+
+func (r *runStoreMock) FindRuns(scheduleID bson.ObjectId, limit int) ([]models.ScheduleRun, error) {
+	return nil, nil
+}
+
+//withOutcome returns the saved runs with the outcome
+func (r *runStoreMock) withOutcome(outcome string) []models.ScheduleRun {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	var found []models.ScheduleRun
+	for _, run := range r.saved {
+		if run.Outcome == outcome {
+			found = append(found, run)
+		}
+	}
+	return found
+}
+
+//waitFinished waits for count runs to end and returns them
+func (r *runStoreMock) waitFinished(t *testing.T, count int) []models.ScheduleRun {
+	var finished []models.ScheduleRun
+	for len(finished) < count {
+		select {
+		case run := <-r.finished:
+			finished = append(finished, run)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d of %d runs finished", len(finished), count)
+		}
+	}
+	return finished
+}
+
+type trackerMock struct {
+	servers map[dtos.StorageServerID]*request.Context
+}
+
+func (tr *trackerMock) GetServerContext(ID dtos.StorageServerID) (*request.Context, bool) {
+	ctx, ok := tr.servers[ID]
+	return ctx, ok
+}
+
+func (tr *trackerMock) GetAllServers() []*request.Context {
+	var all []*request.Context
+	for _, ctx := range tr.servers {
+		all = append(all, ctx)
+	}
+	return all
+}
+
+func (tr *trackerMock) RegisterServer(ctx *request.Context, ID dtos.StorageServerID) *request.Context {
+	replaced := tr.servers[ID]
+	tr.servers[ID] = ctx
+	return replaced
+}
+
+func (tr *trackerMock) RemoveServer(ID dtos.StorageServerID, ctx *request.Context) {
+	if tr.servers[ID] == ctx {
+		delete(tr.servers, ID)
+	}
+}
+
+/*slaveMock is the connection of a storage server, every request is answered
+with the payload returned by respond.*/
+type slaveMock struct {
+	mtx      sync.Mutex
+	ctx      *request.Context
+	requests []dtos.PayloadType
+	respond  func(dtos.PayloadType) dtos.PayloadType
+}
+
+func (s *slaveMock) SendAsync(msg dtos.WebSocketMessage) <-chan error {
+	s.mtx.Lock()
+	s.requests = append(s.requests, msg.Payload)
+	s.mtx.Unlock()
+	if responseChannel, ok := s.ctx.GetRequest(msg.RequestID); ok {
+		responseChannel <- dtos.NewWebSocketMessage(msg.RequestID, s.respond(msg.Payload))
+	}
+	return nil
+}
+
+func (s *slaveMock) Close() {}
+
+func (s *slaveMock) sent() []dtos.PayloadType {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]dtos.PayloadType{}, s.requests...)
+}
+
+//finishTasks answers the requests as a storage server whose tasks end at once
+func finishTasks(payload dtos.PayloadType) dtos.PayloadType {
+	finished := dtos.TaskContainer{Task: tasks.Task{ID: 7, State: tasks.StateFinished}}
+	switch payload.(type) {
+	case *dtos.BtrfsScrubStartRequest:
+		return &dtos.BtrfsScrubStartResponse{TaskContainer: finished}
+	case *dtos.BtrfsBalanceStartRequest:
+		return &dtos.BtrfsBalanceStartResponse{TaskContainer: finished}
+	case *dtos.BackupStartRequest:
+		return &dtos.BackupStartResponse{TaskContainer: finished}
+	}
+	return &dtos.BtrfsSubvolumeSnapshotResponse{}
+}
+
+//newScheduler returns a scheduler with storage server 1 connected
+func newScheduler(store *scheduleStoreMock, respond func(dtos.PayloadType) dtos.PayloadType) (*Scheduler, *runStoreMock, *slaveMock) {
+	slave := &slaveMock{respond: respond}
+	slave.ctx = request.NewContext(slave)
+	tracker := &trackerMock{servers: map[dtos.StorageServerID]*request.Context{1: slave.ctx}}
+	runs := newRunStoreMock()
+	return NewScheduler(tracker, store, runs), runs, slave
+}
+
+func hourlyScrub(serverID int32, catchUp string, lastScheduled time.Time) models.Schedule {
+	return models.Schedule{
+		ID:            bson.NewObjectId(),
+		Cron:          "0 * * * *",
+		Timezone:      "UTC",
+		ServerID:      serverID,
+		VolumeUUID:    "5a8c1e2b-5c3a-4b8e-9d43-6a2f0f1c7e11",
+		Action:        dtos.ScheduleActionScrub,
+		CatchUp:       catchUp,
+		Enabled:       true,
+		LastScheduled: lastScheduled,
+	}
+}
+
+func TestRunDue(t *testing.T) {
+	now := time.Date(2026, time.October, 18, 12, 59, 30, 0, time.UTC)
+	added := hourlyScrub(1, dtos.CatchUpSkip, time.Time{})
+	disabled := hourlyScrub(1, dtos.CatchUpSkip, now.Add(-3*time.Hour))
+	disabled.Enabled = false
+	invalid := hourlyScrub(1, dtos.CatchUpSkip, now.Add(-3*time.Hour))
+	invalid.Cron = "0 * *"
+	store := newScheduleStoreMock(added, disabled, invalid)
+	s, runs, slave := newScheduler(store, finishTasks)
+
+	wait := s.runDue(now)
+	assert.Equal(t, 30*time.Second, wait)
+	assert.Equal(t, map[bson.ObjectId]time.Time{added.ID: now}, store.lastScheduled,
+		"a new schedule starts counting from now, the others are left alone")
+	assert.Empty(t, runs.saved)
+	assert.Empty(t, slave.sent())
+}
+
+func TestStartDueDisconnectedServer(t *testing.T) {
+	now := time.Date(2026, time.October, 18, 12, 0, 30, 0, time.UTC)
+	schedule := hourlyScrub(2, dtos.CatchUpAll, time.Date(2026, time.October, 18, 9, 0, 0, 0, time.UTC))
+	store := newScheduleStoreMock(schedule)
+	s, runs, slave := newScheduler(store, finishTasks)
+
+	s.runDue(now)
+	assert.Equal(t, time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC), store.lastScheduled[schedule.ID])
+	assert.Empty(t, runs.saved, "the runs are left to the storage server")
+	assert.Empty(t, slave.sent())
+}
+
+func TestStartDueCatchUpOnce(t *testing.T) {
+	now := time.Date(2026, time.October, 18, 11, 30, 0, 0, time.UTC)
+	schedule := hourlyScrub(1, dtos.CatchUpOnce, time.Date(2026, time.October, 18, 9, 0, 0, 0, time.UTC))
+	store := newScheduleStoreMock(schedule)
+	s, runs, slave := newScheduler(store, finishTasks)
+
+	s.runDue(now)
+	finished := runs.waitFinished(t, 1)
+	assert.Equal(t, time.Date(2026, time.October, 18, 11, 0, 0, 0, time.UTC), finished[0].ScheduledTime)
+	assert.Equal(t, dtos.RunOutcomeSucceeded, finished[0].Outcome)
+	assert.Equal(t, uint64(7), finished[0].TaskID)
+	skipped := runs.withOutcome(dtos.RunOutcomeSkipped)
+	if assert.Len(t, skipped, 1) {
+		assert.Equal(t, time.Date(2026, time.October, 18, 10, 0, 0, 0, time.UTC), skipped[0].ScheduledTime)
+		assert.Equal(t, "1 runs were missed while the master was down", skipped[0].Error)
+	}
+	assert.Equal(t, time.Date(2026, time.October, 18, 11, 0, 0, 0, time.UTC), store.lastScheduled[schedule.ID])
+	assert.Len(t, slave.sent(), 1)
+}
+
+func TestStartDueCatchUpAll(t *testing.T) {
+	now := time.Date(2026, time.October, 18, 11, 30, 0, 0, time.UTC)
+	schedule := hourlyScrub(1, dtos.CatchUpAll, time.Date(2026, time.October, 18, 9, 0, 0, 0, time.UTC))
+	store := newScheduleStoreMock(schedule)
+	s, runs, slave := newScheduler(store, finishTasks)
+
+	s.runDue(now)
+	finished := runs.waitFinished(t, 2)
+	var scheduledTimes []time.Time
+	for _, run := range finished {
+		assert.Equal(t, dtos.RunOutcomeSucceeded, run.Outcome)
+		scheduledTimes = append(scheduledTimes, run.ScheduledTime)
+	}
+	assert.Contains(t, scheduledTimes, time.Date(2026, time.October, 18, 10, 0, 0, 0, time.UTC))
+	assert.Contains(t, scheduledTimes, time.Date(2026, time.October, 18, 11, 0, 0, 0, time.UTC))
+	assert.Empty(t, runs.withOutcome(dtos.RunOutcomeSkipped))
+	assert.Len(t, slave.sent(), 2)
+}
+
+func TestExecute(t *testing.T) {
+	scheduledTime := time.Date(2026, time.October, 18, 3, 0, 0, 0, time.UTC)
+	backup := hourlyScrub(1, dtos.CatchUpSkip, scheduledTime)
+	backup.Action = dtos.ScheduleActionBackup
+	backup.Subvolume = "home"
+	backup.SnapshotDir = "snapshots"
+	backup.BackupDir = "/mnt/backup"
+	s, runs, slave := newScheduler(newScheduleStoreMock(backup), finishTasks)
+
+	var run models.ScheduleRun
+	assert.NoError(t, s.execute(backup, scheduledTime, &run))
+	assert.Equal(t, uint64(7), run.TaskID)
+	assert.Len(t, runs.saved, 1, "the run is saved once its task started")
+	sent := slave.sent()
+	if assert.Len(t, sent, 2) {
+		snapshot := sent[0].(*dtos.BtrfsSubvolumeSnapshotRequest)
+		assert.Equal(t, "snapshots/home@2026-10-18_0300+0000", snapshot.TargetPath)
+		assert.True(t, snapshot.ReadOnly)
+		started := sent[1].(*dtos.BackupStartRequest)
+		assert.Equal(t, snapshot.TargetPath, started.RelativePath)
+		assert.Equal(t, "/mnt/backup", started.TargetDir)
+	}
+
+	s, _, _ = newScheduler(newScheduleStoreMock(backup), func(payload dtos.PayloadType) dtos.PayloadType {
+		return &dtos.Error{Subsystem: "btrfs", Details: "No such subvolume"}
+	})
+	assert.EqualError(t, s.execute(backup, scheduledTime, &models.ScheduleRun{}), dtos.Error{Subsystem: "btrfs", Details: "No such subvolume"}.Error())
+
+	scrub := hourlyScrub(1, dtos.CatchUpSkip, scheduledTime)
+	s, _, _ = newScheduler(newScheduleStoreMock(scrub), func(payload dtos.PayloadType) dtos.PayloadType {
+		return &dtos.BtrfsScrubStartResponse{TaskContainer: dtos.TaskContainer{
+			Task: tasks.Task{ID: 8, State: tasks.StateFailed, Error: "Device removed"},
+		}}
+	})
+	assert.EqualError(t, s.execute(scrub, scheduledTime, &models.ScheduleRun{}), "Device removed")
+
+	disconnected := hourlyScrub(2, dtos.CatchUpSkip, scheduledTime)
+	assert.Equal(t, storageservers.ErrServerNotConnected, s.execute(disconnected, scheduledTime, &models.ScheduleRun{}))
+}
+
+func TestAddLocalRuns(t *testing.T) {
+	owned := hourlyScrub(1, dtos.CatchUpSkip, time.Date(2026, time.October, 18, 9, 0, 0, 0, time.UTC))
+	foreign := hourlyScrub(2, dtos.CatchUpSkip, time.Date(2026, time.October, 18, 9, 0, 0, 0, time.UTC))
+	store := newScheduleStoreMock(owned, foreign)
+	s, runs, _ := newScheduler(store, finishTasks)
+	scheduledTime := time.Date(2026, time.October, 18, 10, 0, 0, 0, time.UTC)
+
+	err := s.addLocalRuns(1, []dtos.ScheduleRun{
+		{ScheduleID: owned.ID.Hex(), ScheduledTime: scheduledTime, Outcome: dtos.RunOutcomeSucceeded, TaskID: 3},
+		{ScheduleID: foreign.ID.Hex(), ScheduledTime: scheduledTime, Outcome: dtos.RunOutcomeSucceeded},
+		{ScheduleID: bson.NewObjectId().Hex(), ScheduledTime: scheduledTime, Outcome: dtos.RunOutcomeSucceeded},
+		{ScheduleID: "not-an-id", ScheduledTime: scheduledTime, Outcome: dtos.RunOutcomeSucceeded},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, runs.local, 1, "only the runs of the schedules of the storage server are recorded") {
+		assert.Equal(t, owned.ID, runs.local[0].ScheduleID)
+		assert.Equal(t, uint64(3), runs.local[0].TaskID)
+		assert.True(t, runs.local[0].Local)
+	}
+	assert.Equal(t, map[bson.ObjectId]time.Time{owned.ID: scheduledTime}, store.advanced)
+}
@@ -4,17 +4,21 @@ import (
 	"github.com/djarek/btrfs-volume-manager/common/dtos"
 	"github.com/djarek/btrfs-volume-manager/common/request"
 	"github.com/djarek/btrfs-volume-manager/common/router"
+	"github.com/djarek/btrfs-volume-manager/common/tasks"
+	"github.com/djarek/btrfs-volume-manager/master/notifications"
 	"github.com/djarek/btrfs-volume-manager/master/storageservers"
 )
 
 type controller struct {
-	serverTracker storageservers.Tracker
+	serverTracker   storageservers.Tracker
+	notificationHub notifications.Hub
 }
 
 /*NewController constructs a new valid controller*/
-func NewController(tracker storageservers.Tracker) router.HandlerExporter {
+func NewController(tracker storageservers.Tracker, hub notifications.Hub) router.HandlerExporter {
 	return &controller{
-		serverTracker: tracker,
+		serverTracker:   tracker,
+		notificationHub: hub,
 	}
 }
 
@@ -39,16 +43,132 @@ func (c *controller) ExportHandlers(adder router.HandlerAdder) {
 
 	adder.AddHandler(dtos.WSMsgBtrfsSubvolumeSnapshotRequest, c.ForwardToSlave)
 	adder.AddHandler(dtos.WSMsgBtrfsSubvolumeSnapshotResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsVolumeUsageRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsVolumeUsageResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsDeviceStatsRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsDeviceStatsResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgTaskStatusRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgTaskStatusResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgTaskPauseRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgTaskPauseResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgTaskResumeRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgTaskResumeResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgTaskCancelRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgTaskCancelResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsScrubStartRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsScrubStartResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsBalanceStartRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsBalanceStartResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsProfileConvertRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsProfileConvertResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsDeviceAddRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsDeviceAddResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsDeviceRemoveRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsDeviceRemoveResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgUnusedBlockDeviceListRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgUnusedBlockDeviceListResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsReplaceStartRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsReplaceStartResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsVolumeCreatePlanRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsVolumeCreatePlanResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsVolumeCreateRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsVolumeCreateResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsDeviceResizeRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsDeviceResizeResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsVolumeRelabelRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsVolumeRelabelResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsQuotaSetRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsQuotaSetResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsQgroupListRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsQgroupListResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsQgroupLimitRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsQgroupLimitResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsQgroupCreateRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsQgroupCreateResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsQgroupAssignRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsQgroupAssignResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsQgroupDestroyRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsQgroupDestroyResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsPropertyGetRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsPropertyGetResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsPropertySetRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsPropertySetResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsSubvolumeSetDefaultRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsSubvolumeSetDefaultResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBackupStartRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBackupStartResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBackupCatalogRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBackupCatalogResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBackupVerifyRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBackupVerifyResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBackupPruneRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBackupPruneResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBackupRestoreRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBackupRestoreResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsMountRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsMountResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgBtrfsUnmountRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgBtrfsUnmountResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgFstabListRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgFstabListResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgFstabEntrySetRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgFstabEntrySetResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgFstabEntryRemoveRequest, c.ForwardToSlave)
+	adder.AddHandler(dtos.WSMsgFstabEntryRemoveResponse, router.DefaultResponseHandler)
+
+	adder.AddHandler(dtos.WSMsgError, router.DefaultResponseHandler)
 }
 
-type serverVolumeGetter interface {
+type serverIDGetter interface {
 	GetServerID() dtos.StorageServerID
-	GetVolumeUUID() dtos.UUIDType
 }
 
+type taskGetter interface {
+	GetTask() tasks.Task
+}
+
+/*ForwardToSlave forwards the request to the storage server it is addressed to
+and passes the response back to the client. If the response carries a task, the
+client is subscribed to the task's notifications.*/
 func (c *controller) ForwardToSlave(ctx *request.Context, msg dtos.WebSocketMessage) {
-	servVolGetter := msg.Payload.(serverVolumeGetter)
-	storageServCtx, ok := c.serverTracker.GetServerContext(servVolGetter.GetServerID())
+	serverID := msg.Payload.(serverIDGetter).GetServerID()
+	storageServCtx, ok := c.serverTracker.GetServerContext(serverID)
 	if !ok {
 		//TODO: unknown storage server, send error
 		return
@@ -61,6 +181,9 @@ func (c *controller) ForwardToSlave(ctx *request.Context, msg dtos.WebSocketMess
 	go func() {
 		response, ok := <-responseChannel
 		if ok {
+			if t, isTask := response.Payload.(taskGetter); isTask {
+				c.notificationHub.SubscribeTask(serverID, t.GetTask().ID, ctx)
+			}
 			response.RequestID = clientRequestID
 			ctx.SendAsync(response)
 		}
@@ -137,6 +260,7 @@ func (c *controller) onBtrfsListRequest(ctx *request.Context, msg dtos.WebSocket
 		//TODO: unknown storage server, send error
 		return
 	}
+	c.notificationHub.SubscribeVolumes(listRequest.ServerID, ctx)
 	clientRequestID := msg.RequestID
 	requestID, responseChannel := storageServCtx.NewRequest()
 	msg.RequestID = requestID
@@ -1,13 +1,24 @@
 package storageservers
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
 	"github.com/djarek/btrfs-volume-manager/common/dtos"
 	"github.com/djarek/btrfs-volume-manager/common/request"
 	"github.com/djarek/btrfs-volume-manager/common/router"
+	"github.com/djarek/btrfs-volume-manager/master/models"
 )
 
 const (
-	serverDetailsKey = "StorageServerDetails"
+	serverDetailsKey        = "StorageServerDetails"
+	storageServersSubsystem = "storageservers"
+	reconnectTokenSize      = 32
 )
 
 type storageServerDetails struct {
@@ -17,8 +28,17 @@ type storageServerDetails struct {
 	slaveVersion string
 }
 
+/*identityStore persists the identities of the storage servers, so that they
+keep their IDs across reconnections and restarts of the master.*/
+type identityStore interface {
+	FindServerByTokenHash(tokenHash string) (models.StorageServer, error)
+	AddServer(name string, tokenHash string) (models.StorageServer, error)
+	SetServerName(ID bson.ObjectId, name string) error
+}
+
 type controller struct {
-	tracker Tracker
+	tracker    Tracker
+	identities identityStore
 }
 
 func (c *controller) ExportHandlers(adder router.HandlerAdder) {
@@ -28,13 +48,17 @@ func (c *controller) ExportHandlers(adder router.HandlerAdder) {
 }
 
 /*NewController constructs a new valid controller*/
-func NewController(t Tracker) router.HandlerExporter {
-	return &controller{tracker: t}
+func NewController(t Tracker, identities identityStore) router.HandlerExporter {
+	return &controller{tracker: t, identities: identities}
 }
 
 func (c *controller) onServerRegistrationRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
 	request := msg.Payload.(*dtos.StorageServerRegistrationRequest)
-	ID := c.tracker.RegisterServer(ctx)
+	ID, token, err := c.identify(request)
+	if err != nil {
+		SendError(ctx, msg.RequestID, storageServersSubsystem, err)
+		return
+	}
 	details := storageServerDetails{
 		ID:           ID,
 		name:         request.ServerName,
@@ -42,17 +66,70 @@ func (c *controller) onServerRegistrationRequest(ctx *request.Context, msg dtos.
 		os:           "Ubuntu 16.04_placeholder",
 	}
 	ctx.SetSessionData(serverDetailsKey, details)
+	replaced := c.tracker.RegisterServer(ctx, ID)
+	if replaced != nil {
+		log.Printf("Storage server %d reconnected, closing its previous connection\n", ID)
+		replaced.Close()
+	}
 	responsePayload := &dtos.StorageServerRegistrationResponse{
 		AssignedID: ID,
+		Token:      token,
 	}
 	responseMsg := dtos.NewWebSocketMessage(msg.RequestID, responsePayload)
 	ctx.SendAsync(responseMsg)
 }
 
+//hashToken returns the hash of the reconnect token that is stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+/*identify finds the storage server the reconnect token was issued to. A server
+without a known token is added with a new ID and is issued a new token, so a
+server can only get an ID back with the token issued together with it.*/
+func (c *controller) identify(registration *dtos.StorageServerRegistrationRequest) (dtos.StorageServerID, string, error) {
+	if registration.Token != "" {
+		server, err := c.identities.FindServerByTokenHash(hashToken(registration.Token))
+		if err == nil {
+			if server.Name != registration.ServerName {
+				err = c.identities.SetServerName(server.ID, registration.ServerName)
+			}
+			return dtos.StorageServerID(server.ServerID), registration.Token, err
+		}
+		if err != mgo.ErrNotFound {
+			return 0, "", err
+		}
+		log.Printf("Storage server %s presented an unknown reconnect token\n", registration.ServerName)
+	}
+
+	tokenBytes := make([]byte, reconnectTokenSize)
+	_, err := rand.Read(tokenBytes)
+	if err != nil {
+		return 0, "", err
+	}
+	token := hex.EncodeToString(tokenBytes)
+	server, err := c.identities.AddServer(registration.ServerName, hashToken(token))
+	if err != nil {
+		return 0, "", err
+	}
+	return dtos.StorageServerID(server.ServerID), token, nil
+}
+
+/*ServerID returns the ID of the storage server connected with the context, if it
+is registered.*/
+func ServerID(ctx *request.Context) (dtos.StorageServerID, bool) {
+	detailsInterface, found := ctx.GetSessionData(serverDetailsKey)
+	if !found {
+		return 0, false
+	}
+	return detailsInterface.(storageServerDetails).ID, true
+}
+
 func (c *controller) onServerConnectionClose(ctx *request.Context, msg dtos.WebSocketMessage) {
 	detailsInterface, found := ctx.GetSessionData(serverDetailsKey)
 	if found {
-		c.tracker.RemoveServer(detailsInterface.(storageServerDetails).ID)
+		c.tracker.RemoveServer(detailsInterface.(storageServerDetails).ID, ctx)
 	}
 }
 
@@ -60,7 +137,11 @@ func (c *controller) onServerListRequest(ctx *request.Context, msg dtos.WebSocke
 	serverList := c.tracker.GetAllServers()
 	var storageServers []dtos.StorageServer
 	for _, storageCtx := range serverList {
-		detailsInterface, _ := storageCtx.GetSessionData(serverDetailsKey)
+		detailsInterface, found := storageCtx.GetSessionData(serverDetailsKey)
+		if !found {
+			//the server is still being registered
+			continue
+		}
 		details := detailsInterface.(storageServerDetails)
 		serv := dtos.StorageServer{
 			ID:           details.ID,
@@ -0,0 +1,113 @@
+package storageservers
+
+import (
+	"testing"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/common/request"
+	"github.com/djarek/btrfs-volume-manager/master/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type asyncSenderCloserMock struct {
+	mock.Mock
+}
+
+func (a *asyncSenderCloserMock) SendAsync(msg dtos.WebSocketMessage) <-chan error {
+	args := a.Called(msg)
+	return args.Get(0).(<-chan error)
+}
+
+func (a *asyncSenderCloserMock) Close() {
+	a.Called()
+}
+
+func newServerContext() (*request.Context, *asyncSenderCloserMock) {
+	cMock := &asyncSenderCloserMock{}
+	var r <-chan error
+	cMock.On("SendAsync", mock.Anything).Return(r)
+	return request.NewContext(cMock), cMock
+}
+
+type identityStoreMock struct {
+	servers []models.StorageServer
+}
+
+func (i *identityStoreMock) FindServerByTokenHash(tokenHash string) (models.StorageServer, error) {
+	for _, server := range i.servers {
+		if server.TokenHash == tokenHash {
+			return server, nil
+		}
+	}
+	return models.StorageServer{}, mgo.ErrNotFound
+}
+
+func (i *identityStoreMock) AddServer(name string, tokenHash string) (models.StorageServer, error) {
+	server := models.StorageServer{
+		ID:        bson.NewObjectId(),
+		ServerID:  int32(len(i.servers) + 1),
+		Name:      name,
+		TokenHash: tokenHash,
+	}
+	i.servers = append(i.servers, server)
+	return server, nil
+}
+
+func (i *identityStoreMock) SetServerName(ID bson.ObjectId, name string) error {
+	for j := range i.servers {
+		if i.servers[j].ID == ID {
+			i.servers[j].Name = name
+		}
+	}
+	return nil
+}
+
+/*register registers the server and returns the ID and the token it was given.*/
+func register(c *controller, ctx *request.Context, cMock *asyncSenderCloserMock, name string, token string) (dtos.StorageServerID, string) {
+	c.onServerRegistrationRequest(ctx, dtos.NewWebSocketMessage(0, &dtos.StorageServerRegistrationRequest{
+		ServerName: name,
+		Token:      token,
+	}))
+	calls := cMock.Calls
+	response := calls[len(calls)-1].Arguments.Get(0).(dtos.WebSocketMessage)
+	payload := response.Payload.(*dtos.StorageServerRegistrationResponse)
+	return payload.AssignedID, payload.Token
+}
+
+func TestRegisterServer(t *testing.T) {
+	store := &identityStoreMock{}
+	c := &controller{tracker: NewTracker(), identities: store}
+	first, firstMock := newServerContext()
+	ID, token := register(c, first, firstMock, "first", "")
+	assert.NotEmpty(t, token)
+	assert.NotEqual(t, token, store.servers[0].TokenHash, "only the hash of the token is stored")
+	other, otherMock := newServerContext()
+	otherID, otherToken := register(c, other, otherMock, "other", "")
+	assert.NotEqual(t, ID, otherID)
+	assert.NotEqual(t, token, otherToken)
+
+	firstMock.On("Close").Return()
+	reconnected, reconnectedMock := newServerContext()
+	reconnectedID, reconnectedToken := register(c, reconnected, reconnectedMock, "renamed", token)
+	assert.Equal(t, ID, reconnectedID)
+	assert.Equal(t, token, reconnectedToken)
+	assert.Equal(t, "renamed", store.servers[0].Name)
+	firstMock.AssertCalled(t, "Close")
+	c.onServerConnectionClose(first, dtos.WebSocketMessage{})
+	ctx, ok := c.tracker.GetServerContext(ID)
+	assert.True(t, ok)
+	assert.True(t, ctx == reconnected, "closing the replaced connection keeps the reconnected server")
+
+	impostor, impostorMock := newServerContext()
+	impostorID, _ := register(c, impostor, impostorMock, "renamed", "forged")
+	assert.NotEqual(t, ID, impostorID)
+	ctx, _ = c.tracker.GetServerContext(ID)
+	assert.True(t, ctx == reconnected, "a server cannot be taken over without its token")
+
+	c.onServerConnectionClose(reconnected, dtos.WebSocketMessage{})
+	_, ok = c.tracker.GetServerContext(ID)
+	assert.False(t, ok)
+}
@@ -0,0 +1,55 @@
+package storageservers
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/common/request"
+)
+
+const slaveResponseTimeout = 30 * time.Second
+
+var (
+	//ErrServerNotConnected indicates that the storage server is not connected
+	ErrServerNotConnected = errors.New("Storage server not connected")
+	//ErrServerDisconnected indicates that the storage server disconnected before it responded
+	ErrServerDisconnected = errors.New("Storage server disconnected")
+	//ErrNoResponse indicates that a storage server did not respond in time
+	ErrNoResponse = errors.New("The storage server did not respond")
+)
+
+/*SendError logs the error and sends it as the response to the request
+identified by requestID. A dtos.Error is sent as is, any other error is sent as
+an error of the subsystem.*/
+func SendError(ctx *request.Context, requestID int64, subsystem string, err error) {
+	log.Println(err)
+	payload, ok := err.(dtos.Error)
+	if !ok {
+		payload = dtos.Error{
+			Subsystem: subsystem,
+			Details:   err.Error(),
+		}
+	}
+	ctx.SendAsync(dtos.NewWebSocketMessage(requestID, &payload))
+}
+
+/*RequestSlave sends the request to the storage server and waits for the
+response. An error response is returned as a dtos.Error.*/
+func RequestSlave(ctx *request.Context, payload dtos.PayloadType) (dtos.WebSocketMessage, error) {
+	requestID, responseChannel := ctx.NewRequest()
+	ctx.SendAsync(dtos.NewWebSocketMessage(requestID, payload))
+	select {
+	case response, ok := <-responseChannel:
+		if !ok {
+			return response, ErrServerDisconnected
+		}
+		if err, isError := response.Payload.(*dtos.Error); isError {
+			return response, *err
+		}
+		return response, nil
+	case <-time.After(slaveResponseTimeout):
+		return dtos.WebSocketMessage{}, ErrNoResponse
+	}
+}
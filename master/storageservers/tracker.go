@@ -12,15 +12,18 @@ type serverMap map[dtos.StorageServerID]*request.Context
 type serverTracker struct {
 	serverMap serverMap
 	mtx       sync.RWMutex
-	nextID    dtos.StorageServerID
 }
 
 /*Tracker tracks storage servers currently connected to the system. */
 type Tracker interface {
 	GetServerContext(ID dtos.StorageServerID) (ctx *request.Context, ok bool)
 	GetAllServers() []*request.Context
-	RegisterServer(ctx *request.Context) dtos.StorageServerID
-	RemoveServer(ID dtos.StorageServerID)
+	/*RegisterServer registers the storage server connected with the context under
+	its ID. A context the server was registered with before is returned as
+	replaced, e.g. if the server reconnected before its old connection closed.*/
+	RegisterServer(ctx *request.Context, ID dtos.StorageServerID) (replaced *request.Context)
+	//RemoveServer removes the server only if it is still registered with the given context
+	RemoveServer(ID dtos.StorageServerID, ctx *request.Context)
 }
 
 /*NewTracker constructs a new valid ServerTracker*/
@@ -47,19 +50,20 @@ func (s *serverTracker) GetAllServers() []*request.Context {
 	return ctxList
 }
 
-func (s *serverTracker) RegisterServer(ctx *request.Context) dtos.StorageServerID {
+func (s *serverTracker) RegisterServer(ctx *request.Context, ID dtos.StorageServerID) (replaced *request.Context) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
-	ID := s.nextID
-	s.nextID++
+	replaced = s.serverMap[ID]
 	s.serverMap[ID] = ctx
-	return ID
+	return replaced
 }
 
-func (s *serverTracker) RemoveServer(ID dtos.StorageServerID) {
+func (s *serverTracker) RemoveServer(ID dtos.StorageServerID, ctx *request.Context) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
-	delete(s.serverMap, ID)
+	if s.serverMap[ID] == ctx {
+		delete(s.serverMap, ID)
+	}
 }
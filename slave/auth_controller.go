@@ -1,8 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 
 	"github.com/djarek/btrfs-volume-manager/common/dtos"
 	"github.com/djarek/btrfs-volume-manager/common/request"
@@ -13,7 +17,47 @@ const (
 	storageServerIDSessionKey = "StorageServerID"
 )
 
-type authController struct{}
+/*serverIdentity is the identity the master issued to the storage server. The
+storage server presents the token to get its ID back.*/
+type serverIdentity struct {
+	ServerID dtos.StorageServerID `json:"serverID"`
+	Token    string               `json:"token"`
+}
+
+/*authController authenticates and registers the storage server. The identity
+issued at the first registration is kept in a file and presented at every later
+registration, including the ones after reconnecting.*/
+type authController struct {
+	identityPath string
+	identity     serverIdentity
+}
+
+func newAuthController(identityPath string) *authController {
+	a := &authController{identityPath: identityPath}
+	data, err := ioutil.ReadFile(identityPath)
+	if err == nil {
+		err = json.Unmarshal(data, &a.identity)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Cannot load the storage server identity from %s: %s\n", identityPath, err)
+	}
+	return a
+}
+
+//saveIdentity writes the identity to a temporary file which replaces the stored one
+func (a *authController) saveIdentity() error {
+	data, err := json.Marshal(a.identity)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(a.identityPath), 0700)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(a.identityPath+".tmp", data, 0600)
+	}
+	if err == nil {
+		err = os.Rename(a.identityPath+".tmp", a.identityPath)
+	}
+	return err
+}
 
 type authError struct{}
 
@@ -24,6 +68,7 @@ func (authError) Error() string {
 func (a *authController) ExportHandlers(adder router.HandlerAdder) {
 	adder.AddHandler(dtos.WSMsgAuthenticationResponse, router.DefaultResponseHandler)
 	adder.AddHandler(dtos.WSMsgStorageServerRegistrationResponse, router.DefaultResponseHandler)
+	adder.AddHandler(dtos.WSMsgError, router.DefaultResponseHandler)
 }
 
 func (a *authController) sendAuthenticationRequest(ctx *request.Context, username string, password string) error {
@@ -50,13 +95,14 @@ func (a *authController) sendAuthenticationRequest(ctx *request.Context, usernam
 		log.Println(err)
 	}
 
-	return nil
+	return err
 }
 
 func (a *authController) sendServerRegistrationRequest(ctx *request.Context, serverName string) error {
 	requestID, responseChannel := ctx.NewRequest()
 	regRequest := &dtos.StorageServerRegistrationRequest{
 		ServerName: serverName,
+		Token:      a.identity.Token,
 	}
 
 	reqMsg := dtos.NewWebSocketMessage(requestID, regRequest)
@@ -78,6 +124,17 @@ func (a *authController) sendServerRegistrationRequest(ctx *request.Context, ser
 
 	response := responseMsg.Payload.(*dtos.StorageServerRegistrationResponse)
 	ctx.SetSessionData(storageServerIDSessionKey, response.AssignedID)
+	if a.identity.Token != "" && a.identity.Token != response.Token {
+		log.Printf("The master did not recognize the storage server ID %d, registered as %d\n",
+			a.identity.ServerID, response.AssignedID)
+	}
+	if a.identity.Token != response.Token || a.identity.ServerID != response.AssignedID {
+		a.identity = serverIdentity{ServerID: response.AssignedID, Token: response.Token}
+		err = a.saveIdentity()
+		if err != nil {
+			log.Printf("Cannot store the storage server identity in %s: %s\n", a.identityPath, err)
+		}
+	}
 	log.Println("Storage server registered successfully.")
 	return nil
 }
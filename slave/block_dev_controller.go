@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"path/filepath"
 
@@ -10,13 +11,61 @@ import (
 	"github.com/djarek/btrfs-volume-manager/slave/osinterface"
 )
 
+const (
+	btrfsSubsystem  = "btrfs"
+	backupSubsystem = "backup"
+)
+
 type blockDevController struct{}
 
+//deleteSubVolume is replaced in the tests
+var deleteSubVolume = osinterface.DeleteSubVolume
+
 func (b *blockDevController) ExportHandlers(adder router.HandlerAdder) {
 	adder.AddHandler(dtos.WSMsgBlockDeviceListRequest, b.onBlockDeviceListRequest)
 	adder.AddHandler(dtos.WSMsgBlockDeviceRescanRequest, b.onBlockDeviceRescanRequest)
 	adder.AddHandler(dtos.WSMsgBtrfsVolumeListRequest, b.onBtrfsVolumeListRequest)
 	adder.AddHandler(dtos.WSMsgBtrfsSubvolumeListRequest, b.onBtrfsSubvolumeListRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsSubvolumeDeleteRequest, b.onBtrfsSubvolumeDeleteRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsSubvolumeSnapshotRequest, b.onBtrfsSubvolumeSnapshotRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsVolumeUsageRequest, b.onBtrfsVolumeUsageRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsDeviceStatsRequest, b.onBtrfsDeviceStatsRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsDeviceAddRequest, b.onBtrfsDeviceAddRequest)
+	adder.AddHandler(dtos.WSMsgUnusedBlockDeviceListRequest, b.onUnusedBlockDeviceListRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsVolumeCreatePlanRequest, b.onBtrfsVolumeCreatePlanRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsVolumeCreateRequest, b.onBtrfsVolumeCreateRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsVolumeRelabelRequest, b.onBtrfsVolumeRelabelRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsQuotaSetRequest, b.onBtrfsQuotaSetRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsQgroupListRequest, b.onBtrfsQgroupListRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsQgroupLimitRequest, b.onBtrfsQgroupLimitRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsQgroupCreateRequest, b.onBtrfsQgroupCreateRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsQgroupAssignRequest, b.onBtrfsQgroupAssignRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsQgroupDestroyRequest, b.onBtrfsQgroupDestroyRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsPropertyGetRequest, b.onBtrfsPropertyGetRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsPropertySetRequest, b.onBtrfsPropertySetRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsSubvolumeSetDefaultRequest, b.onBtrfsSubvolumeSetDefaultRequest)
+	adder.AddHandler(dtos.WSMsgBackupCatalogRequest, b.onBackupCatalogRequest)
+	adder.AddHandler(dtos.WSMsgBackupPruneRequest, b.onBackupPruneRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsMountRequest, b.onBtrfsMountRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsUnmountRequest, b.onBtrfsUnmountRequest)
+	adder.AddHandler(dtos.WSMsgFstabListRequest, b.onFstabListRequest)
+	adder.AddHandler(dtos.WSMsgFstabEntrySetRequest, b.onFstabEntrySetRequest)
+	adder.AddHandler(dtos.WSMsgFstabEntryRemoveRequest, b.onFstabEntryRemoveRequest)
+}
+
+/*sendError logs the error and sends it as the response to the request
+identified by requestID. A dtos.Error is sent as is.*/
+func sendError(ctx *request.Context, requestID int64, subsystem string, err error) {
+	log.Println(err)
+	payload, ok := err.(dtos.Error)
+	if !ok {
+		payload = dtos.Error{
+			Subsystem: subsystem,
+			Details:   err.Error(),
+		}
+	}
+	response := dtos.NewWebSocketMessage(requestID, &payload)
+	ctx.SendAsync(response)
 }
 
 func filterBlockDevices(blockDevs []dtos.BlockDevice) []dtos.BlockDevice {
@@ -62,16 +111,20 @@ func (b blockDevController) onBtrfsSubvolumeListRequest(ctx *request.Context, ms
 	request := msg.Payload.(*dtos.BtrfsSubvolumeListRequest)
 	mountPath, err := osinterface.GetBtrfsRootMount(dtos.BtrfsVolume{UUID: request.VolumeUUID})
 	if err != nil {
-		//TODO: send error
-		log.Println(err)
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
 		return
 	}
 	subvols, err := osinterface.ProbeSubVolumes(mountPath)
 	if err != nil {
-		log.Println(err)
-		//TODO: send error
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
 		return
 	}
+	for i := range subvols {
+		subvols[i].VolumeUUID = request.VolumeUUID
+	}
+	if request.WithUsage {
+		osinterface.AddSubVolumeUsage(mountPath, subvols)
+	}
 
 	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsSubvolumeListResponse{Subvolumes: subvols})
 	ctx.SendAsync(response)
@@ -98,17 +151,9 @@ func (b blockDevController) onBtrfsSubvolumeCreateRequest(ctx *request.Context,
 
 func (b blockDevController) onBtrfsSubvolumeDeleteRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
 	request := msg.Payload.(*dtos.BtrfsSubvolumeDeleteRequest)
-	vol := dtos.BtrfsVolume{UUID: request.VolumeUUID}
-	mountPath, err := osinterface.GetBtrfsRootMount(vol)
+	err := deleteSubVolume(dtos.BtrfsVolume{UUID: request.VolumeUUID}, request.RelativePath)
 	if err != nil {
-		//TODO: send error
-		log.Println(err)
-		return
-	}
-	volpath := filepath.Join(mountPath, request.RelativePath)
-	err = osinterface.DeleteSubVolume(vol, volpath)
-	if err != nil {
-		log.Println(err)
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
 		return
 	}
 	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsSubvolumeDeleteResponse{})
@@ -121,11 +166,406 @@ func (b blockDevController) onBtrfsSubvolumeSnapshotRequest(ctx *request.Context
 	err := osinterface.CreateSnapshot(dtos.BtrfsSubVolume{
 		VolumeUUID:   request.VolumeUUID,
 		RelativePath: request.RelativePath,
-	}, request.TargetPath)
+	}, request.TargetPath, osinterface.SnapshotOptions{
+		ReadOnly:  request.ReadOnly,
+		Recursive: request.Recursive,
+	})
 	if err != nil {
-		log.Println(err)
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
 		return
 	}
 	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsSubvolumeSnapshotResponse{})
 	ctx.SendAsync(response)
 }
+
+func (b blockDevController) onBtrfsVolumeUsageRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsVolumeUsageRequest)
+	mountPath, err := osinterface.GetBtrfsRootMount(dtos.BtrfsVolume{UUID: request.VolumeUUID})
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	usage, err := osinterface.ProbeVolumeUsage(mountPath)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsVolumeUsageResponse{Usage: usage})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onBtrfsDeviceStatsRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsDeviceStatsRequest)
+	mountPath, err := osinterface.GetBtrfsRootMount(dtos.BtrfsVolume{UUID: request.VolumeUUID})
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	blockDevs, err := osinterface.ListDeviceStats(mountPath, request.Reset)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsDeviceStatsResponse{BlockDevices: blockDevs})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onBtrfsDeviceAddRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsDeviceAddRequest)
+	blockDevs, err := osinterface.AddDevices(request.VolumeUUID, request.Paths, request.Force)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsDeviceAddResponse{BlockDevices: blockDevs})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onUnusedBlockDeviceListRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	err := osinterface.BlockDeviceCache.Rescan()
+	if err == nil {
+		err = osinterface.MountPointCache.Rescan()
+	}
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	blockDevs := osinterface.UnusedBlockDevices()
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.UnusedBlockDeviceListResponse{BlockDevices: blockDevs})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onBtrfsVolumeCreatePlanRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsVolumeCreatePlanRequest)
+	err := osinterface.MountPointCache.Rescan()
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	plan, err := osinterface.PlanVolumeCreate(request.Spec)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsVolumeCreatePlanResponse{Plan: plan})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onBtrfsVolumeCreateRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsVolumeCreateRequest)
+	err := osinterface.MountPointCache.Rescan()
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	vol, err := osinterface.CreateVolume(request.Spec, request.Token)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsVolumeCreateResponse{BtrfsVolume: vol})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onBtrfsVolumeRelabelRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsVolumeRelabelRequest)
+	err := osinterface.MountPointCache.Rescan()
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	vol, err := osinterface.RelabelVolume(request.VolumeUUID, request.Label)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsVolumeRelabelResponse{BtrfsVolume: vol})
+	ctx.SendAsync(response)
+	sendVolumeChanged(ctx, vol)
+}
+
+/*sendVolumeChanged notifies the master that the properties of the volume
+changed.*/
+func sendVolumeChanged(ctx *request.Context, vol dtos.BtrfsVolume) {
+	notification := &dtos.BtrfsVolumeChangedNotification{BtrfsVolume: vol}
+	ctx.SendAsync(dtos.NewWebSocketMessage(0, notification))
+}
+
+func (b blockDevController) onBtrfsQuotaSetRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsQuotaSetRequest)
+	mountPath, err := osinterface.GetBtrfsRootMount(dtos.BtrfsVolume{UUID: request.VolumeUUID})
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	err = osinterface.SetQuota(mountPath, request.Enabled)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsQuotaSetResponse{})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onBtrfsQgroupListRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsQgroupListRequest)
+	mountPath, err := osinterface.GetBtrfsRootMount(dtos.BtrfsVolume{UUID: request.VolumeUUID})
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	qgroups, err := osinterface.ProbeQgroups(mountPath)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsQgroupListResponse{Qgroups: qgroups})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onBtrfsQgroupLimitRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsQgroupLimitRequest)
+	mountPath, err := osinterface.GetBtrfsRootMount(dtos.BtrfsVolume{UUID: request.VolumeUUID})
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	qgroup, err := osinterface.LimitQgroup(mountPath, request.QgroupID, request.Limit, request.Exclusive)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsQgroupLimitResponse{Qgroup: qgroup})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onBtrfsQgroupCreateRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsQgroupCreateRequest)
+	mountPath, err := osinterface.GetBtrfsRootMount(dtos.BtrfsVolume{UUID: request.VolumeUUID})
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	qgroup, err := osinterface.CreateQgroup(mountPath, request.QgroupID, request.Members)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsQgroupCreateResponse{Qgroup: qgroup})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onBtrfsQgroupAssignRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsQgroupAssignRequest)
+	mountPath, err := osinterface.GetBtrfsRootMount(dtos.BtrfsVolume{UUID: request.VolumeUUID})
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	qgroup, err := osinterface.AssignQgroup(mountPath, request.QgroupID, request.Members, request.Remove)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsQgroupAssignResponse{Qgroup: qgroup})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onBtrfsQgroupDestroyRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsQgroupDestroyRequest)
+	mountPath, err := osinterface.GetBtrfsRootMount(dtos.BtrfsVolume{UUID: request.VolumeUUID})
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	err = osinterface.DestroyQgroup(mountPath, request.QgroupID)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsQgroupDestroyResponse{})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onBtrfsPropertyGetRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsPropertyGetRequest)
+	properties, err := osinterface.GetSubVolumeProperties(request.VolumeUUID, request.RelativePath)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsPropertyGetResponse{Properties: properties})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onBtrfsPropertySetRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsPropertySetRequest)
+	properties, relabeled, err := osinterface.SetSubVolumeProperties(request.VolumeUUID, request.RelativePath, request.Properties)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsPropertySetResponse{Properties: properties})
+	ctx.SendAsync(response)
+	if relabeled != nil {
+		sendVolumeChanged(ctx, *relabeled)
+	}
+}
+
+func (b blockDevController) onBtrfsSubvolumeSetDefaultRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsSubvolumeSetDefaultRequest)
+	mountPath, err := osinterface.GetBtrfsRootMount(dtos.BtrfsVolume{UUID: request.VolumeUUID})
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	err = osinterface.SetDefaultSubVolume(mountPath, request.SubVolID)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsSubvolumeSetDefaultResponse{})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onBackupCatalogRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BackupCatalogRequest)
+	dir, err := osinterface.BackupTargetDir(request.TargetDir)
+	var streams []dtos.BackupStream
+	if err == nil {
+		streams, err = osinterface.LoadBackupCatalog(dir)
+	}
+	if err != nil {
+		sendError(ctx, msg.RequestID, backupSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BackupCatalogResponse{Streams: streams})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onBackupPruneRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BackupPruneRequest)
+	dir, err := osinterface.BackupTargetDir(request.TargetDir)
+	var removed []dtos.BackupStream
+	if err == nil {
+		removed, err = osinterface.PruneBackups(dir, request.KeepChains, request.DryRun)
+	}
+	if err != nil {
+		sendError(ctx, msg.RequestID, backupSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BackupPruneResponse{Removed: removed})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onBtrfsMountRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsMountRequest)
+	err := osinterface.MountPointCache.Rescan()
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	vol := dtos.BtrfsVolume{UUID: request.VolumeUUID}
+	mountPoint, err := osinterface.MountSubVolume(vol, request.Subvolume, request.MountPath, request.Options)
+	if err == nil && request.Persist {
+		_, err = osinterface.SetFstabEntry(vol, request.Subvolume, request.MountPath, request.Options)
+		if err != nil {
+			//a failed request leaves no mount behind, unless it cannot be undone
+			unmountErr := osinterface.Unmount(request.MountPath, false)
+			if unmountErr != nil {
+				err = errors.New(err.Error() + ", the file system stays mounted at " +
+					request.MountPath + " as the unmount failed: " + unmountErr.Error())
+			}
+		}
+	}
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsMountResponse{MountPoint: mountPoint})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onBtrfsUnmountRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsUnmountRequest)
+	err := osinterface.MountPointCache.Rescan()
+	if err == nil && request.Persist {
+		//an unmanaged fstab entry refuses the request before anything is changed
+		err = osinterface.ValidateFstabEntryRemove(request.MountPath)
+	}
+	if err == nil {
+		err = osinterface.Unmount(request.MountPath, request.Lazy)
+	}
+	if err == nil && request.Persist {
+		err = osinterface.RemoveFstabEntryIfPresent(request.MountPath)
+	}
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsUnmountResponse{})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onFstabListRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	err := osinterface.MountPointCache.Rescan()
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	entries, err := osinterface.ReadFstab()
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.FstabListResponse{
+		Entries: entries,
+		Mounts:  osinterface.LiveBtrfsMounts(),
+	})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onFstabEntrySetRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.FstabEntrySetRequest)
+	entry, err := osinterface.SetFstabEntry(dtos.BtrfsVolume{UUID: request.VolumeUUID},
+		request.Subvolume, request.MountPath, request.Options)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.FstabEntrySetResponse{Entry: entry})
+	ctx.SendAsync(response)
+}
+
+func (b blockDevController) onFstabEntryRemoveRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.FstabEntryRemoveRequest)
+	err := osinterface.RemoveFstabEntry(request.MountPath)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+
+	response := dtos.NewWebSocketMessage(msg.RequestID, &dtos.FstabEntryRemoveResponse{})
+	ctx.SendAsync(response)
+}
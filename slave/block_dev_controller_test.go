@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/common/router"
+	"github.com/stretchr/testify/assert"
+)
+
+//senderStub passes the sent messages to a channel
+type senderStub struct {
+	sent chan dtos.WebSocketMessage
+}
+
+func (s *senderStub) SendAsync(msg dtos.WebSocketMessage) <-chan error {
+	s.sent <- msg
+	return nil
+}
+
+func (s *senderStub) Close() {}
+
+/*routeRequest passes the request to the handlers exported by the controller and
+returns the response.*/
+func routeRequest(t *testing.T, exporter router.HandlerExporter, msg dtos.WebSocketMessage) dtos.WebSocketMessage {
+	r := router.New()
+	exporter.ExportHandlers(r)
+	sender := &senderStub{sent: make(chan dtos.WebSocketMessage, 1)}
+	recv := make(chan dtos.WebSocketMessage, 1)
+	defer close(recv)
+	r.OnNewConnection(sender, recv)
+	recv <- msg
+	select {
+	case response := <-sender.sent:
+		return response
+	case <-time.After(time.Second):
+		t.Fatal("no response to the request")
+		return dtos.WebSocketMessage{}
+	}
+}
+
+func TestSubvolumeDeleteRequest(t *testing.T) {
+	var deleted []string
+	var deleteErr error
+	oldDeleteSubVolume := deleteSubVolume
+	deleteSubVolume = func(vol dtos.BtrfsVolume, subvolRelativePath string) error {
+		deleted = append(deleted, string(vol.UUID)+":"+subvolRelativePath)
+		return deleteErr
+	}
+	defer func() { deleteSubVolume = oldDeleteSubVolume }()
+
+	request := &dtos.BtrfsSubvolumeDeleteRequest{RelativePath: "snapshots/home@2026-10-18_0905+0000"}
+	request.VolumeUUID = "vol-uuid"
+	response := routeRequest(t, &blockDevController{}, dtos.NewWebSocketMessage(7, request))
+	assert.EqualValues(t, 7, response.RequestID)
+	assert.IsType(t, &dtos.BtrfsSubvolumeDeleteResponse{}, response.Payload)
+	assert.EqualValues(t, []string{"vol-uuid:snapshots/home@2026-10-18_0905+0000"}, deleted)
+
+	deleteErr = errors.New("Unable to delete subvolume: busy")
+	response = routeRequest(t, &blockDevController{}, dtos.NewWebSocketMessage(8, request))
+	assert.EqualValues(t, 8, response.RequestID)
+	payload, ok := response.Payload.(*dtos.Error)
+	if assert.True(t, ok) {
+		assert.Equal(t, btrfsSubsystem, payload.Subsystem)
+		assert.Equal(t, deleteErr.Error(), payload.Details)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultConfigPath = "/etc/btrfs-volume-manager/slave.json"
+	configPathEnv     = "BVM_CONFIG"
+)
+
+/*config contains the settings of the storage server. They are read from the
+JSON configuration file, then overridden by the environment and then by the
+command-line flags.*/
+type config struct {
+	MasterURL string `json:"masterURL"`
+	Username  string `json:"username"`
+	Password  string `json:"password,omitempty"`
+	//PasswordFile is read for the password, so that it is not kept in the configuration
+	PasswordFile      string `json:"passwordFile,omitempty"`
+	ServerName        string `json:"serverName"`
+	BtrfsProgram      string `json:"btrfsProgram"`
+	RootMountsPath    string `json:"rootMountsPath"`
+	RootMountIdle     string `json:"rootMountIdle"`
+	ScheduleStatePath string `json:"scheduleStatePath"`
+	BackupDir         string `json:"backupDir"`
+	IdentityPath      string `json:"identityPath"`
+
+	rootMountIdle time.Duration
+}
+
+//setting is a setting that can be overridden by a flag or an environment variable
+type setting struct {
+	//flag is empty if the setting has no flag, e.g. the password
+	flag  string
+	env   string
+	usage string
+	field func(*config) *string
+}
+
+var settings = []setting{
+	{"master-url", "BVM_MASTER_URL", "WebSocket URL of the master",
+		func(c *config) *string { return &c.MasterURL }},
+	{"username", "BVM_USERNAME", "user name used to authenticate with the master",
+		func(c *config) *string { return &c.Username }},
+	{"", "BVM_PASSWORD", "",
+		func(c *config) *string { return &c.Password }},
+	{"password-file", "BVM_PASSWORD_FILE", "file containing the password used to authenticate with the master",
+		func(c *config) *string { return &c.PasswordFile }},
+	{"server-name", "BVM_SERVER_NAME", "name the storage server registers with",
+		func(c *config) *string { return &c.ServerName }},
+	{"btrfs", "BVM_BTRFS", "btrfs program of btrfs-progs",
+		func(c *config) *string { return &c.BtrfsProgram }},
+	{"root-mounts", "BVM_ROOT_MOUNTS", "directory the roots of the volumes are mounted in",
+		func(c *config) *string { return &c.RootMountsPath }},
+	{"root-mount-idle", "BVM_ROOT_MOUNT_IDLE",
+		"unmount the root mounts created by the storage server after being idle for this long, 0 keeps them until shutdown",
+		func(c *config) *string { return &c.RootMountIdle }},
+	{"schedule-state", "BVM_SCHEDULE_STATE", "file the schedules and their runs are kept in while the master is not connected",
+		func(c *config) *string { return &c.ScheduleStatePath }},
+	{"backup-dir", "BVM_BACKUP_DIR", "directory the backup targets have to be in",
+		func(c *config) *string { return &c.BackupDir }},
+	{"identity", "BVM_IDENTITY", "file the storage server identity issued by the master is kept in",
+		func(c *config) *string { return &c.IdentityPath }},
+}
+
+//defaultConfig returns the settings used when nothing overrides them
+func defaultConfig() config {
+	serverName, err := os.Hostname()
+	if err != nil || serverName == "" {
+		serverName = "StorageServer1"
+	}
+	return config{
+		MasterURL:         "ws://localhost:8080/ws",
+		Username:          "admin",
+		ServerName:        serverName,
+		BtrfsProgram:      "btrfs",
+		RootMountsPath:    "/mnt",
+		RootMountIdle:     "10m",
+		ScheduleStatePath: "/var/lib/btrfs-volume-manager/schedules.json",
+		BackupDir:         "/var/backups/btrfs-volume-manager",
+		IdentityPath:      "/var/lib/btrfs-volume-manager/identity.json",
+	}
+}
+
+/*loadConfig reads the configuration file given by the -config flag or the
+BVM_CONFIG variable and applies the overrides. A missing configuration file is
+only an error if it was given explicitly. The configuration is validated.*/
+func loadConfig(args []string, getenv func(string) string) (config, error) {
+	flags := flag.NewFlagSet("slave", flag.ContinueOnError)
+	configPath := flags.String("config", "", "configuration file (default "+defaultConfigPath+")")
+	var overrides config
+	for _, s := range settings {
+		if s.flag != "" {
+			flags.StringVar(s.field(&overrides), s.flag, "", s.usage+" ("+s.env+")")
+		}
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return config{}, err
+	}
+
+	cfg := defaultConfig()
+	path := *configPath
+	if path == "" {
+		path = getenv(configPathEnv)
+	}
+	err = readConfigFile(&cfg, path)
+	if err != nil {
+		return config{}, err
+	}
+	for _, s := range settings {
+		if value := getenv(s.env); value != "" {
+			*s.field(&cfg) = value
+		}
+	}
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name {
+				*s.field(&cfg) = *s.field(&overrides)
+			}
+		}
+	})
+
+	err = cfg.readSecrets()
+	if err == nil {
+		err = cfg.validate()
+	}
+	return cfg, err
+}
+
+//readConfigFile reads the JSON configuration file at path, or the default one if path is empty
+func readConfigFile(cfg *config, path string) error {
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, cfg)
+	if err == nil {
+		err = checkConfigKeys(data)
+	}
+	if err != nil {
+		return errors.New("Invalid configuration file " + path + ": " + err.Error())
+	}
+	return nil
+}
+
+/*checkConfigKeys rejects the keys of the JSON configuration that are not
+settings. Keys are matched case-insensitively, as they are when decoded.*/
+func checkConfigKeys(data []byte) error {
+	var keys map[string]json.RawMessage
+	err := json.Unmarshal(data, &keys)
+	if err != nil {
+		return err
+	}
+	configType := reflect.TypeOf(config{})
+	var unknown []string
+	for key := range keys {
+		known := false
+		for i := 0; i < configType.NumField() && !known; i++ {
+			field := configType.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			known = field.PkgPath == "" && strings.EqualFold(name, key)
+		}
+		if !known {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return errors.New("unknown settings: " + strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+/*readSecrets reads the password from the password file, the trailing newline is
+removed. The password file replaces a password given in any other way.*/
+func (c *config) readSecrets() error {
+	if c.PasswordFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(c.PasswordFile)
+	if err != nil {
+		return err
+	}
+	c.Password = strings.TrimRight(string(data), "\r\n")
+	return nil
+}
+
+//validate checks all the settings, the returned error lists every invalid one
+func (c *config) validate() error {
+	var problems []string
+	masterURL, err := url.Parse(c.MasterURL)
+	if err != nil || (masterURL.Scheme != "ws" && masterURL.Scheme != "wss") || masterURL.Host == "" {
+		problems = append(problems, "the master URL has to be a ws:// or wss:// URL: "+c.MasterURL)
+	}
+	if c.Username == "" {
+		problems = append(problems, "the user name is empty")
+	}
+	if c.Password == "" {
+		problems = append(problems, "the password is not given, set passwordFile or BVM_PASSWORD")
+	}
+	if strings.TrimSpace(c.ServerName) == "" {
+		problems = append(problems, "the server name is empty")
+	}
+	if _, err := exec.LookPath(c.BtrfsProgram); err != nil {
+		problems = append(problems, "the btrfs program was not found: "+c.BtrfsProgram)
+	}
+	if !filepath.IsAbs(c.RootMountsPath) || filepath.Clean(c.RootMountsPath) != c.RootMountsPath || c.RootMountsPath == "/" {
+		problems = append(problems, "the root mounts directory has to be a clean absolute path other than /: "+c.RootMountsPath)
+	}
+	c.rootMountIdle, err = time.ParseDuration(c.RootMountIdle)
+	if err != nil || c.rootMountIdle < 0 {
+		problems = append(problems, "the root mount idle period has to be a non-negative duration: "+c.RootMountIdle)
+	}
+	if !filepath.IsAbs(c.ScheduleStatePath) {
+		problems = append(problems, "the schedule state file has to be an absolute path: "+c.ScheduleStatePath)
+	}
+	if !filepath.IsAbs(c.IdentityPath) {
+		problems = append(problems, "the identity file has to be an absolute path: "+c.IdentityPath)
+	}
+	if !filepath.IsAbs(c.BackupDir) || filepath.Clean(c.BackupDir) != c.BackupDir || c.BackupDir == "/" {
+		problems = append(problems, "the backup directory has to be a clean absolute path other than /: "+c.BackupDir)
+	}
+	if len(problems) > 0 {
+		return errors.New("Invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
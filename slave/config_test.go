@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "slave.json")
+	passwordPath := filepath.Join(dir, "password")
+	assert.NoError(t, ioutil.WriteFile(configPath, []byte(`{
+		"masterURL": "wss://master.example.com/ws",
+		"serverName": "from-file",
+		"username": "from-file",
+		"btrfsProgram": "sh",
+		"passwordFile": "`+passwordPath+`"
+	}`), 0600))
+	assert.NoError(t, ioutil.WriteFile(passwordPath, []byte("s3cret\n"), 0600))
+	env := map[string]string{
+		configPathEnv:         configPath,
+		"BVM_SERVER_NAME":     "from-env",
+		"BVM_USERNAME":        "from-env",
+		"BVM_ROOT_MOUNT_IDLE": "1h",
+	}
+
+	cfg, err := loadConfig([]string{"-server-name", "from-flag"}, func(name string) string { return env[name] })
+	assert.NoError(t, err)
+	assert.Equal(t, "wss://master.example.com/ws", cfg.MasterURL)
+	assert.Equal(t, "from-flag", cfg.ServerName)
+	assert.Equal(t, "from-env", cfg.Username)
+	assert.Equal(t, "s3cret", cfg.Password)
+	assert.Equal(t, "/mnt", cfg.RootMountsPath)
+	assert.Equal(t, "/var/backups/btrfs-volume-manager", cfg.BackupDir)
+	assert.Equal(t, time.Hour, cfg.rootMountIdle)
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "slave.json")
+	noEnv := func(string) string { return "" }
+
+	_, err = loadConfig([]string{"-config", configPath}, noEnv)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, ioutil.WriteFile(configPath, []byte(`{"masterUrl": "ws://master/ws"}`), 0600))
+	_, err = loadConfig([]string{"-config", configPath}, noEnv)
+	assert.Error(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(configPath, []byte(`{"masterURL": "ws://master/ws", "password": "x",
+		"rootMountIdel": "1m", "backupdir": "/srv/backups", "server": {}}`), 0600))
+	_, err = loadConfig([]string{"-config", configPath}, noEnv)
+	assert.EqualError(t, err, "Invalid configuration file "+configPath+": unknown settings: rootMountIdel, server")
+
+	assert.NoError(t, ioutil.WriteFile(configPath, []byte(`{"btrfsProgram": "sh"}`), 0600))
+	_, err = loadConfig([]string{"-config", configPath, "-master-url", "http://master/ws",
+		"-root-mounts", "/mnt/", "-root-mount-idle", "-1m", "-server-name", " ", "-backup-dir", "backups"}, noEnv)
+	assert.EqualError(t, err, "Invalid configuration: "+
+		"the master URL has to be a ws:// or wss:// URL: http://master/ws; "+
+		"the password is not given, set passwordFile or BVM_PASSWORD; "+
+		"the server name is empty; "+
+		"the root mounts directory has to be a clean absolute path other than /: /mnt/; "+
+		"the root mount idle period has to be a non-negative duration: -1m; "+
+		"the backup directory has to be a clean absolute path other than /: backups")
+}
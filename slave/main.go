@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"os"
 	"os/signal"
@@ -8,47 +9,59 @@ import (
 	"time"
 
 	"github.com/djarek/btrfs-volume-manager/common/router"
-	"github.com/djarek/btrfs-volume-manager/common/wsprotocol"
 	"github.com/djarek/btrfs-volume-manager/slave/osinterface"
 )
 
-const (
-	masterControlURL  = "ws://localhost:8080/ws"
-	defaultUsername   = "admin"
-	defaultPassword   = "admin"
-	defaultServerName = "StorageServer1"
-)
+const rootMountCheckInterval = time.Minute
+
+/*releaseIdleRootMounts periodically unmounts the root mounts created by the
+storage server that were idle for the given period. Nothing is unmounted while
+a task or a stream is running, as they may hold a root mount without using it.*/
+func releaseIdleRootMounts(idle time.Duration, busy ...func() bool) {
+	for range time.Tick(rootMountCheckInterval) {
+		inUse := false
+		for _, b := range busy {
+			inUse = inUse || b()
+		}
+		if !inUse {
+			osinterface.RootMounts.ReleaseIdle(idle)
+		}
+	}
+}
 
 func main() {
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	osinterface.Configure(cfg.BtrfsProgram, cfg.RootMountsPath, cfg.BackupDir)
+
 	r := router.New()
-	auth := &authController{}
+	auth := newAuthController(cfg.IdentityPath)
 	auth.ExportHandlers(r)
 	osinterface.BlockDeviceCache.Rescan()
 	bdCtrl := blockDevController{}
 	bdCtrl.ExportHandlers(r)
-	ctx, err := wsprotocol.DefaultDialer.Dial(masterControlURL, r)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	defer func() {
-		ctx.Close()
-		time.Sleep(time.Second * 1)
-		if err != nil {
-			log.Fatalln(err)
-		}
-	}()
-
-	err = auth.sendAuthenticationRequest(ctx, defaultUsername, defaultPassword)
-	if err != nil {
-		return
-	}
-
-	err = auth.sendServerRegistrationRequest(ctx, defaultServerName)
-	if err != nil {
-		return
+	taskCtrl := newTaskController()
+	taskCtrl.ExportHandlers(r)
+	replicationCtrl := newReplicationController()
+	replicationCtrl.ExportHandlers(r)
+	if cfg.rootMountIdle > 0 {
+		go releaseIdleRootMounts(cfg.rootMountIdle, taskCtrl.busy, replicationCtrl.busy)
 	}
+	scheduleCtrl := newScheduleController(taskCtrl, cfg.ScheduleStatePath)
+	scheduleCtrl.ExportHandlers(r)
+	scheduleCtrl.start()
+	masterConn := newMasterConnection(cfg, r, auth, taskCtrl, scheduleCtrl)
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	<-sigs
+	err = masterConn.run(sigs)
+	osinterface.RootMounts.ReleaseAll()
+	if err != nil {
+		log.Fatalln(err)
+	}
 }
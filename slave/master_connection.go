@@ -0,0 +1,142 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/common/request"
+	"github.com/djarek/btrfs-volume-manager/common/router"
+	"github.com/djarek/btrfs-volume-manager/common/wsprotocol"
+)
+
+const (
+	reconnectMinDelay = time.Second
+	reconnectMaxDelay = 2 * time.Minute
+	closeTimeout      = time.Second
+)
+
+/*masterConnection keeps the storage server connected to the master. When the
+connection is lost, it is established again with a jittered exponential backoff
+and the storage server authenticates and registers again. The tasks and the
+schedules keep running meanwhile, their results are sent after reconnecting.*/
+type masterConnection struct {
+	cfg       config
+	router    *router.Router
+	auth      *authController
+	tasks     *taskController
+	schedules *scheduleController
+
+	mtx sync.Mutex
+	//lost is closed when the current connection closes
+	lost chan struct{}
+}
+
+func newMasterConnection(cfg config, r *router.Router, auth *authController,
+	tasks *taskController, schedules *scheduleController) *masterConnection {
+	m := &masterConnection{cfg: cfg, router: r, auth: auth, tasks: tasks, schedules: schedules}
+	r.AddOnCloseHandler(m.onClose)
+	return m
+}
+
+/*reconnectDelay returns the delay before the reconnection attempt, counted from
+0. The delay doubles with every attempt up to reconnectMaxDelay, random(n) picks
+a value in [0, n) that replaces up to a half of it, so that the storage servers
+do not all reconnect at once when the master restarts.*/
+func reconnectDelay(attempt int, random func(n int64) int64) time.Duration {
+	delay := reconnectMaxDelay
+	if attempt < 16 {
+		delay = reconnectMinDelay << uint(attempt)
+	}
+	if delay > reconnectMaxDelay {
+		delay = reconnectMaxDelay
+	}
+	return delay/2 + time.Duration(random(int64(delay/2)+1))
+}
+
+/*run connects to the master and reconnects whenever the connection is lost,
+until stop receives. An authentication failure is only retried if the storage
+server was connected before, as it is a configuration error otherwise.*/
+func (m *masterConnection) run(stop <-chan os.Signal) error {
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	connected := false
+	failures := 0
+	for {
+		ctx, lost, err := m.connect()
+		if _, refused := err.(authError); refused && !connected {
+			return err
+		}
+		if err == nil {
+			connected = true
+			failures = 0
+			select {
+			case <-lost:
+				log.Println("The connection to the master was lost.")
+			case <-stop:
+				m.close(ctx, lost)
+				return nil
+			}
+		} else {
+			log.Println("Cannot connect to the master: " + err.Error())
+		}
+
+		delay := reconnectDelay(failures, random.Int63n)
+		failures++
+		log.Printf("Reconnecting to the master in %s\n", delay)
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+/*connect dials the master, authenticates and registers the storage server. The
+returned channel is closed when the connection closes.*/
+func (m *masterConnection) connect() (*request.Context, <-chan struct{}, error) {
+	lost := make(chan struct{})
+	m.mtx.Lock()
+	m.lost = lost
+	m.mtx.Unlock()
+	ctx, err := wsprotocol.DefaultDialer.Dial(m.cfg.MasterURL, m.router)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = m.auth.sendAuthenticationRequest(ctx, m.cfg.Username, m.cfg.Password)
+	if err == nil {
+		err = m.auth.sendServerRegistrationRequest(ctx, m.cfg.ServerName)
+	}
+	if err != nil {
+		//the next connection cannot be made until this one is gone
+		ctx.Close()
+		<-lost
+		return nil, nil, err
+	}
+
+	m.tasks.setContext(ctx)
+	m.schedules.setContext(ctx)
+	return ctx, lost, nil
+}
+
+//close closes the connection and waits for a while for the master to be told
+func (m *masterConnection) close(ctx *request.Context, lost <-chan struct{}) {
+	ctx.Close()
+	select {
+	case <-lost:
+	case <-time.After(closeTimeout):
+	}
+}
+
+func (m *masterConnection) onClose(ctx *request.Context, msg dtos.WebSocketMessage) {
+	m.tasks.setContext(nil)
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.lost != nil {
+		close(m.lost)
+		m.lost = nil
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	none := func(n int64) int64 { return 0 }
+	most := func(n int64) int64 { return n - 1 }
+
+	assert.Equal(t, reconnectMinDelay/2, reconnectDelay(0, none))
+	assert.Equal(t, reconnectMinDelay, reconnectDelay(0, most))
+	assert.Equal(t, 4*time.Second, reconnectDelay(3, none))
+	assert.Equal(t, 8*time.Second, reconnectDelay(3, most))
+	assert.Equal(t, reconnectMaxDelay/2, reconnectDelay(8, none))
+	assert.Equal(t, reconnectMaxDelay, reconnectDelay(8, most))
+	assert.Equal(t, reconnectMaxDelay, reconnectDelay(1000, most))
+}
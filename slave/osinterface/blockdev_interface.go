@@ -4,24 +4,24 @@ package osinterface
 #cgo LDFLAGS: -lblkid
 #include <mntent.h>
 #include <blkid/blkid.h>
+#include <stdlib.h>
 #include <string.h>
 */
 import "C"
 import (
-	"fmt"
+	"errors"
 	"regexp"
 	"strings"
+	"unsafe"
 
 	"github.com/djarek/btrfs-volume-manager/common/dtos"
 )
 
-const (
-	mTabFilePath   = "/proc/mounts"
-	setmntentFlags = "r"
-)
+const setmntentFlags = "r"
+
+var mTabFilePath = "/proc/mounts"
 
 var (
-	mTabFilePathCString     = C.CString(mTabFilePath)
 	setmntentFlagsCString   = C.CString(setmntentFlags)
 	blkidUUIDTagNameCString = C.CString("UUID")
 	blkidTypeTagNameCString = C.CString("TYPE")
@@ -70,18 +70,36 @@ func probeBlockDevices() ([]dtos.BlockDevice, error) {
 }
 
 func probeMountPoints() ([]dtos.MountPoint, error) {
-	var mnt C.struct_mntent
-	var buf [4096]C.char
+	mountPoints, ok := readMountEntries(mTabFilePath)
+	if !ok {
+		return nil, ErrMTabOpen
+	}
+	return mountPoints, nil
+}
+
+/*readMountEntries reads the entries of a file in the format of the fstab, such
+as the mtab, with getmntent. The second return value indicates whether the file
+could be opened.*/
+func readMountEntries(filePath string) ([]dtos.MountPoint, bool) {
+	const bufSize = 4096
 	var ret []dtos.MountPoint
 
-	mTab := C.setmntent(mTabFilePathCString, setmntentFlagsCString)
+	//getmntent_r stores pointers to buf in mnt, so both have to be C memory
+	mnt := (*C.struct_mntent)(C.calloc(1, C.sizeof_struct_mntent))
+	defer C.free(unsafe.Pointer(mnt))
+	buf := (*C.char)(C.malloc(bufSize))
+	defer C.free(unsafe.Pointer(buf))
+
+	filePathCString := C.CString(filePath)
+	defer C.free(unsafe.Pointer(filePathCString))
+	mTab := C.setmntent(filePathCString, setmntentFlagsCString)
 	if mTab == nil {
-		return nil, ErrMTabOpen
+		return nil, false
 	}
 	defer C.endmntent(mTab)
 
 	for {
-		mntPtr := C.getmntent_r(mTab, &mnt, &buf[0], C.int(len(buf)))
+		mntPtr := C.getmntent_r(mTab, mnt, buf, bufSize)
 		if mntPtr == nil {
 			break
 		}
@@ -96,10 +114,13 @@ func probeMountPoints() ([]dtos.MountPoint, error) {
 		}
 		ret = append(ret, mountPoint)
 	}
-	return ret, nil
+	return ret, true
 }
 
-var devMatcher = regexp.MustCompile("path (\\/dev\\/[a-zA-Z0-9\\/_]+)")
+var (
+	devMatcher          = regexp.MustCompile("path (\\/dev\\/[a-zA-Z0-9\\/_]+)")
+	volumeHeaderMatcher = regexp.MustCompile(`^\s*(?:none|'(.*)')\s+uuid: (\S+)`)
+)
 
 /*ProbeBtrfsVolumes retrieves the list of all btrfs volumes present on this
 server.*/
@@ -108,11 +129,25 @@ func ProbeBtrfsVolumes() (vols []dtos.BtrfsVolume, err error) {
 	if err != nil {
 		return
 	}
+	return parseFilesystemShow(output)
+}
+
+/*parseFilesystemShow parses the output of btrfs filesystem show, for example:
+Label: 'data'  uuid: 5c1e4b1b-7a2d-4f5e-9d5c-0d0e0f101112
+	Total devices 1 FS bytes used 112.00KiB
+	devid    1 size 10.00GiB used 2.02GiB path /dev/sdb
+The devices are looked up in the BlockDeviceCache.*/
+func parseFilesystemShow(output string) (vols []dtos.BtrfsVolume, err error) {
 	volBlocks := strings.Split(output, "Label:")
 	volBlocks = volBlocks[1:]
 	for _, volBlock := range volBlocks {
 		var volume dtos.BtrfsVolume
-		_, err = fmt.Sscanf(volBlock, "%s uuid: %s\n", &volume.Label, &volume.UUID)
+		header := volumeHeaderMatcher.FindStringSubmatch(volBlock)
+		if header == nil {
+			return nil, errors.New("Unexpected filesystem show output: " + volBlock)
+		}
+		volume.Label = header[1]
+		volume.UUID = dtos.UUIDType(header[2])
 
 		foundMatches := devMatcher.FindAllStringSubmatch(volBlock, -1)
 		for _, devMatch := range foundMatches {
@@ -0,0 +1,26 @@
+package osinterface
+
+import (
+	"testing"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFilesystemShow(t *testing.T) {
+	output := "Label: 'data volume'  uuid: 5c1e4b1b-7a2d-4f5e-9d5c-0d0e0f101112\n" +
+		"\tTotal devices 1 FS bytes used 112.00KiB\n" +
+		"\tdevid    1 size 10.00GiB used 2.02GiB path /dev/sdb\n" +
+		"\n" +
+		"Label: none  uuid: 07f4e569-0323-49be-ab2f-96927e07cfbc\n" +
+		"\tTotal devices 1 FS bytes used 112.00KiB\n" +
+		"\tdevid    1 size 10.00GiB used 2.02GiB path /dev/sdc\n"
+
+	vols, err := parseFilesystemShow(output)
+	assert.NoError(t, err)
+	assert.Len(t, vols, 2)
+	assert.EqualValues(t, "data volume", vols[0].Label)
+	assert.EqualValues(t, dtos.UUIDType("5c1e4b1b-7a2d-4f5e-9d5c-0d0e0f101112"), vols[0].UUID)
+	assert.EqualValues(t, "", vols[1].Label)
+	assert.EqualValues(t, dtos.UUIDType("07f4e569-0323-49be-ab2f-96927e07cfbc"), vols[1].UUID)
+}
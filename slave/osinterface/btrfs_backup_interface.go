@@ -0,0 +1,518 @@
+package osinterface
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+)
+
+const (
+	backupCatalogFile = "catalog.json"
+	backupStreamExt   = ".btrfs"
+	partialExt        = ".partial"
+)
+
+var (
+	//ErrBackupTargetNotAbsolute indicates that the backup target directory is not an absolute path
+	ErrBackupTargetNotAbsolute = errors.New("The backup target has to be an absolute path")
+	//ErrInvalidBackupCatalog indicates that a stream of the catalog has a file outside of the target directory
+	ErrInvalidBackupCatalog = errors.New("The backup catalog lists a stream file outside of the backup target")
+	//ErrBackupTargetOutside indicates that the backup target directory is not inside the backup directory
+	ErrBackupTargetOutside = errors.New("The backup target has to be inside the backup directory")
+	//ErrAlreadyBackedUp indicates that the snapshot is already stored in the backup target
+	ErrAlreadyBackedUp = errors.New("The snapshot is already stored in the backup target")
+	//ErrBackupParentPruned indicates that the parent stream was pruned during the backup
+	ErrBackupParentPruned = errors.New("The parent stream was pruned during the backup")
+	//ErrBackupChainNotFound indicates that the catalog has no chain with the ID
+	ErrBackupChainNotFound = errors.New("Backup chain not found")
+	//ErrInvalidKeepChains indicates that a prune would delete all chains
+	ErrInvalidKeepChains = errors.New("At least one chain has to be kept")
+	//ErrBackupCancelled indicates that the backup was cancelled
+	ErrBackupCancelled = errors.New("The backup was cancelled")
+)
+
+//backupMtx serializes the changes of backup catalogs
+var backupMtx sync.Mutex
+
+//backupDir is the directory all the backup targets have to be in
+var backupDir = "/var/backups/btrfs-volume-manager"
+
+/*BackupTargetDir resolves the backup target directory of a request. A relative
+target is taken relative to the backup directory, an absolute one has to be
+inside it.*/
+func BackupTargetDir(target string) (string, error) {
+	dir := target
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(backupDir, dir)
+	}
+	dir = filepath.Clean(dir)
+	if !isUnder(dir, backupDir) {
+		return "", ErrBackupTargetOutside
+	}
+	return dir, nil
+}
+
+/*LoadBackupCatalog reads the catalog of the streams stored in the backup target
+directory, oldest first. A directory without a catalog has no streams. A catalog
+whose stream files are not plain file names in the directory is rejected, as the
+files are read and deleted.*/
+func LoadBackupCatalog(dir string) ([]dtos.BackupStream, error) {
+	if !filepath.IsAbs(dir) {
+		return nil, ErrBackupTargetNotAbsolute
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, backupCatalogFile))
+	if os.IsNotExist(err) {
+		return []dtos.BackupStream{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var streams []dtos.BackupStream
+	err = json.Unmarshal(data, &streams)
+	if err != nil {
+		return nil, err
+	}
+	for _, stream := range streams {
+		if stream.File == "" || stream.File == "." || stream.File == ".." || filepath.Base(stream.File) != stream.File {
+			return nil, ErrInvalidBackupCatalog
+		}
+	}
+	return streams, nil
+}
+
+/*writeFileSynced writes the file with the mode under a temporary name and renames
+it once the data is synced, so that the file is never left half-written.*/
+func writeFileSynced(path string, mode os.FileMode, write func(io.Writer) error) error {
+	tmp := path + partialExt
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	err = write(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func saveBackupCatalog(dir string, streams []dtos.BackupStream) error {
+	data, err := json.MarshalIndent(streams, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileSynced(filepath.Join(dir, backupCatalogFile), 0644, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func findBackupStream(streams []dtos.BackupStream, ID string) (dtos.BackupStream, bool) {
+	for _, stream := range streams {
+		if stream.ID == ID {
+			return stream, true
+		}
+	}
+	return dtos.BackupStream{}, false
+}
+
+//chainStreams returns the streams of the chain in catalog order
+func chainStreams(streams []dtos.BackupStream, chain string) []dtos.BackupStream {
+	var found []dtos.BackupStream
+	for _, stream := range streams {
+		if stream.Chain == chain {
+			found = append(found, stream)
+		}
+	}
+	return found
+}
+
+/*selectBackupParent returns the most recent stream of a snapshot of the same
+subvolume whose snapshot is still present and read-only on the volume, and the
+current path of that snapshot.*/
+func selectBackupParent(streams []dtos.BackupStream, volumeUUID dtos.UUIDType,
+	snapshot dtos.BtrfsSubVolume, subvols []dtos.BtrfsSubVolume) (dtos.BackupStream, string, bool) {
+
+	if snapshot.ParentUUID == "" {
+		return dtos.BackupStream{}, "", false
+	}
+	for i := len(streams) - 1; i >= 0; i-- {
+		stream := streams[i]
+		if stream.VolumeUUID != volumeUUID || stream.SourceUUID != snapshot.ParentUUID {
+			continue
+		}
+		for _, subvol := range subvols {
+			if subvol.UUID == stream.SnapshotUUID && subvol.ReadOnly {
+				return stream, subvol.RelativePath, true
+			}
+		}
+	}
+	return dtos.BackupStream{}, "", false
+}
+
+type countingWriter struct {
+	count *uint64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	atomic.AddUint64(c.count, uint64(len(p)))
+	return len(p), nil
+}
+
+/*writeBackupStream stores the stream in the file and returns its size and
+SHA-256 checksum. The bytes written so far are counted in written.*/
+func writeBackupStream(path string, r io.Reader, written *uint64) (size uint64, sum string, err error) {
+	hash := sha256.New()
+	var count uint64
+	err = writeFileSynced(path, 0644, func(w io.Writer) error {
+		_, err := io.Copy(io.MultiWriter(w, hash, countingWriter{&count}, countingWriter{written}), r)
+		return err
+	})
+	return count, hex.EncodeToString(hash.Sum(nil)), err
+}
+
+/*BackupOperation writes the btrfs send stream of a snapshot to a backup target
+directory and adds it to the catalog. It satisfies the tasks.CancellableOperation
+interface.*/
+type BackupOperation struct {
+	volumeUUID dtos.UUIDType
+	snapshot   dtos.BtrfsSubVolume
+	dir        string
+	parent     *dtos.BackupStream
+	parentPath string
+	written    uint64
+
+	mtx       sync.Mutex
+	send      *SendStream
+	cancelled bool
+}
+
+/*NewBackupOperation constructs the backup of the read-only snapshot at the path
+relative to the root of the volume identified by UUID. The stream is incremental
+if the target stores a stream of an earlier snapshot of the same subvolume that
+is still present on the volume, unless full is set.*/
+func NewBackupOperation(UUID dtos.UUIDType, relativePath string, dir string, full bool) (*BackupOperation, error) {
+	streams, err := LoadBackupCatalog(dir)
+	if err != nil {
+		return nil, err
+	}
+	mountPath, err := GetBtrfsRootMount(dtos.BtrfsVolume{UUID: UUID})
+	if err != nil {
+		return nil, err
+	}
+	subvols, err := ProbeSubVolumes(mountPath)
+	if err != nil {
+		return nil, err
+	}
+	err = ValidateSend(subvols, relativePath, "")
+	if err != nil {
+		return nil, err
+	}
+	snapshot, _ := findSubVolume(subvols, relativePath)
+	if _, exists := findBackupStream(streams, string(snapshot.UUID)); exists {
+		return nil, ErrAlreadyBackedUp
+	}
+
+	b := &BackupOperation{volumeUUID: UUID, snapshot: snapshot, dir: dir}
+	if !full {
+		parent, parentPath, ok := selectBackupParent(streams, UUID, snapshot, subvols)
+		if ok {
+			b.parent = &parent
+			b.parentPath = parentPath
+		}
+	}
+	return b, nil
+}
+
+/*Run sends the snapshot to a stream file and blocks until it is written and
+added to the catalog. A failed or cancelled backup leaves nothing behind.*/
+func (b *BackupOperation) Run() error {
+	send, err := StartSend(b.volumeUUID, b.snapshot.RelativePath, b.parentPath)
+	if err != nil {
+		return err
+	}
+	b.mtx.Lock()
+	b.send = send
+	cancelled := b.cancelled
+	b.mtx.Unlock()
+	if cancelled {
+		send.Kill()
+		return ErrBackupCancelled
+	}
+
+	stream := dtos.BackupStream{
+		ID:           string(b.snapshot.UUID),
+		Chain:        string(b.snapshot.UUID),
+		VolumeUUID:   b.volumeUUID,
+		SourceUUID:   b.snapshot.ParentUUID,
+		SnapshotPath: b.snapshot.RelativePath,
+		SnapshotUUID: b.snapshot.UUID,
+		File:         string(b.snapshot.UUID) + backupStreamExt,
+	}
+	if b.parent != nil {
+		stream.Chain = b.parent.Chain
+		stream.ParentID = b.parent.ID
+	}
+	path := filepath.Join(b.dir, stream.File)
+	stream.Size, stream.SHA256, err = writeBackupStream(path, send, &b.written)
+	if err != nil {
+		send.Kill()
+		return err
+	}
+	err = send.Wait()
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	stream.Time = time.Now()
+
+	err = b.addToCatalog(stream)
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+func (b *BackupOperation) addToCatalog(stream dtos.BackupStream) error {
+	backupMtx.Lock()
+	defer backupMtx.Unlock()
+	streams, err := LoadBackupCatalog(b.dir)
+	if err != nil {
+		return err
+	}
+	if _, exists := findBackupStream(streams, stream.ID); exists {
+		return ErrAlreadyBackedUp
+	}
+	if _, exists := findBackupStream(streams, stream.ParentID); stream.ParentID != "" && !exists {
+		return ErrBackupParentPruned
+	}
+	return saveBackupCatalog(b.dir, append(streams, stream))
+}
+
+//Cancel stops the send, the incomplete stream file is deleted
+func (b *BackupOperation) Cancel() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.cancelled = true
+	if b.send != nil {
+		return b.send.cmd.Process.Kill()
+	}
+	return nil
+}
+
+//Progress returns the current dtos.BackupProgress of the backup
+func (b *BackupOperation) Progress() (interface{}, error) {
+	progress := dtos.BackupProgress{BytesWritten: atomic.LoadUint64(&b.written)}
+	if b.parent != nil {
+		progress.ParentID = b.parent.ID
+	}
+	return progress, nil
+}
+
+/*validateChain checks the dependencies of the streams of a chain. The chain has
+to start with its full stream and the parent of every incremental stream has to
+be part of the chain.*/
+func validateChain(chain string, streams []dtos.BackupStream) []dtos.BackupStreamFailure {
+	var failures []dtos.BackupStreamFailure
+	root, ok := findBackupStream(streams, chain)
+	if !ok {
+		failures = append(failures, dtos.BackupStreamFailure{ID: chain, Error: "The full stream of the chain is missing"})
+	} else if root.ParentID != "" {
+		failures = append(failures, dtos.BackupStreamFailure{ID: chain, Error: "The first stream of the chain is not a full stream"})
+	}
+	for _, stream := range streams {
+		if stream.ID == chain {
+			continue
+		}
+		if stream.ParentID == "" {
+			failures = append(failures, dtos.BackupStreamFailure{ID: stream.ID, Error: "A full stream is part of another chain"})
+		} else if _, ok := findBackupStream(streams, stream.ParentID); !ok {
+			failures = append(failures, dtos.BackupStreamFailure{ID: stream.ID, Error: "Parent stream missing: " + stream.ParentID})
+		}
+	}
+	return failures
+}
+
+//verifyStreamFile checks the size and the checksum of the stream file
+func verifyStreamFile(dir string, stream dtos.BackupStream) error {
+	file, err := os.Open(filepath.Join(dir, stream.File))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	if uint64(size) != stream.Size {
+		return fmt.Errorf("Size mismatch, expected %d bytes, found %d", stream.Size, size)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != stream.SHA256 {
+		return errors.New("Checksum mismatch")
+	}
+	return nil
+}
+
+/*BackupVerifyOperation verifies the dependencies and the checksums of the
+streams of a backup chain. It satisfies the tasks.CancellableOperation
+interface.*/
+type BackupVerifyOperation struct {
+	dir       string
+	chain     string
+	streams   []dtos.BackupStream
+	cancelled int32
+
+	mtx      sync.Mutex
+	progress dtos.BackupVerifyProgress
+}
+
+//NewBackupVerifyOperation constructs the verification of the chain in the backup target directory
+func NewBackupVerifyOperation(dir string, chain string) (*BackupVerifyOperation, error) {
+	streams, err := LoadBackupCatalog(dir)
+	if err != nil {
+		return nil, err
+	}
+	streams = chainStreams(streams, chain)
+	if len(streams) == 0 {
+		return nil, ErrBackupChainNotFound
+	}
+	return &BackupVerifyOperation{
+		dir:      dir,
+		chain:    chain,
+		streams:  streams,
+		progress: dtos.BackupVerifyProgress{StreamsTotal: len(streams), Failures: []dtos.BackupStreamFailure{}},
+	}, nil
+}
+
+/*Run checks the chain and then every stream file. It fails if any stream
+failed, the failures are listed in the progress.*/
+func (v *BackupVerifyOperation) Run() error {
+	v.mtx.Lock()
+	v.progress.Failures = append(v.progress.Failures, validateChain(v.chain, v.streams)...)
+	v.mtx.Unlock()
+
+	for _, stream := range v.streams {
+		if atomic.LoadInt32(&v.cancelled) != 0 {
+			return ErrBackupCancelled
+		}
+		err := verifyStreamFile(v.dir, stream)
+		v.mtx.Lock()
+		if err != nil {
+			v.progress.Failures = append(v.progress.Failures, dtos.BackupStreamFailure{ID: stream.ID, Error: err.Error()})
+		}
+		v.progress.StreamsChecked++
+		v.mtx.Unlock()
+	}
+
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	if len(v.progress.Failures) > 0 {
+		return fmt.Errorf("%d problems found in the chain %s", len(v.progress.Failures), v.chain)
+	}
+	return nil
+}
+
+//Cancel stops the verification after the stream being checked
+func (v *BackupVerifyOperation) Cancel() error {
+	atomic.StoreInt32(&v.cancelled, 1)
+	return nil
+}
+
+//Progress returns the current dtos.BackupVerifyProgress of the verification
+func (v *BackupVerifyOperation) Progress() (interface{}, error) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	progress := v.progress
+	progress.Failures = append([]dtos.BackupStreamFailure{}, v.progress.Failures...)
+	return progress, nil
+}
+
+/*planPrune returns the streams of the oldest chains of every subvolume, so that
+keepChains chains of every subvolume remain. Only whole chains are removed, so
+no remaining stream loses its parent.*/
+func planPrune(streams []dtos.BackupStream, keepChains int) []dtos.BackupStream {
+	type sourceKey struct {
+		volumeUUID dtos.UUIDType
+		sourceUUID dtos.UUIDType
+	}
+	chainsBySource := make(map[sourceKey][]string)
+	for _, stream := range streams {
+		if stream.ParentID == "" {
+			key := sourceKey{stream.VolumeUUID, stream.SourceUUID}
+			chainsBySource[key] = append(chainsBySource[key], stream.Chain)
+		}
+	}
+	removedChains := make(map[string]bool)
+	for _, chains := range chainsBySource {
+		for i := 0; i < len(chains)-keepChains; i++ {
+			removedChains[chains[i]] = true
+		}
+	}
+
+	removed := []dtos.BackupStream{}
+	for _, stream := range streams {
+		if removedChains[stream.Chain] {
+			removed = append(removed, stream)
+		}
+	}
+	return removed
+}
+
+/*PruneBackups deletes the oldest chains in the backup target directory, so that
+keepChains chains of every subvolume remain. The removed streams are returned,
+in a dry run they are only listed. The catalog is updated before the stream
+files are deleted.*/
+func PruneBackups(dir string, keepChains int, dryRun bool) ([]dtos.BackupStream, error) {
+	if keepChains < 1 {
+		return nil, ErrInvalidKeepChains
+	}
+	backupMtx.Lock()
+	defer backupMtx.Unlock()
+	streams, err := LoadBackupCatalog(dir)
+	if err != nil {
+		return nil, err
+	}
+	removed := planPrune(streams, keepChains)
+	if dryRun || len(removed) == 0 {
+		return removed, nil
+	}
+
+	remaining := []dtos.BackupStream{}
+	for _, stream := range streams {
+		if _, isRemoved := findBackupStream(removed, stream.ID); !isRemoved {
+			remaining = append(remaining, stream)
+		}
+	}
+	err = saveBackupCatalog(dir, remaining)
+	if err != nil {
+		return nil, err
+	}
+	for _, stream := range removed {
+		err = os.Remove(filepath.Join(dir, stream.File))
+		if err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
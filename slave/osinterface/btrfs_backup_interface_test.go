@@ -0,0 +1,126 @@
+package osinterface
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/stretchr/testify/assert"
+)
+
+var backupTestStreams = []dtos.BackupStream{
+	{ID: "a1", Chain: "a1", VolumeUUID: "vol", SourceUUID: "home", SnapshotUUID: "a1", File: "a1.btrfs"},
+	{ID: "a2", Chain: "a1", ParentID: "a1", VolumeUUID: "vol", SourceUUID: "home", SnapshotUUID: "a2", File: "a2.btrfs"},
+	{ID: "b1", Chain: "b1", VolumeUUID: "vol", SourceUUID: "srv", SnapshotUUID: "b1", File: "b1.btrfs"},
+	{ID: "a3", Chain: "a3", VolumeUUID: "vol", SourceUUID: "home", SnapshotUUID: "a3", File: "a3.btrfs"},
+	{ID: "a4", Chain: "a3", ParentID: "a3", VolumeUUID: "vol", SourceUUID: "home", SnapshotUUID: "a4", File: "a4.btrfs"},
+}
+
+func TestSelectBackupParent(t *testing.T) {
+	snapshot := dtos.BtrfsSubVolume{RelativePath: "snapshots/a5", UUID: "a5", ParentUUID: "home", ReadOnly: true}
+	subvols := []dtos.BtrfsSubVolume{
+		{RelativePath: "snapshots/a2", UUID: "a2", ReadOnly: true},
+		{RelativePath: "snapshots/a4", UUID: "a4"},
+		snapshot,
+	}
+
+	//a4 is no longer read-only, a3 is gone
+	parent, path, ok := selectBackupParent(backupTestStreams, "vol", snapshot, subvols)
+	assert.True(t, ok)
+	assert.EqualValues(t, "a2", parent.ID)
+	assert.EqualValues(t, "snapshots/a2", path)
+
+	_, _, ok = selectBackupParent(backupTestStreams, "other", snapshot, subvols)
+	assert.False(t, ok)
+}
+
+func TestValidateChain(t *testing.T) {
+	assert.Empty(t, validateChain("a1", chainStreams(backupTestStreams, "a1")))
+
+	failures := validateChain("a3", chainStreams(backupTestStreams, "a3")[1:])
+	assert.EqualValues(t, []dtos.BackupStreamFailure{
+		{ID: "a3", Error: "The full stream of the chain is missing"},
+		{ID: "a4", Error: "Parent stream missing: a3"},
+	}, failures)
+}
+
+func TestPlanPrune(t *testing.T) {
+	removed := planPrune(backupTestStreams, 1)
+	assert.EqualValues(t, backupTestStreams[:2], removed)
+	assert.Empty(t, planPrune(backupTestStreams, 2))
+}
+
+func TestBackupTargetDir(t *testing.T) {
+	oldBackupDir := backupDir
+	backupDir = "/srv/backups"
+	defer func() { backupDir = oldBackupDir }()
+
+	dir, err := BackupTargetDir("usb/")
+	assert.NoError(t, err)
+	assert.Equal(t, "/srv/backups/usb", dir)
+	dir, err = BackupTargetDir("/srv/backups/nfs")
+	assert.NoError(t, err)
+	assert.Equal(t, "/srv/backups/nfs", dir)
+	dir, err = BackupTargetDir("")
+	assert.NoError(t, err)
+	assert.Equal(t, "/srv/backups", dir)
+	for _, target := range []string{"/etc", "../etc", "usb/../../etc", "/srv/backups-old", "/srv/backups/../x"} {
+		_, err = BackupTargetDir(target)
+		assert.Equal(t, ErrBackupTargetOutside, err, target)
+	}
+}
+
+func TestBackupStreamFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	streams, err := LoadBackupCatalog(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, streams)
+	_, err = LoadBackupCatalog("relative")
+	assert.Equal(t, ErrBackupTargetNotAbsolute, err)
+
+	catalog := append([]dtos.BackupStream{}, backupTestStreams...)
+	var written uint64
+	for i := range catalog {
+		stream := &catalog[i]
+		stream.Size, stream.SHA256, err = writeBackupStream(filepath.Join(dir, stream.File),
+			bytes.NewBufferString("stream "+stream.ID), &written)
+		assert.NoError(t, err)
+	}
+	assert.EqualValues(t, 5*len("stream a1"), written)
+	assert.NoError(t, saveBackupCatalog(dir, catalog))
+	assert.NoError(t, verifyStreamFile(dir, catalog[0]))
+
+	ioutil.WriteFile(filepath.Join(dir, "a2.btrfs"), []byte("stream a0"), 0600)
+	assert.EqualError(t, verifyStreamFile(dir, catalog[1]), "Checksum mismatch")
+
+	removed, err := PruneBackups(dir, 1, true)
+	assert.NoError(t, err)
+	assert.Len(t, removed, 2)
+	_, err = os.Stat(filepath.Join(dir, "a1.btrfs"))
+	assert.NoError(t, err)
+
+	removed, err = PruneBackups(dir, 1, false)
+	assert.NoError(t, err)
+	assert.Len(t, removed, 2)
+	_, err = os.Stat(filepath.Join(dir, "a1.btrfs"))
+	assert.True(t, os.IsNotExist(err))
+	streams, err = LoadBackupCatalog(dir)
+	assert.NoError(t, err)
+	assert.EqualValues(t, catalog[2:], streams)
+
+	_, err = PruneBackups(dir, 0, false)
+	assert.Equal(t, ErrInvalidKeepChains, err)
+
+	for _, file := range []string{"../../etc/shadow", "/etc/shadow", "sub/a1.btrfs", ".."} {
+		streams[0].File = file
+		assert.NoError(t, saveBackupCatalog(dir, streams))
+		_, err = LoadBackupCatalog(dir)
+		assert.Equal(t, ErrInvalidBackupCatalog, err, file)
+	}
+}
@@ -0,0 +1,181 @@
+package osinterface
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+)
+
+var (
+	//ErrBalanceInProgress indicates that a balance is already running or
+	//paused on the volume
+	ErrBalanceInProgress = errors.New("A balance is already in progress on the volume")
+	//ErrBalanceSystemNoForce indicates that system chunks were selected without
+	//the force flag
+	ErrBalanceSystemNoForce = errors.New("Balancing system chunks requires the force flag")
+
+	balanceProgressMatcher = regexp.MustCompile(
+		`([0-9]+) out of about ([0-9]+) chunks balanced \(([0-9]+) considered\),\s+([0-9]+)% left`)
+)
+
+//BalanceArgs describes which chunks of a volume are balanced
+type BalanceArgs struct {
+	Data     *dtos.BalanceFilter
+	Metadata *dtos.BalanceFilter
+	System   *dtos.BalanceFilter
+	Force    bool
+}
+
+/*BalanceOperation runs btrfs balance on a volume. It satisfies the
+tasks.PausableOperation and tasks.CancellableOperation interfaces.*/
+type BalanceOperation struct {
+	mountPath string
+	args      BalanceArgs
+
+	progressMtx  sync.Mutex
+	lastProgress dtos.BalanceProgress
+}
+
+/*NewBalanceOperation constructs a balance of the given volume. The volume's root
+is mounted if necessary. If a balance is already running or paused on the volume,
+ErrBalanceInProgress is returned.*/
+func NewBalanceOperation(vol dtos.BtrfsVolume, args BalanceArgs) (*BalanceOperation, error) {
+	if args.System != nil && !args.Force {
+		return nil, ErrBalanceSystemNoForce
+	}
+	mountPath, err := GetBtrfsRootMount(vol)
+	if err != nil {
+		return nil, err
+	}
+	output, err := balanceStatus(mountPath)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(output, "No balance found") {
+		return nil, ErrBalanceInProgress
+	}
+	return &BalanceOperation{mountPath: mountPath, args: args}, nil
+}
+
+func formatBalanceFilter(flag string, filter *dtos.BalanceFilter) string {
+	var filters []string
+	if filter.Usage != nil {
+		filters = append(filters, "usage="+strconv.Itoa(*filter.Usage))
+	}
+	if filter.DevID != nil {
+		filters = append(filters, "devid="+strconv.Itoa(*filter.DevID))
+	}
+	if len(filter.Profiles) > 0 {
+		filters = append(filters, "profiles="+strings.Join(filter.Profiles, "|"))
+	}
+	if filter.Convert != "" {
+		filters = append(filters, "convert="+filter.Convert)
+	}
+	if filter.Soft {
+		filters = append(filters, "soft")
+	}
+	return flag + strings.Join(filters, ",")
+}
+
+//balanceStartOptions builds the command line options of btrfs balance start
+func balanceStartOptions(mountPath string, args BalanceArgs) []string {
+	options := []string{"balance", "start"}
+	if args.Force {
+		options = append(options, "--force")
+	}
+	if args.Data != nil {
+		options = append(options, formatBalanceFilter("-d", args.Data))
+	}
+	if args.Metadata != nil {
+		options = append(options, formatBalanceFilter("-m", args.Metadata))
+	}
+	if args.System != nil {
+		options = append(options, formatBalanceFilter("-s", args.System))
+	}
+	if args.Data == nil && args.Metadata == nil && args.System == nil {
+		options = append(options, "--full-balance")
+	}
+	return append(options, mountPath)
+}
+
+/*balanceStatus returns the output of btrfs balance status. The tool exits with
+a non-zero status if a balance is in progress, which is not treated as an error.*/
+func balanceStatus(mountPath string) (string, error) {
+	output, err := runBtrfsCommand("balance", "status", mountPath)
+	if err != nil {
+		cmdErr, ok := err.(BtrfsCmdError)
+		if !ok || !strings.Contains(cmdErr.Output, "Balance on") {
+			return "", err
+		}
+		output = cmdErr.Output
+	}
+	return output, nil
+}
+
+//Run starts the balance and blocks until it finishes, is paused or cancelled
+func (b *BalanceOperation) Run() error {
+	_, err := runBtrfsCommand(balanceStartOptions(b.mountPath, b.args)...)
+	return err
+}
+
+//Pause pauses the running balance
+func (b *BalanceOperation) Pause() error {
+	_, err := runBtrfsCommand("balance", "pause", b.mountPath)
+	return err
+}
+
+//Resume continues a paused balance and blocks until it finishes, is paused or cancelled
+func (b *BalanceOperation) Resume() error {
+	_, err := runBtrfsCommand("balance", "resume", b.mountPath)
+	return err
+}
+
+//Cancel cancels a running or paused balance
+func (b *BalanceOperation) Cancel() error {
+	_, err := runBtrfsCommand("balance", "cancel", b.mountPath)
+	return err
+}
+
+/*Progress returns the current dtos.BalanceProgress of the balance. Once the
+balance is over the kernel no longer reports it, so the last known progress is
+returned.*/
+func (b *BalanceOperation) Progress() (interface{}, error) {
+	output, err := balanceStatus(b.mountPath)
+	if err != nil {
+		return nil, err
+	}
+
+	b.progressMtx.Lock()
+	defer b.progressMtx.Unlock()
+	if strings.Contains(output, "No balance found") {
+		return b.lastProgress, nil
+	}
+	progress, err := parseBalanceStatus(output)
+	if err != nil {
+		return nil, err
+	}
+	b.lastProgress = progress
+	return progress, nil
+}
+
+/*parseBalanceStatus parses the output of btrfs balance status, for example:
+Balance on '/mnt' is running
+2 out of about 9 chunks balanced (3 considered),  78% left*/
+func parseBalanceStatus(output string) (progress dtos.BalanceProgress, err error) {
+	match := balanceProgressMatcher.FindStringSubmatch(output)
+	if match == nil {
+		if strings.Contains(output, "No balance found") {
+			return
+		}
+		return progress, errors.New("Unable to parse balance status: " + output)
+	}
+	progress.ChunksRelocated, _ = strconv.ParseUint(match[1], 10, 64)
+	progress.ChunksExpected, _ = strconv.ParseUint(match[2], 10, 64)
+	progress.ChunksConsidered, _ = strconv.ParseUint(match[3], 10, 64)
+	progress.PercentLeft, _ = strconv.Atoi(match[4])
+	return
+}
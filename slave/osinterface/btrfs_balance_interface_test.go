@@ -0,0 +1,41 @@
+package osinterface
+
+import (
+	"testing"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBalanceStatus(t *testing.T) {
+	output := "Balance on '/mnt/volume' is running\n" +
+		"2 out of about 9 chunks balanced (3 considered),  78% left\n"
+	progress, err := parseBalanceStatus(output)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, progress.ChunksRelocated)
+	assert.EqualValues(t, 9, progress.ChunksExpected)
+	assert.EqualValues(t, 3, progress.ChunksConsidered)
+	assert.EqualValues(t, 78, progress.PercentLeft)
+
+	progress, err = parseBalanceStatus("No balance found on '/mnt/volume'\n")
+	assert.NoError(t, err)
+	assert.EqualValues(t, dtos.BalanceProgress{}, progress)
+}
+
+func TestBalanceStartOptions(t *testing.T) {
+	usage := 50
+	devID := 2
+	args := BalanceArgs{
+		Data:     &dtos.BalanceFilter{Usage: &usage, DevID: &devID},
+		Metadata: &dtos.BalanceFilter{Profiles: []string{"dup", "single"}},
+	}
+	options := balanceStartOptions("/mnt/volume", args)
+	assert.EqualValues(t, []string{"balance", "start", "-dusage=50,devid=2",
+		"-mprofiles=dup|single", "/mnt/volume"}, options)
+
+	options = balanceStartOptions("/mnt/volume", BalanceArgs{})
+	assert.EqualValues(t, []string{"balance", "start", "--full-balance", "/mnt/volume"}, options)
+
+	options = balanceStartOptions("/mnt/volume", BalanceArgs{System: &dtos.BalanceFilter{}, Force: true})
+	assert.EqualValues(t, []string{"balance", "start", "--force", "-s", "/mnt/volume"}, options)
+}
@@ -0,0 +1,216 @@
+package osinterface
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+)
+
+//ErrCodeConversionRefused is the dtos.Error code of a refused profile conversion
+const ErrCodeConversionRefused = "profile_conversion_refused"
+
+//dtos.ErrorCause codes of a refused profile conversion
+const (
+	CauseUnknownProfile       = "unknown_profile"
+	CauseNothingToConvert     = "nothing_to_convert"
+	CauseNotEnoughDevices     = "not_enough_devices"
+	CauseNotEnoughSpace       = "not_enough_space"
+	CauseNotEnoughUnallocated = "not_enough_unallocated"
+	CauseRedundancyReduction  = "metadata_redundancy_reduction"
+)
+
+const (
+	dataChunkSize     = 1 << 30
+	metadataChunkSize = 256 << 20
+)
+
+type raidProfile struct {
+	minDevices        int
+	toleratedFailures int
+	//rawRatio returns the raw bytes used per logical byte on n devices
+	rawRatio func(n int) float64
+}
+
+func fixedRatio(ratio float64) func(int) float64 {
+	return func(int) float64 { return ratio }
+}
+
+var raidProfiles = map[string]raidProfile{
+	"single":  {minDevices: 1, toleratedFailures: 0, rawRatio: fixedRatio(1)},
+	"dup":     {minDevices: 1, toleratedFailures: 0, rawRatio: fixedRatio(2)},
+	"raid0":   {minDevices: 2, toleratedFailures: 0, rawRatio: fixedRatio(1)},
+	"raid1":   {minDevices: 2, toleratedFailures: 1, rawRatio: fixedRatio(2)},
+	"raid1c3": {minDevices: 3, toleratedFailures: 2, rawRatio: fixedRatio(3)},
+	"raid1c4": {minDevices: 4, toleratedFailures: 3, rawRatio: fixedRatio(4)},
+	"raid10":  {minDevices: 4, toleratedFailures: 1, rawRatio: fixedRatio(2)},
+	"raid5": {minDevices: 2, toleratedFailures: 1, rawRatio: func(n int) float64 {
+		return float64(n) / float64(n-1)
+	}},
+	"raid6": {minDevices: 3, toleratedFailures: 2, rawRatio: func(n int) float64 {
+		return float64(n) / float64(n-2)
+	}},
+}
+
+//ProfileConversionArgs describes the target profiles of a conversion. An empty
+//profile leaves the block group type untouched.
+type ProfileConversionArgs struct {
+	DataProfile     string
+	MetadataProfile string
+	Force           bool
+}
+
+type blockGroupSpace struct {
+	used         uint64
+	rawAllocated uint64
+	profile      string
+}
+
+//blockGroupSpaceByType sums up the space of all block groups of the given type
+func blockGroupSpaceByType(usage dtos.BtrfsVolumeUsage, bgType string) (space blockGroupSpace) {
+	for _, bg := range usage.BlockGroups {
+		if !strings.EqualFold(bg.Type, bgType) {
+			continue
+		}
+		space.used += bg.Used
+		for _, dev := range bg.Devices {
+			space.rawAllocated += dev.Bytes
+		}
+		if bg.Used > 0 || space.profile == "" {
+			space.profile = strings.ToLower(bg.Profile)
+		}
+	}
+	return
+}
+
+/*ValidateProfileConversion checks whether the volume can be converted to the
+requested profiles, considering its device count and unallocated space. The
+returned error is a dtos.Error listing every reason the conversion is refused.*/
+func ValidateProfileConversion(vol dtos.BtrfsVolume, usage dtos.BtrfsVolumeUsage, args ProfileConversionArgs) error {
+	var causes []dtos.ErrorCause
+	devCount := len(vol.Devices)
+
+	if args.DataProfile == "" && args.MetadataProfile == "" {
+		causes = append(causes, dtos.ErrorCause{
+			Code:    CauseNothingToConvert,
+			Message: "Neither a data nor a metadata profile was requested",
+		})
+	}
+
+	var requiredRaw, availableRaw uint64
+	var minUnallocated uint64
+	check := func(bgType string, target string, chunkSize uint64) {
+		if target == "" {
+			return
+		}
+		profile, ok := raidProfiles[target]
+		if !ok {
+			causes = append(causes, dtos.ErrorCause{
+				Code:    CauseUnknownProfile,
+				Message: "Unknown " + bgType + " profile: " + target,
+			})
+			return
+		}
+		if devCount < profile.minDevices {
+			causes = append(causes, dtos.ErrorCause{
+				Code:      CauseNotEnoughDevices,
+				Message:   fmt.Sprintf("The %s profile %s requires at least %d devices", bgType, target, profile.minDevices),
+				Required:  uint64(profile.minDevices),
+				Available: uint64(devCount),
+			})
+			return
+		}
+
+		ratio := profile.rawRatio(devCount)
+		space := blockGroupSpaceByType(usage, bgType)
+		requiredRaw += uint64(float64(space.used) * ratio)
+		availableRaw += space.rawAllocated
+		if chunkRaw := uint64(float64(chunkSize) * ratio); chunkRaw > minUnallocated {
+			minUnallocated = chunkRaw
+		}
+
+		current, known := raidProfiles[space.profile]
+		if bgType == "metadata" && known && !args.Force &&
+			profile.toleratedFailures < current.toleratedFailures {
+			causes = append(causes, dtos.ErrorCause{
+				Code:      CauseRedundancyReduction,
+				Message:   "Converting metadata from " + space.profile + " to " + target + " reduces redundancy, force is required",
+				Required:  uint64(current.toleratedFailures),
+				Available: uint64(profile.toleratedFailures),
+			})
+		}
+	}
+	check("data", args.DataProfile, dataChunkSize)
+	check("metadata", args.MetadataProfile, metadataChunkSize)
+
+	//Relocated chunks are freed as the conversion progresses, so the space
+	//allocated to the converted block groups can be reused.
+	availableRaw += usage.DeviceUnallocated
+	if requiredRaw > availableRaw {
+		causes = append(causes, dtos.ErrorCause{
+			Code:      CauseNotEnoughSpace,
+			Message:   "Not enough space to store the converted block groups",
+			Required:  requiredRaw,
+			Available: availableRaw,
+		})
+	}
+	if usage.DeviceUnallocated < minUnallocated {
+		causes = append(causes, dtos.ErrorCause{
+			Code:      CauseNotEnoughUnallocated,
+			Message:   "Not enough unallocated space to allocate the first converted chunk",
+			Required:  minUnallocated,
+			Available: usage.DeviceUnallocated,
+		})
+	}
+
+	if len(causes) == 0 {
+		return nil
+	}
+	return newRefusalError(ErrCodeConversionRefused, "Profile conversion refused", causes)
+}
+
+func findBtrfsVolume(UUID dtos.UUIDType) (dtos.BtrfsVolume, error) {
+	vols, err := ProbeBtrfsVolumes()
+	if err != nil {
+		return dtos.BtrfsVolume{}, err
+	}
+	for _, vol := range vols {
+		if vol.UUID == UUID {
+			return vol, nil
+		}
+	}
+	return dtos.BtrfsVolume{}, ErrVolumeNotFound{UUID: UUID}
+}
+
+/*NewProfileConversion validates the conversion of the volume's profiles and
+constructs the convert balance that performs it.*/
+func NewProfileConversion(UUID dtos.UUIDType, args ProfileConversionArgs) (*BalanceOperation, error) {
+	args.DataProfile = strings.ToLower(args.DataProfile)
+	args.MetadataProfile = strings.ToLower(args.MetadataProfile)
+
+	vol, err := findBtrfsVolume(UUID)
+	if err != nil {
+		return nil, err
+	}
+	mountPath, err := GetBtrfsRootMount(vol)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := ProbeVolumeUsage(mountPath)
+	if err != nil {
+		return nil, err
+	}
+	err = ValidateProfileConversion(vol, usage, args)
+	if err != nil {
+		return nil, err
+	}
+
+	balanceArgs := BalanceArgs{Force: args.Force}
+	if args.DataProfile != "" {
+		balanceArgs.Data = &dtos.BalanceFilter{Convert: args.DataProfile, Soft: true}
+	}
+	if args.MetadataProfile != "" {
+		balanceArgs.Metadata = &dtos.BalanceFilter{Convert: args.MetadataProfile, Soft: true}
+	}
+	return NewBalanceOperation(vol, balanceArgs)
+}
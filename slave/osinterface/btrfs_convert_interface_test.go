@@ -0,0 +1,83 @@
+package osinterface
+
+import (
+	"testing"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/stretchr/testify/assert"
+)
+
+const gib = 1 << 30
+
+func testVolume(devCount int) dtos.BtrfsVolume {
+	var vol dtos.BtrfsVolume
+	for i := 0; i < devCount; i++ {
+		vol.Devices = append(vol.Devices, &dtos.BlockDevice{})
+	}
+	return vol
+}
+
+func testUsage(unallocated uint64) dtos.BtrfsVolumeUsage {
+	return dtos.BtrfsVolumeUsage{
+		DeviceUnallocated: unallocated,
+		BlockGroups: []dtos.BtrfsBlockGroupUsage{
+			{Type: "Data", Profile: "single", Allocated: 10 * gib, Used: 8 * gib,
+				Devices: []dtos.BtrfsDeviceSpace{{Path: "/dev/sdb", Bytes: 10 * gib}}},
+			{Type: "Metadata", Profile: "RAID1", Allocated: gib, Used: gib / 2,
+				Devices: []dtos.BtrfsDeviceSpace{{Path: "/dev/sdb", Bytes: gib}, {Path: "/dev/sdc", Bytes: gib}}},
+		},
+	}
+}
+
+func causeCodes(err error) []string {
+	var codes []string
+	for _, cause := range err.(dtos.Error).Causes {
+		codes = append(codes, cause.Code)
+	}
+	return codes
+}
+
+func TestValidateProfileConversionAccepted(t *testing.T) {
+	err := ValidateProfileConversion(testVolume(2), testUsage(20*gib),
+		ProfileConversionArgs{DataProfile: "raid1"})
+	assert.NoError(t, err)
+}
+
+func TestValidateProfileConversionNotEnoughDevices(t *testing.T) {
+	err := ValidateProfileConversion(testVolume(2), testUsage(20*gib),
+		ProfileConversionArgs{DataProfile: "raid1c3", MetadataProfile: "raid10"})
+	assert.Error(t, err)
+	assert.EqualValues(t, ErrCodeConversionRefused, err.(dtos.Error).Code)
+	assert.EqualValues(t, []string{CauseNotEnoughDevices, CauseNotEnoughDevices}, causeCodes(err))
+	assert.EqualValues(t, 3, err.(dtos.Error).Causes[0].Required)
+	assert.EqualValues(t, 2, err.(dtos.Error).Causes[0].Available)
+}
+
+func TestValidateProfileConversionNotEnoughSpace(t *testing.T) {
+	//8GiB of data need 16GiB raw in raid1, 10GiB allocated + 2GiB unallocated
+	err := ValidateProfileConversion(testVolume(2), testUsage(2*gib),
+		ProfileConversionArgs{DataProfile: "raid1"})
+	assert.Error(t, err)
+	assert.EqualValues(t, []string{CauseNotEnoughSpace}, causeCodes(err))
+}
+
+func TestValidateProfileConversionMetadataReduction(t *testing.T) {
+	args := ProfileConversionArgs{MetadataProfile: "single"}
+	err := ValidateProfileConversion(testVolume(2), testUsage(20*gib), args)
+	assert.Error(t, err)
+	assert.EqualValues(t, []string{CauseRedundancyReduction}, causeCodes(err))
+
+	args.Force = true
+	err = ValidateProfileConversion(testVolume(2), testUsage(20*gib), args)
+	assert.NoError(t, err)
+}
+
+func TestValidateProfileConversionUnknownProfile(t *testing.T) {
+	err := ValidateProfileConversion(testVolume(2), testUsage(20*gib),
+		ProfileConversionArgs{DataProfile: "raid7"})
+	assert.Error(t, err)
+	assert.EqualValues(t, []string{CauseUnknownProfile}, causeCodes(err))
+
+	err = ValidateProfileConversion(testVolume(2), testUsage(20*gib), ProfileConversionArgs{})
+	assert.EqualValues(t, []string{CauseNothingToConvert}, causeCodes(err))
+}
@@ -0,0 +1,598 @@
+package osinterface
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+)
+
+var (
+	deviceStatsMatcher       = regexp.MustCompile(`^\[(.+)\]\.([a-z_]+)\s+([0-9]+)`)
+	deviceUsageHeaderMatcher = regexp.MustCompile(`^(\S+), ID: ([0-9]+)`)
+	deviceUsageLineMatcher   = regexp.MustCompile(`^\s+([^:]+):\s+([0-9]+)\s*$`)
+)
+
+//sysBlockPath is the sysfs directory that lists the block devices and their partitions
+var sysBlockPath = "/sys/class/block"
+
+//ErrCodeDeviceAddRefused is the dtos.Error code of a refused device addition
+const ErrCodeDeviceAddRefused = "device_add_refused"
+
+//ErrCodeDeviceRemoveRefused is the dtos.Error code of a refused device removal
+const ErrCodeDeviceRemoveRefused = "device_remove_refused"
+
+//ErrCodeDeviceResizeRefused is the dtos.Error code of a refused device resize
+const ErrCodeDeviceResizeRefused = "device_resize_refused"
+
+//dtos.ErrorCause codes of a refused device addition or removal
+const (
+	CauseDeviceNotFound      = "device_not_found"
+	CauseDeviceInVolume      = "device_in_volume"
+	CauseDeviceNotInVolume   = "device_not_in_volume"
+	CauseDeviceMounted       = "device_mounted"
+	CauseDeviceHasFilesystem = "device_has_filesystem"
+	CauseDeviceHasPartitions = "device_has_partitions"
+	CauseNoDeviceLeft        = "no_device_left"
+	CauseNoDevicesKnown      = "no_devices_known"
+	CauseDevIDNotFound       = "devid_not_found"
+	CauseShrinkBelowUsed     = "shrink_below_used"
+)
+
+/*ProbeDeviceStats retrieves the error counters of every device of the btrfs
+volume mounted at mountPath. The returned map is keyed by the kernel identifier
+of the device. If reset is set, the counters are zeroed after being read.*/
+func ProbeDeviceStats(mountPath string, reset bool) (map[string]*dtos.DeviceStats, error) {
+	options := []string{"device", "stats"}
+	if reset {
+		options = append(options, "-z")
+	}
+	options = append(options, mountPath)
+
+	output, err := runBtrfsCommand(options...)
+	if err != nil {
+		return nil, err
+	}
+	return parseDeviceStats(output)
+}
+
+/*parseDeviceStats parses the output of btrfs device stats, for example:
+[/dev/sdb].write_io_errs    0*/
+func parseDeviceStats(output string) (map[string]*dtos.DeviceStats, error) {
+	stats := make(map[string]*dtos.DeviceStats)
+	for _, line := range strings.Split(output, "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		match := deviceStatsMatcher.FindStringSubmatch(line)
+		if match == nil {
+			return nil, errors.New("Unexpected device stats line: " + line)
+		}
+		value, err := strconv.ParseUint(match[3], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		devStats, ok := stats[match[1]]
+		if !ok {
+			devStats = &dtos.DeviceStats{}
+			stats[match[1]] = devStats
+		}
+		switch match[2] {
+		case "write_io_errs":
+			devStats.WriteIOErrs = value
+		case "read_io_errs":
+			devStats.ReadIOErrs = value
+		case "flush_io_errs":
+			devStats.FlushIOErrs = value
+		case "corruption_errs":
+			devStats.CorruptionErrs = value
+		case "generation_errs":
+			devStats.GenerationErrs = value
+		}
+	}
+	return stats, nil
+}
+
+//pathsByDevID sorts device paths by devid, then by path
+type pathsByDevID struct {
+	paths  []string
+	devIDs map[string]uint64
+}
+
+func (p pathsByDevID) Len() int      { return len(p.paths) }
+func (p pathsByDevID) Swap(i, j int) { p.paths[i], p.paths[j] = p.paths[j], p.paths[i] }
+func (p pathsByDevID) Less(i, j int) bool {
+	if p.devIDs[p.paths[i]] != p.devIDs[p.paths[j]] {
+		return p.devIDs[p.paths[i]] < p.devIDs[p.paths[j]]
+	}
+	return p.paths[i] < p.paths[j]
+}
+
+/*ListDeviceStats returns the devices of the btrfs volume mounted at mountPath
+with their error counters, sorted by devid. If reset is set, the counters are
+zeroed after being read.*/
+func ListDeviceStats(mountPath string, reset bool) ([]dtos.BlockDevice, error) {
+	sizes, err := ProbeDeviceSizes(mountPath)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := ProbeDeviceStats(mountPath, reset)
+	if err != nil {
+		return nil, err
+	}
+
+	devIDs := make(map[string]uint64)
+	for devID, size := range sizes {
+		devIDs[size.Path] = devID
+	}
+	paths := make([]string, 0, len(stats))
+	for path := range stats {
+		paths = append(paths, path)
+	}
+	sort.Sort(pathsByDevID{paths: paths, devIDs: devIDs})
+
+	blockDevs := make([]dtos.BlockDevice, 0, len(paths))
+	for _, path := range paths {
+		blockDev := dtos.BlockDevice{Path: path}
+		cached, ok := BlockDeviceCache.FindByKernelIdentifier(path)
+		if ok {
+			blockDev = *cached
+		}
+		blockDev.Stats = stats[path]
+		blockDevs = append(blockDevs, blockDev)
+	}
+	return blockDevs, nil
+}
+
+/*blockDeviceExists tells whether the kernel knows the block device, read from
+sysfs. Unlike the block device cache, it finds blank devices without any
+signature as well.*/
+func blockDeviceExists(path string) bool {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	_, err := os.Stat(filepath.Join(sysBlockPath, filepath.Base(path)))
+	return err == nil
+}
+
+/*devicePartitions returns the names of the partitions of the block device,
+read from sysfs.*/
+func devicePartitions(path string) ([]string, error) {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	name := filepath.Base(path)
+	entries, err := ioutil.ReadDir(filepath.Join(sysBlockPath, name))
+	if err != nil {
+		return nil, err
+	}
+
+	var partitions []string
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), name) {
+			continue
+		}
+		_, err := os.Stat(filepath.Join(sysBlockPath, name, entry.Name(), "partition"))
+		if err == nil {
+			partitions = append(partitions, entry.Name())
+		}
+	}
+	return partitions, nil
+}
+
+/*newDeviceCauses lists the reasons the block devices cannot become part of the
+volume. Devices that are mounted, contain a filesystem or hold partitions are
+refused unless force is set. Devices that do not exist or are already part of the
+volume are always refused. Blank devices are missing from the block device cache,
+it only tells about the filesystems.*/
+func newDeviceCauses(vol dtos.BtrfsVolume, paths []string, force bool) (causes []dtos.ErrorCause) {
+	for _, path := range paths {
+		if !blockDeviceExists(path) {
+			causes = append(causes, dtos.ErrorCause{
+				Code:    CauseDeviceNotFound,
+				Message: "Block device not found: " + path,
+			})
+			continue
+		}
+		bd, cached := BlockDeviceCache.FindByKernelIdentifier(path)
+		if cached && bd.UUID != "" && bd.UUID == vol.UUID {
+			causes = append(causes, dtos.ErrorCause{
+				Code:    CauseDeviceInVolume,
+				Message: "The device " + path + " is already part of the volume",
+			})
+			continue
+		}
+		if force {
+			continue
+		}
+
+		if _, mounted := MountPointCache.FindByKernelIdentifier(path); mounted {
+			causes = append(causes, dtos.ErrorCause{
+				Code:    CauseDeviceMounted,
+				Message: "The device " + path + " is mounted",
+			})
+		}
+		if cached && bd.Type != "" {
+			causes = append(causes, dtos.ErrorCause{
+				Code:    CauseDeviceHasFilesystem,
+				Message: "The device " + path + " contains a " + bd.Type + " filesystem " + string(bd.UUID),
+			})
+		}
+		partitions, err := devicePartitions(path)
+		if err == nil && len(partitions) > 0 {
+			causes = append(causes, dtos.ErrorCause{
+				Code:    CauseDeviceHasPartitions,
+				Message: "The device " + path + " holds partitions: " + strings.Join(partitions, ", "),
+			})
+		}
+	}
+	return
+}
+
+/*ValidateDeviceAdd checks whether the block devices can be added to the volume.
+The returned error is a dtos.Error listing every reason the addition is refused.*/
+func ValidateDeviceAdd(vol dtos.BtrfsVolume, paths []string, force bool) error {
+	causes := newDeviceCauses(vol, paths, force)
+	if len(causes) == 0 {
+		return nil
+	}
+	return newRefusalError(ErrCodeDeviceAddRefused, "Device addition refused", causes)
+}
+
+/*UnusedBlockDevices returns the block devices listed in sysfs that contain no
+filesystem, are not mounted, hold no partitions, are not used by other block
+devices and are not empty. The partitions themselves are not listed.*/
+func UnusedBlockDevices() []dtos.BlockDevice {
+	entries, err := ioutil.ReadDir(sysBlockPath)
+	if err != nil {
+		return nil
+	}
+	var unused []dtos.BlockDevice
+	for _, entry := range entries {
+		name := entry.Name()
+		dir := filepath.Join(sysBlockPath, name)
+		if _, err := os.Stat(filepath.Join(dir, "partition")); err == nil {
+			continue
+		}
+		if size, err := ioutil.ReadFile(filepath.Join(dir, "size")); err != nil || strings.TrimSpace(string(size)) == "0" {
+			continue
+		}
+		if holders, _ := ioutil.ReadDir(filepath.Join(dir, "holders")); len(holders) > 0 {
+			continue
+		}
+
+		bd := dtos.BlockDevice{Path: "/dev/" + name}
+		if cached, ok := BlockDeviceCache.FindByKernelIdentifier(bd.Path); ok {
+			bd = *cached
+		}
+		if bd.Type != "" {
+			continue
+		}
+		if _, mounted := MountPointCache.FindByKernelIdentifier(bd.Path); mounted {
+			continue
+		}
+		partitions, err := devicePartitions(bd.Path)
+		if err == nil && len(partitions) > 0 {
+			continue
+		}
+		unused = append(unused, bd)
+	}
+	return unused
+}
+
+/*AddDevices adds the block devices to the btrfs volume identified by UUID, after
+validating them with ValidateDeviceAdd. The block device cache is rescanned and
+the devices of the volume are returned.*/
+func AddDevices(UUID dtos.UUIDType, paths []string, force bool) ([]dtos.BlockDevice, error) {
+	vol, err := findBtrfsVolume(UUID)
+	if err != nil {
+		return nil, err
+	}
+	err = ValidateDeviceAdd(vol, paths, force)
+	if err != nil {
+		return nil, err
+	}
+	mountPath, err := GetBtrfsRootMount(vol)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []string{"device", "add"}
+	if force {
+		options = append(options, "-f")
+	}
+	options = append(options, paths...)
+	options = append(options, mountPath)
+	_, err = runBtrfsCommand(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = BlockDeviceCache.Rescan()
+	if err != nil {
+		return nil, err
+	}
+	bds, _ := BlockDeviceCache.FindByUUID(UUID)
+	blockDevs := make([]dtos.BlockDevice, len(bds))
+	for i, bd := range bds {
+		blockDevs[i] = *bd
+	}
+	return blockDevs, nil
+}
+
+/*ValidateDeviceRemove checks whether the block devices can be removed from the
+volume. Every device has to be part of the volume and at least one device has to
+remain. The keyword "missing" removes the devices that are no longer present.
+Nothing is removed from a volume whose devices are not known.*/
+func ValidateDeviceRemove(vol dtos.BtrfsVolume, paths []string) error {
+	if len(vol.Devices) == 0 {
+		return newRefusalError(ErrCodeDeviceRemoveRefused, "Device removal refused", []dtos.ErrorCause{{
+			Code:    CauseNoDevicesKnown,
+			Message: "The devices of the volume are not known",
+		}})
+	}
+	var causes []dtos.ErrorCause
+	removed := make(map[string]bool)
+	for _, path := range paths {
+		if path == "missing" {
+			continue
+		}
+		inVolume := false
+		for _, dev := range vol.Devices {
+			if dev.Path == path {
+				inVolume = true
+				break
+			}
+		}
+		if !inVolume {
+			causes = append(causes, dtos.ErrorCause{
+				Code:    CauseDeviceNotInVolume,
+				Message: "The device " + path + " is not part of the volume",
+			})
+			continue
+		}
+		removed[path] = true
+	}
+	if len(removed) >= len(vol.Devices) {
+		causes = append(causes, dtos.ErrorCause{
+			Code:      CauseNoDeviceLeft,
+			Message:   "At least one device has to remain in the volume",
+			Required:  uint64(len(removed) + 1),
+			Available: uint64(len(vol.Devices)),
+		})
+	}
+
+	if len(causes) == 0 {
+		return nil
+	}
+	return newRefusalError(ErrCodeDeviceRemoveRefused, "Device removal refused", causes)
+}
+
+/*DeviceRemoveOperation removes devices from a btrfs volume, relocating their
+data to the remaining devices. It satisfies the tasks.Operation interface, the
+removal can be neither paused nor cancelled.*/
+type DeviceRemoveOperation struct {
+	mountPath string
+	paths     []string
+
+	progressMtx     sync.Mutex
+	bytesToRelocate uint64
+	bytesRemaining  uint64
+}
+
+/*NewDeviceRemoveOperation validates the removal of the block devices from the
+btrfs volume identified by UUID and constructs the operation that performs it.*/
+func NewDeviceRemoveOperation(UUID dtos.UUIDType, paths []string) (*DeviceRemoveOperation, error) {
+	vol, err := findBtrfsVolume(UUID)
+	if err != nil {
+		return nil, err
+	}
+	err = ValidateDeviceRemove(vol, paths)
+	if err != nil {
+		return nil, err
+	}
+	mountPath, err := GetBtrfsRootMount(vol)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DeviceRemoveOperation{mountPath: mountPath, paths: paths}
+	usage, err := ProbeVolumeUsage(mountPath)
+	if err == nil {
+		d.bytesToRelocate = allocatedOnDevices(usage, paths)
+		d.bytesRemaining = d.bytesToRelocate
+	}
+	return d, nil
+}
+
+//allocatedOnDevices sums up the bytes allocated to block groups on the devices
+func allocatedOnDevices(usage dtos.BtrfsVolumeUsage, paths []string) (allocated uint64) {
+	for _, bg := range usage.BlockGroups {
+		for _, dev := range bg.Devices {
+			for _, path := range paths {
+				if dev.Path == path {
+					allocated += dev.Bytes
+				}
+			}
+		}
+	}
+	return
+}
+
+//Run removes the devices and blocks until their data is relocated
+func (d *DeviceRemoveOperation) Run() error {
+	options := append([]string{"device", "remove"}, d.paths...)
+	_, err := runBtrfsCommand(append(options, d.mountPath)...)
+	if err != nil {
+		return err
+	}
+	d.progressMtx.Lock()
+	d.bytesRemaining = 0
+	d.progressMtx.Unlock()
+	return BlockDeviceCache.Rescan()
+}
+
+/*Progress returns the current dtos.DeviceRemoveProgress of the removal, based
+on the bytes still allocated on the removed devices.*/
+func (d *DeviceRemoveOperation) Progress() (interface{}, error) {
+	d.progressMtx.Lock()
+	defer d.progressMtx.Unlock()
+	if d.bytesRemaining > 0 {
+		usage, err := ProbeVolumeUsage(d.mountPath)
+		if err != nil {
+			return nil, err
+		}
+		d.bytesRemaining = allocatedOnDevices(usage, d.paths)
+	}
+	return dtos.DeviceRemoveProgress{
+		BytesToRelocate: d.bytesToRelocate,
+		BytesRemaining:  d.bytesRemaining,
+	}, nil
+}
+
+/*ProbeDeviceSizes retrieves the size of every device of the btrfs volume mounted
+at mountPath. The returned map is keyed by devid.*/
+func ProbeDeviceSizes(mountPath string) (map[uint64]dtos.BtrfsDeviceSize, error) {
+	output, err := runBtrfsCommand("device", "usage", "-b", mountPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseDeviceUsage(output)
+}
+
+/*parseDeviceUsage parses the output of btrfs device usage -b, for example:
+/dev/sdb, ID: 1
+   Device size:           10737418240
+   Device slack:                    0
+   Data,single:            1073741824
+   Unallocated:            9663676416*/
+func parseDeviceUsage(output string) (map[uint64]dtos.BtrfsDeviceSize, error) {
+	sizes := make(map[uint64]dtos.BtrfsDeviceSize)
+	var current *dtos.BtrfsDeviceSize
+	for _, line := range strings.Split(output, "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		if match := deviceUsageHeaderMatcher.FindStringSubmatch(line); match != nil {
+			if current != nil {
+				sizes[current.DevID] = *current
+			}
+			current = &dtos.BtrfsDeviceSize{Path: match[1]}
+			current.DevID, _ = strconv.ParseUint(match[2], 10, 64)
+			continue
+		}
+		match := deviceUsageLineMatcher.FindStringSubmatch(line)
+		if match == nil || current == nil {
+			return nil, errors.New("Unexpected device usage line: " + line)
+		}
+		value, _ := strconv.ParseUint(match[2], 10, 64)
+		switch match[1] {
+		case "Device size":
+			current.Size = value
+		case "Device slack":
+			current.Slack = value
+		case "Unallocated":
+		default:
+			current.Allocated += value
+		}
+	}
+	if current != nil {
+		sizes[current.DevID] = *current
+	}
+	return sizes, nil
+}
+
+/*ValidateDeviceResize checks whether the device can be resized to newSize bytes.
+A device cannot shrink below the bytes allocated to chunks on it.*/
+func ValidateDeviceResize(device dtos.BtrfsDeviceSize, newSize uint64) error {
+	if newSize >= device.Allocated {
+		return nil
+	}
+	return newRefusalError(ErrCodeDeviceResizeRefused, "Device resize refused", []dtos.ErrorCause{{
+		Code:      CauseShrinkBelowUsed,
+		Message:   "The device " + device.Path + " cannot shrink below its allocated bytes",
+		Required:  device.Allocated,
+		Available: newSize,
+	}})
+}
+
+/*DeviceResizeOperation grows or shrinks a device of a btrfs volume. It
+satisfies the tasks.Operation interface, the resize can be neither paused nor
+cancelled.*/
+type DeviceResizeOperation struct {
+	mountPath string
+	devID     uint64
+	sizeArg   string
+
+	progressMtx sync.Mutex
+	progress    dtos.DeviceResizeProgress
+}
+
+/*NewDeviceResizeOperation validates the resize of the device with the devid of
+the btrfs volume identified by UUID to size bytes, or to the size of the block
+device if max is set, and constructs the operation that performs it.*/
+func NewDeviceResizeOperation(UUID dtos.UUIDType, devID uint64, size uint64, max bool) (*DeviceResizeOperation, error) {
+	mountPath, err := GetBtrfsRootMount(dtos.BtrfsVolume{UUID: UUID})
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := ProbeDeviceSizes(mountPath)
+	if err != nil {
+		return nil, err
+	}
+	before, ok := sizes[devID]
+	if !ok {
+		return nil, newRefusalError(ErrCodeDeviceResizeRefused, "Device resize refused", []dtos.ErrorCause{{
+			Code:    CauseDevIDNotFound,
+			Message: "No device with devid " + strconv.FormatUint(devID, 10) + " in the volume",
+		}})
+	}
+
+	sizeArg := "max"
+	if !max {
+		err = ValidateDeviceResize(before, size)
+		if err != nil {
+			return nil, err
+		}
+		sizeArg = strconv.FormatUint(size, 10)
+	}
+	return &DeviceResizeOperation{
+		mountPath: mountPath,
+		devID:     devID,
+		sizeArg:   sizeArg,
+		progress:  dtos.DeviceResizeProgress{Before: before},
+	}, nil
+}
+
+//Run resizes the device and records its size after the resize
+func (d *DeviceResizeOperation) Run() error {
+	_, err := runBtrfsCommand("filesystem", "resize",
+		strconv.FormatUint(d.devID, 10)+":"+d.sizeArg, d.mountPath)
+	if err != nil {
+		return err
+	}
+	sizes, err := ProbeDeviceSizes(d.mountPath)
+	if err != nil {
+		return err
+	}
+	d.progressMtx.Lock()
+	d.progress.After = sizes[d.devID]
+	d.progressMtx.Unlock()
+	return nil
+}
+
+/*Progress returns the dtos.DeviceResizeProgress of the resize, the size after
+the resize is only known once it is finished.*/
+func (d *DeviceResizeOperation) Progress() (interface{}, error) {
+	d.progressMtx.Lock()
+	defer d.progressMtx.Unlock()
+	return d.progress, nil
+}
@@ -0,0 +1,218 @@
+package osinterface
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDeviceStats(t *testing.T) {
+	output := "[/dev/sdb].write_io_errs    0\n" +
+		"[/dev/sdb].read_io_errs     3\n" +
+		"[/dev/sdb].flush_io_errs    0\n" +
+		"[/dev/sdb].corruption_errs  7\n" +
+		"[/dev/sdb].generation_errs  0\n" +
+		"[/dev/sdc].write_io_errs    1\n" +
+		"[/dev/sdc].read_io_errs     0\n" +
+		"[/dev/sdc].flush_io_errs    2\n" +
+		"[/dev/sdc].corruption_errs  0\n" +
+		"[/dev/sdc].generation_errs  4\n"
+
+	stats, err := parseDeviceStats(output)
+	assert.NoError(t, err)
+	assert.Len(t, stats, 2)
+	assert.EqualValues(t, 3, stats["/dev/sdb"].ReadIOErrs)
+	assert.EqualValues(t, 7, stats["/dev/sdb"].CorruptionErrs)
+	assert.EqualValues(t, 1, stats["/dev/sdc"].WriteIOErrs)
+	assert.EqualValues(t, 2, stats["/dev/sdc"].FlushIOErrs)
+	assert.EqualValues(t, 4, stats["/dev/sdc"].GenerationErrs)
+}
+
+func TestListDeviceStats(t *testing.T) {
+	oldRunBtrfsCommand := runBtrfsCommand
+	defer func() { runBtrfsCommand = oldRunBtrfsCommand }()
+	runBtrfsCommand = func(options ...string) (string, error) {
+		if options[0] == "device" && options[1] == "usage" {
+			return "/dev/sdc, ID: 1\n   Device size: 100\n" +
+				"/dev/sdd, ID: 2\n   Device size: 100\n" +
+				"/dev/sdb, ID: 3\n   Device size: 100\n", nil
+		}
+		return "[/dev/sdb].read_io_errs 3\n[/dev/sdd].read_io_errs 2\n[/dev/sdc].read_io_errs 1\n", nil
+	}
+
+	for i := 0; i < 5; i++ {
+		blockDevs, err := ListDeviceStats("/mnt/vol", false)
+		assert.NoError(t, err)
+		var paths []string
+		for _, blockDev := range blockDevs {
+			paths = append(paths, blockDev.Path)
+		}
+		assert.EqualValues(t, []string{"/dev/sdc", "/dev/sdd", "/dev/sdb"}, paths)
+		assert.EqualValues(t, 1, blockDevs[0].Stats.ReadIOErrs)
+	}
+}
+
+func TestParseDeviceStatsMalformed(t *testing.T) {
+	_, err := parseDeviceStats("ERROR: not a btrfs filesystem\n")
+	assert.Error(t, err)
+}
+
+//addSysBlockDevice adds a device of 1 GiB to the fake sysfs
+func addSysBlockDevice(t *testing.T, dir string, name string) {
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, name, "holders"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name, "size"), []byte("2097152\n"), 0644))
+}
+
+//setupDeviceCaches fills the caches and a fake sysfs, the returned function restores them
+func setupDeviceCaches(t *testing.T, blockDevs []dtos.BlockDevice, mountedPaths ...string) func() {
+	BlockDeviceCache.blockDevsByKIdent = make(map[string]*dtos.BlockDevice)
+	for i := range blockDevs {
+		BlockDeviceCache.blockDevsByKIdent[blockDevs[i].Path] = &blockDevs[i]
+	}
+	MountPointCache.mountPointByIdent = make(map[string][]*dtos.MountPoint)
+	for _, path := range mountedPaths {
+		MountPointCache.mountPointByIdent[path] = []*dtos.MountPoint{{Identifier: path}}
+	}
+
+	dir, err := ioutil.TempDir("", "sysblock")
+	assert.NoError(t, err)
+	for _, bd := range blockDevs {
+		addSysBlockDevice(t, dir, filepath.Base(bd.Path))
+	}
+	oldSysBlockPath := sysBlockPath
+	sysBlockPath = dir
+	return func() {
+		sysBlockPath = oldSysBlockPath
+		os.RemoveAll(dir)
+		BlockDeviceCache.blockDevsByKIdent = make(map[string]*dtos.BlockDevice)
+		MountPointCache.mountPointByIdent = make(map[string][]*dtos.MountPoint)
+	}
+}
+
+func TestValidateDeviceAdd(t *testing.T) {
+	cleanup := setupDeviceCaches(t, []dtos.BlockDevice{
+		{Path: "/dev/sdb", UUID: "vol", Type: "btrfs"},
+		{Path: "/dev/sdc"},
+		{Path: "/dev/sdd", UUID: "other", Type: "ext4"},
+		{Path: "/dev/sde"},
+	}, "/dev/sdd")
+	defer cleanup()
+	assert.NoError(t, os.MkdirAll(filepath.Join(sysBlockPath, "sde", "sde1"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sysBlockPath, "sde", "sde1", "partition"), []byte("1\n"), 0644))
+	vol := dtos.BtrfsVolume{UUID: "vol"}
+
+	assert.NoError(t, ValidateDeviceAdd(vol, []string{"/dev/sdc"}, false))
+
+	err := ValidateDeviceAdd(vol, []string{"/dev/sdd", "/dev/sde", "/dev/sdf"}, false)
+	assert.Error(t, err)
+	assert.EqualValues(t, ErrCodeDeviceAddRefused, err.(dtos.Error).Code)
+	assert.EqualValues(t, []string{CauseDeviceMounted, CauseDeviceHasFilesystem,
+		CauseDeviceHasPartitions, CauseDeviceNotFound}, causeCodes(err))
+
+	err = ValidateDeviceAdd(vol, []string{"/dev/sdb", "/dev/sdd", "/dev/sde"}, true)
+	assert.EqualValues(t, []string{CauseDeviceInVolume}, causeCodes(err))
+
+	//a blank disk has no signature for blkid and is not cached
+	addSysBlockDevice(t, sysBlockPath, "sdf")
+	assert.NoError(t, ValidateDeviceAdd(vol, []string{"/dev/sdf"}, false))
+	assert.NoError(t, ValidateDeviceAdd(vol, []string{"/dev/sdf"}, true))
+}
+
+func TestUnusedBlockDevices(t *testing.T) {
+	cleanup := setupDeviceCaches(t, []dtos.BlockDevice{
+		{Path: "/dev/sdb", UUID: "vol", Type: "btrfs"},
+		{Path: "/dev/sdc"},
+		{Path: "/dev/sdd"},
+	}, "/dev/sdd")
+	defer cleanup()
+	//blank and not cached
+	addSysBlockDevice(t, sysBlockPath, "sde")
+	//partitioned
+	addSysBlockDevice(t, sysBlockPath, "sdf")
+	assert.NoError(t, os.MkdirAll(filepath.Join(sysBlockPath, "sdf", "sdf1"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sysBlockPath, "sdf", "sdf1", "partition"), []byte("1\n"), 0644))
+	addSysBlockDevice(t, sysBlockPath, "sdf1")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sysBlockPath, "sdf1", "partition"), []byte("1\n"), 0644))
+	//used by a device mapper device
+	addSysBlockDevice(t, sysBlockPath, "sdg")
+	assert.NoError(t, os.MkdirAll(filepath.Join(sysBlockPath, "sdg", "holders", "dm-0"), 0755))
+	//empty
+	assert.NoError(t, os.MkdirAll(filepath.Join(sysBlockPath, "loop0"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(sysBlockPath, "loop0", "size"), []byte("0\n"), 0644))
+
+	var paths []string
+	for _, bd := range UnusedBlockDevices() {
+		paths = append(paths, bd.Path)
+	}
+	assert.EqualValues(t, []string{"/dev/sdc", "/dev/sde"}, paths)
+}
+
+func TestValidateDeviceRemove(t *testing.T) {
+	vol := dtos.BtrfsVolume{Devices: []*dtos.BlockDevice{{Path: "/dev/sdb"}, {Path: "/dev/sdc"}}}
+
+	assert.NoError(t, ValidateDeviceRemove(vol, []string{"/dev/sdc"}))
+	assert.NoError(t, ValidateDeviceRemove(vol, []string{"missing"}))
+
+	err := ValidateDeviceRemove(vol, []string{"/dev/sdd"})
+	assert.EqualValues(t, []string{CauseDeviceNotInVolume}, causeCodes(err))
+
+	err = ValidateDeviceRemove(vol, []string{"/dev/sdb", "/dev/sdc"})
+	assert.EqualValues(t, ErrCodeDeviceRemoveRefused, err.(dtos.Error).Code)
+	assert.EqualValues(t, []string{CauseNoDeviceLeft}, causeCodes(err))
+
+	empty := dtos.BtrfsVolume{}
+	err = ValidateDeviceRemove(empty, []string{"missing"})
+	assert.EqualValues(t, []string{CauseNoDevicesKnown}, causeCodes(err))
+	err = ValidateDeviceRemove(empty, []string{"/dev/sdb"})
+	assert.EqualValues(t, []string{CauseNoDevicesKnown}, causeCodes(err))
+}
+
+func TestAllocatedOnDevices(t *testing.T) {
+	usage := dtos.BtrfsVolumeUsage{BlockGroups: []dtos.BtrfsBlockGroupUsage{
+		{Devices: []dtos.BtrfsDeviceSpace{{Path: "/dev/sdb", Bytes: 100}, {Path: "/dev/sdc", Bytes: 50}}},
+		{Devices: []dtos.BtrfsDeviceSpace{{Path: "/dev/sdc", Bytes: 20}}},
+	}}
+	assert.EqualValues(t, 70, allocatedOnDevices(usage, []string{"/dev/sdc"}))
+	assert.EqualValues(t, 170, allocatedOnDevices(usage, []string{"/dev/sdb", "/dev/sdc"}))
+}
+
+func TestParseDeviceUsage(t *testing.T) {
+	output := "/dev/sdb, ID: 1\n" +
+		"   Device size:           10737418240\n" +
+		"   Device slack:                    0\n" +
+		"   Data,RAID1:             2147483648\n" +
+		"   Metadata,RAID1:          268435456\n" +
+		"   System,RAID1:              8388608\n" +
+		"   Unallocated:            8313110528\n" +
+		"\n" +
+		"/dev/sdc, ID: 2\n" +
+		"   Device size:           21474836480\n" +
+		"   Device slack:           1073741824\n" +
+		"   Data,RAID1:             2147483648\n" +
+		"   Unallocated:           18253611008\n"
+
+	sizes, err := parseDeviceUsage(output)
+	assert.NoError(t, err)
+	assert.Len(t, sizes, 2)
+	assert.EqualValues(t, dtos.BtrfsDeviceSize{DevID: 1, Path: "/dev/sdb", Size: 10737418240,
+		Allocated: 2147483648 + 268435456 + 8388608}, sizes[1])
+	assert.EqualValues(t, 1073741824, sizes[2].Slack)
+	assert.EqualValues(t, "/dev/sdc", sizes[2].Path)
+
+	_, err = parseDeviceUsage("   Device size: 10\n")
+	assert.Error(t, err)
+}
+
+func TestValidateDeviceResize(t *testing.T) {
+	device := dtos.BtrfsDeviceSize{DevID: 1, Path: "/dev/sdb", Size: 100, Allocated: 40}
+	assert.NoError(t, ValidateDeviceResize(device, 200))
+	assert.NoError(t, ValidateDeviceResize(device, 40))
+
+	err := ValidateDeviceResize(device, 39)
+	assert.EqualValues(t, ErrCodeDeviceResizeRefused, err.(dtos.Error).Code)
+	assert.EqualValues(t, []string{CauseShrinkBelowUsed}, causeCodes(err))
+}
@@ -0,0 +1,348 @@
+package osinterface
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+)
+
+//Values mirror the definitions in linux/btrfs.h and linux/btrfs_tree.h
+const (
+	btrfsIoctlMagic       = 0x94
+	btrfsSearchArgsSize   = 4096
+	btrfsSearchKeySize    = 104
+	btrfsSearchHeaderSize = 32
+	btrfsInoLookupPathMax = 4080
+	btrfsSearchMaxItems   = 4096
+
+	btrfsRootTreeObjectID  = 1
+	btrfsFSTreeObjectID    = 5
+	btrfsFirstFreeObjectID = 256
+	btrfsLastFreeObjectID  = math.MaxUint64 - 255
+
+	btrfsRootItemKey    = 132
+	btrfsRootBackrefKey = 144
+
+	btrfsRootSubvolRdonly = 1 << 0
+)
+
+//Offsets of the btrfs_root_item fields, the structure is packed on disk
+const (
+	rootItemGenerationOffset   = 160
+	rootItemFlagsOffset        = 208
+	rootItemUUIDOffset         = 247
+	rootItemParentUUIDOffset   = 263
+	rootItemReceivedUUIDOffset = 279
+	rootItemOTimeOffset        = 339
+	rootItemSize               = 439
+
+	rootRefDirIDOffset   = 0
+	rootRefNameLenOffset = 16
+	rootRefNameOffset    = 18
+
+	btrfsUUIDSize = 16
+)
+
+func btrfsIOWR(nr uintptr, size uintptr) uintptr {
+	return 3<<30 | size<<16 | btrfsIoctlMagic<<8 | nr
+}
+
+var (
+	btrfsIocTreeSearch = btrfsIOWR(17, btrfsSearchArgsSize)
+	btrfsIocInoLookup  = btrfsIOWR(18, btrfsSearchArgsSize)
+)
+
+type btrfsIoctlSearchKey struct {
+	treeID      uint64
+	minObjectID uint64
+	maxObjectID uint64
+	minOffset   uint64
+	maxOffset   uint64
+	minTransID  uint64
+	maxTransID  uint64
+	minType     uint32
+	maxType     uint32
+	nrItems     uint32
+	unused      uint32
+	unused1     uint64
+	unused2     uint64
+	unused3     uint64
+	unused4     uint64
+}
+
+type btrfsIoctlSearchArgs struct {
+	key btrfsIoctlSearchKey
+	buf [btrfsSearchArgsSize - btrfsSearchKeySize]byte
+}
+
+type btrfsIoctlSearchHeader struct {
+	transID  uint64
+	objectID uint64
+	offset   uint64
+	itemType uint32
+	length   uint32
+}
+
+type btrfsIoctlInoLookupArgs struct {
+	treeID   uint64
+	objectID uint64
+	name     [btrfsInoLookupPathMax]byte
+}
+
+//btrfsTreeItem is a single item returned by a tree search. The data is in the
+//on-disk (little endian) format.
+type btrfsTreeItem struct {
+	objectID uint64
+	itemType uint32
+	offset   uint64
+	data     []byte
+}
+
+func btrfsIoctl(f *os.File, request uintptr, args unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), request, uintptr(args))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+/*btrfsTreeSearch calls the visitor for every item in the range described by
+the search key. The ioctl returns the items in batches, the key is advanced past
+the last returned item until the kernel reports no more items.*/
+func btrfsTreeSearch(f *os.File, key btrfsIoctlSearchKey, visit func(btrfsTreeItem)) error {
+	args := &btrfsIoctlSearchArgs{key: key}
+	for {
+		args.key.nrItems = btrfsSearchMaxItems
+		err := btrfsIoctl(f, btrfsIocTreeSearch, unsafe.Pointer(args))
+		if err != nil {
+			return err
+		}
+		if args.key.nrItems == 0 {
+			return nil
+		}
+
+		var header btrfsIoctlSearchHeader
+		offset := 0
+		for i := uint32(0); i < args.key.nrItems; i++ {
+			//The search header is in the native byte order
+			header = *(*btrfsIoctlSearchHeader)(unsafe.Pointer(&args.buf[offset]))
+			offset += btrfsSearchHeaderSize
+			data := make([]byte, header.length)
+			copy(data, args.buf[offset:offset+int(header.length)])
+			offset += int(header.length)
+
+			visit(btrfsTreeItem{
+				objectID: header.objectID,
+				itemType: header.itemType,
+				offset:   header.offset,
+				data:     data,
+			})
+		}
+
+		if !advanceSearchKey(&args.key, header) {
+			return nil
+		}
+	}
+}
+
+//advanceSearchKey moves the minimal key right past the given item.
+func advanceSearchKey(key *btrfsIoctlSearchKey, last btrfsIoctlSearchHeader) bool {
+	key.minObjectID = last.objectID
+	key.minType = last.itemType
+	key.minOffset = last.offset
+	switch {
+	case key.minOffset < math.MaxUint64:
+		key.minOffset++
+	case key.minType < math.MaxUint8:
+		key.minType++
+		key.minOffset = 0
+	case key.minObjectID < key.maxObjectID:
+		key.minObjectID++
+		key.minType = 0
+		key.minOffset = 0
+	default:
+		return false
+	}
+	return key.minObjectID <= key.maxObjectID
+}
+
+//btrfsInoLookup resolves the path of a directory relative to the root of its subvolume.
+func btrfsInoLookup(f *os.File, treeID uint64, objectID uint64) (string, error) {
+	args := &btrfsIoctlInoLookupArgs{treeID: treeID, objectID: objectID}
+	err := btrfsIoctl(f, btrfsIocInoLookup, unsafe.Pointer(args))
+	if err != nil {
+		return "", err
+	}
+	return cStringToString(args.name[:]), nil
+}
+
+func cStringToString(buf []byte) string {
+	for i, b := range buf {
+		if b == 0 {
+			return string(buf[:i])
+		}
+	}
+	return string(buf)
+}
+
+func formatUUID(b []byte) dtos.UUIDType {
+	empty := true
+	for _, v := range b {
+		if v != 0 {
+			empty = false
+			break
+		}
+	}
+	if empty {
+		return ""
+	}
+	return dtos.UUIDType(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]))
+}
+
+/*parseRootItem fills the subvolume with the data stored in a btrfs_root_item.
+Root items created by old kernels lack the UUID and time fields, these are left
+empty.*/
+func parseRootItem(data []byte, subvol *dtos.BtrfsSubVolume) {
+	le := binary.LittleEndian
+	if len(data) < rootItemFlagsOffset+8 {
+		return
+	}
+	subvol.Generation = le.Uint64(data[rootItemGenerationOffset:])
+	subvol.Flags = le.Uint64(data[rootItemFlagsOffset:])
+	subvol.ReadOnly = subvol.Flags&btrfsRootSubvolRdonly != 0
+
+	if len(data) < rootItemSize {
+		return
+	}
+	subvol.UUID = formatUUID(data[rootItemUUIDOffset : rootItemUUIDOffset+btrfsUUIDSize])
+	subvol.ParentUUID = formatUUID(data[rootItemParentUUIDOffset : rootItemParentUUIDOffset+btrfsUUIDSize])
+	subvol.ReceivedUUID = formatUUID(data[rootItemReceivedUUIDOffset : rootItemReceivedUUIDOffset+btrfsUUIDSize])
+	sec := le.Uint64(data[rootItemOTimeOffset:])
+	nsec := le.Uint32(data[rootItemOTimeOffset+8:])
+	if sec != 0 {
+		subvol.CreationTime = time.Unix(int64(sec), int64(nsec))
+	}
+}
+
+//rootRef is the parsed btrfs_root_ref stored under a ROOT_BACKREF key
+type rootRef struct {
+	parentTreeID uint64
+	dirID        uint64
+	name         string
+}
+
+func parseRootRef(parentTreeID uint64, data []byte) (ref rootRef, ok bool) {
+	le := binary.LittleEndian
+	if len(data) < rootRefNameOffset {
+		return
+	}
+	nameLen := int(le.Uint16(data[rootRefNameLenOffset:]))
+	if len(data) < rootRefNameOffset+nameLen {
+		return
+	}
+	ref = rootRef{
+		parentTreeID: parentTreeID,
+		dirID:        le.Uint64(data[rootRefDirIDOffset:]),
+		name:         string(data[rootRefNameOffset : rootRefNameOffset+nameLen]),
+	}
+	return ref, true
+}
+
+/*probeSubVolumesIoctl enumerates subvolumes by searching the root tree of the
+volume mounted at mountPath. It requires CAP_SYS_ADMIN.*/
+func probeSubVolumesIoctl(mountPath string) ([]dtos.BtrfsSubVolume, error) {
+	f, err := os.Open(mountPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	subvolsByID := make(map[uint64]*dtos.BtrfsSubVolume)
+	refs := make(map[uint64]rootRef)
+	var ids []uint64
+
+	key := btrfsIoctlSearchKey{
+		treeID:      btrfsRootTreeObjectID,
+		minObjectID: btrfsFirstFreeObjectID,
+		maxObjectID: btrfsLastFreeObjectID,
+		maxOffset:   math.MaxUint64,
+		maxTransID:  math.MaxUint64,
+		minType:     btrfsRootItemKey,
+		maxType:     btrfsRootBackrefKey,
+	}
+	err = btrfsTreeSearch(f, key, func(item btrfsTreeItem) {
+		switch item.itemType {
+		case btrfsRootItemKey:
+			subvol, found := subvolsByID[item.objectID]
+			if !found {
+				subvol = &dtos.BtrfsSubVolume{SubVolID: int(item.objectID)}
+				subvolsByID[item.objectID] = subvol
+				ids = append(ids, item.objectID)
+			}
+			parseRootItem(item.data, subvol)
+		case btrfsRootBackrefKey:
+			ref, ok := parseRootRef(item.offset, item.data)
+			if ok {
+				refs[item.objectID] = ref
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	paths := map[uint64]string{btrfsFSTreeObjectID: ""}
+	var resolvePath func(id uint64, depth int) (string, error)
+	resolvePath = func(id uint64, depth int) (string, error) {
+		if path, ok := paths[id]; ok {
+			return path, nil
+		}
+		ref, ok := refs[id]
+		if !ok || depth > len(refs) {
+			return "", fmt.Errorf("unable to resolve path of subvolume %d", id)
+		}
+		parentPath, err := resolvePath(ref.parentTreeID, depth+1)
+		if err != nil {
+			return "", err
+		}
+		dirPath, err := btrfsInoLookup(f, ref.parentTreeID, ref.dirID)
+		if err != nil {
+			return "", err
+		}
+		//The looked up directory path is either empty or ends with a slash
+		path := joinSubvolPath(parentPath, dirPath+ref.name)
+		paths[id] = path
+		return path, nil
+	}
+
+	var subvols []dtos.BtrfsSubVolume
+	for _, id := range ids {
+		ref, ok := refs[id]
+		if !ok {
+			//A root item without a back reference belongs to a deleted
+			//subvolume which has not been cleaned up yet.
+			continue
+		}
+		subvol := subvolsByID[id]
+		subvol.ParentID = int(ref.parentTreeID)
+		subvol.RelativePath, err = resolvePath(id, 0)
+		if err != nil {
+			return nil, err
+		}
+		subvols = append(subvols, *subvol)
+	}
+	return subvols, nil
+}
+
+func joinSubvolPath(parent string, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "/" + name
+}
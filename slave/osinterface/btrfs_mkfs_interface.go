@@ -0,0 +1,296 @@
+package osinterface
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+)
+
+const (
+	mkfsBtrfsCmd = "mkfs.btrfs"
+	wipefsCmd    = "wipefs"
+
+	//createPlanTTL is the time a volume creation plan can be confirmed in
+	createPlanTTL = 5 * time.Minute
+)
+
+//ErrCodeVolumeCreateRefused is the dtos.Error code of a refused volume creation
+const ErrCodeVolumeCreateRefused = "volume_create_refused"
+
+//dtos.ErrorCause codes of a refused volume creation
+const (
+	CauseNoDevices         = "no_devices"
+	CausePartitionMounted  = "partition_mounted"
+	CauseInvalidNodeSize   = "invalid_node_size"
+	CauseInvalidFeature    = "invalid_feature"
+	CauseInvalidToken      = "invalid_token"
+	CauseSignaturesChanged = "signatures_changed"
+)
+
+var featureMatcher = regexp.MustCompile(`^\^?[a-z0-9_-]+$`)
+
+type createPlanStore struct {
+	mtx   sync.Mutex
+	plans map[string]dtos.BtrfsVolumeCreatePlan
+}
+
+//createPlans contains the plans that were not yet confirmed
+var createPlans = createPlanStore{plans: make(map[string]dtos.BtrfsVolumeCreatePlan)}
+
+func (c *createPlanStore) add(plan dtos.BtrfsVolumeCreatePlan) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	now := time.Now()
+	for token, stored := range c.plans {
+		if now.After(stored.ExpiresAt) {
+			delete(c.plans, token)
+		}
+	}
+	c.plans[plan.Token] = plan
+}
+
+/*take removes and returns the plan with the token, if it has not expired. Every
+token can be used only once.*/
+func (c *createPlanStore) take(token string) (dtos.BtrfsVolumeCreatePlan, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	plan, ok := c.plans[token]
+	delete(c.plans, token)
+	if !ok || time.Now().After(plan.ExpiresAt) {
+		return dtos.BtrfsVolumeCreatePlan{}, false
+	}
+	return plan, true
+}
+
+func newPlanToken() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+/*parseWipefsOutput parses the output of wipefs --parsable, for example:
+0x438,07f4e569-0323-49be-ab2f-96927e07cfbc,,ext4*/
+func parseWipefsOutput(path string, output string) ([]dtos.WipedSignature, error) {
+	var signatures []dtos.WipedSignature
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 4 {
+			return nil, errors.New("Unexpected wipefs line: " + line)
+		}
+		signatures = append(signatures, dtos.WipedSignature{
+			Path:   path,
+			Offset: fields[0],
+			UUID:   dtos.UUIDType(fields[1]),
+			Label:  strings.Join(fields[2:len(fields)-1], ","),
+			Type:   fields[len(fields)-1],
+		})
+	}
+	return signatures, nil
+}
+
+//probeSignatures lists the signatures present on the devices, nothing is wiped
+func probeSignatures(paths []string) ([]dtos.WipedSignature, error) {
+	var signatures []dtos.WipedSignature
+	for _, path := range paths {
+		output, err := runCommand(wipefsCmd, "--parsable", path)
+		if err != nil {
+			return nil, err
+		}
+		found, err := parseWipefsOutput(path, output)
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, found...)
+	}
+	return signatures, nil
+}
+
+/*ValidateVolumeCreate checks whether a volume can be created as specified. The
+devices have to exist, blank ones included, and neither they nor their
+partitions may be mounted.
+The returned error is a dtos.Error listing every reason the creation is refused.*/
+func ValidateVolumeCreate(spec dtos.BtrfsVolumeCreateSpec) error {
+	var causes []dtos.ErrorCause
+	if len(spec.Paths) == 0 {
+		causes = append(causes, dtos.ErrorCause{
+			Code:    CauseNoDevices,
+			Message: "No devices were given",
+		})
+	}
+	for _, path := range spec.Paths {
+		if !blockDeviceExists(path) {
+			causes = append(causes, dtos.ErrorCause{
+				Code:    CauseDeviceNotFound,
+				Message: "Block device not found: " + path,
+			})
+			continue
+		}
+		if _, mounted := MountPointCache.FindByKernelIdentifier(path); mounted {
+			causes = append(causes, dtos.ErrorCause{
+				Code:    CauseDeviceMounted,
+				Message: "The device " + path + " is mounted",
+			})
+		}
+		partitions, _ := devicePartitions(path)
+		for _, partition := range partitions {
+			partitionPath := filepath.Join(filepath.Dir(path), partition)
+			if _, mounted := MountPointCache.FindByKernelIdentifier(partitionPath); mounted {
+				causes = append(causes, dtos.ErrorCause{
+					Code:    CausePartitionMounted,
+					Message: "The partition " + partitionPath + " of the device " + path + " is mounted",
+				})
+			}
+		}
+	}
+
+	for _, profile := range []string{spec.DataProfile, spec.MetadataProfile} {
+		if profile == "" {
+			continue
+		}
+		raid, ok := raidProfiles[strings.ToLower(profile)]
+		if !ok {
+			causes = append(causes, dtos.ErrorCause{
+				Code:    CauseUnknownProfile,
+				Message: "Unknown profile: " + profile,
+			})
+		} else if len(spec.Paths) < raid.minDevices {
+			causes = append(causes, dtos.ErrorCause{
+				Code:      CauseNotEnoughDevices,
+				Message:   "The profile " + profile + " requires at least " + strconv.Itoa(raid.minDevices) + " devices",
+				Required:  uint64(raid.minDevices),
+				Available: uint64(len(spec.Paths)),
+			})
+		}
+	}
+
+	if n := spec.NodeSize; n != 0 && (n < 4096 || n > 65536 || n&(n-1) != 0) {
+		causes = append(causes, dtos.ErrorCause{
+			Code:    CauseInvalidNodeSize,
+			Message: "The node size has to be a power of 2 between 4096 and 65536",
+		})
+	}
+	for _, feature := range spec.Features {
+		if !featureMatcher.MatchString(feature) {
+			causes = append(causes, dtos.ErrorCause{
+				Code:    CauseInvalidFeature,
+				Message: "Invalid feature: " + feature,
+			})
+		}
+	}
+
+	if len(causes) == 0 {
+		return nil
+	}
+	return newRefusalError(ErrCodeVolumeCreateRefused, "Volume creation refused", causes)
+}
+
+/*PlanVolumeCreate validates the specification and lists the signatures that
+the creation of the volume will wipe. The returned plan's token confirms the
+creation in CreateVolume.*/
+func PlanVolumeCreate(spec dtos.BtrfsVolumeCreateSpec) (plan dtos.BtrfsVolumeCreatePlan, err error) {
+	err = ValidateVolumeCreate(spec)
+	if err != nil {
+		return
+	}
+	plan.Spec = spec
+	plan.Signatures, err = probeSignatures(spec.Paths)
+	if err != nil {
+		return
+	}
+	plan.Token, err = newPlanToken()
+	if err != nil {
+		return
+	}
+	plan.ExpiresAt = time.Now().Add(createPlanTTL)
+	createPlans.add(plan)
+	return
+}
+
+//mkfsOptions builds the command line options of mkfs.btrfs
+func mkfsOptions(spec dtos.BtrfsVolumeCreateSpec) []string {
+	options := []string{"-f"}
+	if spec.Label != "" {
+		options = append(options, "-L", spec.Label)
+	}
+	if spec.DataProfile != "" {
+		options = append(options, "-d", strings.ToLower(spec.DataProfile))
+	}
+	if spec.MetadataProfile != "" {
+		options = append(options, "-m", strings.ToLower(spec.MetadataProfile))
+	}
+	if spec.NodeSize != 0 {
+		options = append(options, "-n", strconv.FormatUint(uint64(spec.NodeSize), 10))
+	}
+	if len(spec.Features) > 0 {
+		options = append(options, "-O", strings.Join(spec.Features, ","))
+	}
+	return append(options, spec.Paths...)
+}
+
+/*CreateVolume creates the btrfs volume of a plan made by PlanVolumeCreate. The
+spec has to match the planned one and the devices must still carry exactly the
+planned signatures, which are wiped before mkfs.btrfs runs. The block device
+cache is rescanned and the new volume is returned.*/
+func CreateVolume(spec dtos.BtrfsVolumeCreateSpec, token string) (dtos.BtrfsVolume, error) {
+	plan, ok := createPlans.take(token)
+	if !ok || !reflect.DeepEqual(plan.Spec, spec) {
+		return dtos.BtrfsVolume{}, newRefusalError(ErrCodeVolumeCreateRefused, "Volume creation refused",
+			[]dtos.ErrorCause{{
+				Code:    CauseInvalidToken,
+				Message: "The confirmation token is unknown, expired or was issued for another request",
+			}})
+	}
+	err := ValidateVolumeCreate(spec)
+	if err != nil {
+		return dtos.BtrfsVolume{}, err
+	}
+	signatures, err := probeSignatures(spec.Paths)
+	if err != nil {
+		return dtos.BtrfsVolume{}, err
+	}
+	if len(signatures) != len(plan.Signatures) ||
+		(len(signatures) > 0 && !reflect.DeepEqual(signatures, plan.Signatures)) {
+		return dtos.BtrfsVolume{}, newRefusalError(ErrCodeVolumeCreateRefused, "Volume creation refused",
+			[]dtos.ErrorCause{{
+				Code:    CauseSignaturesChanged,
+				Message: "The signatures on the devices changed since the plan was made",
+			}})
+	}
+
+	for _, path := range spec.Paths {
+		_, err = runCommand(wipefsCmd, "--all", path)
+		if err != nil {
+			return dtos.BtrfsVolume{}, err
+		}
+	}
+	_, err = runCommand(mkfsBtrfsCmd, mkfsOptions(spec)...)
+	if err != nil {
+		return dtos.BtrfsVolume{}, err
+	}
+
+	err = BlockDeviceCache.Rescan()
+	if err != nil {
+		return dtos.BtrfsVolume{}, err
+	}
+	bd, ok := BlockDeviceCache.FindByKernelIdentifier(spec.Paths[0])
+	if !ok {
+		return dtos.BtrfsVolume{}, errors.New("Created volume not found on " + spec.Paths[0])
+	}
+	return findBtrfsVolume(bd.UUID)
+}
@@ -0,0 +1,100 @@
+package osinterface
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWipefsOutput(t *testing.T) {
+	output := "# offset,uuid,label,type\n" +
+		"0x438,07f4e569-0323-49be-ab2f-96927e07cfbc,data,backup,ext4\n" +
+		"0x1fe,,,dos\n"
+	signatures, err := parseWipefsOutput("/dev/sdb", output)
+	assert.NoError(t, err)
+	assert.EqualValues(t, []dtos.WipedSignature{
+		{Path: "/dev/sdb", Offset: "0x438", UUID: "07f4e569-0323-49be-ab2f-96927e07cfbc", Label: "data,backup", Type: "ext4"},
+		{Path: "/dev/sdb", Offset: "0x1fe", Type: "dos"},
+	}, signatures)
+
+	_, err = parseWipefsOutput("/dev/sdb", "0x438")
+	assert.Error(t, err)
+}
+
+func TestMkfsOptions(t *testing.T) {
+	options := mkfsOptions(dtos.BtrfsVolumeCreateSpec{
+		Paths:           []string{"/dev/sdb", "/dev/sdc"},
+		Label:           "data",
+		DataProfile:     "RAID1",
+		MetadataProfile: "raid1",
+		NodeSize:        16384,
+		Features:        []string{"no-holes", "^extref"},
+	})
+	assert.EqualValues(t, []string{"-f", "-L", "data", "-d", "raid1", "-m", "raid1", "-n", "16384",
+		"-O", "no-holes,^extref", "/dev/sdb", "/dev/sdc"}, options)
+	assert.EqualValues(t, []string{"-f", "/dev/sdb"}, mkfsOptions(dtos.BtrfsVolumeCreateSpec{Paths: []string{"/dev/sdb"}}))
+}
+
+func TestValidateVolumeCreate(t *testing.T) {
+	cleanup := setupDeviceCaches(t, []dtos.BlockDevice{{Path: "/dev/sdb"}, {Path: "/dev/sdc"}}, "/dev/sdc")
+	defer cleanup()
+
+	assert.NoError(t, ValidateVolumeCreate(dtos.BtrfsVolumeCreateSpec{Paths: []string{"/dev/sdb"}}))
+
+	err := ValidateVolumeCreate(dtos.BtrfsVolumeCreateSpec{
+		Paths:       []string{"/dev/sdc", "/dev/sdd"},
+		DataProfile: "raid10",
+		NodeSize:    5000,
+		Features:    []string{"no holes"},
+	})
+	assert.EqualValues(t, ErrCodeVolumeCreateRefused, err.(dtos.Error).Code)
+	assert.EqualValues(t, []string{CauseDeviceMounted, CauseDeviceNotFound, CauseNotEnoughDevices,
+		CauseInvalidNodeSize, CauseInvalidFeature}, causeCodes(err))
+
+	err = ValidateVolumeCreate(dtos.BtrfsVolumeCreateSpec{})
+	assert.EqualValues(t, []string{CauseNoDevices}, causeCodes(err))
+
+	//a fresh unformatted disk is not in the block device cache
+	addSysBlockDevice(t, sysBlockPath, "sdd")
+	assert.NoError(t, ValidateVolumeCreate(dtos.BtrfsVolumeCreateSpec{Paths: []string{"/dev/sdb", "/dev/sdd"}}))
+}
+
+func TestVolumeCreateToken(t *testing.T) {
+	cleanup := setupDeviceCaches(t, []dtos.BlockDevice{{Path: "/dev/sdb"}})
+	defer cleanup()
+	oldRunCommand := runCommand
+	defer func() { runCommand = oldRunCommand }()
+	var commands []string
+	signatures := "0x438,07f4e569-0323-49be-ab2f-96927e07cfbc,,ext4\n"
+	runCommand = func(name string, options ...string) (string, error) {
+		commands = append(commands, name+" "+strings.Join(options, " "))
+		return signatures, nil
+	}
+
+	spec := dtos.BtrfsVolumeCreateSpec{Paths: []string{"/dev/sdb"}, Label: "data"}
+	plan, err := PlanVolumeCreate(spec)
+	assert.NoError(t, err)
+	assert.Len(t, plan.Signatures, 1)
+	assert.EqualValues(t, "ext4", plan.Signatures[0].Type)
+	assert.Len(t, plan.Token, 32)
+
+	otherSpec := spec
+	otherSpec.Label = "other"
+	_, err = CreateVolume(otherSpec, plan.Token)
+	assert.EqualValues(t, []string{CauseInvalidToken}, causeCodes(err))
+	//A token can be used only once, even by a refused request
+	_, err = CreateVolume(spec, plan.Token)
+	assert.EqualValues(t, []string{CauseInvalidToken}, causeCodes(err))
+
+	plan, err = PlanVolumeCreate(spec)
+	assert.NoError(t, err)
+	signatures = "0x438,5c1e4b1b-7a2d-4f5e-9d5c-0d0e0f101112,,xfs\n"
+	_, err = CreateVolume(spec, plan.Token)
+	assert.EqualValues(t, []string{CauseSignaturesChanged}, causeCodes(err))
+	for _, command := range commands {
+		assert.False(t, strings.HasPrefix(command, mkfsBtrfsCmd), command)
+		assert.NotContains(t, command, "--all")
+	}
+}
@@ -0,0 +1,466 @@
+package osinterface
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+)
+
+//procPath is the procfs directory searched for the processes using a mount point
+var procPath = "/proc"
+
+//ErrCodeMountRefused is the dtos.Error code of a refused mount
+const ErrCodeMountRefused = "mount_refused"
+
+//ErrCodeUnmountRefused is the dtos.Error code of a refused unmount
+const ErrCodeUnmountRefused = "unmount_refused"
+
+//dtos.ErrorCause codes of a refused mount or unmount
+const (
+	CauseInvalidMountPath   = "invalid_mount_path"
+	CauseMountPathReserved  = "mount_path_reserved"
+	CauseMountPathMounted   = "mount_path_mounted"
+	CauseUnknownMountOption = "unknown_mount_option"
+	CauseInvalidMountOption = "invalid_mount_option"
+	CauseSubvolumeConflict  = "subvolume_conflict"
+	CauseNotMounted         = "not_mounted"
+	CauseNotBtrfs           = "not_btrfs"
+	CauseRootMount          = "root_mount"
+	CauseMountBusy          = "mount_busy"
+	CauseNestedMount        = "nested_mount"
+	CauseProcessUsesMount   = "process_uses_mount"
+)
+
+//mountFlag is a generic mount option, it sets or clears a flag of mount(2)
+type mountFlag struct {
+	flag  uintptr
+	clear bool
+}
+
+var mountFlags = map[string]mountFlag{
+	"defaults":    {},
+	"ro":          {flag: syscall.MS_RDONLY},
+	"rw":          {flag: syscall.MS_RDONLY, clear: true},
+	"noatime":     {flag: syscall.MS_NOATIME},
+	"atime":       {flag: syscall.MS_NOATIME, clear: true},
+	"nodiratime":  {flag: syscall.MS_NODIRATIME},
+	"diratime":    {flag: syscall.MS_NODIRATIME, clear: true},
+	"relatime":    {flag: syscall.MS_RELATIME},
+	"norelatime":  {flag: syscall.MS_RELATIME, clear: true},
+	"strictatime": {flag: syscall.MS_STRICTATIME},
+	"nosuid":      {flag: syscall.MS_NOSUID},
+	"suid":        {flag: syscall.MS_NOSUID, clear: true},
+	"nodev":       {flag: syscall.MS_NODEV},
+	"dev":         {flag: syscall.MS_NODEV, clear: true},
+	"noexec":      {flag: syscall.MS_NOEXEC},
+	"exec":        {flag: syscall.MS_NOEXEC, clear: true},
+	"sync":        {flag: syscall.MS_SYNCHRONOUS},
+	"async":       {flag: syscall.MS_SYNCHRONOUS, clear: true},
+	"dirsync":     {flag: syscall.MS_DIRSYNC},
+}
+
+//btrfsOptionValue tells whether a btrfs mount option takes a value
+type btrfsOptionValue int
+
+const (
+	noValue btrfsOptionValue = iota
+	requiredValue
+	optionalValue
+)
+
+//btrfsMountOptions lists the mount options of btrfs passed to the file system
+var btrfsMountOptions = map[string]btrfsOptionValue{
+	"subvol":                 requiredValue,
+	"subvolid":               requiredValue,
+	"device":                 requiredValue,
+	"compress":               optionalValue,
+	"compress-force":         optionalValue,
+	"space_cache":            optionalValue,
+	"nospace_cache":          noValue,
+	"clear_cache":            noValue,
+	"autodefrag":             noValue,
+	"noautodefrag":           noValue,
+	"ssd":                    noValue,
+	"nossd":                  noValue,
+	"ssd_spread":             noValue,
+	"nossd_spread":           noValue,
+	"discard":                optionalValue,
+	"nodiscard":              noValue,
+	"commit":                 requiredValue,
+	"degraded":               noValue,
+	"datacow":                noValue,
+	"nodatacow":              noValue,
+	"datasum":                noValue,
+	"nodatasum":              noValue,
+	"barrier":                noValue,
+	"nobarrier":              noValue,
+	"acl":                    noValue,
+	"noacl":                  noValue,
+	"flushoncommit":          noValue,
+	"noflushoncommit":        noValue,
+	"treelog":                noValue,
+	"notreelog":              noValue,
+	"max_inline":             requiredValue,
+	"metadata_ratio":         requiredValue,
+	"thread_pool":            requiredValue,
+	"fatal_errors":           requiredValue,
+	"skip_balance":           noValue,
+	"rescan_uuid_tree":       noValue,
+	"user_subvol_rm_allowed": noValue,
+	"enospc_debug":           noValue,
+	"noenospc_debug":         noValue,
+	"usebackuproot":          noValue,
+	"nologreplay":            noValue,
+	"norecovery":             noValue,
+}
+
+//maxCompressLevel is the highest level of the compression algorithms that take one
+var maxCompressLevel = map[string]int{
+	"zlib": 9,
+	"zstd": 15,
+	"lzo":  0,
+	"no":   0,
+	"none": 0,
+}
+
+/*checkBtrfsOptionValue checks the values of the options that take a fixed set of
+them. The values of the other options are checked by the kernel.*/
+func checkBtrfsOptionValue(name string, value string) bool {
+	switch name {
+	case "compress", "compress-force":
+		algorithm, level := value, ""
+		if i := strings.IndexByte(value, ':'); i >= 0 {
+			algorithm, level = value[:i], value[i+1:]
+		}
+		maxLevel, ok := maxCompressLevel[algorithm]
+		if !ok {
+			return false
+		}
+		if level == "" {
+			return true
+		}
+		n, err := strconv.Atoi(level)
+		return err == nil && n >= 1 && n <= maxLevel
+	case "space_cache":
+		return value == "v1" || value == "v2"
+	case "discard":
+		return value == "sync" || value == "async"
+	case "fatal_errors":
+		return value == "bug" || value == "panic"
+	case "subvolid", "commit", "max_inline", "metadata_ratio", "thread_pool":
+		_, err := strconv.ParseUint(value, 10, 64)
+		return err == nil
+	}
+	return true
+}
+
+/*parseMountOptions splits the mount options into the flags of mount(2) and the
+options passed to btrfs. An option may hold several options separated by commas.
+The returned causes list the options that are not valid.*/
+func parseMountOptions(options []string) (flags uintptr, data []string, causes []dtos.ErrorCause) {
+	for _, option := range options {
+		for _, opt := range strings.Split(option, ",") {
+			if opt == "" {
+				continue
+			}
+			if f, ok := mountFlags[opt]; ok {
+				if f.clear {
+					flags &^= f.flag
+				} else {
+					flags |= f.flag
+				}
+				continue
+			}
+
+			name, value := opt, ""
+			i := strings.IndexByte(opt, '=')
+			if i >= 0 {
+				name, value = opt[:i], opt[i+1:]
+			}
+			kind, ok := btrfsMountOptions[name]
+			if !ok {
+				causes = append(causes, dtos.ErrorCause{
+					Code:    CauseUnknownMountOption,
+					Message: "Unknown mount option: " + opt,
+				})
+				continue
+			}
+			valid := true
+			switch kind {
+			case noValue:
+				valid = i < 0
+			case requiredValue:
+				valid = value != "" && checkBtrfsOptionValue(name, value)
+			case optionalValue:
+				valid = i < 0 || (value != "" && checkBtrfsOptionValue(name, value))
+			}
+			if !valid {
+				causes = append(causes, dtos.ErrorCause{
+					Code:    CauseInvalidMountOption,
+					Message: "Invalid mount option: " + opt,
+				})
+				continue
+			}
+			data = append(data, opt)
+		}
+	}
+	return
+}
+
+//isUnder tells whether the path is the directory dir or lies inside it
+func isUnder(path string, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+/*newMountPathCauses checks that the mount path is an absolute, clean path outside
+of the directory of the btrfs root mounts.*/
+func newMountPathCauses(mountPath string) (causes []dtos.ErrorCause) {
+	if !filepath.IsAbs(mountPath) || filepath.Clean(mountPath) != mountPath || mountPath == "/" {
+		return []dtos.ErrorCause{{
+			Code:    CauseInvalidMountPath,
+			Message: "The mount path has to be a clean absolute path other than /: " + mountPath,
+		}}
+	}
+	if filepath.Dir(mountPath) == rootMountsPath {
+		causes = append(causes, dtos.ErrorCause{
+			Code:    CauseMountPathReserved,
+			Message: "The paths in " + rootMountsPath + " are reserved for the root mounts of the volumes",
+		})
+	}
+	return
+}
+
+/*ValidateMount checks whether the subvolume can be mounted at the mount path
+with the options. The mount point cache has to be rescanned first. The returned
+error is a dtos.Error listing every reason the mount is refused.*/
+func ValidateMount(subvolume string, mountPath string, options []string) error {
+	causes := newMountPathCauses(mountPath)
+	if _, mounted := MountPointCache.FindByMountPath(mountPath); mounted {
+		causes = append(causes, dtos.ErrorCause{
+			Code:    CauseMountPathMounted,
+			Message: "A file system is already mounted at " + mountPath,
+		})
+	}
+	causes = append(causes, newMountOptionCauses(subvolume, options)...)
+	if len(causes) == 0 {
+		return nil
+	}
+	return newRefusalError(ErrCodeMountRefused, "Mount refused", causes)
+}
+
+/*ValidateReplaceMount checks whether the subvolume can be mounted at the mount
+path with the options in place of the file system mounted there.*/
+func ValidateReplaceMount(subvolume string, mountPath string, options []string) error {
+	causes := append(newMountPathCauses(mountPath), newMountOptionCauses(subvolume, options)...)
+	if len(causes) == 0 {
+		return nil
+	}
+	return newRefusalError(ErrCodeMountRefused, "Mount refused", causes)
+}
+
+/*newMountOptionCauses checks the mount options and that they do not select a
+subvolume if one is given.*/
+func newMountOptionCauses(subvolume string, options []string) []dtos.ErrorCause {
+	_, data, causes := parseMountOptions(options)
+	if subvolume != "" {
+		for _, opt := range data {
+			if strings.HasPrefix(opt, "subvol=") || strings.HasPrefix(opt, "subvolid=") {
+				causes = append(causes, dtos.ErrorCause{
+					Code:    CauseSubvolumeConflict,
+					Message: "The subvolume is given both in the request and in the option " + opt,
+				})
+			}
+		}
+	}
+	return causes
+}
+
+//subvolumeOption returns the mount option selecting the subvolume relative to the top level
+func subvolumeOption(subvolume string) string {
+	return "subvol=/" + strings.TrimPrefix(filepath.Clean("/"+subvolume), "/")
+}
+
+/*MountSubVolume mounts the subvolume of the btrfs volume at the mount path, the
+directory is created if it does not exist. The subvolume is relative to the
+top level of the volume, the default subvolume is mounted if it is empty. The
+mount point cache has to be rescanned first.*/
+func MountSubVolume(vol dtos.BtrfsVolume, subvolume string, mountPath string, options []string) (dtos.MountPoint, error) {
+	const errStr = "BTRFS mount failed: "
+	err := ValidateMount(subvolume, mountPath, options)
+	if err != nil {
+		return dtos.MountPoint{}, err
+	}
+	bds, ok := BlockDeviceCache.FindByUUID(vol.UUID)
+	if !ok || len(bds) == 0 {
+		return dtos.MountPoint{}, ErrVolumeNotFound{UUID: vol.UUID}
+	}
+
+	flags, data, _ := parseMountOptions(options)
+	if subvolume != "" {
+		data = append(data, subvolumeOption(subvolume))
+	}
+	err = os.MkdirAll(mountPath, 0755)
+	if err != nil {
+		return dtos.MountPoint{}, err
+	}
+	err = mount(bds[0].Path, mountPath, "btrfs", flags, strings.Join(data, ","))
+	if err != nil {
+		return dtos.MountPoint{}, errors.New(errStr + err.Error())
+	}
+
+	err = MountPointCache.Rescan()
+	if err != nil {
+		return dtos.MountPoint{}, err
+	}
+	mountPoint, _ := MountPointCache.FindByMountPath(mountPath)
+	return mountPoint, nil
+}
+
+/*ReplaceMount mounts the subvolume of the btrfs volume at the mount path in
+place of the btrfs file system mounted there, if any. If the mount fails, the
+previous file system is mounted again with its options. The mount point cache
+has to be rescanned first.*/
+func ReplaceMount(vol dtos.BtrfsVolume, subvolume string, mountPath string, options []string) (dtos.MountPoint, error) {
+	previous, mounted := MountPointCache.FindByMountPath(mountPath)
+	if !mounted {
+		return MountSubVolume(vol, subvolume, mountPath, options)
+	}
+	err := ValidateReplaceMount(subvolume, mountPath, options)
+	if err != nil {
+		return dtos.MountPoint{}, err
+	}
+	err = Unmount(mountPath, false)
+	if err != nil {
+		return dtos.MountPoint{}, err
+	}
+
+	mountPoint, err := MountSubVolume(vol, subvolume, mountPath, options)
+	if err != nil {
+		remountErr := remount(previous)
+		if remountErr != nil {
+			return dtos.MountPoint{}, errors.New(err.Error() + ", mounting " + previous.Identifier +
+				" again failed: " + remountErr.Error())
+		}
+	}
+	return mountPoint, err
+}
+
+//remount mounts the file system of the mount point again with its options
+func remount(mountPoint dtos.MountPoint) error {
+	flags, data, _ := parseMountOptions([]string{mountPoint.MountOptions})
+	err := mount(mountPoint.Identifier, mountPoint.MountPath, mountPoint.MountType, flags, strings.Join(data, ","))
+	if err != nil {
+		return err
+	}
+	return MountPointCache.Rescan()
+}
+
+/*Unmount unmounts the btrfs file system mounted at the mount path. Other file
+systems and the root mounts of the volumes are never unmounted. A lazy unmount
+detaches the mount point even if it is busy. If the mount point is busy, the
+returned dtos.Error lists the mounts nested in it and the processes using it.
+The mount point cache has to be rescanned first.*/
+func Unmount(mountPath string, lazy bool) error {
+	causes := newMountPathCauses(mountPath)
+	mountPoint, mounted := MountPointCache.FindByMountPath(mountPath)
+	switch {
+	case len(causes) > 0:
+	case !mounted:
+		causes = append(causes, dtos.ErrorCause{
+			Code:    CauseNotMounted,
+			Message: "No file system is mounted at " + mountPath,
+		})
+	case mountPoint.MountType != "btrfs":
+		causes = append(causes, dtos.ErrorCause{
+			Code:    CauseNotBtrfs,
+			Message: "The file system mounted at " + mountPath + " is not btrfs",
+		})
+	}
+	if RootMounts.isTracked(mountPath) {
+		causes = append(causes, dtos.ErrorCause{
+			Code:    CauseRootMount,
+			Message: mountPath + " is the root mount of a volume",
+		})
+	}
+	if len(causes) > 0 {
+		return newRefusalError(ErrCodeUnmountRefused, "Unmount refused", causes)
+	}
+
+	flags := 0
+	if lazy {
+		flags = syscall.MNT_DETACH
+	}
+	err := unmount(mountPath, flags)
+	if err == syscall.EBUSY {
+		return newRefusalError(ErrCodeUnmountRefused, "Unmount refused", newBusyCauses(mountPath))
+	}
+	if err != nil {
+		return errors.New("BTRFS unmount failed: " + err.Error())
+	}
+	return MountPointCache.Rescan()
+}
+
+//newBusyCauses lists the mounts nested in the mount path and the processes using it
+func newBusyCauses(mountPath string) (causes []dtos.ErrorCause) {
+	for _, mountPoint := range MountPointCache.GetAll() {
+		if mountPoint.MountPath != mountPath && isUnder(mountPoint.MountPath, mountPath) {
+			causes = append(causes, dtos.ErrorCause{
+				Code:    CauseNestedMount,
+				Message: "A file system is mounted at " + mountPoint.MountPath,
+			})
+		}
+	}
+	causes = append(causes, findMountUsers(mountPath)...)
+	if len(causes) == 0 {
+		causes = append(causes, dtos.ErrorCause{
+			Code:    CauseMountBusy,
+			Message: "The mount point " + mountPath + " is busy",
+		})
+	}
+	return
+}
+
+/*findMountUsers searches procfs for the processes whose working directory, root
+directory, executable or open files lie under the mount path. Processes that
+cannot be inspected are skipped.*/
+func findMountUsers(mountPath string) (causes []dtos.ErrorCause) {
+	entries, err := ioutil.ReadDir(procPath)
+	if err != nil {
+		return nil
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		processPath := filepath.Join(procPath, entry.Name())
+		links := []string{"cwd", "root", "exe"}
+		fds, _ := ioutil.ReadDir(filepath.Join(processPath, "fd"))
+		for _, fd := range fds {
+			links = append(links, filepath.Join("fd", fd.Name()))
+		}
+
+		for _, link := range links {
+			target, err := os.Readlink(filepath.Join(processPath, link))
+			if err != nil || !isUnder(target, mountPath) {
+				continue
+			}
+			comm, _ := ioutil.ReadFile(filepath.Join(processPath, "comm"))
+			command := strings.TrimSpace(string(comm))
+			causes = append(causes, dtos.ErrorCause{
+				Code:    CauseProcessUsesMount,
+				Message: "The process " + entry.Name() + " (" + command + ") uses " + target,
+				PID:     pid,
+				Command: command,
+			})
+			break
+		}
+	}
+	return
+}
@@ -0,0 +1,199 @@
+package osinterface
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMountOptions(t *testing.T) {
+	flags, data, causes := parseMountOptions([]string{"noatime,compress=zstd:3", "space_cache=v2", "ro", "rw", "nodev"})
+	assert.Empty(t, causes)
+	assert.EqualValues(t, syscall.MS_NOATIME|syscall.MS_NODEV, flags)
+	assert.EqualValues(t, []string{"compress=zstd:3", "space_cache=v2"}, data)
+
+	_, data, causes = parseMountOptions([]string{"subvol=/home", "compress", "ssd", "commit=120"})
+	assert.Empty(t, causes)
+	assert.EqualValues(t, []string{"subvol=/home", "compress", "ssd", "commit=120"}, data)
+
+	_, data, causes = parseMountOptions([]string{"compress=zstd:16", "compress=lzo:1", "space_cache=v3",
+		"ssd=1", "subvol=", "commit=soon", "bogus"})
+	assert.Empty(t, data)
+	assert.Len(t, causes, 7)
+	assert.EqualValues(t, CauseUnknownMountOption, causes[6].Code)
+	for _, cause := range causes[:6] {
+		assert.EqualValues(t, CauseInvalidMountOption, cause.Code)
+	}
+}
+
+//setupMountPoints fills the mount point cache, the returned function clears it
+func setupMountPoints(mountPoints ...dtos.MountPoint) func() {
+	MountPointCache.mountPoints = mountPoints
+	return func() {
+		MountPointCache.mountPoints = nil
+	}
+}
+
+func TestValidateMount(t *testing.T) {
+	cleanup := setupMountPoints(dtos.MountPoint{Identifier: "/dev/sdb", MountPath: "/srv/data"})
+	defer cleanup()
+
+	assert.NoError(t, ValidateMount("home", "/srv/home", []string{"noatime", "compress=zstd"}))
+	assert.NoError(t, ValidateMount("", "/srv/home", []string{"subvolid=257"}))
+
+	err := ValidateMount("home", "/srv/data", []string{"subvol=/other", "bogus"})
+	assert.Error(t, err)
+	assert.EqualValues(t, ErrCodeMountRefused, err.(dtos.Error).Code)
+	assert.EqualValues(t, []string{CauseMountPathMounted, CauseUnknownMountOption, CauseSubvolumeConflict},
+		causeCodes(err))
+
+	err = ValidateMount("", "srv/../home", nil)
+	assert.EqualValues(t, []string{CauseInvalidMountPath}, causeCodes(err))
+	err = ValidateMount("", rootMountsPath+"/data", nil)
+	assert.EqualValues(t, []string{CauseMountPathReserved}, causeCodes(err))
+}
+
+func TestUnmountBusy(t *testing.T) {
+	cleanup := setupMountPoints(
+		dtos.MountPoint{Identifier: "/dev/sdb", MountPath: "/srv/data", MountType: "btrfs"},
+		dtos.MountPoint{Identifier: "/dev/sdb", MountPath: "/srv/data/nested", MountType: "btrfs"},
+		dtos.MountPoint{Identifier: "/dev/sdc", MountPath: "/srv/database", MountType: "btrfs"},
+	)
+	defer cleanup()
+	dir, err := ioutil.TempDir("", "proc")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	oldProcPath := procPath
+	procPath = dir
+	defer func() { procPath = oldProcPath }()
+	var flagsUsed int
+	oldUnmount := unmount
+	unmount = func(target string, flags int) error {
+		flagsUsed = flags
+		return syscall.EBUSY
+	}
+	defer func() { unmount = oldUnmount }()
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "42", "fd"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "42", "comm"), []byte("bash\n"), 0644))
+	assert.NoError(t, os.Symlink("/srv/data/logs", filepath.Join(dir, "42", "cwd")))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "43", "fd"), 0755))
+	assert.NoError(t, os.Symlink("/srv/data/file", filepath.Join(dir, "43", "fd", "3")))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "44", "fd"), 0755))
+	assert.NoError(t, os.Symlink("/srv/database/file", filepath.Join(dir, "44", "fd", "3")))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "self"), 0755))
+
+	err = Unmount("/srv/data", true)
+	assert.Error(t, err)
+	assert.Equal(t, syscall.MNT_DETACH, flagsUsed)
+	assert.EqualValues(t, ErrCodeUnmountRefused, err.(dtos.Error).Code)
+	causes := err.(dtos.Error).Causes
+	assert.EqualValues(t, []string{CauseNestedMount, CauseProcessUsesMount, CauseProcessUsesMount}, causeCodes(err))
+	assert.Equal(t, 42, causes[1].PID)
+	assert.Equal(t, "bash", causes[1].Command)
+	assert.Equal(t, 43, causes[2].PID)
+
+	err = Unmount("/srv/other", false)
+	assert.EqualValues(t, []string{CauseNotMounted}, causeCodes(err))
+}
+
+func TestUnmountRefused(t *testing.T) {
+	cleanup := setupMountPoints(
+		dtos.MountPoint{Identifier: "/dev/sda1", MountPath: "/boot", MountType: "ext4"},
+		dtos.MountPoint{Identifier: "/dev/sdb", MountPath: rootMountsPath + "/data", MountType: "btrfs"},
+		dtos.MountPoint{Identifier: "/dev/sdc", MountPath: "/srv/root", MountType: "btrfs"},
+	)
+	defer cleanup()
+	oldRootMounts := RootMounts
+	RootMounts = &rootMountTracker{mounts: map[dtos.UUIDType]*rootMount{
+		"uuid": {path: "/srv/root"},
+	}}
+	defer func() { RootMounts = oldRootMounts }()
+	oldUnmount := unmount
+	unmount = func(target string, flags int) error {
+		t.Error("unmount called for " + target)
+		return nil
+	}
+	defer func() { unmount = oldUnmount }()
+
+	assert.EqualValues(t, []string{CauseNotBtrfs}, causeCodes(Unmount("/boot", false)))
+	assert.EqualValues(t, []string{CauseMountPathReserved}, causeCodes(Unmount(rootMountsPath+"/data", false)))
+	assert.EqualValues(t, []string{CauseRootMount}, causeCodes(Unmount("/srv/root", false)))
+	assert.EqualValues(t, []string{CauseInvalidMountPath}, causeCodes(Unmount("/", false)))
+}
+
+func TestReplaceMount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mtab")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	mountPath := filepath.Join(dir, "data")
+	oldMTabFilePath := mTabFilePath
+	mTabFilePath = filepath.Join(dir, "mtab")
+	defer func() { mTabFilePath = oldMTabFilePath }()
+	oldBlockDevsByUUID := BlockDeviceCache.blockDevsByUUID
+	BlockDeviceCache.blockDevsByUUID = map[dtos.UUIDType][]*dtos.BlockDevice{"restored": {{Path: "/dev/sdc"}}}
+	defer func() { BlockDeviceCache.blockDevsByUUID = oldBlockDevsByUUID }()
+	defer setupMountPoints()()
+
+	//the stubs keep the fake mtab up to date
+	mounts := map[string]string{mountPath: "/dev/sdb " + mountPath + " btrfs rw,noatime,subvolid=257,subvol=/data 0 0"}
+	writeMTab := func() {
+		var lines string
+		for _, line := range mounts {
+			lines += line + "\n"
+		}
+		assert.NoError(t, ioutil.WriteFile(mTabFilePath, []byte(lines), 0644))
+	}
+	var mountCalls []string
+	var failingSource string
+	oldMount := mount
+	mount = func(source string, target string, fstype string, flags uintptr, data string) error {
+		mountCalls = append(mountCalls, source+" "+data)
+		if source == failingSource {
+			return syscall.EINVAL
+		}
+		mounts[target] = source + " " + target + " " + fstype + " " + data + " 0 0"
+		writeMTab()
+		return nil
+	}
+	defer func() { mount = oldMount }()
+	oldUnmount := unmount
+	unmount = func(target string, flags int) error {
+		delete(mounts, target)
+		writeMTab()
+		return nil
+	}
+	defer func() { unmount = oldUnmount }()
+	writeMTab()
+	assert.NoError(t, MountPointCache.Rescan())
+
+	vol := dtos.BtrfsVolume{UUID: "restored"}
+	err = ValidateReplaceMount("backups/data", mountPath, []string{"subvolid=5"})
+	assert.EqualValues(t, []string{CauseSubvolumeConflict}, causeCodes(err))
+	_, err = ReplaceMount(vol, "backups/data", mountPath, []string{"bogus"})
+	assert.EqualValues(t, []string{CauseUnknownMountOption}, causeCodes(err))
+	assert.Empty(t, mountCalls, "the previous mount is kept if the mount is refused")
+
+	failingSource = "/dev/sdc"
+	_, err = ReplaceMount(vol, "backups/data", mountPath, []string{"compress=zstd"})
+	assert.Error(t, err)
+	assert.EqualValues(t, []string{
+		"/dev/sdc compress=zstd,subvol=/backups/data",
+		"/dev/sdb subvolid=257,subvol=/data",
+	}, mountCalls)
+	mountPoint, ok := MountPointCache.FindByMountPath(mountPath)
+	assert.True(t, ok)
+	assert.Equal(t, "/dev/sdb", mountPoint.Identifier, "the previous source is mounted again")
+
+	failingSource = ""
+	mountCalls = nil
+	mountPoint, err = ReplaceMount(vol, "backups/data", mountPath, nil)
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"/dev/sdc subvol=/backups/data"}, mountCalls)
+	assert.Equal(t, "/dev/sdc", mountPoint.Identifier)
+}
@@ -0,0 +1,156 @@
+package osinterface
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+)
+
+const (
+	propReadOnly    = "ro"
+	propCompression = "compression"
+	propLabel       = "label"
+)
+
+var (
+	//ErrUnknownProperty indicates that the property is not supported on subvolumes
+	ErrUnknownProperty = errors.New("Unknown subvolume property, supported are ro, compression and label")
+	//ErrInvalidPropertyValue indicates that the value is not valid for the property
+	ErrInvalidPropertyValue = errors.New("Invalid property value")
+	//ErrLabelNotTopLevel indicates that the label was set on a subvolume other than the top level one
+	ErrLabelNotTopLevel = errors.New("The label can only be set on the top level subvolume")
+
+	compressionValues = map[string]bool{"": true, "none": true, "zlib": true, "lzo": true, "zstd": true}
+)
+
+/*subvolumePath joins the path relative to the volume root with the mount path.
+The relative path cannot point outside the volume.*/
+func subvolumePath(mountPath string, relativePath string) string {
+	return filepath.Join(mountPath, filepath.Clean("/"+relativePath))
+}
+
+func isTopLevel(relativePath string) bool {
+	return filepath.Clean("/"+relativePath) == "/"
+}
+
+func validateProperty(name string, value string) error {
+	switch name {
+	case propReadOnly:
+		if value != "true" && value != "false" {
+			return ErrInvalidPropertyValue
+		}
+	case propCompression:
+		if !compressionValues[value] {
+			return ErrInvalidPropertyValue
+		}
+	case propLabel:
+		if len(value) > btrfsLabelSize || strings.ContainsAny(value, "\n\x00") {
+			return ErrInvalidLabel
+		}
+	default:
+		return ErrUnknownProperty
+	}
+	return nil
+}
+
+/*parsePropertyList parses the output of btrfs property get, for example:
+ro=false
+compression=zstd*/
+func parsePropertyList(output string) map[string]string {
+	properties := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) == 2 {
+			properties[parts[0]] = parts[1]
+		}
+	}
+	return properties
+}
+
+/*GetSubVolumeProperties retrieves the ro and compression properties of the
+subvolume at the path relative to the root of the volume identified by UUID. For
+the top level subvolume the label of the volume is included.*/
+func GetSubVolumeProperties(UUID dtos.UUIDType, relativePath string) (map[string]string, error) {
+	mountPath, err := GetBtrfsRootMount(dtos.BtrfsVolume{UUID: UUID})
+	if err != nil {
+		return nil, err
+	}
+	path := subvolumePath(mountPath, relativePath)
+	output, err := runBtrfsCommand("property", "get", "-ts", path)
+	if err != nil {
+		return nil, err
+	}
+	properties := parsePropertyList(output)
+	if _, ok := properties[propCompression]; !ok {
+		properties[propCompression] = ""
+	}
+
+	if isTopLevel(relativePath) {
+		output, err = runBtrfsCommand("property", "get", "-tf", path, propLabel)
+		if err != nil {
+			return nil, err
+		}
+		properties[propLabel] = parsePropertyList(output)[propLabel]
+	}
+	return properties, nil
+}
+
+/*SetSubVolumeProperties sets properties of the subvolume at the path relative
+to the root of the volume identified by UUID and returns all of its properties.
+The label is set on the volume, which is returned if it was relabeled. A
+subvolume is made writable before and read-only after other properties change.*/
+func SetSubVolumeProperties(UUID dtos.UUIDType, relativePath string, properties map[string]string) (map[string]string, *dtos.BtrfsVolume, error) {
+	for name, value := range properties {
+		err := validateProperty(name, value)
+		if err != nil {
+			return nil, nil, errors.New(err.Error() + ": " + name + "=" + value)
+		}
+	}
+	label, setLabel := properties[propLabel]
+	if setLabel && !isTopLevel(relativePath) {
+		return nil, nil, ErrLabelNotTopLevel
+	}
+
+	mountPath, err := GetBtrfsRootMount(dtos.BtrfsVolume{UUID: UUID})
+	if err != nil {
+		return nil, nil, err
+	}
+	path := subvolumePath(mountPath, relativePath)
+	setProperty := func(name string) error {
+		_, err := runBtrfsCommand("property", "set", "-ts", path, name, properties[name])
+		return err
+	}
+
+	if properties[propReadOnly] == "false" {
+		err = setProperty(propReadOnly)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if _, ok := properties[propCompression]; ok {
+		err = setProperty(propCompression)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if properties[propReadOnly] == "true" {
+		err = setProperty(propReadOnly)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var relabeled *dtos.BtrfsVolume
+	if setLabel {
+		vol, err := RelabelVolume(UUID, label)
+		if err != nil {
+			return nil, nil, err
+		}
+		relabeled = &vol
+	}
+
+	current, err := GetSubVolumeProperties(UUID, relativePath)
+	return current, relabeled, err
+}
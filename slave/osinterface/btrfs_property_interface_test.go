@@ -0,0 +1,52 @@
+package osinterface
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePropertyList(t *testing.T) {
+	properties := parsePropertyList("ro=false\ncompression=zstd\n")
+	assert.EqualValues(t, map[string]string{"ro": "false", "compression": "zstd"}, properties)
+	properties = parsePropertyList("label=data=2016\n")
+	assert.EqualValues(t, "data=2016", properties["label"])
+}
+
+func TestValidateProperty(t *testing.T) {
+	assert.NoError(t, validateProperty("ro", "true"))
+	assert.NoError(t, validateProperty("compression", ""))
+	assert.NoError(t, validateProperty("compression", "lzo"))
+	assert.NoError(t, validateProperty("label", "data"))
+	assert.Equal(t, ErrInvalidPropertyValue, validateProperty("ro", "yes"))
+	assert.Equal(t, ErrInvalidPropertyValue, validateProperty("compression", "gzip"))
+	assert.Equal(t, ErrInvalidLabel, validateProperty("label", "a\nb"))
+	assert.Equal(t, ErrUnknownProperty, validateProperty("owner", "root"))
+}
+
+func TestSubvolumePath(t *testing.T) {
+	assert.EqualValues(t, "/mnt/vol/home", subvolumePath("/mnt/vol", "home"))
+	assert.EqualValues(t, "/mnt/vol/etc", subvolumePath("/mnt/vol", "../../etc"))
+	assert.True(t, isTopLevel(""))
+	assert.True(t, isTopLevel("/"))
+	assert.True(t, isTopLevel("home/.."))
+	assert.False(t, isTopLevel("home"))
+}
+
+func TestProbeDefaultSubVolume(t *testing.T) {
+	oldRunBtrfsCommand := runBtrfsCommand
+	defer func() { runBtrfsCommand = oldRunBtrfsCommand }()
+	output := "ID 256 gen 12 top level 5 path home\n"
+	runBtrfsCommand = func(options ...string) (string, error) {
+		return output, nil
+	}
+
+	ID, err := ProbeDefaultSubVolume("/mnt/vol")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 256, ID)
+
+	output = "ID 5 (FS_TREE)\n"
+	ID, err = ProbeDefaultSubVolume("/mnt/vol")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, ID)
+}
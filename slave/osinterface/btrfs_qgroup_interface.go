@@ -0,0 +1,242 @@
+package osinterface
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+)
+
+var (
+	//ErrInvalidQgroupID indicates that a qgroup ID is not of the form level/id
+	ErrInvalidQgroupID = errors.New("A qgroup ID has to be of the form <level>/<id>")
+	//ErrQgroupLevel indicates that a level 0 qgroup was used as a group
+	ErrQgroupLevel = errors.New("Only qgroups of level 1 or higher can group other qgroups")
+	//ErrQgroupMemberLevel indicates that a member's level is not below the group's level
+	ErrQgroupMemberLevel = errors.New("Members have to be of a lower level than the qgroup")
+	//ErrQgroupNotFound indicates that the volume has no qgroup with the ID
+	ErrQgroupNotFound = errors.New("Qgroup not found")
+)
+
+//parseQgroupLevel validates the qgroup ID and returns its level
+func parseQgroupLevel(qgroupID string) (uint64, error) {
+	parts := strings.Split(qgroupID, "/")
+	if len(parts) != 2 {
+		return 0, ErrInvalidQgroupID
+	}
+	level, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return 0, ErrInvalidQgroupID
+	}
+	_, err = strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, ErrInvalidQgroupID
+	}
+	return level, nil
+}
+
+//validateQgroupMembers checks that the qgroup can group the members
+func validateQgroupMembers(qgroupID string, members []string) error {
+	level, err := parseQgroupLevel(qgroupID)
+	if err != nil {
+		return err
+	}
+	if level == 0 {
+		return ErrQgroupLevel
+	}
+	for _, member := range members {
+		memberLevel, err := parseQgroupLevel(member)
+		if err != nil {
+			return err
+		}
+		if memberLevel >= level {
+			return ErrQgroupMemberLevel
+		}
+	}
+	return nil
+}
+
+/*SetQuota enables or disables quotas on the btrfs volume mounted at mountPath.
+Enabling quotas starts a rescan of the volume's usage.*/
+func SetQuota(mountPath string, enabled bool) error {
+	action := "disable"
+	if enabled {
+		action = "enable"
+	}
+	_, err := runBtrfsCommand("quota", action, mountPath)
+	return err
+}
+
+/*ProbeQgroups retrieves the qgroups of the btrfs volume mounted at mountPath.
+An error is returned if quotas are not enabled.*/
+func ProbeQgroups(mountPath string) ([]dtos.Qgroup, error) {
+	output, err := runBtrfsCommand("qgroup", "show", "-pcre", "--raw", mountPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseQgroupShow(output)
+}
+
+func parseQgroupLimit(field string) (*uint64, error) {
+	if field == "none" {
+		return nil, nil
+	}
+	limit, err := strconv.ParseUint(field, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &limit, nil
+}
+
+func parseQgroupRelations(field string) []string {
+	if strings.Trim(field, "-") == "" {
+		return []string{}
+	}
+	return strings.Split(field, ",")
+}
+
+/*parseQgroupShow parses the output of btrfs qgroup show -pcre --raw, for example:
+qgroupid         rfer         excl     max_rfer     max_excl parent  child
+--------         ----         ----     --------     -------- ------  -----
+0/257           16384        16384   1073741824         none 1/100   ---
+Newer versions of the tool append the path of the subvolume, it is ignored.*/
+func parseQgroupShow(output string) ([]dtos.Qgroup, error) {
+	var qgroups []dtos.Qgroup
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if _, err := parseQgroupLevel(fields[0]); err != nil {
+			//header
+			continue
+		}
+		if len(fields) < 7 {
+			return nil, errors.New("Unexpected qgroup line: " + line)
+		}
+
+		qgroup := dtos.Qgroup{ID: fields[0]}
+		var err error
+		qgroup.Referenced, err = strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		qgroup.Exclusive, err = strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		qgroup.MaxReferenced, err = parseQgroupLimit(fields[3])
+		if err != nil {
+			return nil, err
+		}
+		qgroup.MaxExclusive, err = parseQgroupLimit(fields[4])
+		if err != nil {
+			return nil, err
+		}
+		qgroup.Parents = parseQgroupRelations(fields[5])
+		qgroup.Children = parseQgroupRelations(fields[6])
+		qgroups = append(qgroups, qgroup)
+	}
+	return qgroups, nil
+}
+
+//findQgroup retrieves the qgroup with the ID
+func findQgroup(mountPath string, qgroupID string) (dtos.Qgroup, error) {
+	qgroups, err := ProbeQgroups(mountPath)
+	if err != nil {
+		return dtos.Qgroup{}, err
+	}
+	for _, qgroup := range qgroups {
+		if qgroup.ID == qgroupID {
+			return qgroup, nil
+		}
+	}
+	return dtos.Qgroup{}, ErrQgroupNotFound
+}
+
+/*LimitQgroup sets the limit of the referenced bytes of the qgroup, or of the
+exclusive bytes if exclusive is set. A nil limit clears it. The changed qgroup
+is returned.*/
+func LimitQgroup(mountPath string, qgroupID string, limit *uint64, exclusive bool) (dtos.Qgroup, error) {
+	if _, err := parseQgroupLevel(qgroupID); err != nil {
+		return dtos.Qgroup{}, err
+	}
+	options := []string{"qgroup", "limit"}
+	if exclusive {
+		options = append(options, "-e")
+	}
+	if limit == nil {
+		options = append(options, "none")
+	} else {
+		options = append(options, strconv.FormatUint(*limit, 10))
+	}
+	_, err := runBtrfsCommand(append(options, qgroupID, mountPath)...)
+	if err != nil {
+		return dtos.Qgroup{}, err
+	}
+	return findQgroup(mountPath, qgroupID)
+}
+
+/*CreateQgroup creates a qgroup of level 1 or higher and assigns the members to
+it. The created qgroup is returned.*/
+func CreateQgroup(mountPath string, qgroupID string, members []string) (dtos.Qgroup, error) {
+	err := validateQgroupMembers(qgroupID, members)
+	if err != nil {
+		return dtos.Qgroup{}, err
+	}
+	_, err = runBtrfsCommand("qgroup", "create", qgroupID, mountPath)
+	if err != nil {
+		return dtos.Qgroup{}, err
+	}
+	return AssignQgroup(mountPath, qgroupID, members, false)
+}
+
+/*AssignQgroup adds the members to the qgroup, or removes them from it if remove
+is set. The changed qgroup is returned.*/
+func AssignQgroup(mountPath string, qgroupID string, members []string, remove bool) (dtos.Qgroup, error) {
+	err := validateQgroupMembers(qgroupID, members)
+	if err != nil {
+		return dtos.Qgroup{}, err
+	}
+	action := "assign"
+	if remove {
+		action = "remove"
+	}
+	for _, member := range members {
+		_, err = runBtrfsCommand("qgroup", action, member, qgroupID, mountPath)
+		if err != nil {
+			return dtos.Qgroup{}, err
+		}
+	}
+	return findQgroup(mountPath, qgroupID)
+}
+
+//DestroyQgroup destroys a qgroup of level 1 or higher
+func DestroyQgroup(mountPath string, qgroupID string) error {
+	err := validateQgroupMembers(qgroupID, nil)
+	if err != nil {
+		return err
+	}
+	_, err = runBtrfsCommand("qgroup", "destroy", qgroupID, mountPath)
+	return err
+}
+
+/*AddSubVolumeUsage sets the usage of every subvolume from its level 0 qgroup.
+If quotas are not enabled the subvolumes are left unchanged.*/
+func AddSubVolumeUsage(mountPath string, subvols []dtos.BtrfsSubVolume) {
+	qgroups, err := ProbeQgroups(mountPath)
+	if err != nil {
+		return
+	}
+	usageByID := make(map[string]dtos.QgroupUsage)
+	for _, qgroup := range qgroups {
+		usageByID[qgroup.ID] = qgroup.QgroupUsage
+	}
+	for i := range subvols {
+		usage, ok := usageByID["0/"+strconv.Itoa(subvols[i].SubVolID)]
+		if ok {
+			subvols[i].Usage = &usage
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package osinterface
+
+import (
+	"testing"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQgroupShow(t *testing.T) {
+	output := "qgroupid         rfer         excl     max_rfer     max_excl parent  child \n" +
+		"--------         ----         ----     --------     -------- ------  ----- \n" +
+		"0/5             16384        16384         none         none ---     ---  \n" +
+		"0/257         1048576       524288   1073741824         none 1/100   ---  \n" +
+		"0/258           32768        16384         none     65536000 1/100   ---  \n" +
+		"1/100         1081344      1064960         none         none ---     0/257,0/258\n"
+
+	qgroups, err := parseQgroupShow(output)
+	assert.NoError(t, err)
+	assert.Len(t, qgroups, 4)
+
+	assert.EqualValues(t, "0/257", qgroups[1].ID)
+	assert.EqualValues(t, 1048576, qgroups[1].Referenced)
+	assert.EqualValues(t, 524288, qgroups[1].Exclusive)
+	assert.EqualValues(t, 1073741824, *qgroups[1].MaxReferenced)
+	assert.Nil(t, qgroups[1].MaxExclusive)
+	assert.EqualValues(t, []string{"1/100"}, qgroups[1].Parents)
+	assert.EqualValues(t, []string{}, qgroups[1].Children)
+	assert.EqualValues(t, 65536000, *qgroups[2].MaxExclusive)
+	assert.EqualValues(t, []string{"0/257", "0/258"}, qgroups[3].Children)
+
+	_, err = parseQgroupShow("0/5 16384 16384\n")
+	assert.Error(t, err)
+}
+
+func TestParseQgroupShowWithPath(t *testing.T) {
+	output := "Qgroupid    Referenced    Exclusive  Max referenced  Max exclusive   Parent   Child   Path \n" +
+		"--------    ----------    ---------  --------------  -------------   ------   -----   ---- \n" +
+		"0/256            16384        16384            none           none   -        -       home\n"
+
+	qgroups, err := parseQgroupShow(output)
+	assert.NoError(t, err)
+	assert.Len(t, qgroups, 1)
+	assert.EqualValues(t, "0/256", qgroups[0].ID)
+	assert.EqualValues(t, []string{}, qgroups[0].Parents)
+}
+
+func TestValidateQgroupMembers(t *testing.T) {
+	assert.NoError(t, validateQgroupMembers("1/100", []string{"0/257", "0/258"}))
+	assert.NoError(t, validateQgroupMembers("2/1", []string{"1/100"}))
+	assert.Equal(t, ErrQgroupLevel, validateQgroupMembers("0/257", nil))
+	assert.Equal(t, ErrQgroupMemberLevel, validateQgroupMembers("1/100", []string{"1/101"}))
+	assert.Equal(t, ErrInvalidQgroupID, validateQgroupMembers("100", nil))
+	assert.Equal(t, ErrInvalidQgroupID, validateQgroupMembers("1/100", []string{"0/abc"}))
+}
+
+func TestAddSubVolumeUsage(t *testing.T) {
+	oldRunBtrfsCommand := runBtrfsCommand
+	defer func() { runBtrfsCommand = oldRunBtrfsCommand }()
+	runBtrfsCommand = func(options ...string) (string, error) {
+		return "0/257 1048576 524288 none none --- ---\n", nil
+	}
+
+	subvols := []dtos.BtrfsSubVolume{{SubVolID: 257}, {SubVolID: 258}}
+	AddSubVolumeUsage("/mnt/vol", subvols)
+	assert.EqualValues(t, &dtos.QgroupUsage{Referenced: 1048576, Exclusive: 524288}, subvols[0].Usage)
+	assert.Nil(t, subvols[1].Usage)
+}
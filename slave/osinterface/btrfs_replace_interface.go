@@ -0,0 +1,154 @@
+package osinterface
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+)
+
+//ErrCodeReplaceRefused is the dtos.Error code of a refused device replace
+const ErrCodeReplaceRefused = "replace_refused"
+
+//CauseSameDevice is the dtos.ErrorCause code of a replace of a device by itself
+const CauseSameDevice = "same_device"
+
+var (
+	replacePercentMatcher     = regexp.MustCompile(`([0-9.]+)% done`)
+	replaceCanceledMatcher    = regexp.MustCompile(`canceled on .* at ([0-9.]+)%`)
+	replaceWriteErrMatcher    = regexp.MustCompile(`([0-9]+) write errs`)
+	replaceUncorrErrorMatcher = regexp.MustCompile(`([0-9]+) uncorr\. read errs`)
+)
+
+/*ReplaceOperation replaces a device of a btrfs volume with btrfs replace. It
+satisfies the tasks.CancellableOperation interface.*/
+type ReplaceOperation struct {
+	mountPath string
+	source    string
+	target    string
+	force     bool
+}
+
+//isDevID reports whether the replace source is a devid rather than a path
+func isDevID(source string) bool {
+	_, err := strconv.ParseUint(source, 10, 64)
+	return err == nil
+}
+
+/*ValidateReplace checks whether the source device of the volume can be replaced
+with the target device. A source given by devid is left to btrfs to check, which
+allows replacing a missing device. The target is checked like an added device,
+so it may be a blank disk.*/
+func ValidateReplace(vol dtos.BtrfsVolume, source string, target string, force bool) error {
+	var causes []dtos.ErrorCause
+	if source == target {
+		causes = append(causes, dtos.ErrorCause{
+			Code:    CauseSameDevice,
+			Message: "The device " + source + " cannot be replaced by itself",
+		})
+	}
+	if !isDevID(source) {
+		inVolume := false
+		for _, dev := range vol.Devices {
+			inVolume = inVolume || dev.Path == source
+		}
+		if !inVolume {
+			causes = append(causes, dtos.ErrorCause{
+				Code:    CauseDeviceNotInVolume,
+				Message: "The device " + source + " is not part of the volume",
+			})
+		}
+	}
+	causes = append(causes, newDeviceCauses(vol, []string{target}, force)...)
+
+	if len(causes) == 0 {
+		return nil
+	}
+	return newRefusalError(ErrCodeReplaceRefused, "Device replace refused", causes)
+}
+
+/*NewReplaceOperation validates the replace of the source device of the btrfs
+volume identified by UUID and constructs the operation that performs it.*/
+func NewReplaceOperation(UUID dtos.UUIDType, source string, target string, force bool) (*ReplaceOperation, error) {
+	vol, err := findBtrfsVolume(UUID)
+	if err != nil {
+		return nil, err
+	}
+	err = ValidateReplace(vol, source, target, force)
+	if err != nil {
+		return nil, err
+	}
+	mountPath, err := GetBtrfsRootMount(vol)
+	if err != nil {
+		return nil, err
+	}
+	return &ReplaceOperation{
+		mountPath: mountPath,
+		source:    source,
+		target:    target,
+		force:     force,
+	}, nil
+}
+
+/*Run starts the replace and blocks until it finishes or is cancelled. Once the
+replace is done the block device cache is rescanned, so that the target shows up
+as a device of the volume.*/
+func (r *ReplaceOperation) Run() error {
+	options := []string{"replace", "start", "-B"}
+	if r.force {
+		options = append(options, "-f")
+	}
+	options = append(options, r.source, r.target, r.mountPath)
+	_, err := runBtrfsCommand(options...)
+	if err != nil {
+		return err
+	}
+	return BlockDeviceCache.Rescan()
+}
+
+//Cancel cancels the running replace, the source device stays in the volume
+func (r *ReplaceOperation) Cancel() error {
+	_, err := runBtrfsCommand("replace", "cancel", r.mountPath)
+	return err
+}
+
+//Progress returns the current dtos.ReplaceProgress of the replace
+func (r *ReplaceOperation) Progress() (interface{}, error) {
+	output, err := runBtrfsCommand("replace", "status", "-1", r.mountPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseReplaceStatus(output)
+}
+
+/*parseReplaceStatus parses the output of btrfs replace status -1, for example:
+0.4% done, 0 write errs, 0 uncorr. read errs
+Started on 14.Jun 10:08:12, finished on 14.Jun 10:09:32, 0 write errs, 0 uncorr. read errs*/
+func parseReplaceStatus(output string) (progress dtos.ReplaceProgress, err error) {
+	if strings.Contains(output, "Never started") {
+		return
+	}
+
+	if match := replacePercentMatcher.FindStringSubmatch(output); match != nil {
+		progress.PercentDone, err = strconv.ParseFloat(match[1], 64)
+	} else if match := replaceCanceledMatcher.FindStringSubmatch(output); match != nil {
+		progress.PercentDone, err = strconv.ParseFloat(match[1], 64)
+	} else if strings.Contains(output, "finished on") {
+		progress.PercentDone = 100
+	} else {
+		return progress, errors.New("Unable to parse replace status: " + output)
+	}
+	if err != nil {
+		return
+	}
+
+	if match := replaceWriteErrMatcher.FindStringSubmatch(output); match != nil {
+		progress.WriteErrors, _ = strconv.ParseUint(match[1], 10, 64)
+	}
+	if match := replaceUncorrErrorMatcher.FindStringSubmatch(output); match != nil {
+		progress.UncorrectableReadErrors, _ = strconv.ParseUint(match[1], 10, 64)
+	}
+	return
+}
@@ -0,0 +1,64 @@
+package osinterface
+
+import (
+	"testing"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReplaceStatus(t *testing.T) {
+	progress, err := parseReplaceStatus("12.5% done, 1 write errs, 2 uncorr. read errs\n")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 12.5, progress.PercentDone)
+	assert.EqualValues(t, 1, progress.WriteErrors)
+	assert.EqualValues(t, 2, progress.UncorrectableReadErrors)
+
+	progress, err = parseReplaceStatus("Started on 14.Jun 10:08:12, finished on 14.Jun 10:09:32, " +
+		"0 write errs, 0 uncorr. read errs\n")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 100, progress.PercentDone)
+
+	progress, err = parseReplaceStatus("Started on 14.Jun 10:08:12, canceled on 14.Jun 10:08:40 at 3.2%, " +
+		"0 write errs, 0 uncorr. read errs\n")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3.2, progress.PercentDone)
+
+	progress, err = parseReplaceStatus("Never started\n")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, progress.PercentDone)
+
+	_, err = parseReplaceStatus("garbage")
+	assert.Error(t, err)
+}
+
+func TestValidateReplace(t *testing.T) {
+	cleanup := setupDeviceCaches(t, []dtos.BlockDevice{
+		{Path: "/dev/sdb", UUID: "vol", Type: "btrfs"},
+		{Path: "/dev/sdc", UUID: "vol", Type: "btrfs"},
+		{Path: "/dev/sdd"},
+		{Path: "/dev/sde", UUID: "other", Type: "ext4"},
+	})
+	defer cleanup()
+	vol := dtos.BtrfsVolume{
+		UUID:    "vol",
+		Devices: []*dtos.BlockDevice{{Path: "/dev/sdb"}, {Path: "/dev/sdc"}},
+	}
+
+	assert.NoError(t, ValidateReplace(vol, "/dev/sdb", "/dev/sdd", false))
+	assert.NoError(t, ValidateReplace(vol, "3", "/dev/sdd", false))
+	assert.NoError(t, ValidateReplace(vol, "/dev/sdb", "/dev/sde", true))
+
+	err := ValidateReplace(vol, "/dev/sdf", "/dev/sde", false)
+	assert.EqualValues(t, ErrCodeReplaceRefused, err.(dtos.Error).Code)
+	assert.EqualValues(t, []string{CauseDeviceNotInVolume, CauseDeviceHasFilesystem}, causeCodes(err))
+
+	err = ValidateReplace(vol, "/dev/sdb", "/dev/sdc", true)
+	assert.EqualValues(t, []string{CauseDeviceInVolume}, causeCodes(err))
+
+	//a new blank disk is not in the block device cache
+	err = ValidateReplace(vol, "2", "/dev/sdf", false)
+	assert.EqualValues(t, []string{CauseDeviceNotFound}, causeCodes(err))
+	addSysBlockDevice(t, sysBlockPath, "sdf")
+	assert.NoError(t, ValidateReplace(vol, "2", "/dev/sdf", false))
+}
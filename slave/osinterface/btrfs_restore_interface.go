@@ -0,0 +1,270 @@
+package osinterface
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+)
+
+var (
+	//ErrBackupStreamNotFound indicates that the catalog has no stream with the ID
+	ErrBackupStreamNotFound = errors.New("Backup stream not found")
+	//ErrRestoreCancelled indicates that the restore was cancelled
+	ErrRestoreCancelled = errors.New("The restore was cancelled")
+)
+
+/*restoreChain returns the streams that have to be received to restore the
+snapshot of the stream with the ID, from the full stream of its chain to the
+stream itself.*/
+func restoreChain(streams []dtos.BackupStream, ID string) ([]dtos.BackupStream, error) {
+	var chain []dtos.BackupStream
+	for ID != "" {
+		stream, ok := findBackupStream(streams, ID)
+		if !ok {
+			return nil, ErrBackupStreamNotFound
+		}
+		for _, s := range chain {
+			if s.ID == ID {
+				return nil, errors.New("Circular stream dependency at " + ID)
+			}
+		}
+		chain = append(chain, stream)
+		ID = stream.ParentID
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain, nil
+}
+
+//findReceived finds the subvolume received from the snapshot with the UUID
+func findReceived(subvols []dtos.BtrfsSubVolume, UUID dtos.UUIDType) (dtos.BtrfsSubVolume, bool) {
+	for _, subvol := range subvols {
+		if UUID != "" && subvol.ReceivedUUID == UUID {
+			return subvol, true
+		}
+	}
+	return dtos.BtrfsSubVolume{}, false
+}
+
+/*RestoreOperation receives a chain of backup streams into a volume. It
+satisfies the tasks.CancellableOperation interface.*/
+type RestoreOperation struct {
+	volumeUUID dtos.UUIDType
+	dir        string
+	targetPath string
+	writable   bool
+	mountPath  string
+	options    []string
+	streams    []dtos.BackupStream
+	bytesDone  []uint64
+
+	mtx       sync.Mutex
+	receive   *ReceiveStream
+	cancelled bool
+	progress  dtos.RestoreProgress
+}
+
+/*NewRestoreOperation constructs the restore of the snapshot of the stream with
+the ID stored in the backup target directory into the directory targetPath of
+the volume identified by UUID. Streams whose snapshot was already received on
+the volume are not received again. If writable is set the restored subvolume is
+made writable, if mountPath is set it is mounted there with the options in place
+of the file system mounted before.*/
+func NewRestoreOperation(UUID dtos.UUIDType, dir string, ID string, targetPath string,
+	writable bool, mountPath string, options []string) (*RestoreOperation, error) {
+
+	streams, err := LoadBackupCatalog(dir)
+	if err != nil {
+		return nil, err
+	}
+	streams, err = restoreChain(streams, ID)
+	if err != nil {
+		return nil, err
+	}
+	last := streams[len(streams)-1]
+	if mountPath != "" {
+		err = ValidateReplaceMount(filepath.Join(targetPath, filepath.Base(last.SnapshotPath)), mountPath, options)
+		if err != nil {
+			return nil, err
+		}
+	}
+	rootMountPath, err := GetBtrfsRootMount(dtos.BtrfsVolume{UUID: UUID})
+	if err != nil {
+		return nil, err
+	}
+	subvols, err := ProbeSubVolumes(rootMountPath)
+	if err != nil {
+		return nil, err
+	}
+	err = ValidateReceive(subvols, targetPath, filepath.Base(last.SnapshotPath), last.SnapshotUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &RestoreOperation{
+		volumeUUID: UUID,
+		dir:        dir,
+		targetPath: targetPath,
+		writable:   writable,
+		mountPath:  mountPath,
+		options:    options,
+		streams:    streams,
+		bytesDone:  make([]uint64, len(streams)),
+	}
+	for _, stream := range streams {
+		state := dtos.RestoreStreamPending
+		if _, ok := findReceived(subvols, stream.SnapshotUUID); ok {
+			state = dtos.RestoreStreamPresent
+		}
+		r.progress.Streams = append(r.progress.Streams, dtos.RestoreStreamProgress{
+			ID:    stream.ID,
+			State: state,
+			Size:  stream.Size,
+		})
+	}
+	return r, nil
+}
+
+func (r *RestoreOperation) setState(i int, state string, err error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.progress.Streams[i].State = state
+	if err != nil {
+		r.progress.Streams[i].Error = err.Error()
+	}
+}
+
+/*Run receives the streams in chain order and blocks until the snapshot is
+restored. Every stream file is verified before it is received. The snapshots
+received only as parents of later streams are deleted once the restore
+completes or fails.*/
+func (r *RestoreOperation) Run() error {
+	var intermediates []dtos.BtrfsSubVolume
+	defer func() {
+		for _, subvol := range intermediates {
+			DeleteSubVolume(dtos.BtrfsVolume{UUID: r.volumeUUID}, subvol.RelativePath)
+		}
+	}()
+
+	var restored dtos.BtrfsSubVolume
+	for i, stream := range r.streams {
+		if r.progress.Streams[i].State == dtos.RestoreStreamPresent {
+			continue
+		}
+		subvol, err := r.receiveStream(i, stream)
+		if err != nil {
+			r.setState(i, dtos.RestoreStreamFailed, err)
+			return err
+		}
+		r.setState(i, dtos.RestoreStreamReceived, nil)
+		if i < len(r.streams)-1 {
+			intermediates = append(intermediates, subvol)
+		}
+		restored = subvol
+	}
+
+	if r.writable {
+		mountPath, err := GetBtrfsRootMount(dtos.BtrfsVolume{UUID: r.volumeUUID})
+		if err != nil {
+			return err
+		}
+		//The received UUID is kept, -f is needed to clear the flag of a received subvolume
+		_, err = runBtrfsCommand("property", "set", "-f", "-ts", subvolumePath(mountPath, restored.RelativePath), propReadOnly, "false")
+		if err != nil {
+			return err
+		}
+		restored.ReadOnly = false
+	}
+	r.mtx.Lock()
+	r.progress.Subvolume = &restored
+	r.mtx.Unlock()
+
+	if r.mountPath == "" {
+		return nil
+	}
+	err := MountPointCache.Rescan()
+	if err != nil {
+		return err
+	}
+	_, err = ReplaceMount(dtos.BtrfsVolume{UUID: r.volumeUUID}, restored.RelativePath, r.mountPath, r.options)
+	return err
+}
+
+//receiveStream verifies the i-th stream file and receives it into the target path
+func (r *RestoreOperation) receiveStream(i int, stream dtos.BackupStream) (dtos.BtrfsSubVolume, error) {
+	err := verifyStreamFile(r.dir, stream)
+	if err != nil {
+		return dtos.BtrfsSubVolume{}, err
+	}
+	file, err := os.Open(filepath.Join(r.dir, stream.File))
+	if err != nil {
+		return dtos.BtrfsSubVolume{}, err
+	}
+	defer file.Close()
+
+	receive, err := StartReceive(r.volumeUUID, r.targetPath, filepath.Base(stream.SnapshotPath), stream.SnapshotUUID)
+	if err != nil {
+		return dtos.BtrfsSubVolume{}, err
+	}
+	r.mtx.Lock()
+	r.receive = receive
+	cancelled := r.cancelled
+	r.progress.Streams[i].State = dtos.RestoreStreamReceiving
+	r.mtx.Unlock()
+	defer func() {
+		r.mtx.Lock()
+		r.receive = nil
+		r.mtx.Unlock()
+	}()
+	if cancelled {
+		receive.Abort()
+		return dtos.BtrfsSubVolume{}, ErrRestoreCancelled
+	}
+
+	var subvol dtos.BtrfsSubVolume
+	_, err = io.Copy(io.MultiWriter(receive, countingWriter{&r.bytesDone[i]}), file)
+	if err != nil {
+		receive.Abort()
+	} else {
+		subvol, err = receive.Finish()
+	}
+	if err != nil && r.isCancelled() {
+		return subvol, ErrRestoreCancelled
+	}
+	return subvol, err
+}
+
+func (r *RestoreOperation) isCancelled() bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.cancelled
+}
+
+//Cancel stops the receive of the current stream, its incomplete subvolume is deleted
+func (r *RestoreOperation) Cancel() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.cancelled = true
+	if r.receive != nil {
+		return r.receive.cmd.Process.Kill()
+	}
+	return nil
+}
+
+//Progress returns the current dtos.RestoreProgress of the restore
+func (r *RestoreOperation) Progress() (interface{}, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	progress := r.progress
+	progress.Streams = append([]dtos.RestoreStreamProgress{}, r.progress.Streams...)
+	for i := range progress.Streams {
+		progress.Streams[i].BytesDone = atomic.LoadUint64(&r.bytesDone[i])
+	}
+	return progress, nil
+}
@@ -0,0 +1,27 @@
+package osinterface
+
+import (
+	"testing"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreChain(t *testing.T) {
+	chain, err := restoreChain(backupTestStreams, "a4")
+	assert.NoError(t, err)
+	assert.EqualValues(t, []dtos.BackupStream{backupTestStreams[3], backupTestStreams[4]}, chain)
+
+	chain, err = restoreChain(backupTestStreams, "b1")
+	assert.NoError(t, err)
+	assert.EqualValues(t, backupTestStreams[2:3], chain)
+
+	_, err = restoreChain(backupTestStreams, "a5")
+	assert.Equal(t, ErrBackupStreamNotFound, err)
+	_, err = restoreChain(backupTestStreams[1:], "a2")
+	assert.Equal(t, ErrBackupStreamNotFound, err)
+
+	circular := []dtos.BackupStream{{ID: "c1", ParentID: "c2"}, {ID: "c2", ParentID: "c1"}}
+	_, err = restoreChain(circular, "c1")
+	assert.EqualError(t, err, "Circular stream dependency at c1")
+}
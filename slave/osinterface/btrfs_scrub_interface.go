@@ -0,0 +1,146 @@
+package osinterface
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+)
+
+var scrubStatMatcher = regexp.MustCompile(`^\s*([a-z_]+):\s+([0-9]+)\s*$`)
+
+/*ScrubOperation runs btrfs scrub on a volume. It satisfies the
+tasks.PausableOperation and tasks.CancellableOperation interfaces. A paused
+scrub is cancelled in the kernel and continued with btrfs scrub resume.*/
+type ScrubOperation struct {
+	mountPath    string
+	readOnly     bool
+	bytesToScrub uint64
+}
+
+/*NewScrubOperation constructs a scrub of the given volume. The volume's root is
+mounted if necessary. If readOnly is set, detected errors are not repaired.*/
+func NewScrubOperation(vol dtos.BtrfsVolume, readOnly bool) (*ScrubOperation, error) {
+	mountPath, err := GetBtrfsRootMount(vol)
+	if err != nil {
+		return nil, err
+	}
+	s := &ScrubOperation{mountPath: mountPath, readOnly: readOnly}
+	usage, err := ProbeVolumeUsage(mountPath)
+	if err == nil {
+		s.bytesToScrub = usage.Used
+	}
+	return s, nil
+}
+
+func (s *ScrubOperation) scrubCommand(subCommand string) error {
+	options := []string{"scrub", subCommand, "-B"}
+	if s.readOnly {
+		options = append(options, "-r")
+	}
+	options = append(options, s.mountPath)
+	_, err := runBtrfsCommand(options...)
+	return err
+}
+
+//Run starts the scrub and blocks until it finishes or is cancelled
+func (s *ScrubOperation) Run() error {
+	return s.scrubCommand("start")
+}
+
+//Pause cancels the running scrub, the kernel keeps the position for resume
+func (s *ScrubOperation) Pause() error {
+	_, err := runBtrfsCommand("scrub", "cancel", s.mountPath)
+	return err
+}
+
+//Resume continues a paused scrub and blocks until it finishes or is cancelled
+func (s *ScrubOperation) Resume() error {
+	return s.scrubCommand("resume")
+}
+
+//Cancel cancels the scrub. A paused scrub is not running, so there is nothing to do.
+func (s *ScrubOperation) Cancel() error {
+	status, err := s.status()
+	if err != nil {
+		return err
+	}
+	if status != "running" {
+		return nil
+	}
+	_, err = runBtrfsCommand("scrub", "cancel", s.mountPath)
+	return err
+}
+
+func (s *ScrubOperation) status() (string, error) {
+	output, err := runBtrfsCommand("scrub", "status", s.mountPath)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case strings.Contains(output, "running"):
+		return "running", nil
+	case strings.Contains(output, "aborted"), strings.Contains(output, "interrupted"):
+		return "aborted", nil
+	}
+	return "finished", nil
+}
+
+//Progress returns the current dtos.ScrubProgress of the scrub
+func (s *ScrubOperation) Progress() (interface{}, error) {
+	output, err := runBtrfsCommand("scrub", "status", "-R", s.mountPath)
+	if err != nil {
+		return nil, err
+	}
+	progress, err := parseScrubStatus(output)
+	if err != nil {
+		return nil, err
+	}
+	progress.BytesToScrub = s.bytesToScrub
+	return progress, nil
+}
+
+/*parseScrubStatus parses the raw statistics printed by btrfs scrub status -R,
+for example:
+	data_bytes_scrubbed: 1179648*/
+func parseScrubStatus(output string) (progress dtos.ScrubProgress, err error) {
+	found := false
+	for _, line := range strings.Split(output, "\n") {
+		match := scrubStatMatcher.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		value, err := strconv.ParseUint(match[2], 10, 64)
+		if err != nil {
+			return progress, err
+		}
+		found = true
+
+		switch match[1] {
+		case "data_extents_scrubbed":
+			progress.DataExtentsScrubbed = value
+		case "tree_extents_scrubbed":
+			progress.TreeExtentsScrubbed = value
+		case "data_bytes_scrubbed", "tree_bytes_scrubbed":
+			progress.BytesScrubbed += value
+		case "read_errors":
+			progress.ReadErrors = value
+		case "csum_errors":
+			progress.CSumErrors = value
+		case "verify_errors":
+			progress.VerifyErrors = value
+		case "super_errors":
+			progress.SuperErrors = value
+		case "corrected_errors":
+			progress.CorrectedErrors = value
+		case "uncorrectable_errors":
+			progress.UncorrectableErrors = value
+		}
+	}
+	if !found {
+		err = errors.New("Unable to parse scrub status: " + output)
+	}
+	return
+}
@@ -0,0 +1,45 @@
+package osinterface
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const scrubStatusOutput = `UUID:             9c5fb5e5-3a3e-4ea4-a5a1-5e0c1b9b5d0e
+Scrub started:    Sat Jun 11 12:00:00 2016
+Status:           running
+Duration:         0:00:12
+	data_extents_scrubbed: 1024
+	tree_extents_scrubbed: 16
+	data_bytes_scrubbed: 67108864
+	tree_bytes_scrubbed: 262144
+	read_errors: 1
+	csum_errors: 2
+	verify_errors: 0
+	no_csum: 0
+	csum_discards: 0
+	super_errors: 0
+	malloc_errors: 0
+	uncorrectable_errors: 1
+	unverified_errors: 0
+	corrected_errors: 2
+	last_physical: 1103101952
+`
+
+func TestParseScrubStatus(t *testing.T) {
+	progress, err := parseScrubStatus(scrubStatusOutput)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 67108864+262144, progress.BytesScrubbed)
+	assert.EqualValues(t, 1024, progress.DataExtentsScrubbed)
+	assert.EqualValues(t, 16, progress.TreeExtentsScrubbed)
+	assert.EqualValues(t, 1, progress.ReadErrors)
+	assert.EqualValues(t, 2, progress.CSumErrors)
+	assert.EqualValues(t, 2, progress.CorrectedErrors)
+	assert.EqualValues(t, 1, progress.UncorrectableErrors)
+}
+
+func TestParseScrubStatusNoStats(t *testing.T) {
+	_, err := parseScrubStatus("scrub status for 9c5fb5e5-3a3e-4ea4-a5a1-5e0c1b9b5d0e\n\tno stats available\n")
+	assert.Error(t, err)
+}
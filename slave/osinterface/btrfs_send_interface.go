@@ -0,0 +1,274 @@
+package osinterface
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+)
+
+//ErrCodeSendRefused is the dtos.Error code of a refused btrfs send
+const ErrCodeSendRefused = "send_refused"
+
+//ErrCodeReceiveRefused is the dtos.Error code of a refused btrfs receive
+const ErrCodeReceiveRefused = "receive_refused"
+
+//dtos.ErrorCause codes of a refused btrfs send or receive
+const (
+	CauseSubvolumeNotFound = "subvolume_not_found"
+	CauseNotReadOnly       = "not_read_only"
+	CauseTargetExists      = "target_exists"
+	CauseAlreadyReceived   = "already_received"
+	CauseInvalidName       = "invalid_name"
+)
+
+//ErrTargetNotDirectory indicates that the receive target is not a directory
+var ErrTargetNotDirectory = errors.New("The receive target is not a directory")
+
+//streamCommand is a running program whose output or input is streamed
+type streamCommand struct {
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+}
+
+//wait waits for the program to exit, a failure is returned as a BtrfsCmdError
+func (s *streamCommand) wait() error {
+	err := s.cmd.Wait()
+	if err != nil {
+		return BtrfsCmdError{BaseErr: err.Error(), Details: s.stderr.String()}
+	}
+	return nil
+}
+
+//kill stops the program and waits for it to exit
+func (s *streamCommand) kill() {
+	s.cmd.Process.Kill()
+	s.cmd.Wait()
+}
+
+//findSubVolume finds the subvolume at the path relative to the volume root
+func findSubVolume(subvols []dtos.BtrfsSubVolume, relativePath string) (dtos.BtrfsSubVolume, bool) {
+	path := filepath.Clean("/" + relativePath)
+	for _, subvol := range subvols {
+		if filepath.Clean("/"+subvol.RelativePath) == path {
+			return subvol, true
+		}
+	}
+	return dtos.BtrfsSubVolume{}, false
+}
+
+/*ValidateSend checks whether the snapshot at relativePath, and the parent
+snapshot if parentPath is set, can be sent. Both have to be read-only. The
+returned error is a dtos.Error listing every reason the send is refused.*/
+func ValidateSend(subvols []dtos.BtrfsSubVolume, relativePath string, parentPath string) error {
+	var causes []dtos.ErrorCause
+	paths := []string{relativePath}
+	if parentPath != "" {
+		paths = append(paths, parentPath)
+	}
+	for _, path := range paths {
+		subvol, ok := findSubVolume(subvols, path)
+		if !ok {
+			causes = append(causes, dtos.ErrorCause{
+				Code:    CauseSubvolumeNotFound,
+				Message: "Subvolume not found: " + path,
+			})
+		} else if !subvol.ReadOnly {
+			causes = append(causes, dtos.ErrorCause{
+				Code:    CauseNotReadOnly,
+				Message: "Only read-only snapshots can be sent: " + path,
+			})
+		}
+	}
+
+	if len(causes) == 0 {
+		return nil
+	}
+	return newRefusalError(ErrCodeSendRefused, "Send refused", causes)
+}
+
+/*ValidateReceive checks whether a snapshot with the source UUID can be received
+as name in the directory targetPath. The returned error is a dtos.Error listing
+every reason the receive is refused.*/
+func ValidateReceive(subvols []dtos.BtrfsSubVolume, targetPath string, name string, sourceUUID dtos.UUIDType) error {
+	var causes []dtos.ErrorCause
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		causes = append(causes, dtos.ErrorCause{
+			Code:    CauseInvalidName,
+			Message: "Invalid subvolume name: " + name,
+		})
+	} else if _, exists := findSubVolume(subvols, filepath.Join(targetPath, name)); exists {
+		causes = append(causes, dtos.ErrorCause{
+			Code:    CauseTargetExists,
+			Message: "A subvolume already exists at " + filepath.Join(targetPath, name),
+		})
+	}
+	for _, subvol := range subvols {
+		if sourceUUID != "" && subvol.ReceivedUUID == sourceUUID {
+			causes = append(causes, dtos.ErrorCause{
+				Code:    CauseAlreadyReceived,
+				Message: "The snapshot was already received at " + subvol.RelativePath,
+			})
+		}
+	}
+
+	if len(causes) == 0 {
+		return nil
+	}
+	return newRefusalError(ErrCodeReceiveRefused, "Receive refused", causes)
+}
+
+//sendOptions builds the command line options of btrfs send
+func sendOptions(mountPath string, relativePath string, parentPath string) []string {
+	options := []string{"send", "-q"}
+	if parentPath != "" {
+		options = append(options, "-p", subvolumePath(mountPath, parentPath))
+	}
+	return append(options, subvolumePath(mountPath, relativePath))
+}
+
+/*SendStream is the output of a running btrfs send. It has to be read until
+EOF and then waited for.*/
+type SendStream struct {
+	io.Reader
+	streamCommand
+}
+
+/*StartSend validates and starts the btrfs send of the read-only snapshot at the
+path relative to the root of the volume identified by UUID. If parentPath is set
+only the changes since the parent snapshot are sent.*/
+func StartSend(UUID dtos.UUIDType, relativePath string, parentPath string) (*SendStream, error) {
+	mountPath, err := GetBtrfsRootMount(dtos.BtrfsVolume{UUID: UUID})
+	if err != nil {
+		return nil, err
+	}
+	subvols, err := ProbeSubVolumes(mountPath)
+	if err != nil {
+		return nil, err
+	}
+	err = ValidateSend(subvols, relativePath, parentPath)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SendStream{}
+	s.cmd = exec.Command(btrfsCmd, sendOptions(mountPath, relativePath, parentPath)...)
+	s.cmd.Stderr = &s.stderr
+	s.Reader, err = s.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = s.cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+//Wait waits for the send to exit once the stream was read
+func (s *SendStream) Wait() error {
+	return s.wait()
+}
+
+//Kill stops the send
+func (s *SendStream) Kill() {
+	s.kill()
+}
+
+/*ReceiveStream is the input of a running btrfs receive. It has to be finished
+once the whole stream was written, or aborted.*/
+type ReceiveStream struct {
+	io.WriteCloser
+	streamCommand
+	mountPath    string
+	relativePath string
+	sourceUUID   dtos.UUIDType
+}
+
+/*StartReceive validates and starts the btrfs receive of the snapshot with the
+source UUID into the directory at the path relative to the root of the volume
+identified by UUID. The received subvolume is named name. The source UUID is the
+StreamUUID of the sent snapshot, it becomes the received UUID of the copy.*/
+func StartReceive(UUID dtos.UUIDType, targetPath string, name string, sourceUUID dtos.UUIDType) (*ReceiveStream, error) {
+	mountPath, err := GetBtrfsRootMount(dtos.BtrfsVolume{UUID: UUID})
+	if err != nil {
+		return nil, err
+	}
+	subvols, err := ProbeSubVolumes(mountPath)
+	if err != nil {
+		return nil, err
+	}
+	err = ValidateReceive(subvols, targetPath, name, sourceUUID)
+	if err != nil {
+		return nil, err
+	}
+	dir := subvolumePath(mountPath, targetPath)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, ErrTargetNotDirectory
+	}
+
+	r := &ReceiveStream{
+		mountPath:    mountPath,
+		relativePath: filepath.Join(targetPath, name),
+		sourceUUID:   sourceUUID,
+	}
+	r.cmd = exec.Command(btrfsCmd, "receive", "-e", dir)
+	r.cmd.Stderr = &r.stderr
+	r.WriteCloser, err = r.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = r.cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+/*Finish closes the stream, waits for the receive to exit and returns the
+received subvolume. If the receive failed, the incomplete subvolume is deleted.*/
+func (r *ReceiveStream) Finish() (dtos.BtrfsSubVolume, error) {
+	r.Close()
+	err := r.wait()
+	if err != nil {
+		r.deleteIncomplete()
+		return dtos.BtrfsSubVolume{}, err
+	}
+	subvols, err := ProbeSubVolumes(r.mountPath)
+	if err != nil {
+		return dtos.BtrfsSubVolume{}, err
+	}
+	subvol, ok := findSubVolume(subvols, r.relativePath)
+	if !ok || subvol.ReceivedUUID != r.sourceUUID {
+		return dtos.BtrfsSubVolume{}, errors.New("Received subvolume not found at " + r.relativePath)
+	}
+	return subvol, nil
+}
+
+//Abort stops the receive and deletes the incomplete subvolume
+func (r *ReceiveStream) Abort() {
+	r.Close()
+	r.kill()
+	r.deleteIncomplete()
+}
+
+/*deleteIncomplete deletes the subvolume created by a failed receive. The
+received UUID is set only once a receive completes.*/
+func (r *ReceiveStream) deleteIncomplete() {
+	subvols, err := ProbeSubVolumes(r.mountPath)
+	if err != nil {
+		return
+	}
+	subvol, ok := findSubVolume(subvols, r.relativePath)
+	if ok && subvol.ReceivedUUID == "" {
+		runBtrfsCommand("subvolume", "delete", subvolumePath(r.mountPath, r.relativePath))
+	}
+}
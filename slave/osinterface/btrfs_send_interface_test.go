@@ -0,0 +1,61 @@
+package osinterface
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"testing"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/stretchr/testify/assert"
+)
+
+var sendTestSubvols = []dtos.BtrfsSubVolume{
+	{RelativePath: "data", UUID: "data-uuid"},
+	{RelativePath: "snapshots/1", UUID: "snap1-uuid", ReadOnly: true},
+	{RelativePath: "snapshots/2", UUID: "snap2-uuid", ReadOnly: true},
+	{RelativePath: "received/1", UUID: "recv1-uuid", ReceivedUUID: "snap1-uuid", ReadOnly: true},
+}
+
+func TestValidateSend(t *testing.T) {
+	assert.NoError(t, ValidateSend(sendTestSubvols, "snapshots/2", ""))
+	assert.NoError(t, ValidateSend(sendTestSubvols, "/snapshots/2", "snapshots/1"))
+
+	err := ValidateSend(sendTestSubvols, "data", "snapshots/3")
+	assert.EqualValues(t, ErrCodeSendRefused, err.(dtos.Error).Code)
+	assert.EqualValues(t, []string{CauseNotReadOnly, CauseSubvolumeNotFound}, causeCodes(err))
+}
+
+func TestValidateReceive(t *testing.T) {
+	assert.NoError(t, ValidateReceive(sendTestSubvols, "received", "2", "snap2-uuid"))
+
+	err := ValidateReceive(sendTestSubvols, "received", "1", "snap1-uuid")
+	assert.EqualValues(t, ErrCodeReceiveRefused, err.(dtos.Error).Code)
+	assert.EqualValues(t, []string{CauseTargetExists, CauseAlreadyReceived}, causeCodes(err))
+
+	err = ValidateReceive(sendTestSubvols, "received", "../data", "snap2-uuid")
+	assert.EqualValues(t, []string{CauseInvalidName}, causeCodes(err))
+}
+
+func TestSendOptions(t *testing.T) {
+	assert.EqualValues(t, []string{"send", "-q", "/mnt/vol/snapshots/2"},
+		sendOptions("/mnt/vol", "snapshots/2", ""))
+	assert.EqualValues(t, []string{"send", "-q", "-p", "/mnt/vol/snapshots/1", "/mnt/vol/snapshots/2"},
+		sendOptions("/mnt/vol", "snapshots/2", "snapshots/1"))
+}
+
+func TestStreamCommand(t *testing.T) {
+	s := &SendStream{}
+	s.cmd = exec.Command("sh", "-c", "printf stream; echo failed >&2; exit 1")
+	s.cmd.Stderr = &s.stderr
+	var err error
+	s.Reader, err = s.cmd.StdoutPipe()
+	assert.NoError(t, err)
+	assert.NoError(t, s.cmd.Start())
+
+	data, err := ioutil.ReadAll(s)
+	assert.NoError(t, err)
+	assert.EqualValues(t, "stream", string(data))
+	err = s.Wait()
+	assert.IsType(t, BtrfsCmdError{}, err)
+	assert.EqualValues(t, "failed\n", err.(BtrfsCmdError).Details)
+}
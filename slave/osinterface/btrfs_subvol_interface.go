@@ -3,30 +3,51 @@ package osinterface
 import (
 	"bytes"
 	"errors"
-	"fmt"
-	"io"
+	"log"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/djarek/btrfs-volume-manager/common/dtos"
 )
 
-const (
-	btrfsCmd       = "btrfs"
+var (
+	//btrfsCmd is the btrfs program of btrfs-progs
+	btrfsCmd = "btrfs"
+	//rootMountsPath is the directory the roots of the volumes are mounted in
 	rootMountsPath = "/mnt"
 )
 
+/*Configure sets the btrfs program, the directory the roots of the volumes are
+mounted in and the directory the backup targets are in. It has to be called
+before the caches are scanned.*/
+func Configure(btrfsProgram string, rootMounts string, backups string) {
+	btrfsCmd = btrfsProgram
+	rootMountsPath = rootMounts
+	backupDir = backups
+}
+
 var runBtrfsCommand = func(options ...string) (outputString string, err error) {
-	cmd := exec.Command(btrfsCmd, options...)
+	return runCommand(btrfsCmd, options...)
+}
+
+/*runCommand runs a program of btrfs-progs (or util-linux) and returns its
+standard output. If the program fails a BtrfsCmdError is returned.*/
+var runCommand = func(name string, options ...string) (outputString string, err error) {
+	cmd := exec.Command(name, options...)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	output, err := cmd.Output()
 	if err != nil {
-		//The btrfs tool returned an error or it was not found in the OS
+		//The tool returned an error or it was not found in the OS
 		err = BtrfsCmdError{
 			BaseErr: err.Error(),
 			Details: stderr.String(),
+			Output:  string(output),
 		}
 		return
 	}
@@ -37,44 +58,133 @@ var runBtrfsCommand = func(options ...string) (outputString string, err error) {
 
 /*ProbeSubVolumes probes the kernel and retrieves all subvolumes present
 in a btrfs volume. The mountPath is the path to any mount point of a volume
-(or a path below the mount point).
+(or a path below the mount point). The root tree is searched with ioctls, if
+that fails the output of the btrfs tool is parsed instead. If the default
+subvolume cannot be probed, no subvolume is marked as the default.
 */
 func ProbeSubVolumes(mountPath string) (subvols []dtos.BtrfsSubVolume, err error) {
-	options := "-tqu"
-	output, err := runBtrfsCommand("subvolume", "list", mountPath, options)
+	subvols, err = probeSubVolumesIoctl(mountPath)
 	if err != nil {
+		log.Println("Subvolume search ioctl failed, falling back to the btrfs tool: " + err.Error())
+		subvols, err = probeSubVolumesCmd(mountPath)
+		if err != nil {
+			return
+		}
+	}
+
+	defaultID, defaultErr := ProbeDefaultSubVolume(mountPath)
+	if defaultErr != nil {
+		log.Println("Unable to probe the default subvolume: " + defaultErr.Error())
 		return
 	}
+	for i := range subvols {
+		subvols[i].Default = subvols[i].SubVolID == defaultID
+	}
+	return
+}
 
-	var subvol dtos.BtrfsSubVolume
-	var gen, topLevel int
-
-	//Get lines and skip the first two, because they contain the list header:
-	//ID      gen     top level       parent_uuid     uuid    path
-	//--      ---     ---------       -----------     ----    ----
-	lines := strings.Split(output, "\n")
-	lines = lines[2:]
-	for _, line := range lines {
-		_, err = fmt.Sscanf(line, "%d %d %d %s %s %s",
-			&subvol.SubVolID,
-			&gen,      //unused by us
-			&topLevel, //unused by us
-			&subvol.ParentUUID,
-			&subvol.VolumeUUID,
-			&subvol.RelativePath,
-		)
-		if err != nil {
-			if err != io.EOF {
-				return
+func probeSubVolumesCmd(mountPath string) (subvols []dtos.BtrfsSubVolume, err error) {
+	output, err := runBtrfsCommand("subvolume", "list", "-pguqR", mountPath)
+	if err != nil {
+		return
+	}
+	subvols, err = parseSubvolumeList(output)
+	if err != nil {
+		return
+	}
+
+	//The read-only flag is not printed, it is only available as a list filter
+	output, err = runBtrfsCommand("subvolume", "list", "-r", mountPath)
+	if err != nil {
+		return
+	}
+	readOnly, err := parseSubvolumeList(output)
+	if err != nil {
+		return
+	}
+	readOnlyIDs := make(map[int]bool)
+	for _, subvol := range readOnly {
+		readOnlyIDs[subvol.SubVolID] = true
+	}
+	for i := range subvols {
+		if readOnlyIDs[subvols[i].SubVolID] {
+			subvols[i].ReadOnly = true
+			subvols[i].Flags |= btrfsRootSubvolRdonly
+		}
+	}
+	return
+}
+
+func parseListUUID(s string) dtos.UUIDType {
+	if s == "-" {
+		return ""
+	}
+	return dtos.UUIDType(s)
+}
+
+/*parseSubvolumeList parses the output of btrfs subvolume list, for example:
+ID 257 gen 9 parent 5 top level 5 parent_uuid - received_uuid - uuid 2c6... path a dir
+The path is always the last column and may contain spaces.*/
+func parseSubvolumeList(output string) (subvols []dtos.BtrfsSubVolume, err error) {
+	for _, line := range strings.Split(output, "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		pathIdx := strings.Index(line, " path ")
+		if !strings.HasPrefix(line, "ID ") || pathIdx < 0 {
+			return nil, errors.New("Unexpected subvolume list line: " + line)
+		}
+
+		subvol := dtos.BtrfsSubVolume{RelativePath: line[pathIdx+len(" path "):]}
+		fields := strings.Fields(strings.Replace(line[:pathIdx], "top level", "top_level", 1))
+		for i := 0; i+1 < len(fields); i += 2 {
+			value := fields[i+1]
+			switch fields[i] {
+			case "ID":
+				subvol.SubVolID, err = strconv.Atoi(value)
+			case "gen":
+				subvol.Generation, err = strconv.ParseUint(value, 10, 64)
+			case "parent":
+				subvol.ParentID, err = strconv.Atoi(value)
+			case "parent_uuid":
+				subvol.ParentUUID = parseListUUID(value)
+			case "received_uuid":
+				subvol.ReceivedUUID = parseListUUID(value)
+			case "uuid":
+				subvol.UUID = parseListUUID(value)
+			}
+			if err != nil {
+				return nil, err
 			}
-			err = nil
-			return
 		}
 		subvols = append(subvols, subvol)
 	}
 	return
 }
 
+var defaultSubvolMatcher = regexp.MustCompile(`^ID ([0-9]+)`)
+
+/*ProbeDefaultSubVolume retrieves the ID of the default subvolume of the volume
+mounted at mountPath. The top level subvolume has ID 5.*/
+func ProbeDefaultSubVolume(mountPath string) (int, error) {
+	output, err := runBtrfsCommand("subvolume", "get-default", mountPath)
+	if err != nil {
+		return 0, err
+	}
+	match := defaultSubvolMatcher.FindStringSubmatch(strings.TrimSpace(output))
+	if match == nil {
+		return 0, errors.New("Unable to parse default subvolume: " + output)
+	}
+	return strconv.Atoi(match[1])
+}
+
+/*SetDefaultSubVolume makes the subvolume with the ID the default subvolume of
+the volume mounted at mountPath.*/
+func SetDefaultSubVolume(mountPath string, subvolID int) error {
+	_, err := runBtrfsCommand("subvolume", "set-default", strconv.Itoa(subvolID), mountPath)
+	return err
+}
+
 func runBtrfsSubvolumeCommand(vol dtos.BtrfsVolume, subvolRelativePath string, subCommand string) error {
 	mountPath, err := GetBtrfsRootMount(vol)
 	if err != nil {
@@ -110,17 +220,110 @@ func DeleteSubVolume(vol dtos.BtrfsVolume, subvolRelativePath string) error {
 	return nil
 }
 
+//SnapshotOptions describes how a snapshot is created
+type SnapshotOptions struct {
+	//ReadOnly makes the snapshot and all nested snapshots read-only
+	ReadOnly bool
+	//Recursive snapshots the nested subvolumes into the matching places of the target
+	Recursive bool
+}
+
 /*CreateSnapshot attempts to create a snapshot of a subvolume at the specified
 path (relative to the volume root). If the volume's root is not mounted this
 function returns an error.*/
-func CreateSnapshot(subvol dtos.BtrfsSubVolume, snapshotRelativePath string) error {
+func CreateSnapshot(subvol dtos.BtrfsSubVolume, snapshotRelativePath string, opts SnapshotOptions) error {
 	mountPath, err := GetBtrfsRootMount(dtos.BtrfsVolume{UUID: subvol.VolumeUUID})
 	if err != nil {
 		return err
 	}
+	if !opts.Recursive {
+		options := []string{"subvolume", "snapshot"}
+		if opts.ReadOnly {
+			options = append(options, "-r")
+		}
+		options = append(options, subvolumePath(mountPath, subvol.RelativePath),
+			subvolumePath(mountPath, snapshotRelativePath))
+		_, err = runBtrfsCommand(options...)
+		return err
+	}
 
-	path := filepath.Join(mountPath, snapshotRelativePath)
-	sourcePath := filepath.Join(mountPath, subvol.RelativePath)
-	_, err = runBtrfsCommand("subvolume", "snapshot", sourcePath, path)
-	return err
+	subvols, err := ProbeSubVolumes(mountPath)
+	if err != nil {
+		return err
+	}
+	return createRecursiveSnapshot(mountPath, subvols, subvol.RelativePath, snapshotRelativePath, opts.ReadOnly)
+}
+
+//parentsFirst sorts relative paths by depth
+type parentsFirst []string
+
+func (p parentsFirst) Len() int      { return len(p) }
+func (p parentsFirst) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p parentsFirst) Less(i, j int) bool {
+	return strings.Count(p[i], "/") < strings.Count(p[j], "/")
+}
+
+/*nestedSubVolumes returns the paths of the subvolumes below the source path,
+relative to the source. Parents come before their children.*/
+func nestedSubVolumes(subvols []dtos.BtrfsSubVolume, sourceRelativePath string) []string {
+	source := filepath.Clean("/" + sourceRelativePath)
+	var nested []string
+	for _, subvol := range subvols {
+		path := filepath.Clean("/" + subvol.RelativePath)
+		rel, err := filepath.Rel(source, path)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		nested = append(nested, rel)
+	}
+	sort.Stable(parentsFirst(nested))
+	return nested
+}
+
+/*createRecursiveSnapshot snapshots the source and then every nested subvolume.
+A snapshot contains an empty directory in place of each nested subvolume, it is
+replaced by the snapshot of the nested subvolume. The snapshots are made
+read-only once all of them exist, children first. If any step fails, the
+created snapshots are deleted.*/
+func createRecursiveSnapshot(mountPath string, subvols []dtos.BtrfsSubVolume, source string, target string, readOnly bool) (err error) {
+	sourcePath := subvolumePath(mountPath, source)
+	targetPath := subvolumePath(mountPath, target)
+	var created []string
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(created) - 1; i >= 0; i-- {
+			runBtrfsCommand("subvolume", "delete", created[i])
+		}
+	}()
+
+	_, err = runBtrfsCommand("subvolume", "snapshot", sourcePath, targetPath)
+	if err != nil {
+		return
+	}
+	created = append(created, targetPath)
+
+	for _, rel := range nestedSubVolumes(subvols, source) {
+		nestedTarget := filepath.Join(targetPath, rel)
+		err = os.Remove(nestedTarget)
+		if err != nil {
+			return
+		}
+		_, err = runBtrfsCommand("subvolume", "snapshot", filepath.Join(sourcePath, rel), nestedTarget)
+		if err != nil {
+			return
+		}
+		created = append(created, nestedTarget)
+	}
+
+	if readOnly {
+		for i := len(created) - 1; i >= 0; i-- {
+			_, err = runBtrfsCommand("property", "set", "-ts", created[i], propReadOnly, "true")
+			if err != nil {
+				return
+			}
+		}
+	}
+	return nil
 }
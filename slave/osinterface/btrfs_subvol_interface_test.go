@@ -0,0 +1,172 @@
+package osinterface
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSubvolumeList(t *testing.T) {
+	output := "ID 257 gen 12 parent 5 top level 5 parent_uuid - received_uuid - uuid 2c6fbf2a-5a7e-d44e-9e9e-1a2b3c4d5e6f path home\n" +
+		"ID 258 gen 14 parent 257 top level 257 parent_uuid 2c6fbf2a-5a7e-d44e-9e9e-1a2b3c4d5e6f received_uuid - uuid 7d1d8f07-0a4e-1a4b-8d3b-6f5e4d3c2b1a path home/my snapshot\n"
+
+	subvols, err := parseSubvolumeList(output)
+	assert.NoError(t, err)
+	assert.Len(t, subvols, 2)
+
+	assert.EqualValues(t, 257, subvols[0].SubVolID)
+	assert.EqualValues(t, 12, subvols[0].Generation)
+	assert.EqualValues(t, 5, subvols[0].ParentID)
+	assert.EqualValues(t, "", subvols[0].ParentUUID)
+	assert.EqualValues(t, "2c6fbf2a-5a7e-d44e-9e9e-1a2b3c4d5e6f", subvols[0].UUID)
+	assert.EqualValues(t, "home", subvols[0].RelativePath)
+
+	assert.EqualValues(t, 257, subvols[1].ParentID)
+	assert.EqualValues(t, subvols[0].UUID, subvols[1].ParentUUID)
+	assert.EqualValues(t, "home/my snapshot", subvols[1].RelativePath)
+}
+
+func TestProbeSubVolumesWithoutDefault(t *testing.T) {
+	mountPath, err := ioutil.TempDir("", "subvols")
+	assert.NoError(t, err)
+	defer os.RemoveAll(mountPath)
+
+	oldRunBtrfsCommand := runBtrfsCommand
+	defer func() { runBtrfsCommand = oldRunBtrfsCommand }()
+	getDefaultErr := errors.New("ERROR: get-default failed")
+	runBtrfsCommand = func(options ...string) (string, error) {
+		if options[1] == "get-default" {
+			if getDefaultErr != nil {
+				return "", getDefaultErr
+			}
+			return "ID 257 gen 12 top level 5 path home\n", nil
+		}
+		return "ID 257 gen 12 parent 5 top level 5 parent_uuid - received_uuid - uuid 2c6fbf2a-5a7e-d44e-9e9e-1a2b3c4d5e6f path home\n", nil
+	}
+
+	//the temporary directory is not btrfs, the btrfs tool is used
+	subvols, err := ProbeSubVolumes(mountPath)
+	assert.NoError(t, err)
+	assert.Len(t, subvols, 1)
+	assert.False(t, subvols[0].Default)
+
+	getDefaultErr = nil
+	subvols, err = ProbeSubVolumes(mountPath)
+	assert.NoError(t, err)
+	assert.True(t, subvols[0].Default)
+}
+
+func TestParseSubvolumeListMalformed(t *testing.T) {
+	_, err := parseSubvolumeList("ERROR: not a btrfs filesystem\n")
+	assert.Error(t, err)
+}
+
+func TestParseRootItem(t *testing.T) {
+	data := make([]byte, rootItemSize)
+	le := binary.LittleEndian
+	le.PutUint64(data[rootItemGenerationOffset:], 42)
+	le.PutUint64(data[rootItemFlagsOffset:], btrfsRootSubvolRdonly)
+	for i := 0; i < btrfsUUIDSize; i++ {
+		data[rootItemUUIDOffset+i] = byte(i + 1)
+	}
+	le.PutUint64(data[rootItemOTimeOffset:], 1466000000)
+	le.PutUint32(data[rootItemOTimeOffset+8:], 500)
+
+	var subvol dtos.BtrfsSubVolume
+	parseRootItem(data, &subvol)
+	assert.EqualValues(t, 42, subvol.Generation)
+	assert.True(t, subvol.ReadOnly)
+	assert.EqualValues(t, "01020304-0506-0708-090a-0b0c0d0e0f10", subvol.UUID)
+	assert.EqualValues(t, "", subvol.ParentUUID)
+	assert.EqualValues(t, "", subvol.ReceivedUUID)
+	assert.True(t, time.Unix(1466000000, 500).Equal(subvol.CreationTime))
+}
+
+func TestParseRootRef(t *testing.T) {
+	name := "snapshots"
+	data := make([]byte, rootRefNameOffset+len(name))
+	binary.LittleEndian.PutUint64(data[rootRefDirIDOffset:], 256)
+	binary.LittleEndian.PutUint16(data[rootRefNameLenOffset:], uint16(len(name)))
+	copy(data[rootRefNameOffset:], name)
+
+	ref, ok := parseRootRef(5, data)
+	assert.True(t, ok)
+	assert.EqualValues(t, 5, ref.parentTreeID)
+	assert.EqualValues(t, 256, ref.dirID)
+	assert.EqualValues(t, name, ref.name)
+
+	_, ok = parseRootRef(5, data[:rootRefNameOffset+2])
+	assert.False(t, ok)
+}
+
+func TestNestedSubVolumes(t *testing.T) {
+	subvols := []dtos.BtrfsSubVolume{
+		{RelativePath: "home"},
+		{RelativePath: "home/user/cache/tmp"},
+		{RelativePath: "home/user"},
+		{RelativePath: "homes"},
+		{RelativePath: "var/lib"},
+	}
+	assert.EqualValues(t, []string{"user", "user/cache/tmp"}, nestedSubVolumes(subvols, "home"))
+	assert.EqualValues(t, []string{"lib"}, nestedSubVolumes(subvols, "/var/"))
+	assert.Empty(t, nestedSubVolumes(subvols, "homes"))
+}
+
+func TestCreateRecursiveSnapshot(t *testing.T) {
+	mountPath, err := ioutil.TempDir("", "snapshot")
+	assert.NoError(t, err)
+	defer os.RemoveAll(mountPath)
+
+	oldRunBtrfsCommand := runBtrfsCommand
+	defer func() { runBtrfsCommand = oldRunBtrfsCommand }()
+	var commands []string
+	runBtrfsCommand = func(options ...string) (string, error) {
+		commands = append(commands, strings.Join(options, " "))
+		if options[1] == "snapshot" {
+			//A snapshot contains empty directories in place of nested subvolumes
+			os.MkdirAll(filepath.Join(options[3], "user"), 0755)
+		}
+		return "", nil
+	}
+
+	subvols := []dtos.BtrfsSubVolume{{RelativePath: "home"}, {RelativePath: "home/user"}}
+	err = createRecursiveSnapshot(mountPath, subvols, "home", "backup", true)
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{
+		"subvolume snapshot " + mountPath + "/home " + mountPath + "/backup",
+		"subvolume snapshot " + mountPath + "/home/user " + mountPath + "/backup/user",
+		"property set -ts " + mountPath + "/backup/user ro true",
+		"property set -ts " + mountPath + "/backup ro true",
+	}, commands)
+}
+
+func TestCreateRecursiveSnapshotCleanup(t *testing.T) {
+	mountPath, err := ioutil.TempDir("", "snapshot")
+	assert.NoError(t, err)
+	defer os.RemoveAll(mountPath)
+
+	oldRunBtrfsCommand := runBtrfsCommand
+	defer func() { runBtrfsCommand = oldRunBtrfsCommand }()
+	var commands []string
+	runBtrfsCommand = func(options ...string) (string, error) {
+		commands = append(commands, strings.Join(options, " "))
+		return "", nil
+	}
+
+	//The placeholder directory of the nested subvolume is missing
+	subvols := []dtos.BtrfsSubVolume{{RelativePath: "home"}, {RelativePath: "home/user"}}
+	err = createRecursiveSnapshot(mountPath, subvols, "home", "backup", false)
+	assert.Error(t, err)
+	assert.EqualValues(t, []string{
+		"subvolume snapshot " + mountPath + "/home " + mountPath + "/backup",
+		"subvolume delete " + mountPath + "/backup",
+	}, commands)
+}
@@ -0,0 +1,144 @@
+package osinterface
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+)
+
+//btrfsLabelSize is the maximum length of a btrfs label in bytes
+const btrfsLabelSize = 255
+
+//ErrInvalidLabel indicates that a label is too long or contains a newline
+var ErrInvalidLabel = errors.New("The label has to be at most 255 bytes long and must not contain newlines")
+
+var (
+	usageOverallMatcher    = regexp.MustCompile(`^\s*([A-Za-z ()]+):\s+([0-9.]+)(?:\s+\((?:min|used): ([0-9]+)\))?`)
+	usageBlockGroupMatcher = regexp.MustCompile(`^([A-Za-z]+),([A-Za-z0-9]+): Size:([0-9]+), Used:([0-9]+)`)
+	usageDeviceMatcher     = regexp.MustCompile(`^\s+(\S+)\s+([0-9]+)\s*$`)
+)
+
+/*ProbeVolumeUsage retrieves the space usage of the btrfs volume mounted at
+mountPath (or a path below the mount point).*/
+func ProbeVolumeUsage(mountPath string) (dtos.BtrfsVolumeUsage, error) {
+	output, err := runBtrfsCommand("filesystem", "usage", "-b", mountPath)
+	if err != nil {
+		return dtos.BtrfsVolumeUsage{}, err
+	}
+	return parseFilesystemUsage(output)
+}
+
+/*parseFilesystemUsage parses the output of btrfs filesystem usage -b. The output
+consists of the "Overall:" section followed by one section per block group type
+and profile, each listing the allocation on every device, and the
+"Unallocated:" section.*/
+func parseFilesystemUsage(output string) (usage dtos.BtrfsVolumeUsage, err error) {
+	var devices *[]dtos.BtrfsDeviceSpace
+	overall := false
+	for _, line := range strings.Split(output, "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		if match := usageBlockGroupMatcher.FindStringSubmatch(line); match != nil {
+			overall = false
+			blockGroup := dtos.BtrfsBlockGroupUsage{Type: match[1], Profile: match[2]}
+			blockGroup.Allocated, _ = strconv.ParseUint(match[3], 10, 64)
+			blockGroup.Used, _ = strconv.ParseUint(match[4], 10, 64)
+			usage.BlockGroups = append(usage.BlockGroups, blockGroup)
+			devices = &usage.BlockGroups[len(usage.BlockGroups)-1].Devices
+			continue
+		}
+
+		switch strings.TrimSpace(line) {
+		case "Overall:":
+			overall = true
+			continue
+		case "Unallocated:":
+			overall = false
+			devices = &usage.Unallocated
+			continue
+		}
+
+		if overall {
+			parseUsageOverallLine(line, &usage)
+		} else if match := usageDeviceMatcher.FindStringSubmatch(line); match != nil && devices != nil {
+			bytes, _ := strconv.ParseUint(match[2], 10, 64)
+			*devices = append(*devices, dtos.BtrfsDeviceSpace{Path: match[1], Bytes: bytes})
+		}
+	}
+
+	if usage.DeviceSize == 0 && len(usage.BlockGroups) == 0 {
+		err = errors.New("Unable to parse filesystem usage: " + output)
+	}
+	return
+}
+
+func parseUsageOverallLine(line string, usage *dtos.BtrfsVolumeUsage) {
+	match := usageOverallMatcher.FindStringSubmatch(line)
+	if match == nil {
+		return
+	}
+	value, _ := strconv.ParseUint(match[2], 10, 64)
+	switch strings.TrimSpace(match[1]) {
+	case "Device size":
+		usage.DeviceSize = value
+	case "Device allocated":
+		usage.DeviceAllocated = value
+	case "Device unallocated":
+		usage.DeviceUnallocated = value
+	case "Used":
+		usage.Used = value
+	case "Free (estimated)":
+		usage.FreeEstimated = value
+		usage.FreeEstimatedMin, _ = strconv.ParseUint(match[3], 10, 64)
+	case "Data ratio":
+		usage.DataRatio, _ = strconv.ParseFloat(match[2], 64)
+	case "Metadata ratio":
+		usage.MetadataRatio, _ = strconv.ParseFloat(match[2], 64)
+	}
+}
+
+/*volumeMountPath returns a mount path of the btrfs volume, if any of its devices
+is mounted.*/
+func volumeMountPath(vol dtos.BtrfsVolume) (string, bool) {
+	if mount, ok := MountPointCache.FindRootMount(vol.UUID); ok {
+		return mount.MountPath, true
+	}
+	for _, dev := range vol.Devices {
+		mounts, ok := MountPointCache.FindByKernelIdentifier(dev.Path)
+		if ok && len(mounts) > 0 {
+			return mounts[0].MountPath, true
+		}
+	}
+	return "", false
+}
+
+/*RelabelVolume changes the label of the btrfs volume identified by UUID. A
+mounted volume is relabeled online, otherwise the label is written to one of
+its devices. The volume with the new label is returned.*/
+func RelabelVolume(UUID dtos.UUIDType, label string) (dtos.BtrfsVolume, error) {
+	if len(label) > btrfsLabelSize || strings.ContainsAny(label, "\n\x00") {
+		return dtos.BtrfsVolume{}, ErrInvalidLabel
+	}
+	vol, err := findBtrfsVolume(UUID)
+	if err != nil {
+		return dtos.BtrfsVolume{}, err
+	}
+
+	target, mounted := volumeMountPath(vol)
+	if !mounted {
+		if len(vol.Devices) == 0 {
+			return dtos.BtrfsVolume{}, errors.New("No device present for volume UUID: " + string(UUID))
+		}
+		target = vol.Devices[0].Path
+	}
+	_, err = runBtrfsCommand("filesystem", "label", target, label)
+	if err != nil {
+		return dtos.BtrfsVolume{}, err
+	}
+	return findBtrfsVolume(UUID)
+}
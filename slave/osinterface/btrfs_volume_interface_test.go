@@ -0,0 +1,69 @@
+package osinterface
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const filesystemUsageOutput = `Overall:
+    Device size:		   21474836480
+    Device allocated:		    2189426688
+    Device unallocated:		   19285409792
+    Device missing:		             0
+    Used:			        786432
+    Free (estimated):		   10736893952	(min: 10736893952)
+    Data ratio:			          2.00
+    Metadata ratio:		          2.00
+    Global reserve:		       3670016	(used: 0)
+
+Data,RAID1: Size:1073741824, Used:393216 (0.04%)
+   /dev/sdb	1073741824
+   /dev/sdc	1073741824
+
+Metadata,RAID1: Size:33554432, Used:65536 (0.20%)
+   /dev/sdb	  33554432
+   /dev/sdc	  33554432
+
+System,RAID1: Size:8388608, Used:16384
+   /dev/sdb	   8388608
+   /dev/sdc	   8388608
+
+Unallocated:
+   /dev/sdb	9642704896
+   /dev/sdc	9642704896
+`
+
+func TestParseFilesystemUsage(t *testing.T) {
+	usage, err := parseFilesystemUsage(filesystemUsageOutput)
+	assert.NoError(t, err)
+
+	assert.EqualValues(t, 21474836480, usage.DeviceSize)
+	assert.EqualValues(t, 2189426688, usage.DeviceAllocated)
+	assert.EqualValues(t, 19285409792, usage.DeviceUnallocated)
+	assert.EqualValues(t, 786432, usage.Used)
+	assert.EqualValues(t, 10736893952, usage.FreeEstimated)
+	assert.EqualValues(t, 10736893952, usage.FreeEstimatedMin)
+	assert.EqualValues(t, 2.0, usage.DataRatio)
+
+	assert.Len(t, usage.BlockGroups, 3)
+	data := usage.BlockGroups[0]
+	assert.EqualValues(t, "Data", data.Type)
+	assert.EqualValues(t, "RAID1", data.Profile)
+	assert.EqualValues(t, 1073741824, data.Allocated)
+	assert.EqualValues(t, 393216, data.Used)
+	assert.Len(t, data.Devices, 2)
+	assert.EqualValues(t, "/dev/sdc", data.Devices[1].Path)
+
+	assert.EqualValues(t, "System", usage.BlockGroups[2].Type)
+	assert.Len(t, usage.BlockGroups[2].Devices, 2)
+
+	assert.Len(t, usage.Unallocated, 2)
+	assert.EqualValues(t, "/dev/sdb", usage.Unallocated[0].Path)
+	assert.EqualValues(t, 9642704896, usage.Unallocated[0].Bytes)
+}
+
+func TestParseFilesystemUsageMalformed(t *testing.T) {
+	_, err := parseFilesystemUsage("ERROR: can't access '/mnt/x'\n")
+	assert.Error(t, err)
+}
@@ -92,6 +92,28 @@ func (mpc *mountPointCache) FindByKernelIdentifier(identifier string) ([]*dtos.M
 	return mp, ok
 }
 
+/*FindByMountPath retrieves the mount point mounted at the path. If several file
+systems are mounted at the path, the topmost one is returned. This function is
+thread-safe.*/
+func (mpc *mountPointCache) FindByMountPath(mountPath string) (dtos.MountPoint, bool) {
+	mpc.mtx.RLock()
+	defer mpc.mtx.RUnlock()
+	for i := len(mpc.mountPoints) - 1; i >= 0; i-- {
+		if mpc.mountPoints[i].MountPath == mountPath {
+			return mpc.mountPoints[i], true
+		}
+	}
+	return dtos.MountPoint{}, false
+}
+
+/*GetAll returns the cached list of all mount points in the order they were
+mounted.*/
+func (mpc *mountPointCache) GetAll() []dtos.MountPoint {
+	mpc.mtx.RLock()
+	defer mpc.mtx.RUnlock()
+	return append([]dtos.MountPoint(nil), mpc.mountPoints...)
+}
+
 type blockDeviceCache struct {
 	mtx               sync.RWMutex
 	blockDevsByKIdent map[string]*dtos.BlockDevice
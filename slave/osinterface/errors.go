@@ -1,6 +1,11 @@
 package osinterface
 
-import "errors"
+import (
+	"errors"
+	"strings"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+)
 
 var (
 	//ErrMTabOpen indicates that the application was not able to open
@@ -10,12 +15,38 @@ var (
 	ErrBlkidGetCache = errors.New("Unable to retrieve blkid cache /etc/blkid/blkid.tab")
 )
 
-//BtrfsCmdError represents an error returned by the btrfs tool
+//BtrfsCmdError represents an error returned by the btrfs tool. Output contains
+//whatever the tool printed to its standard output before failing.
 type BtrfsCmdError struct {
 	BaseErr string
 	Details string
+	Output  string
 }
 
 func (err BtrfsCmdError) Error() string {
 	return "btrfs program error: " + err.BaseErr + "\nDetails: " + err.Details
 }
+
+//ErrVolumeNotFound indicates that no btrfs volume with the UUID is present
+type ErrVolumeNotFound struct {
+	UUID dtos.UUIDType
+}
+
+func (err ErrVolumeNotFound) Error() string {
+	return "Btrfs volume not found: " + string(err.UUID)
+}
+
+/*newRefusalError constructs the dtos.Error returned when a request is refused
+for the given causes. The messages of the causes are joined into its details.*/
+func newRefusalError(code string, summary string, causes []dtos.ErrorCause) error {
+	details := make([]string, len(causes))
+	for i, cause := range causes {
+		details[i] = cause.Message
+	}
+	return dtos.Error{
+		Subsystem: "btrfs",
+		Details:   summary + ": " + strings.Join(details, "; "),
+		Code:      code,
+		Causes:    causes,
+	}
+}
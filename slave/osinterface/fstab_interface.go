@@ -0,0 +1,336 @@
+package osinterface
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+)
+
+//fstabPath is the file system table holding the persistent mounts
+var fstabPath = "/etc/fstab"
+
+const (
+	//fstabMarker precedes every fstab entry managed by the storage server
+	fstabMarker = "# Managed by btrfs-volume-manager"
+	//fstabBackupTimeFormat is appended to the name of the fstab backups
+	fstabBackupTimeFormat = "20060102-150405.000000000"
+	//fstabBackups is the number of the latest fstab backups that are kept
+	fstabBackups = 5
+)
+
+//ErrCodeFstabRefused is the dtos.Error code of a refused fstab change
+const ErrCodeFstabRefused = "fstab_refused"
+
+//dtos.ErrorCause codes of a refused fstab change
+const (
+	CauseFstabEntryUnmanaged = "fstab_entry_unmanaged"
+	CauseFstabEntryNotFound  = "fstab_entry_not_found"
+)
+
+var (
+	//fstabMtx serializes the changes of the fstab
+	fstabMtx sync.Mutex
+
+	//the escapes of the fstab fields, as decoded by getmntent
+	fstabEscaper   = strings.NewReplacer("\\", "\\134", " ", "\\040", "\t", "\\011", "\n", "\\012")
+	fstabUnescaper = strings.NewReplacer("\\134", "\\", "\\040", " ", "\\011", "\t", "\\012", "\n")
+)
+
+/*fstabOnlyOptions are understood by mount(8) and systemd only, they are kept in
+the fstab but not checked as mount options.*/
+var fstabOnlyOptions = map[string]bool{
+	"auto":    true,
+	"noauto":  true,
+	"nofail":  true,
+	"_netdev": true,
+	"user":    true,
+	"nouser":  true,
+	"users":   true,
+}
+
+func isFstabOnlyOption(opt string) bool {
+	return fstabOnlyOptions[opt] || strings.HasPrefix(opt, "x-") || strings.HasPrefix(opt, "comment=")
+}
+
+//readFstabLines reads the lines of the fstab, a missing fstab has none
+func readFstabLines() ([]string, error) {
+	data, err := ioutil.ReadFile(fstabPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, nil
+}
+
+//fstabLineMountPath returns the mount path of the line if it is an fstab entry
+func fstabLineMountPath(line string) (string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+		return "", false
+	}
+	return fstabUnescaper.Replace(fields[1]), true
+}
+
+//isManagedFstabLine tells whether the line at index i is an entry written by the storage server
+func isManagedFstabLine(lines []string, i int) bool {
+	return i > 0 && strings.TrimSpace(lines[i-1]) == fstabMarker
+}
+
+/*findFstabEntry returns the index of the line of the entry of the mount path,
+or -1 if there is none. A managed entry is preferred over an unmanaged one.*/
+func findFstabEntry(lines []string, mountPath string) int {
+	found := -1
+	for i, line := range lines {
+		if p, ok := fstabLineMountPath(line); ok && p == mountPath {
+			if isManagedFstabLine(lines, i) {
+				return i
+			}
+			if found < 0 {
+				found = i
+			}
+		}
+	}
+	return found
+}
+
+//mountSourceUUID returns the UUID of the volume of an fstab or mtab source
+func mountSourceUUID(source string) dtos.UUIDType {
+	if strings.HasPrefix(source, "UUID=") {
+		return dtos.UUIDType(strings.TrimPrefix(source, "UUID="))
+	}
+	if bd, ok := BlockDeviceCache.FindByKernelIdentifier(source); ok {
+		return bd.UUID
+	}
+	return ""
+}
+
+/*ReadFstab reads the entries of the fstab with getmntent. The entries written
+by the storage server are marked as managed.*/
+func ReadFstab() ([]dtos.MountEntry, error) {
+	fstabMtx.Lock()
+	defer fstabMtx.Unlock()
+	lines, err := readFstabLines()
+	if err != nil || lines == nil {
+		return []dtos.MountEntry{}, err
+	}
+	managed := make(map[string]bool)
+	for i, line := range lines {
+		if p, ok := fstabLineMountPath(line); ok && isManagedFstabLine(lines, i) {
+			managed[p] = true
+		}
+	}
+
+	mountPoints, ok := readMountEntries(fstabPath)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	entries := []dtos.MountEntry{}
+	for _, mountPoint := range mountPoints {
+		entries = append(entries, dtos.MountEntry{
+			MountPoint: mountPoint,
+			VolumeUUID: mountSourceUUID(mountPoint.Identifier),
+			Managed:    managed[mountPoint.MountPath],
+		})
+	}
+	return entries, nil
+}
+
+/*LiveBtrfsMounts returns the cached btrfs mounts, without the root mounts of the
+volumes. The mount point cache has to be rescanned first.*/
+func LiveBtrfsMounts() []dtos.MountEntry {
+	mounts := []dtos.MountEntry{}
+	for _, mountPoint := range MountPointCache.GetAll() {
+		if mountPoint.MountType != "btrfs" || filepath.Dir(mountPoint.MountPath) == rootMountsPath {
+			continue
+		}
+		mounts = append(mounts, dtos.MountEntry{
+			MountPoint: mountPoint,
+			VolumeUUID: mountSourceUUID(mountPoint.Identifier),
+		})
+	}
+	return mounts
+}
+
+/*writeFstab backs up the fstab and replaces it with the lines. The backup is
+kept next to the fstab, with the time of the write appended to its name, the
+backups older than the latest fstabBackups are removed.*/
+func writeFstab(lines []string) error {
+	mode := os.FileMode(0644)
+	old, err := ioutil.ReadFile(fstabPath)
+	if err == nil {
+		if info, statErr := os.Stat(fstabPath); statErr == nil {
+			mode = info.Mode().Perm()
+		}
+		err = ioutil.WriteFile(fstabPath+".bak-"+time.Now().Format(fstabBackupTimeFormat), old, mode)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = writeFileSynced(fstabPath, mode, func(w io.Writer) error {
+		_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	pruneFstabBackups()
+	return nil
+}
+
+//pruneFstabBackups removes the fstab backups older than the latest fstabBackups
+func pruneFstabBackups() {
+	//the time format sorts the backups from the oldest
+	backups, err := filepath.Glob(fstabPath + ".bak-*")
+	if err != nil || len(backups) <= fstabBackups {
+		return
+	}
+	sort.Strings(backups)
+	for _, backup := range backups[:len(backups)-fstabBackups] {
+		err = os.Remove(backup)
+		if err != nil {
+			log.Println("Cannot remove the fstab backup: " + err.Error())
+		}
+	}
+}
+
+/*SetFstabEntry adds the managed fstab entry mounting the subvolume of the volume
+at the mount path, or updates it if it exists. The subvolume is relative to the
+top level of the volume. Unmanaged entries are never changed, the returned
+dtos.Error lists every reason the change is refused.*/
+func SetFstabEntry(vol dtos.BtrfsVolume, subvolume string, mountPath string, options []string) (dtos.MountEntry, error) {
+	var fstabOptions, mountOptions []string
+	for _, option := range options {
+		for _, opt := range strings.Split(option, ",") {
+			if opt == "" {
+				continue
+			}
+			fstabOptions = append(fstabOptions, opt)
+			if !isFstabOnlyOption(opt) {
+				mountOptions = append(mountOptions, opt)
+			}
+		}
+	}
+	causes := newMountPathCauses(mountPath)
+	causes = append(causes, newMountOptionCauses(subvolume, mountOptions)...)
+	if subvolume != "" {
+		fstabOptions = append(fstabOptions, subvolumeOption(subvolume))
+	}
+	if len(fstabOptions) == 0 {
+		fstabOptions = []string{"defaults"}
+	}
+
+	fstabMtx.Lock()
+	defer fstabMtx.Unlock()
+	lines, err := readFstabLines()
+	if err != nil {
+		return dtos.MountEntry{}, err
+	}
+	i := findFstabEntry(lines, mountPath)
+	if i >= 0 && !isManagedFstabLine(lines, i) {
+		causes = append(causes, dtos.ErrorCause{
+			Code:    CauseFstabEntryUnmanaged,
+			Message: "The fstab entry of " + mountPath + " is not managed by the storage server",
+		})
+	}
+	if len(causes) > 0 {
+		return dtos.MountEntry{}, newRefusalError(ErrCodeFstabRefused, "Fstab change refused", causes)
+	}
+
+	entry := dtos.MountEntry{
+		MountPoint: dtos.MountPoint{
+			Identifier:   "UUID=" + string(vol.UUID),
+			MountPath:    mountPath,
+			MountType:    "btrfs",
+			MountOptions: strings.Join(fstabOptions, ","),
+		},
+		VolumeUUID: vol.UUID,
+		Managed:    true,
+	}
+	line := strings.Join([]string{entry.Identifier, fstabEscaper.Replace(mountPath), entry.MountType,
+		fstabEscaper.Replace(entry.MountOptions), "0", "0"}, " ")
+	if i >= 0 {
+		lines[i] = line
+	} else {
+		lines = append(lines, fstabMarker, line)
+	}
+	return entry, writeFstab(lines)
+}
+
+/*RemoveFstabEntry removes the managed fstab entry of the mount path. Unmanaged
+entries are never removed.*/
+func RemoveFstabEntry(mountPath string) error {
+	return removeFstabEntry(mountPath, false)
+}
+
+/*RemoveFstabEntryIfPresent removes the managed fstab entry of the mount path
+like RemoveFstabEntry, but a missing entry is not an error.*/
+func RemoveFstabEntryIfPresent(mountPath string) error {
+	return removeFstabEntry(mountPath, true)
+}
+
+/*ValidateFstabEntryRemove checks whether RemoveFstabEntryIfPresent would remove
+the fstab entry of the mount path, so that it can be checked before unmounting.*/
+func ValidateFstabEntryRemove(mountPath string) error {
+	fstabMtx.Lock()
+	defer fstabMtx.Unlock()
+	lines, err := readFstabLines()
+	if err != nil {
+		return err
+	}
+	_, err = findRemovedFstabEntry(lines, mountPath, true)
+	return err
+}
+
+func removeFstabEntry(mountPath string, missingOK bool) error {
+	fstabMtx.Lock()
+	defer fstabMtx.Unlock()
+	lines, err := readFstabLines()
+	if err != nil {
+		return err
+	}
+	i, err := findRemovedFstabEntry(lines, mountPath, missingOK)
+	if err != nil || i < 0 {
+		return err
+	}
+	lines = append(lines[:i-1], lines[i+1:]...)
+	return writeFstab(lines)
+}
+
+/*findRemovedFstabEntry returns the index of the managed fstab entry of the mount
+path, -1 if there is none and missingOK is set. fstabMtx has to be held.*/
+func findRemovedFstabEntry(lines []string, mountPath string, missingOK bool) (int, error) {
+	i := findFstabEntry(lines, mountPath)
+	if i < 0 && missingOK {
+		return i, nil
+	}
+	if i < 0 {
+		return i, newRefusalError(ErrCodeFstabRefused, "Fstab change refused", []dtos.ErrorCause{{
+			Code:    CauseFstabEntryNotFound,
+			Message: "The fstab has no entry for " + mountPath,
+		}})
+	}
+	if !isManagedFstabLine(lines, i) {
+		return i, newRefusalError(ErrCodeFstabRefused, "Fstab change refused", []dtos.ErrorCause{{
+			Code:    CauseFstabEntryUnmanaged,
+			Message: "The fstab entry of " + mountPath + " is not managed by the storage server",
+		}})
+	}
+	return i, nil
+}
@@ -0,0 +1,120 @@
+package osinterface
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/stretchr/testify/assert"
+)
+
+const testFstab = "# /etc/fstab: static file system information.\n" +
+	"UUID=root-uuid / ext4 errors=remount-ro 0 1\n" +
+	"\n" +
+	"UUID=data-uuid /srv/data btrfs noatime 0 0\n"
+
+//setupFstab writes the fstab into a temporary directory, the returned function removes it
+func setupFstab(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "fstab")
+	assert.NoError(t, err)
+	oldFstabPath := fstabPath
+	fstabPath = filepath.Join(dir, "fstab")
+	assert.NoError(t, ioutil.WriteFile(fstabPath, []byte(content), 0644))
+	return dir, func() {
+		fstabPath = oldFstabPath
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSetFstabEntry(t *testing.T) {
+	dir, cleanup := setupFstab(t, testFstab)
+	defer cleanup()
+	vol := dtos.BtrfsVolume{UUID: "vol-uuid"}
+
+	entry, err := SetFstabEntry(vol, "home", "/srv/my home", []string{"compress=zstd:3,noatime", "nofail"})
+	assert.NoError(t, err)
+	assert.Equal(t, "compress=zstd:3,noatime,nofail,subvol=/home", entry.MountOptions)
+	_, err = SetFstabEntry(vol, "", "/srv/backup", nil)
+	assert.NoError(t, err)
+	_, err = SetFstabEntry(vol, "home", "/srv/my home", []string{"space_cache=v2"})
+	assert.NoError(t, err)
+
+	data, err := ioutil.ReadFile(fstabPath)
+	assert.NoError(t, err)
+	assert.Equal(t, testFstab+
+		fstabMarker+"\nUUID=vol-uuid /srv/my\\040home btrfs space_cache=v2,subvol=/home 0 0\n"+
+		fstabMarker+"\nUUID=vol-uuid /srv/backup btrfs defaults 0 0\n", string(data))
+	backups, err := filepath.Glob(filepath.Join(dir, "fstab.bak-*"))
+	assert.NoError(t, err)
+	assert.Len(t, backups, 3)
+
+	entries, err := ReadFstab()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4)
+	assert.False(t, entries[1].Managed)
+	assert.EqualValues(t, "data-uuid", entries[1].VolumeUUID)
+	assert.True(t, entries[2].Managed)
+	assert.Equal(t, "/srv/my home", entries[2].MountPath)
+	assert.EqualValues(t, "vol-uuid", entries[2].VolumeUUID)
+}
+
+func TestSetFstabEntryRefused(t *testing.T) {
+	_, cleanup := setupFstab(t, testFstab)
+	defer cleanup()
+
+	_, err := SetFstabEntry(dtos.BtrfsVolume{UUID: "vol-uuid"}, "home", "/srv/data", []string{"subvolid=5", "bogus"})
+	assert.Error(t, err)
+	assert.EqualValues(t, ErrCodeFstabRefused, err.(dtos.Error).Code)
+	assert.EqualValues(t, []string{CauseUnknownMountOption, CauseSubvolumeConflict, CauseFstabEntryUnmanaged},
+		causeCodes(err))
+
+	data, err := ioutil.ReadFile(fstabPath)
+	assert.NoError(t, err)
+	assert.Equal(t, testFstab, string(data))
+}
+
+func TestRemoveFstabEntry(t *testing.T) {
+	_, cleanup := setupFstab(t, testFstab)
+	defer cleanup()
+	_, err := SetFstabEntry(dtos.BtrfsVolume{UUID: "vol-uuid"}, "", "/srv/backup", nil)
+	assert.NoError(t, err)
+
+	assert.NoError(t, RemoveFstabEntry("/srv/backup"))
+	data, err := ioutil.ReadFile(fstabPath)
+	assert.NoError(t, err)
+	assert.Equal(t, testFstab, string(data))
+
+	err = RemoveFstabEntry("/srv/backup")
+	assert.EqualValues(t, []string{CauseFstabEntryNotFound}, causeCodes(err))
+	err = RemoveFstabEntry("/srv/data")
+	assert.EqualValues(t, []string{CauseFstabEntryUnmanaged}, causeCodes(err))
+	assert.NoError(t, RemoveFstabEntryIfPresent("/srv/backup"))
+	err = RemoveFstabEntryIfPresent("/srv/data")
+	assert.EqualValues(t, []string{CauseFstabEntryUnmanaged}, causeCodes(err))
+	assert.NoError(t, ValidateFstabEntryRemove("/srv/backup"))
+	err = ValidateFstabEntryRemove("/srv/data")
+	assert.EqualValues(t, []string{CauseFstabEntryUnmanaged}, causeCodes(err))
+}
+
+func TestFstabBackupsPruned(t *testing.T) {
+	dir, cleanup := setupFstab(t, testFstab)
+	defer cleanup()
+	vol := dtos.BtrfsVolume{UUID: "vol-uuid"}
+	for i := 0; i < fstabBackups+2; i++ {
+		_, err := SetFstabEntry(vol, "", "/srv/backup", []string{"commit=" + strconv.Itoa(i+1)})
+		assert.NoError(t, err)
+	}
+
+	backups, err := filepath.Glob(filepath.Join(dir, "fstab.bak-*"))
+	assert.NoError(t, err)
+	assert.Len(t, backups, fstabBackups)
+	data, err := ioutil.ReadFile(backups[len(backups)-1])
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "commit="+strconv.Itoa(fstabBackups+1))
+	partial, err := filepath.Glob(filepath.Join(dir, "*"+partialExt))
+	assert.NoError(t, err)
+	assert.Empty(t, partial)
+}
@@ -2,8 +2,12 @@ package osinterface
 
 import (
 	"errors"
+	"log"
 	"os"
+	"path/filepath"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/djarek/btrfs-volume-manager/common/dtos"
 )
@@ -12,17 +16,140 @@ var mount = func(source string, target string, fstype string, flags uintptr, dat
 	return syscall.Mount(source, target, fstype, flags, data)
 }
 
+var unmount = func(target string, flags int) error {
+	return syscall.Unmount(target, flags)
+}
+
+/*GetBtrfsRootMount returns the path the root of the btrfs volume is mounted at.
+If the root is not mounted, it is mounted and tracked by RootMounts.*/
 func GetBtrfsRootMount(vol dtos.BtrfsVolume) (mountPath string, err error) {
-	mount, ok := MountPointCache.FindRootMount(vol.UUID)
-	if !ok {
-		mountPath, err = MountBtrfsRoot(vol)
+	return RootMounts.get(vol)
+}
+
+//rootMount is a root mount created by the storage server
+type rootMount struct {
+	path string
+	//createdDirs are the directories created for the mount, the deepest first
+	createdDirs []string
+	lastUsed    time.Time
+}
+
+/*rootMountTracker keeps the root mounts created by GetBtrfsRootMount, so that
+they can be unmounted once they are idle. Root mounts it did not create are
+never unmounted.*/
+type rootMountTracker struct {
+	mtx    sync.Mutex
+	mounts map[dtos.UUIDType]*rootMount
+}
+
+//RootMounts contains the tracker of the root mounts created by this storage server
+var RootMounts = &rootMountTracker{mounts: make(map[dtos.UUIDType]*rootMount)}
+
+func (r *rootMountTracker) get(vol dtos.BtrfsVolume) (string, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	tracked, isTracked := r.mounts[vol.UUID]
+	if mount, ok := MountPointCache.FindRootMount(vol.UUID); ok {
+		if isTracked {
+			tracked.lastUsed = time.Now()
+		}
+		return mount.MountPath, nil
+	}
+
+	createdDirs := missingDirs(rootMountsPath + "/" + string(vol.UUID))
+	mountPath, err := MountBtrfsRoot(vol)
+	if err != nil {
+		removeDirs(createdDirs)
+		return "", err
+	}
+	if isTracked && len(createdDirs) == 0 {
+		createdDirs = tracked.createdDirs
+	}
+	r.mounts[vol.UUID] = &rootMount{path: mountPath, createdDirs: createdDirs, lastUsed: time.Now()}
+	return mountPath, nil
+}
+
+//isTracked tells whether the mount path is one of the tracked root mounts
+func (r *rootMountTracker) isTracked(mountPath string) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, mount := range r.mounts {
+		if mount.path == mountPath {
+			return true
+		}
+	}
+	return false
+}
+
+/*release unmounts the root mount and removes the directories created for it,
+if they are empty. r.mtx has to be held.*/
+func (r *rootMountTracker) release(UUID dtos.UUIDType, mount *rootMount) error {
+	err := unmount(mount.path, 0)
+	if err != nil && err != syscall.EINVAL {
+		return errors.New("BTRFS root unmount failed: " + err.Error())
+	}
+	delete(r.mounts, UUID)
+	removeDirs(mount.createdDirs)
+	return nil
+}
+
+/*ReleaseIdle unmounts the created root mounts that were not used for the idle
+period. Busy root mounts are kept until a later call.*/
+func (r *rootMountTracker) ReleaseIdle(idle time.Duration) {
+	r.releaseWhere(func(mount *rootMount) bool {
+		return time.Since(mount.lastUsed) >= idle
+	})
+}
+
+//ReleaseAll unmounts all the created root mounts, it is called on shutdown
+func (r *rootMountTracker) ReleaseAll() {
+	r.releaseWhere(func(*rootMount) bool { return true })
+}
+
+func (r *rootMountTracker) releaseWhere(shouldRelease func(*rootMount) bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	released := false
+	for UUID, mount := range r.mounts {
+		if !shouldRelease(mount) {
+			continue
+		}
+		err := r.release(UUID, mount)
 		if err != nil {
+			log.Printf("Root mount %s kept: %s\n", mount.path, err)
+			continue
+		}
+		log.Printf("Root mount %s released\n", mount.path)
+		released = true
+	}
+	if released {
+		err := MountPointCache.Rescan()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+//missingDirs returns the directories of the path that do not exist, the deepest first
+func missingDirs(path string) (dirs []string) {
+	for p := filepath.Clean(path); ; p = filepath.Dir(p) {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			return
+		}
+		dirs = append(dirs, p)
+		if p == filepath.Dir(p) {
+			return
+		}
+	}
+}
+
+//removeDirs removes the directories in order, it stops at the first one that is not empty
+func removeDirs(dirs []string) {
+	for _, dir := range dirs {
+		if os.Remove(dir) != nil {
 			return
 		}
-	} else {
-		mountPath = mount.MountPath
 	}
-	return
 }
 
 /*MountBtrfsRoot attempts to mount the specified btrfs volume's root at the
@@ -55,3 +182,4 @@ func MountBtrfsRoot(vol dtos.BtrfsVolume) (rootMountPath string, err error) {
 	}
 	return targetPath, nil
 }
+
@@ -0,0 +1,68 @@
+package osinterface
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMissingDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rootmounts")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Empty(t, missingDirs(dir))
+	assert.EqualValues(t, []string{filepath.Join(dir, "a", "b"), filepath.Join(dir, "a")},
+		missingDirs(filepath.Join(dir, "a", "b")))
+}
+
+func TestReleaseRootMounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rootmounts")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	var unmounted []string
+	busy := map[string]bool{}
+	oldUnmount := unmount
+	unmount = func(target string, flags int) error {
+		if busy[target] {
+			return syscall.EBUSY
+		}
+		unmounted = append(unmounted, target)
+		return nil
+	}
+	defer func() { unmount = oldUnmount }()
+
+	idlePath := filepath.Join(dir, "mnt", "idle")
+	usedPath := filepath.Join(dir, "mnt", "used")
+	busyPath := filepath.Join(dir, "busy")
+	for _, path := range []string{idlePath, usedPath, busyPath} {
+		assert.NoError(t, os.MkdirAll(path, 0755))
+	}
+	r := &rootMountTracker{mounts: map[dtos.UUIDType]*rootMount{
+		"idle": {path: idlePath, createdDirs: []string{idlePath, filepath.Dir(idlePath)}, lastUsed: time.Now().Add(-time.Hour)},
+		"used": {path: usedPath, createdDirs: []string{usedPath}, lastUsed: time.Now()},
+		"busy": {path: busyPath, createdDirs: []string{busyPath}, lastUsed: time.Now().Add(-time.Hour)},
+	}}
+	busy[busyPath] = true
+
+	r.ReleaseIdle(10 * time.Minute)
+	assert.EqualValues(t, []string{idlePath}, unmounted)
+	assert.Len(t, r.mounts, 2)
+	_, err = os.Stat(idlePath)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Dir(idlePath))
+	assert.NoError(t, err, "the directory still holds another root mount")
+
+	busy[busyPath] = false
+	r.ReleaseAll()
+	assert.Len(t, unmounted, 3)
+	assert.Empty(t, r.mounts)
+	_, err = os.Stat(busyPath)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/common/request"
+	"github.com/djarek/btrfs-volume-manager/common/router"
+	"github.com/djarek/btrfs-volume-manager/slave/osinterface"
+)
+
+const (
+	//streamChunkSize is the size of the send stream chunks relayed by the master
+	streamChunkSize = 64 << 10
+	//streamWindow is the number of chunks that may be sent but not yet written
+	streamWindow = 16
+)
+
+var errStreamAborted = errors.New("The stream was aborted")
+
+type sendStream struct {
+	stream    *osinterface.SendStream
+	window    chan struct{}
+	aborted   chan struct{}
+	abortOnce sync.Once
+}
+
+func (s *sendStream) abort() {
+	s.abortOnce.Do(func() { close(s.aborted) })
+}
+
+type receiveStream struct {
+	stream *osinterface.ReceiveStream
+	chunks chan *dtos.BtrfsStreamChunkNotification
+}
+
+/*replicationController runs the btrfs send and receive of the streams relayed
+by the master. At most streamWindow chunks of a stream are sent before the
+receiving side acknowledges them.*/
+type replicationController struct {
+	mtx      sync.Mutex
+	sends    map[dtos.StreamID]*sendStream
+	receives map[dtos.StreamID]*receiveStream
+}
+
+func newReplicationController() *replicationController {
+	return &replicationController{
+		sends:    make(map[dtos.StreamID]*sendStream),
+		receives: make(map[dtos.StreamID]*receiveStream),
+	}
+}
+
+//busy tells whether a send or receive stream is open
+func (r *replicationController) busy() bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return len(r.sends) > 0 || len(r.receives) > 0
+}
+
+func (r *replicationController) ExportHandlers(adder router.HandlerAdder) {
+	adder.AddHandler(dtos.WSMsgBtrfsSendStartRequest, r.onBtrfsSendStartRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsReceiveStartRequest, r.onBtrfsReceiveStartRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsStreamChunkNotification, r.onBtrfsStreamChunkNotification)
+	adder.AddHandler(dtos.WSMsgBtrfsStreamAckNotification, r.onBtrfsStreamAckNotification)
+	adder.AddOnCloseHandler(r.onConnectionClose)
+}
+
+func (r *replicationController) onBtrfsSendStartRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsSendStartRequest)
+	stream, err := osinterface.StartSend(request.VolumeUUID, request.RelativePath, request.ParentPath)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	s := &sendStream{
+		stream:  stream,
+		window:  make(chan struct{}, streamWindow),
+		aborted: make(chan struct{}),
+	}
+	r.mtx.Lock()
+	r.sends[request.StreamID] = s
+	r.mtx.Unlock()
+
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsSendStartResponse{}))
+	go r.runSend(ctx, request.StreamID, s)
+}
+
+/*runSend reads the send stream and sends it to the master in chunks. The last
+chunk reports whether the send succeeded.*/
+func (r *replicationController) runSend(ctx *request.Context, ID dtos.StreamID, s *sendStream) {
+	defer func() {
+		r.mtx.Lock()
+		delete(r.sends, ID)
+		r.mtx.Unlock()
+	}()
+
+	buf := make([]byte, streamChunkSize)
+	var seq uint64
+	var err error
+	for err == nil {
+		select {
+		case s.window <- struct{}{}:
+		case <-s.aborted:
+			s.stream.Kill()
+			return
+		}
+
+		var n int
+		n, err = io.ReadFull(s.stream, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		if n > 0 {
+			chunk := &dtos.BtrfsStreamChunkNotification{Seq: seq, Data: append([]byte(nil), buf[:n]...)}
+			chunk.StreamID = ID
+			ctx.SendAsync(dtos.NewWebSocketMessage(0, chunk))
+			seq++
+		}
+	}
+
+	if err == io.EOF {
+		err = s.stream.Wait()
+	} else {
+		s.stream.Kill()
+	}
+	last := &dtos.BtrfsStreamChunkNotification{Seq: seq, EOF: true}
+	last.StreamID = ID
+	if err != nil {
+		log.Println(err)
+		last.Error = err.Error()
+	}
+	ctx.SendAsync(dtos.NewWebSocketMessage(0, last))
+}
+
+func (r *replicationController) onBtrfsStreamAckNotification(ctx *request.Context, msg dtos.WebSocketMessage) {
+	ack := msg.Payload.(*dtos.BtrfsStreamAckNotification)
+	r.mtx.Lock()
+	s, ok := r.sends[ack.StreamID]
+	r.mtx.Unlock()
+	if !ok {
+		return
+	}
+	if ack.Abort {
+		s.abort()
+		return
+	}
+	select {
+	case <-s.window:
+	default:
+	}
+}
+
+func (r *replicationController) onBtrfsReceiveStartRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsReceiveStartRequest)
+	stream, err := osinterface.StartReceive(request.VolumeUUID, request.TargetPath, request.Name, request.SourceUUID)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	rs := &receiveStream{
+		stream: stream,
+		chunks: make(chan *dtos.BtrfsStreamChunkNotification, streamWindow+1),
+	}
+	r.mtx.Lock()
+	r.receives[request.StreamID] = rs
+	r.mtx.Unlock()
+
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, &dtos.BtrfsReceiveStartResponse{}))
+	go r.runReceive(ctx, request.StreamID, rs)
+}
+
+/*runReceive writes the received chunks to the receive and acknowledges them.
+The result of the receive is sent to the master once the last chunk arrives.*/
+func (r *replicationController) runReceive(ctx *request.Context, ID dtos.StreamID, rs *receiveStream) {
+	defer func() {
+		r.mtx.Lock()
+		delete(r.receives, ID)
+		r.mtx.Unlock()
+	}()
+
+	result := &dtos.BtrfsReceiveResultNotification{}
+	result.StreamID = ID
+	sendAbort := func(err error) {
+		log.Println(err)
+		rs.stream.Abort()
+		ack := &dtos.BtrfsStreamAckNotification{Abort: true, Error: err.Error()}
+		ack.StreamID = ID
+		ctx.SendAsync(dtos.NewWebSocketMessage(0, ack))
+		result.Error = err.Error()
+		ctx.SendAsync(dtos.NewWebSocketMessage(0, result))
+	}
+
+	for chunk := range rs.chunks {
+		if len(chunk.Data) > 0 {
+			_, err := rs.stream.Write(chunk.Data)
+			if err != nil {
+				sendAbort(err)
+				return
+			}
+			ack := &dtos.BtrfsStreamAckNotification{Seq: chunk.Seq}
+			ack.StreamID = ID
+			ctx.SendAsync(dtos.NewWebSocketMessage(0, ack))
+		}
+		if !chunk.EOF {
+			continue
+		}
+
+		if chunk.Error != "" {
+			rs.stream.Abort()
+			result.Error = chunk.Error
+		} else if subvol, err := rs.stream.Finish(); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Subvolume = &subvol
+		}
+		ctx.SendAsync(dtos.NewWebSocketMessage(0, result))
+		return
+	}
+	sendAbort(errStreamAborted)
+}
+
+/*onBtrfsStreamChunkNotification passes the chunk on to the receive. The sender
+never has more than streamWindow chunks and the last one in flight, so the
+channel only fills up if the master misbehaves, in which case the receive is
+aborted.*/
+func (r *replicationController) onBtrfsStreamChunkNotification(ctx *request.Context, msg dtos.WebSocketMessage) {
+	chunk := msg.Payload.(*dtos.BtrfsStreamChunkNotification)
+	r.mtx.Lock()
+	rs, ok := r.receives[chunk.StreamID]
+	r.mtx.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case rs.chunks <- chunk:
+	default:
+		log.Printf("Stream %d: more chunks received than acknowledged\n", chunk.StreamID)
+		r.mtx.Lock()
+		delete(r.receives, chunk.StreamID)
+		r.mtx.Unlock()
+		close(rs.chunks)
+	}
+}
+
+/*onConnectionClose stops all streams, without the master they cannot be
+completed.*/
+func (r *replicationController) onConnectionClose(ctx *request.Context, msg dtos.WebSocketMessage) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, s := range r.sends {
+		s.abort()
+	}
+	for ID, rs := range r.receives {
+		close(rs.chunks)
+		delete(r.receives, ID)
+	}
+}
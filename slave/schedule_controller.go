@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/common/request"
+	"github.com/djarek/btrfs-volume-manager/common/router"
+	"github.com/djarek/btrfs-volume-manager/common/schedules"
+	"github.com/djarek/btrfs-volume-manager/common/tasks"
+	"github.com/djarek/btrfs-volume-manager/slave/osinterface"
+)
+
+const (
+	scheduleCheckInterval = 30 * time.Second
+	scheduleTaskInterval  = 5 * time.Second
+	syncResponseTimeout   = 30 * time.Second
+)
+
+var (
+	errTaskCancelled = errors.New("The task was cancelled")
+	errTaskLost      = errors.New("The task is no longer tracked")
+	errSyncTimeout   = errors.New("The master did not respond to the schedule sync")
+)
+
+//scheduleState is the part of the schedule controller stored on disk
+type scheduleState struct {
+	Schedules []dtos.Schedule    `json:"schedules"`
+	Runs      []dtos.ScheduleRun `json:"runs"`
+}
+
+/*scheduleController keeps the schedules of the volumes of this storage server.
+While the master is connected it runs them, the controller only takes over
+while the master is not connected. The runs are kept on disk until they are
+uploaded to the master.*/
+type scheduleController struct {
+	tasks *taskController
+	path  string
+	//syncMtx serializes the uploads, so that every run is removed once
+	syncMtx sync.Mutex
+
+	mtx   sync.Mutex
+	state scheduleState
+	ctx   *request.Context
+}
+
+/*newScheduleController constructs a new valid scheduleController with the
+state stored at path. The local runs start once start is called.*/
+func newScheduleController(t *taskController, path string) *scheduleController {
+	s := &scheduleController{tasks: t, path: path}
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &s.state)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Cannot load the schedules from %s: %s\n", path, err)
+	}
+	return s
+}
+
+func (s *scheduleController) ExportHandlers(adder router.HandlerAdder) {
+	adder.AddHandler(dtos.WSMsgScheduleSyncResponse, router.DefaultResponseHandler)
+	adder.AddHandler(dtos.WSMsgScheduleSyncNotification, s.onScheduleSyncNotification)
+	adder.AddOnCloseHandler(s.onClose)
+}
+
+//save writes the state to a temporary file which replaces the stored one, s.mtx has to be held
+func (s *scheduleController) save() {
+	data, err := json.Marshal(s.state)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(s.path), 0700)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(s.path+".tmp", data, 0600)
+	}
+	if err == nil {
+		err = os.Rename(s.path+".tmp", s.path)
+	}
+	if err != nil {
+		log.Printf("Cannot store the schedules in %s: %s\n", s.path, err)
+	}
+}
+
+/*setSchedules replaces the schedules with the ones sent by the master. A schedule
+run here later than the master knows of keeps its local last scheduled time.
+s.mtx has to be held.*/
+func (s *scheduleController) setSchedules(received []dtos.Schedule) {
+	last := make(map[string]time.Time)
+	for _, schedule := range s.state.Schedules {
+		last[schedule.ID] = schedule.LastScheduled
+	}
+	for i := range received {
+		if t, ok := last[received[i].ID]; ok && t.After(received[i].LastScheduled) {
+			received[i].LastScheduled = t
+		}
+	}
+	s.state.Schedules = received
+	s.save()
+}
+
+/*setContext hands the schedules over to the master connected with ctx, no more
+runs are started locally. The runs made meanwhile are uploaded and the current
+schedules received in the background.*/
+func (s *scheduleController) setContext(ctx *request.Context) {
+	s.mtx.Lock()
+	s.ctx = ctx
+	s.mtx.Unlock()
+	go s.syncUntilDone(ctx)
+}
+
+/*syncUntilDone syncs with the master connected with ctx, retrying until it
+succeeds or the connection closes. A failed sync does not hand the schedules
+back, the master runs them as long as the storage server is connected.*/
+func (s *scheduleController) syncUntilDone(ctx *request.Context) {
+	for {
+		err := s.sync(ctx)
+		if err == nil {
+			return
+		}
+		log.Println("Cannot sync the schedules with the master: " + err.Error())
+		time.Sleep(scheduleCheckInterval)
+		s.mtx.Lock()
+		connected := s.ctx == ctx
+		s.mtx.Unlock()
+		if !connected {
+			return
+		}
+	}
+}
+
+/*sync uploads the runs made while the master was not connected and receives the
+current schedules.*/
+func (s *scheduleController) sync(ctx *request.Context) error {
+	s.syncMtx.Lock()
+	defer s.syncMtx.Unlock()
+	s.mtx.Lock()
+	runs := append([]dtos.ScheduleRun{}, s.state.Runs...)
+	s.mtx.Unlock()
+
+	requestID, responseChannel := ctx.NewRequest()
+	err := <-ctx.SendAsync(dtos.NewWebSocketMessage(requestID, &dtos.ScheduleSyncRequest{Runs: runs}))
+	if err != nil {
+		return err
+	}
+	var responseMsg dtos.WebSocketMessage
+	select {
+	case msg, ok := <-responseChannel:
+		if !ok {
+			return errors.New("Connection closed")
+		}
+		responseMsg = msg
+	case <-time.After(syncResponseTimeout):
+		return errSyncTimeout
+	}
+	if responseMsg.MessageType == dtos.WSMsgError {
+		return responseMsg.Payload.(*dtos.Error)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.state.Runs = s.state.Runs[len(runs):]
+	s.setSchedules(responseMsg.Payload.(*dtos.ScheduleSyncResponse).Schedules)
+	log.Printf("Uploaded %d schedule runs, received %d schedules\n", len(runs), len(s.state.Schedules))
+	return nil
+}
+
+func (s *scheduleController) onScheduleSyncNotification(ctx *request.Context, msg dtos.WebSocketMessage) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.setSchedules(msg.Payload.(*dtos.ScheduleSyncNotification).Schedules)
+}
+
+/*onClose takes over the schedules. The runs due until now were left to the
+master.*/
+func (s *scheduleController) onClose(ctx *request.Context, msg dtos.WebSocketMessage) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.ctx != ctx {
+		return
+	}
+	s.ctx = nil
+	now := time.Now()
+	for i := range s.state.Schedules {
+		if s.state.Schedules[i].LastScheduled.Before(now) {
+			s.state.Schedules[i].LastScheduled = now
+		}
+	}
+	s.save()
+	log.Println("Master disconnected, running the schedules locally")
+}
+
+//start starts running the schedules while the master is not connected
+func (s *scheduleController) start() {
+	go func() {
+		for {
+			s.runDue(time.Now())
+			time.Sleep(scheduleCheckInterval)
+		}
+	}()
+}
+
+/*runDue starts the due runs of the enabled schedules if the master is not
+connected. The time of the last due run is stored before the runs are
+started, so that no run is repeated if the storage server stops.*/
+func (s *scheduleController) runDue(now time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.ctx != nil {
+		return
+	}
+	for i := range s.state.Schedules {
+		schedule := &s.state.Schedules[i]
+		if !schedule.Enabled {
+			continue
+		}
+		cron, loc, err := schedules.Parse(schedule.Cron, schedule.Timezone)
+		if err != nil {
+			log.Printf("Schedule %s: %s\n", schedule.ID, err)
+			continue
+		}
+		if schedule.LastScheduled.IsZero() {
+			schedule.LastScheduled = now
+			continue
+		}
+		due, total := schedules.DueRuns(cron, schedule.LastScheduled.In(loc), now, schedules.MaxCatchUpRuns+1)
+		if total == 0 {
+			continue
+		}
+		schedule.LastScheduled = due[len(due)-1]
+		started, skipped := schedules.PlanCatchUp(due, total, now, schedule.CatchUp)
+		if skipped > 0 {
+			log.Printf("Schedule %s: skipping %d missed runs\n", schedule.ID, skipped)
+			s.state.Runs = append(s.state.Runs, dtos.ScheduleRun{
+				ScheduleID:    schedule.ID,
+				ScheduledTime: due[0],
+				StartTime:     now,
+				EndTime:       now,
+				Outcome:       dtos.RunOutcomeSkipped,
+				Error:         fmt.Sprintf("%d runs were missed while the storage server was down", skipped),
+				Local:         true,
+			})
+		}
+		if len(started) > 0 {
+			go s.runAll(*schedule, started)
+		}
+	}
+	s.save()
+}
+
+//runAll runs the action of the schedule for each of the scheduled times in order
+func (s *scheduleController) runAll(schedule dtos.Schedule, scheduledTimes []time.Time) {
+	for _, t := range scheduledTimes {
+		s.run(schedule, t)
+	}
+}
+
+/*run runs the action of the schedule and records its outcome. The run is
+uploaded right away if the master connected in the meantime.*/
+func (s *scheduleController) run(schedule dtos.Schedule, scheduledTime time.Time) {
+	log.Printf("Schedule %s: starting the %s scheduled at %s\n", schedule.ID, schedule.Action, scheduledTime)
+	run := dtos.ScheduleRun{
+		ScheduleID:    schedule.ID,
+		ScheduledTime: scheduledTime,
+		StartTime:     time.Now(),
+		Local:         true,
+	}
+	err := s.execute(schedule, scheduledTime, &run)
+	run.EndTime = time.Now()
+	if err != nil {
+		log.Printf("Schedule %s: the %s scheduled at %s failed: %s\n", schedule.ID, schedule.Action, scheduledTime, err)
+		run.Outcome = dtos.RunOutcomeFailed
+		run.Error = err.Error()
+	} else {
+		log.Printf("Schedule %s: the %s scheduled at %s succeeded\n", schedule.ID, schedule.Action, scheduledTime)
+		run.Outcome = dtos.RunOutcomeSucceeded
+	}
+
+	s.mtx.Lock()
+	s.state.Runs = append(s.state.Runs, run)
+	s.save()
+	ctx := s.ctx
+	s.mtx.Unlock()
+	if ctx != nil {
+		s.syncUntilDone(ctx)
+	}
+}
+
+/*execute runs the action the same way the master requests it. The actions run
+as tasks are waited for, the task is recorded in the run once it started.*/
+func (s *scheduleController) execute(schedule dtos.Schedule, scheduledTime time.Time, run *dtos.ScheduleRun) error {
+	volume := dtos.BtrfsVolume{UUID: schedule.VolumeUUID}
+	var kind, target string
+	var op tasks.Operation
+	var err error
+	switch schedule.Action {
+	case dtos.ScheduleActionSnapshot, dtos.ScheduleActionBackup:
+		snapshotPath := schedules.SnapshotPath(schedule.Subvolume, schedule.SnapshotDir, scheduledTime)
+		err = osinterface.CreateSnapshot(dtos.BtrfsSubVolume{
+			VolumeUUID:   schedule.VolumeUUID,
+			RelativePath: schedule.Subvolume,
+		}, snapshotPath, osinterface.SnapshotOptions{ReadOnly: true})
+		if err != nil || schedule.Action == dtos.ScheduleActionSnapshot {
+			return err
+		}
+		kind = backupTaskKind
+		target, err = osinterface.BackupTargetDir(schedule.BackupDir)
+		if err == nil {
+			op, err = osinterface.NewBackupOperation(schedule.VolumeUUID, snapshotPath, target, false)
+		}
+	case dtos.ScheduleActionScrub:
+		kind, target = scrubTaskKind, string(schedule.VolumeUUID)
+		op, err = osinterface.NewScrubOperation(volume, false)
+	case dtos.ScheduleActionBalance:
+		args := osinterface.BalanceArgs{}
+		if schedule.BalanceUsage > 0 {
+			usage := schedule.BalanceUsage
+			args.Data = &dtos.BalanceFilter{Usage: &usage}
+			args.Metadata = &dtos.BalanceFilter{Usage: &usage}
+		}
+		kind, target = balanceTaskKind, string(schedule.VolumeUUID)
+		op, err = osinterface.NewBalanceOperation(volume, args)
+	default:
+		return schedules.ErrUnknownAction
+	}
+	if err != nil {
+		return err
+	}
+
+	task, err := s.tasks.tracker.Start(kind, target, op)
+	if err != nil {
+		return err
+	}
+	run.TaskID = uint64(task.ID)
+	for task.State.IsActive() {
+		time.Sleep(scheduleTaskInterval)
+		var ok bool
+		task, ok = s.tasks.tracker.Get(task.ID)
+		if !ok {
+			return errTaskLost
+		}
+	}
+	switch task.State {
+	case tasks.StateFailed:
+		return errors.New(task.Error)
+	case tasks.StateCancelled:
+		return errTaskCancelled
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/djarek/btrfs-volume-manager/common/dtos"
+	"github.com/djarek/btrfs-volume-manager/common/request"
+	"github.com/djarek/btrfs-volume-manager/common/router"
+	"github.com/djarek/btrfs-volume-manager/common/tasks"
+	"github.com/djarek/btrfs-volume-manager/slave/osinterface"
+)
+
+const (
+	taskProgressInterval = 5 * time.Second
+	tasksSubsystem       = "tasks"
+
+	scrubTaskKind        = "scrub"
+	balanceTaskKind      = "balance"
+	deviceRemoveTaskKind = "device-remove"
+	deviceResizeTaskKind = "device-resize"
+	replaceTaskKind      = "replace"
+	backupTaskKind       = "backup"
+	backupVerifyTaskKind = "backup-verify"
+	restoreTaskKind      = "restore"
+)
+
+/*taskController runs long-running operations as tasks and pushes their state
+to the master.*/
+type taskController struct {
+	tracker tasks.Tracker
+
+	ctxMtx sync.RWMutex
+	ctx    *request.Context
+	//disconnected is when the master connection was lost
+	disconnected time.Time
+}
+
+func newTaskController() *taskController {
+	t := &taskController{}
+	t.tracker = tasks.NewTracker(taskProgressInterval, t.onTaskUpdate)
+	return t
+}
+
+func (t *taskController) ExportHandlers(adder router.HandlerAdder) {
+	adder.AddHandler(dtos.WSMsgTaskStatusRequest, t.onTaskStatusRequest)
+	adder.AddHandler(dtos.WSMsgTaskPauseRequest, t.onTaskPauseRequest)
+	adder.AddHandler(dtos.WSMsgTaskResumeRequest, t.onTaskResumeRequest)
+	adder.AddHandler(dtos.WSMsgTaskCancelRequest, t.onTaskCancelRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsScrubStartRequest, t.onBtrfsScrubStartRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsBalanceStartRequest, t.onBtrfsBalanceStartRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsProfileConvertRequest, t.onBtrfsProfileConvertRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsDeviceRemoveRequest, t.onBtrfsDeviceRemoveRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsDeviceResizeRequest, t.onBtrfsDeviceResizeRequest)
+	adder.AddHandler(dtos.WSMsgBtrfsReplaceStartRequest, t.onBtrfsReplaceStartRequest)
+	adder.AddHandler(dtos.WSMsgBackupStartRequest, t.onBackupStartRequest)
+	adder.AddHandler(dtos.WSMsgBackupVerifyRequest, t.onBackupVerifyRequest)
+	adder.AddHandler(dtos.WSMsgBackupRestoreRequest, t.onBackupRestoreRequest)
+}
+
+/*setContext sets the master connection used to send task notifications, nil
+when the connection is lost. The state of the tasks that are active or that
+ended while the master was not connected is sent on the new connection, as
+their notifications were missed.*/
+func (t *taskController) setContext(ctx *request.Context) {
+	t.ctxMtx.Lock()
+	if ctx == nil && t.ctx != nil {
+		t.disconnected = time.Now()
+	}
+	t.ctx = ctx
+	disconnected := t.disconnected
+	t.ctxMtx.Unlock()
+	if ctx == nil {
+		return
+	}
+
+	for _, task := range t.tracker.GetAll() {
+		if task.State.IsActive() || !task.EndTime.Before(disconnected) {
+			t.onTaskUpdate(task)
+		}
+	}
+}
+
+//busy tells whether a task is running or paused
+func (t *taskController) busy() bool {
+	for _, task := range t.tracker.GetAll() {
+		if task.State.IsActive() {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *taskController) onTaskUpdate(task tasks.Task) {
+	t.ctxMtx.RLock()
+	ctx := t.ctx
+	t.ctxMtx.RUnlock()
+	if ctx == nil {
+		return
+	}
+
+	notification := &dtos.TaskStatusNotification{}
+	notification.Task = task
+	serverID, ok := ctx.GetSessionData(storageServerIDSessionKey)
+	if ok {
+		notification.ServerID = serverID.(dtos.StorageServerID)
+	}
+	ctx.SendAsync(dtos.NewWebSocketMessage(0, notification))
+}
+
+func (t *taskController) onTaskStatusRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.TaskStatusRequest)
+	task, ok := t.tracker.Get(request.TaskID)
+	if !ok {
+		sendError(ctx, msg.RequestID, tasksSubsystem, tasks.ErrTaskNotFound)
+		return
+	}
+	response := &dtos.TaskStatusResponse{}
+	response.Task = task
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}
+
+func (t *taskController) onTaskPauseRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.TaskPauseRequest)
+	task, err := t.tracker.Pause(request.TaskID)
+	if err != nil {
+		sendError(ctx, msg.RequestID, tasksSubsystem, err)
+		return
+	}
+	response := &dtos.TaskPauseResponse{}
+	response.Task = task
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}
+
+func (t *taskController) onTaskResumeRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.TaskResumeRequest)
+	task, err := t.tracker.Resume(request.TaskID)
+	if err != nil {
+		sendError(ctx, msg.RequestID, tasksSubsystem, err)
+		return
+	}
+	response := &dtos.TaskResumeResponse{}
+	response.Task = task
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}
+
+func (t *taskController) onTaskCancelRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.TaskCancelRequest)
+	task, err := t.tracker.Cancel(request.TaskID)
+	if err != nil {
+		sendError(ctx, msg.RequestID, tasksSubsystem, err)
+		return
+	}
+	response := &dtos.TaskCancelResponse{}
+	response.Task = task
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}
+
+func (t *taskController) onBtrfsScrubStartRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsScrubStartRequest)
+	op, err := osinterface.NewScrubOperation(dtos.BtrfsVolume{UUID: request.VolumeUUID}, request.ReadOnly)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	task, err := t.tracker.Start(scrubTaskKind, string(request.VolumeUUID), op)
+	if err != nil {
+		sendError(ctx, msg.RequestID, tasksSubsystem, err)
+		return
+	}
+	response := &dtos.BtrfsScrubStartResponse{}
+	response.Task = task
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}
+
+func (t *taskController) onBtrfsBalanceStartRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsBalanceStartRequest)
+	op, err := osinterface.NewBalanceOperation(dtos.BtrfsVolume{UUID: request.VolumeUUID}, osinterface.BalanceArgs{
+		Data:     request.Data,
+		Metadata: request.Metadata,
+		System:   request.System,
+		Force:    request.Force,
+	})
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	task, err := t.tracker.Start(balanceTaskKind, string(request.VolumeUUID), op)
+	if err != nil {
+		sendError(ctx, msg.RequestID, tasksSubsystem, err)
+		return
+	}
+	response := &dtos.BtrfsBalanceStartResponse{}
+	response.Task = task
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}
+
+func (t *taskController) onBtrfsProfileConvertRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsProfileConvertRequest)
+	op, err := osinterface.NewProfileConversion(request.VolumeUUID, osinterface.ProfileConversionArgs{
+		DataProfile:     request.DataProfile,
+		MetadataProfile: request.MetadataProfile,
+		Force:           request.Force,
+	})
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	task, err := t.tracker.Start(balanceTaskKind, string(request.VolumeUUID), op)
+	if err != nil {
+		sendError(ctx, msg.RequestID, tasksSubsystem, err)
+		return
+	}
+	response := &dtos.BtrfsProfileConvertResponse{}
+	response.Task = task
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}
+
+func (t *taskController) onBtrfsDeviceRemoveRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsDeviceRemoveRequest)
+	op, err := osinterface.NewDeviceRemoveOperation(request.VolumeUUID, request.Paths)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	task, err := t.tracker.Start(deviceRemoveTaskKind, string(request.VolumeUUID), op)
+	if err != nil {
+		sendError(ctx, msg.RequestID, tasksSubsystem, err)
+		return
+	}
+	response := &dtos.BtrfsDeviceRemoveResponse{}
+	response.Task = task
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}
+
+func (t *taskController) onBtrfsDeviceResizeRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsDeviceResizeRequest)
+	op, err := osinterface.NewDeviceResizeOperation(request.VolumeUUID, request.DevID, request.Size, request.Max)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	task, err := t.tracker.Start(deviceResizeTaskKind, string(request.VolumeUUID), op)
+	if err != nil {
+		sendError(ctx, msg.RequestID, tasksSubsystem, err)
+		return
+	}
+	response := &dtos.BtrfsDeviceResizeResponse{}
+	response.Task = task
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}
+
+func (t *taskController) onBtrfsReplaceStartRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BtrfsReplaceStartRequest)
+	op, err := osinterface.NewReplaceOperation(request.VolumeUUID, request.Source, request.Target, request.Force)
+	if err != nil {
+		sendError(ctx, msg.RequestID, btrfsSubsystem, err)
+		return
+	}
+	task, err := t.tracker.Start(replaceTaskKind, string(request.VolumeUUID), op)
+	if err != nil {
+		sendError(ctx, msg.RequestID, tasksSubsystem, err)
+		return
+	}
+	response := &dtos.BtrfsReplaceStartResponse{}
+	response.Task = task
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}
+
+func (t *taskController) onBackupStartRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BackupStartRequest)
+	dir, err := osinterface.BackupTargetDir(request.TargetDir)
+	var op *osinterface.BackupOperation
+	if err == nil {
+		op, err = osinterface.NewBackupOperation(request.VolumeUUID, request.RelativePath, dir, request.Full)
+	}
+	if err != nil {
+		sendError(ctx, msg.RequestID, backupSubsystem, err)
+		return
+	}
+	task, err := t.tracker.Start(backupTaskKind, dir, op)
+	if err != nil {
+		sendError(ctx, msg.RequestID, tasksSubsystem, err)
+		return
+	}
+	response := &dtos.BackupStartResponse{}
+	response.Task = task
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}
+
+func (t *taskController) onBackupVerifyRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BackupVerifyRequest)
+	dir, err := osinterface.BackupTargetDir(request.TargetDir)
+	var op *osinterface.BackupVerifyOperation
+	if err == nil {
+		op, err = osinterface.NewBackupVerifyOperation(dir, request.Chain)
+	}
+	if err != nil {
+		sendError(ctx, msg.RequestID, backupSubsystem, err)
+		return
+	}
+	task, err := t.tracker.Start(backupVerifyTaskKind, dir+":"+request.Chain, op)
+	if err != nil {
+		sendError(ctx, msg.RequestID, tasksSubsystem, err)
+		return
+	}
+	response := &dtos.BackupVerifyResponse{}
+	response.Task = task
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}
+
+func (t *taskController) onBackupRestoreRequest(ctx *request.Context, msg dtos.WebSocketMessage) {
+	request := msg.Payload.(*dtos.BackupRestoreRequest)
+	dir, err := osinterface.BackupTargetDir(request.TargetDir)
+	var op *osinterface.RestoreOperation
+	if err == nil {
+		op, err = osinterface.NewRestoreOperation(request.VolumeUUID, dir, request.BackupStreamID,
+			request.TargetPath, request.Writable, request.MountPath, request.MountOptions)
+	}
+	if err != nil {
+		sendError(ctx, msg.RequestID, backupSubsystem, err)
+		return
+	}
+	task, err := t.tracker.Start(restoreTaskKind, string(request.VolumeUUID)+":"+request.TargetPath, op)
+	if err != nil {
+		sendError(ctx, msg.RequestID, tasksSubsystem, err)
+		return
+	}
+	response := &dtos.BackupRestoreResponse{}
+	response.Task = task
+	ctx.SendAsync(dtos.NewWebSocketMessage(msg.RequestID, response))
+}